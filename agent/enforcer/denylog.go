@@ -0,0 +1,379 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package enforcer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/romana/core/agent/firewall"
+	"github.com/romana/core/agent/iptsave"
+	"github.com/romana/core/agent/nflog"
+	"github.com/romana/core/common/api"
+	"github.com/romana/core/pkg/policytools"
+
+	log "github.com/romana/rlog"
+	"golang.org/x/sys/unix"
+)
+
+// Modes of logging the traffic denied by romana policies.
+const (
+	// DenyLogNone disables logging.
+	DenyLogNone = "none"
+
+	// DenyLogPolicy logs denied traffic of the policies
+	// that have LogDenies set.
+	DenyLogPolicy = "policy"
+
+	// DenyLogAll logs every packet dropped by romana rules.
+	DenyLogAll = "all"
+)
+
+const (
+	DefaultNflogGroup   = 100
+	DefaultDenyLogRate  = "10/second"
+	denyLogBurst        = 20
+	denyLogReadTimeout  = time.Second
+	denyLogRetryTimeout = 10 * time.Second
+)
+
+// denyLogRate matches rates accepted by both, iptables limit
+// module and nftables limit statement.
+var denyLogRate = regexp.MustCompile(`^[0-9]+/(second|minute|hour|day)$`)
+
+// DenyLogConfig describes logging of the traffic denied by romana policies.
+type DenyLogConfig struct {
+	// Mode is one of DenyLogNone, DenyLogPolicy or DenyLogAll.
+	Mode string
+
+	// Group is the NFLOG group used to pass denied packets to the agent.
+	Group uint16
+
+	// Rate limits how many packets are logged by each rule, e.g. 10/second.
+	Rate string
+
+	// Output receives one JSON object per denied packet.
+	Output io.Writer
+}
+
+// Validate checks if config is usable.
+func (c DenyLogConfig) Validate() error {
+	switch c.Mode {
+	case DenyLogNone:
+		return nil
+	case DenyLogPolicy, DenyLogAll:
+	default:
+		return fmt.Errorf("unknown deny log mode %s, expected one of %s, %s or %s",
+			c.Mode, DenyLogNone, DenyLogPolicy, DenyLogAll)
+	}
+
+	if !denyLogRate.MatchString(c.Rate) {
+		return fmt.Errorf("invalid deny log rate %s, expected e.g. %s", c.Rate, DefaultDenyLogRate)
+	}
+
+	if c.Output == nil {
+		return fmt.Errorf("deny log output required")
+	}
+
+	return nil
+}
+
+// makeDenyLogRules inserts rate limited NFLOG rules in front of the rules
+// that drop the traffic denied by romana policies.
+//
+// In DenyLogAll mode every drop rule in romana forward chains gets
+// a log rule, while in DenyLogPolicy mode the log rules only match
+// the targets of policies that have LogDenies set.
+func makeDenyLogRules(iptables *iptsave.IPtables, policies []api.Policy, config DenyLogConfig) {
+	filter := iptables.TableByName("filter")
+	if filter == nil {
+		return
+	}
+
+	switch config.Mode {
+	case DenyLogAll:
+		for _, chainName := range []string{firewall.ChainNameEndpointIngress, firewall.ChainNameEndpointEgress, firewall.ChainNameEgressPolicy} {
+			chain := filter.ChainByName(chainName)
+			if chain == nil {
+				continue
+			}
+
+			for i := 0; i < len(chain.Rules); i++ {
+				if chain.Rules[i].Action.Body != "DROP" {
+					continue
+				}
+
+				var match []*iptsave.Match
+				for _, m := range chain.Rules[i].Match {
+					if !strings.HasPrefix(m.Body, "-m comment") {
+						match = append(match, m)
+					}
+				}
+				chain.InsertRule(i, makeDenyLogRule(match, chainName, config))
+				i++
+			}
+		}
+
+	case DenyLogPolicy:
+		for _, policy := range policies {
+			if !policy.LogDenies {
+				continue
+			}
+
+			for _, directed := range policytools.SplitPolicy(policy) {
+				for _, target := range directed.AppliedTo {
+					makePolicyDenyLogRule(filter, directed, target, config)
+				}
+			}
+		}
+	}
+}
+
+// makePolicyDenyLogRule inserts a log rule for the traffic of the policy
+// target in front of the drop rules of the chain which handles
+// the direction of the policy.
+func makePolicyDenyLogRule(filter *iptsave.IPtable, policy api.Policy, target api.Endpoint, config DenyLogConfig) {
+	chainName := firewall.ChainNameEndpointIngress
+	makeTenantMatch := policytools.MakeDstTenantMatch
+	makeTenantSegmentMatch := policytools.MakeDstTenantSegmentMatch
+	switch policytools.BlueprintDirection(policy) {
+	case policytools.DirectionEgressSection:
+		chainName = firewall.ChainNameEgressPolicy
+		makeTenantMatch = policytools.MakeSrcTenantMatch
+		makeTenantSegmentMatch = policytools.MakeSrcTenantSegmentMatch
+	case api.PolicyDirectionEgress:
+		chainName = firewall.ChainNameEndpointEgress
+		makeTenantMatch = policytools.MakeSrcTenantMatch
+		makeTenantSegmentMatch = policytools.MakeSrcTenantSegmentMatch
+	}
+
+	var match string
+	switch policytools.DetectPolicyTargetType(target) {
+	case policytools.TargetTenant:
+		match = makeTenantMatch(target)
+	case policytools.TargetTenantSegment:
+		match = makeTenantSegmentMatch(target)
+	default:
+		log.Debugf("Target %s is not eligible for deny logging", target)
+		return
+	}
+
+	chain := filter.ChainByName(chainName)
+	if chain == nil {
+		return
+	}
+
+	rule := makeDenyLogRule(
+		[]*iptsave.Match{&iptsave.Match{Body: match}},
+		policytools.MakeRomanaPolicyName(policy),
+		config,
+	)
+	if chain.RuleInChain(rule) {
+		return
+	}
+
+	for i, r := range chain.Rules {
+		if r.Action.Body == "DROP" {
+			chain.InsertRule(i, rule)
+			return
+		}
+	}
+}
+
+// makeDenyLogRule returns NFLOG rule, prefix identifies the chain
+// that denied the packet.
+func makeDenyLogRule(match []*iptsave.Match, prefix string, config DenyLogConfig) *iptsave.IPrule {
+	rule := &iptsave.IPrule{
+		Action: iptsave.IPtablesAction{
+			Type: iptsave.ActionDefault,
+			Body: fmt.Sprintf("NFLOG --nflog-group %d --nflog-prefix %s", config.Group, prefix),
+		},
+	}
+	rule.Match = append(rule.Match, match...)
+	rule.Match = append(rule.Match, &iptsave.Match{
+		Body: fmt.Sprintf("-m limit --limit %s --limit-burst %d", config.Rate, denyLogBurst),
+	})
+
+	return rule
+}
+
+// DenyLogEntry describes a packet denied by romana policies.
+type DenyLogEntry struct {
+	Time       time.Time `json:"time"`
+	Chain      string    `json:"chain"`
+	PolicyID   string    `json:"policy_id,omitempty"`
+	Direction  string    `json:"direction,omitempty"`
+	Src        string    `json:"src"`
+	Dst        string    `json:"dst"`
+	Protocol   string    `json:"protocol"`
+	SrcPort    uint16    `json:"src_port,omitempty"`
+	Port       uint16    `json:"port,omitempty"`
+	SrcTenant  string    `json:"src_tenant,omitempty"`
+	SrcSegment string    `json:"src_segment,omitempty"`
+	DstTenant  string    `json:"dst_tenant,omitempty"`
+	DstSegment string    `json:"dst_segment,omitempty"`
+}
+
+// denyLogger receives packets logged by NFLOG rules and
+// writes them out as DenyLogEntry.
+type denyLogger struct {
+	config DenyLogConfig
+
+	mu     sync.Mutex
+	blocks []api.IPAMBlockResponse
+
+	// policies by the name of their chain.
+	policies map[string]api.Policy
+}
+
+func newDenyLogger(config DenyLogConfig) *denyLogger {
+	return &denyLogger{config: config, policies: make(map[string]api.Policy)}
+}
+
+// update refreshes blocks and policies used to describe denied packets.
+func (l *denyLogger) update(blocks []api.IPAMBlockResponse, policies []api.Policy) {
+	chains := make(map[string]api.Policy)
+	for _, policy := range policies {
+		for _, directed := range policytools.SplitPolicy(policy) {
+			chains[policytools.MakeRomanaPolicyName(directed)] = directed
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.blocks = blocks
+	l.policies = chains
+}
+
+// makeEntry describes the packet logged by NFLOG rule.
+func (l *denyLogger) makeEntry(packet nflog.Packet, now time.Time) (DenyLogEntry, error) {
+	flow, err := nflog.ParseFlow(packet.Payload)
+	if err != nil {
+		return DenyLogEntry{}, err
+	}
+
+	entry := DenyLogEntry{
+		Time:     now,
+		Chain:    packet.Prefix,
+		Src:      flow.Src.String(),
+		Dst:      flow.Dst.String(),
+		Protocol: flow.Protocol,
+		SrcPort:  flow.SrcPort,
+		Port:     flow.DstPort,
+	}
+
+	switch packet.Prefix {
+	case firewall.ChainNameEndpointIngress:
+		entry.Direction = api.PolicyDirectionIngress
+	case firewall.ChainNameEndpointEgress, firewall.ChainNameEgressPolicy:
+		entry.Direction = api.PolicyDirectionEgress
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if policy, ok := l.policies[packet.Prefix]; ok {
+		entry.PolicyID = policy.ID
+		entry.Direction = policy.Direction
+	}
+
+	for _, block := range l.blocks {
+		if block.CIDR.Contains(flow.Src) {
+			entry.SrcTenant = block.Tenant
+			entry.SrcSegment = block.Segment
+		}
+		if block.CIDR.Contains(flow.Dst) {
+			entry.DstTenant = block.Tenant
+			entry.DstSegment = block.Segment
+		}
+	}
+
+	return entry, nil
+}
+
+// run reads packets from NFLOG group until context is canceled.
+func (l *denyLogger) run(ctx context.Context) {
+	encoder := json.NewEncoder(l.config.Output)
+
+	for {
+		conn, err := nflog.Open(l.config.Group)
+		if err == nil {
+			err = conn.SetReadTimeout(denyLogReadTimeout)
+		}
+		if err != nil {
+			log.Errorf("Failed to listen for denied packets on nflog group %d, %s", l.config.Group, err)
+			ErrDenyLog.Inc()
+		} else {
+			l.receive(ctx, conn, encoder)
+			conn.Close()
+		}
+
+		select {
+		case <-time.After(denyLogRetryTimeout):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// receive writes out packets received from conn until context is canceled
+// or conn fails.
+func (l *denyLogger) receive(ctx context.Context, conn *nflog.Conn, encoder *json.Encoder) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		packets, err := conn.Receive()
+		switch err {
+		case nil:
+		case unix.EAGAIN, unix.EINTR:
+			continue
+		case unix.ENOBUFS:
+			// kernel dropped messages because agent
+			// was too slow to read them.
+			log.Infof("Some of denied packets were not logged, %s", err)
+			continue
+		default:
+			log.Errorf("Failed to receive denied packets, %s", err)
+			ErrDenyLog.Inc()
+			return
+		}
+
+		for _, packet := range packets {
+			entry, err := l.makeEntry(packet, time.Now())
+			if err != nil {
+				log.Debugf("Skipping denied packet logged by %s, %s", packet.Prefix, err)
+				continue
+			}
+
+			if err := encoder.Encode(entry); err != nil {
+				log.Errorf("Failed to write denied packet, %s", err)
+				ErrDenyLog.Inc()
+				continue
+			}
+			NumDeniedPackets.Inc()
+		}
+	}
+}
@@ -0,0 +1,261 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package enforcer
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/romana/core/agent/firewall"
+	"github.com/romana/core/agent/iptsave"
+	"github.com/romana/core/agent/nflog"
+	"github.com/romana/core/common/api"
+	"github.com/romana/core/pkg/policytools"
+)
+
+func denyLogTestData() ([]api.IPAMBlockResponse, []api.Policy) {
+	makeCIDR := func(s string) api.IPNet {
+		_, ipnet, _ := net.ParseCIDR(s)
+		return api.IPNet{IPNet: *ipnet}
+	}
+
+	blocks := []api.IPAMBlockResponse{
+		{Tenant: "tenant-a", Segment: "backend", CIDR: makeCIDR("10.0.0.0/28"), Host: "host1"},
+		{Tenant: "tenant-a", Segment: "frontend", CIDR: makeCIDR("10.0.1.0/28"), Host: "host2"},
+	}
+	policies := []api.Policy{
+		{
+			ID:        "logged",
+			Direction: api.PolicyDirectionIngress,
+			AppliedTo: []api.Endpoint{{TenantID: "tenant-a", SegmentID: "backend"}},
+			Ingress: []api.RomanaIngress{
+				{
+					Peers: []api.Endpoint{{TenantID: "tenant-a", SegmentID: "frontend"}},
+					Rules: []api.Rule{{Protocol: "tcp", Ports: []uint{80}}},
+				},
+			},
+			Egress: []api.RomanaEgress{
+				{
+					Peers: []api.Endpoint{{Cidr: "10.0.0.0/8"}},
+					Rules: []api.Rule{{Protocol: "udp"}},
+				},
+			},
+			LogDenies: true,
+		},
+		{
+			ID:        "not-logged",
+			Direction: api.PolicyDirectionIngress,
+			AppliedTo: []api.Endpoint{{TenantID: "tenant-a", SegmentID: "frontend"}},
+			Ingress: []api.RomanaIngress{
+				{
+					Peers: []api.Endpoint{{Peer: "any"}},
+					Rules: []api.Rule{{Protocol: "tcp", Ports: []uint{443}}},
+				},
+			},
+		},
+	}
+
+	return blocks, policies
+}
+
+// ruleIndex returns index of the first rule in chain
+// which contains every substring.
+func ruleIndex(chain *iptsave.IPchain, substrings ...string) int {
+	for i, rule := range chain.Rules {
+		found := true
+		for _, s := range substrings {
+			if !strings.Contains(rule.String(), s) {
+				found = false
+			}
+		}
+		if found {
+			return i
+		}
+	}
+	return -1
+}
+
+func TestMakeDenyLogRules(t *testing.T) {
+	blocks, policies := denyLogTestData()
+	config := DenyLogConfig{Group: 5, Rate: "3/second"}
+
+	var ingress, egress api.Policy
+	for _, directed := range policytools.SplitPolicy(policies[0]) {
+		if directed.Direction == api.PolicyDirectionEgress {
+			egress = directed
+		} else {
+			ingress = directed
+		}
+	}
+
+	config.Mode = DenyLogPolicy
+	iptables, _, err := Render(policies, blocks, "host1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	makeDenyLogRules(iptables, policies, config)
+	t.Log(iptables.Render())
+
+	filter := iptables.TableByName("filter")
+	forwardIn := filter.ChainByName(firewall.ChainNameEndpointIngress)
+	logIdx := ruleIndex(forwardIn,
+		policytools.MakeDstTenantSegmentMatch(policies[0].AppliedTo[0]),
+		"-m limit --limit 3/second --limit-burst 20",
+		"-j NFLOG --nflog-group 5 --nflog-prefix "+policytools.MakeRomanaPolicyName(ingress))
+	dropIdx := ruleIndex(forwardIn, "DefaultDrop", "-j DROP")
+	if logIdx == -1 || logIdx+1 != dropIdx {
+		t.Errorf("expected ingress log rule right before default drop, got %d and %d", logIdx, dropIdx)
+	}
+	if ruleIndex(forwardIn, policytools.MakeDstTenantSegmentMatch(policies[1].AppliedTo[0]), "NFLOG") != -1 {
+		t.Errorf("unexpected log rule for policy without LogDenies")
+	}
+
+	egressChain := filter.ChainByName(firewall.ChainNameEgressPolicy)
+	logIdx = ruleIndex(egressChain,
+		policytools.MakeSrcTenantSegmentMatch(policies[0].AppliedTo[0]),
+		"--nflog-prefix "+policytools.MakeRomanaPolicyName(egress))
+	dropIdx = ruleIndex(egressChain, "EgressDefaultDrop", "-j DROP")
+	if logIdx == -1 || logIdx+1 != dropIdx {
+		t.Errorf("expected egress log rule right before egress drop, got %d and %d", logIdx, dropIdx)
+	}
+
+	config.Mode = DenyLogAll
+	iptables, _, err = Render(policies, blocks, "host1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	makeDenyLogRules(iptables, policies, config)
+
+	filter = iptables.TableByName("filter")
+	for _, chainName := range []string{firewall.ChainNameEndpointIngress, firewall.ChainNameEndpointEgress, firewall.ChainNameEgressPolicy} {
+		chain := filter.ChainByName(chainName)
+		for i, rule := range chain.Rules {
+			if rule.Action.Body != "DROP" {
+				continue
+			}
+			if i == 0 || !strings.Contains(chain.Rules[i-1].Action.Body, "--nflog-prefix "+chainName) {
+				t.Errorf("expected log rule before %s in %s", rule, chainName)
+				continue
+			}
+			if strings.Contains(chain.Rules[i-1].String(), "--comment") {
+				t.Errorf("expected no comments in log rule %s", chain.Rules[i-1])
+			}
+		}
+	}
+
+	// log rules can be translated for nftables backend.
+	iptables, sets, err := Render(policies, blocks, "host1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Mode = DenyLogPolicy
+	makeDenyLogRules(iptables, policies, config)
+	ruleset, err := renderNftables(iptables, sets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := fmt.Sprintf(`limit rate 3/second burst 20 packets log prefix "%s" group 5`,
+		policytools.MakeRomanaPolicyName(ingress))
+	if !strings.Contains(ruleset, expect) {
+		t.Errorf("expected %q in nftables ruleset", expect)
+	}
+}
+
+func TestDenyLogConfigValidate(t *testing.T) {
+	output := &bytes.Buffer{}
+	for _, config := range []DenyLogConfig{
+		{Mode: DenyLogNone},
+		{Mode: DenyLogPolicy, Rate: "10/second", Output: output},
+		{Mode: DenyLogAll, Rate: "1/minute", Output: output},
+	} {
+		if err := config.Validate(); err != nil {
+			t.Errorf("unexpected error for %+v, %s", config, err)
+		}
+	}
+
+	for _, config := range []DenyLogConfig{
+		{Mode: "some", Rate: "10/second", Output: output},
+		{Mode: DenyLogAll, Rate: "10/sec", Output: output},
+		{Mode: DenyLogAll, Rate: "10/second"},
+	} {
+		if err := config.Validate(); err == nil {
+			t.Errorf("expected error for %+v", config)
+		}
+	}
+}
+
+func TestDenyLoggerMakeEntry(t *testing.T) {
+	blocks, policies := denyLogTestData()
+	logger := newDenyLogger(DenyLogConfig{Mode: DenyLogPolicy})
+	logger.update(blocks, policies)
+
+	var ingress api.Policy
+	for _, directed := range policytools.SplitPolicy(policies[0]) {
+		if directed.Direction == api.PolicyDirectionIngress {
+			ingress = directed
+		}
+	}
+
+	// tcp 10.0.1.1:40000 -> 10.0.0.1:22
+	payload := []byte{
+		0x45, 0, 0, 40, 0, 0, 0x40, 0, 64, 6, 0, 0,
+		10, 0, 1, 1, 10, 0, 0, 1,
+		0x9c, 0x40, 0, 22,
+	}
+	now := time.Now()
+
+	entry, err := logger.makeEntry(nflog.Packet{
+		Prefix:  policytools.MakeRomanaPolicyName(ingress),
+		Payload: payload,
+	}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := DenyLogEntry{
+		Time:       now,
+		Chain:      policytools.MakeRomanaPolicyName(ingress),
+		PolicyID:   "logged",
+		Direction:  api.PolicyDirectionIngress,
+		Src:        "10.0.1.1",
+		Dst:        "10.0.0.1",
+		Protocol:   "tcp",
+		SrcPort:    40000,
+		Port:       22,
+		SrcTenant:  "tenant-a",
+		SrcSegment: "frontend",
+		DstTenant:  "tenant-a",
+		DstSegment: "backend",
+	}
+	if entry != expect {
+		t.Errorf("expected %+v, got %+v", expect, entry)
+	}
+
+	entry, err = logger.makeEntry(nflog.Packet{Prefix: firewall.ChainNameEndpointEgress, Payload: payload}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.PolicyID != "" || entry.Direction != api.PolicyDirectionEgress {
+		t.Errorf("unexpected entry %+v", entry)
+	}
+
+	if _, err := logger.makeEntry(nflog.Packet{Payload: payload[:10]}, now); err == nil {
+		t.Error("expected error for truncated packet")
+	}
+}
@@ -18,12 +18,14 @@ package enforcer
 
 import (
 	"context"
+	"fmt"
 	"os/exec"
 	"strings"
 	"time"
 
 	"github.com/pkg/errors"
 	utilexec "github.com/romana/core/agent/exec"
+	"github.com/romana/core/agent/firewall"
 	"github.com/romana/core/agent/iptsave"
 	"github.com/romana/core/agent/policycache"
 	"github.com/romana/core/common/api"
@@ -72,6 +74,16 @@ type Enforcer struct {
 
 	// attempt to refresh policies every refreshSeconds.
 	refreshSeconds int
+
+	// backend used to enforce policies, one of
+	// BackendIptables or BackendNftables.
+	backend string
+
+	// denyLog configures logging of denied traffic.
+	denyLog DenyLogConfig
+
+	// denyLogger is nil unless denied traffic is logged.
+	denyLogger *denyLogger
 }
 
 // New returns new policy enforcer.
@@ -81,16 +93,36 @@ func New(policy policycache.Interface,
 	blocksChannel <-chan api.IPAMBlocksResponse,
 	hostname string,
 	utilexec utilexec.Executable,
-	refreshSeconds int) (Interface, error) {
+	refreshSeconds int,
+	backend string,
+	denyLog DenyLogConfig) (Interface, error) {
 
 	var err error
 
-	if IptablesSaveBin, err = exec.LookPath("iptables-save"); err != nil {
+	if err = denyLog.Validate(); err != nil {
 		return nil, err
 	}
 
-	if IptablesRestoreBin, err = exec.LookPath("iptables-restore"); err != nil {
-		return nil, err
+	switch backend {
+	case BackendIptables:
+		if IptablesSaveBin, err = exec.LookPath("iptables-save"); err != nil {
+			return nil, err
+		}
+
+		if IptablesRestoreBin, err = exec.LookPath("iptables-restore"); err != nil {
+			return nil, err
+		}
+	case BackendNftables:
+		if NftBin, err = exec.LookPath("nft"); err != nil {
+			return nil, err
+		}
+
+		// iptables is only used to flush policies left
+		// by iptables backend, so it may be missing.
+		IptablesSaveBin, _ = exec.LookPath("iptables-save")
+		IptablesRestoreBin, _ = exec.LookPath("iptables-restore")
+	default:
+		return nil, errors.Errorf("unknown policy backend %s", backend)
 	}
 
 	return &Enforcer{
@@ -101,6 +133,8 @@ func New(policy policycache.Interface,
 		hostname:       hostname,
 		exec:           utilexec,
 		refreshSeconds: refreshSeconds,
+		backend:        backend,
+		denyLog:        denyLog,
 	}, nil
 }
 
@@ -116,6 +150,22 @@ func (a *Enforcer) Run(ctx context.Context) {
 	iptables := &iptsave.IPtables{}
 	a.ticker = time.NewTicker(time.Duration(a.refreshSeconds) * time.Second)
 
+	if a.backend == BackendIptables {
+		go a.collectPolicyCounters(ctx)
+	}
+
+	if a.backend == BackendNftables {
+		if err := flushIptablesPolicies(a.exec); err != nil {
+			log.Errorf("Failed to flush romana iptables chains left by iptables backend, %s", err)
+		}
+	}
+
+	if a.denyLog.Mode != DenyLogNone {
+		a.denyLogger = newDenyLogger(a.denyLog)
+		a.denyLogger.update(romanaBlocks, a.policyCache.List())
+		go a.denyLogger.run(ctx)
+	}
+
 	go func() {
 		for {
 			select {
@@ -131,6 +181,16 @@ func (a *Enforcer) Run(ctx context.Context) {
 				}
 				NumEnforcerTick.Inc()
 
+				if a.backend == BackendNftables {
+					if err := a.enforceNftables(romanaBlocks); err != nil {
+						log.Errorf("Failed to apply Romana policies with nftables, %s", err)
+						continue
+					}
+					a.policyUpdate = false
+					a.blocksUpdate = false
+					continue
+				}
+
 				sets, err := makeBlockSets(romanaBlocks, a.policyCache, a.hostname)
 				if err != nil {
 					log.Errorf("Failed to update ipsets, can't apply Romana policies, %s", err)
@@ -148,6 +208,7 @@ func (a *Enforcer) Run(ctx context.Context) {
 				NumManagedSets.Set(float64(len(sets.Sets)))
 
 				iptables = renderIPtables(a.policyCache, a.hostname, romanaBlocks)
+				a.makeDenyLogRules(iptables, romanaBlocks)
 				cleanupUnusedChains(iptables, a.exec)
 				if ValidateIPtables(iptables, a.exec) {
 					if err := ApplyIPtables(iptables, a.exec); err != nil {
@@ -184,6 +245,52 @@ func (a *Enforcer) Run(ctx context.Context) {
 	}()
 }
 
+// enforceNftables renders policies and blocks into nftables ruleset
+// and applies it in one transaction.
+func (a *Enforcer) enforceNftables(blocks []api.IPAMBlockResponse) error {
+	iptables, sets, err := Render(a.policyCache.List(), blocks, a.hostname)
+	if err != nil {
+		ErrMakeSets.Inc()
+		return errors.Wrap(err, "failed to make sets")
+	}
+	NumManagedSets.Set(float64(len(sets.Sets)))
+	a.makeDenyLogRules(iptables, blocks)
+
+	ruleset, err := renderNftables(iptables, sets)
+	if err != nil {
+		ErrValidateNftables.Inc()
+		return errors.Wrap(err, "failed to render nftables")
+	}
+
+	if !ValidateNftables(ruleset, a.exec) {
+		ErrValidateNftables.Inc()
+		log.Tracef(6, "Failed to validate nftables\n%s", ruleset)
+		return errors.New("failed to validate nftables")
+	}
+
+	if err := ApplyNftables(ruleset, a.exec); err != nil {
+		ErrApplyNftables.Inc()
+		return errors.Wrap(err, "nft call failed")
+	}
+	log.Tracef(6, "Applied nftables rules\n%s", ruleset)
+
+	NumBlockUpdates.Inc()
+	NumPolicyUpdates.Inc()
+	return nil
+}
+
+// makeDenyLogRules adds deny log rules to iptables and lets
+// denyLogger know about policies and blocks the rules are made for.
+func (a *Enforcer) makeDenyLogRules(iptables *iptsave.IPtables, blocks []api.IPAMBlockResponse) {
+	if a.denyLogger == nil {
+		return
+	}
+
+	policies := a.policyCache.List()
+	makeDenyLogRules(iptables, policies, a.denyLog)
+	a.denyLogger.update(blocks, policies)
+}
+
 // makeBlockSets creates ipset configuration for policies and blocks.
 func makeBlockSets(blocks []api.IPAMBlockResponse, policyCache policycache.Interface, hostname string) (*ipset.Ipset, error) {
 	policies := policyCache.List()
@@ -191,14 +298,16 @@ func makeBlockSets(blocks []api.IPAMBlockResponse, policyCache policycache.Inter
 
 	// for every policy produce a set to match policy related traffic.
 	for _, policy := range policies {
-		policySet, err := makePolicySets(policy)
-		if err != nil {
-			return nil, err
-		}
+		for _, directed := range policytools.SplitPolicy(policy) {
+			policySet, err := makePolicySets(directed)
+			if err != nil {
+				return nil, err
+			}
 
-		err = sets.AddSet(policySet)
-		if err != nil {
-			return nil, err
+			err = sets.AddSet(policySet)
+			if err != nil {
+				return nil, err
+			}
 		}
 	}
 
@@ -279,6 +388,7 @@ func makeBlockSets(blocks []api.IPAMBlockResponse, policyCache policycache.Inter
 const LocalBlockSetName = "localBlocks"
 
 // makePolicySets produces a set that matches traffic selected by policy Peer fields.
+// Policy is expected to have only one direction, see policytools.SplitPolicy.
 func makePolicySets(policy api.Policy) (*ipset.Set, error) {
 	var policySet *ipset.Set
 	var err error
@@ -296,22 +406,28 @@ func makePolicySets(policy api.Policy) (*ipset.Set, error) {
 		return nil, err
 	}
 
+	var peers []api.Endpoint
 	for _, ingress := range policy.Ingress {
-		for _, peer := range ingress.Peers {
-			peerType := policytools.DetectPolicyPeerType(peer)
-			if peerType != policytools.PeerCIDR {
-				continue
-			}
+		peers = append(peers, ingress.Peers...)
+	}
+	for _, egress := range policy.Egress {
+		peers = append(peers, egress.Peers...)
+	}
 
-			member, err := ipset.NewMember(peer.Cidr, policySet)
-			if err != nil {
-				return nil, err
-			}
+	for _, peer := range peers {
+		peerType := policytools.DetectPolicyPeerType(peer)
+		if peerType != policytools.PeerCIDR {
+			continue
+		}
 
-			err = ipset.SuppressItemExist(policySet.AddMember(member))
-			if err != nil {
-				return nil, err
-			}
+		member, err := ipset.NewMember(peer.Cidr, policySet)
+		if err != nil {
+			return nil, err
+		}
+
+		err = ipset.SuppressItemExist(policySet.AddMember(member))
+		if err != nil {
+			return nil, err
 		}
 	}
 
@@ -360,6 +476,22 @@ func renderIPtables(policyCache policycache.Interface, hostname string, blocks [
 	return &iptables
 }
 
+// Render produces iptables rules and ipsets that policy enforcer would
+// apply on the host for given policies and blocks, without applying them.
+func Render(policies []api.Policy, blocks []api.IPAMBlockResponse, hostname string) (*iptsave.IPtables, *ipset.Ipset, error) {
+	policyCache := policycache.New()
+	for _, policy := range policies {
+		policyCache.Put(policy.ID, policy)
+	}
+
+	sets, err := makeBlockSets(blocks, policyCache, hostname)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return renderIPtables(policyCache, hostname, blocks), sets, nil
+}
+
 // makeBase populates iptables with romana chains that do not depend on presence
 // if any external resource like tenant and policy chains do.
 func makeBase(iptables *iptsave.IPtables) {
@@ -394,6 +526,10 @@ func makePolicies(policies []api.Policy, valid validateFunc, iptables *iptsave.I
 			continue
 		}
 
+		// Egress sections are translated with their own blueprints,
+		// while egress Direction keeps applying to Ingress section.
+		direction := policytools.BlueprintDirection(policy)
+
 		// translates singe romana policy Rule into iptables chains.
 		err := translateRule(
 			policy,
@@ -401,7 +537,7 @@ func makePolicies(policies []api.Policy, valid validateFunc, iptables *iptsave.I
 			peer,
 			target,
 			rule,
-			policy.Direction,
+			direction,
 			iptables,
 		)
 
@@ -411,6 +547,110 @@ func makePolicies(policies []api.Policy, valid validateFunc, iptables *iptsave.I
 		}
 
 		NumPolicyRules.Inc()
+
+		if direction == policytools.DirectionEgressSection {
+			makeEgressBase(iptables)
+			makeEgressDefaultDrop(target, iptables)
+		}
+	}
+}
+
+// makeEgressBase hooks the chain with policies of Egress sections into
+// ROMANA-FORWARD-OUT ahead of the jump to ROMANA-FORWARD-IN, so that
+// traffic between endpoints on the same host is subject to Egress sections
+// too. Traffic allowed by Egress sections goes to ROMANA-EGRESS-ALLOWED,
+// which still passes traffic for local endpoints to ingress policies.
+// -A ROMANA-FORWARD-OUT -m comment --comment Egress -m state --state RELATED,ESTABLISHED -j ACCEPT
+// -A ROMANA-FORWARD-OUT -j ROMANA-EGRESS
+// -A ROMANA-EGRESS-ALLOWED -m set --match-set localBlocks dst -j ROMANA-FORWARD-IN
+// -A ROMANA-EGRESS-ALLOWED -m comment --comment Egress -j ACCEPT
+func makeEgressBase(iptables *iptsave.IPtables) {
+	filter := iptables.TableByName("filter")
+
+	allowedChain := EnsureChainExists(filter, firewall.ChainNameEgressAllowed)
+	if len(allowedChain.Rules) == 0 {
+		allowedChain.Rules = []*iptsave.IPrule{
+			&iptsave.IPrule{
+				Match: []*iptsave.Match{
+					&iptsave.Match{
+						Body: fmt.Sprintf("-m set --match-set %s dst", LocalBlockSetName),
+					},
+				},
+				Action: iptsave.IPtablesAction{
+					Type: iptsave.ActionDefault,
+					Body: firewall.ChainNameEndpointIngress,
+				},
+			},
+			&iptsave.IPrule{
+				Match: []*iptsave.Match{
+					&iptsave.Match{
+						Body: "-m comment --comment Egress",
+					},
+				},
+				Action: iptsave.IPtablesAction{
+					Type: iptsave.ActionDefault,
+					Body: "ACCEPT",
+				},
+			},
+		}
+	}
+
+	EnsureChainExists(filter, firewall.ChainNameEgressPolicy)
+	forwardOut := EnsureChainExists(filter, firewall.ChainNameEndpointEgress)
+	establishedRule := &iptsave.IPrule{
+		Match: []*iptsave.Match{
+			&iptsave.Match{
+				Body: "-m comment --comment Egress",
+			},
+			&iptsave.Match{
+				Body: "-m state --state RELATED,ESTABLISHED",
+			},
+		},
+		Action: iptsave.IPtablesAction{
+			Type: iptsave.ActionDefault,
+			Body: "ACCEPT",
+		},
+	}
+	for i, rule := range rules2list(establishedRule, MakeSimpleJumpRule(firewall.ChainNameEgressPolicy)) {
+		if !forwardOut.RuleInChain(rule) {
+			forwardOut.InsertRule(i, rule)
+		}
+	}
+}
+
+// makeEgressDefaultDrop drops the traffic originating from the target
+// of an Egress section unless it was allowed by one of the policies.
+func makeEgressDefaultDrop(target api.Endpoint, iptables *iptsave.IPtables) {
+	var match string
+	switch policytools.DetectPolicyTargetType(target) {
+	case policytools.TargetTenant:
+		match = policytools.MakeSrcTenantMatch(target)
+	case policytools.TargetTenantSegment:
+		match = policytools.MakeSrcTenantSegmentMatch(target)
+	default:
+		log.Debugf("Target %s is not eligible for egress default drop", target)
+		return
+	}
+
+	filter := iptables.TableByName("filter")
+	egressChain := EnsureChainExists(filter, firewall.ChainNameEgressPolicy)
+	dropRule := &iptsave.IPrule{
+		Match: []*iptsave.Match{
+			&iptsave.Match{
+				Body: "-m comment --comment EgressDefaultDrop",
+			},
+			&iptsave.Match{
+				Body: match,
+			},
+		},
+		Action: iptsave.IPtablesAction{
+			Type: iptsave.ActionDefault,
+			Body: "DROP",
+		},
+	}
+
+	if !egressChain.RuleInChain(dropRule) {
+		InsertDefaultDropRule(egressChain, dropRule)
 	}
 }
 
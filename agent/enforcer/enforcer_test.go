@@ -25,6 +25,7 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/romana/core/agent/firewall"
 	"github.com/romana/core/agent/iptsave"
 	"github.com/romana/core/agent/policycache"
 	"github.com/romana/core/common/api"
@@ -433,6 +434,265 @@ func TestMakePolicies(t *testing.T) {
 	}
 }
 
+func TestEgressDefaultDrop(t *testing.T) {
+	iptables := iptsave.IPtables{
+		Tables: []*iptsave.IPtable{
+			&iptsave.IPtable{
+				Name: "filter",
+			},
+		},
+	}
+	makeBase(&iptables)
+
+	policy := api.Policy{
+		ID:        "<TESTPOLICYID>",
+		AppliedTo: []api.Endpoint{api.Endpoint{TenantID: "T800", SegmentID: "John"}},
+		Egress: []api.RomanaEgress{
+			api.RomanaEgress{
+				Peers: []api.Endpoint{api.Endpoint{Cidr: "10.0.0.0/8"}},
+				Rules: []api.Rule{api.Rule{Protocol: "tcp", Ports: []uint{80}}},
+			},
+		},
+	}
+
+	noop := func(target api.Endpoint) bool { return true }
+	makePolicies([]api.Policy{policy}, noop, &iptables)
+	t.Log(iptables.Render())
+
+	filter := iptables.TableByName("filter")
+	directed := policytools.SplitPolicy(policy)[0]
+	for _, tc := range []struct {
+		chain    string
+		expected []string
+	}{
+		{
+			firewall.ChainNameEndpointEgress,
+			[]string{
+				"ACCEPT",
+				firewall.ChainNameEgressPolicy,
+				"ROMANA-FORWARD-IN",
+				"ACCEPT",
+			},
+		},
+		{
+			firewall.ChainNameEgressPolicy,
+			[]string{
+				policytools.MakeRomanaPolicyName(directed),
+				"DROP",
+			},
+		},
+		{
+			firewall.ChainNameEgressAllowed,
+			[]string{
+				"ROMANA-FORWARD-IN",
+				"ACCEPT",
+			},
+		},
+	} {
+		chain := filter.ChainByName(tc.chain)
+		if chain == nil {
+			t.Fatalf("expected chain %s", tc.chain)
+		}
+
+		if len(chain.Rules) != len(tc.expected) {
+			t.Fatalf("expected %d rules in %s, got %d", len(tc.expected), chain.Name, len(chain.Rules))
+		}
+
+		for i, action := range tc.expected {
+			if chain.Rules[i].Action.Body != action {
+				t.Errorf("expected rule %d in %s to be %s, got %s", i, chain.Name, action, chain.Rules[i])
+			}
+		}
+	}
+}
+
+// testFlow describes the first packet of a connection for evaluating
+// rendered iptables rules with.
+type testFlow struct {
+	srcSets  []string
+	dstSets  []string
+	dst      net.IP
+	protocol string
+	port     uint
+}
+
+// matches checks if the flow matches the body of iptsave.Match,
+// only the matches produced by the enforcer are supported.
+func (f testFlow) matches(t *testing.T, body string) bool {
+	inSets := func(sets []string, name string) bool {
+		for _, set := range sets {
+			if set == name {
+				return true
+			}
+		}
+		return false
+	}
+
+	fields := strings.Fields(body)
+	switch {
+	case len(fields) == 0:
+		return true
+	case len(fields) == 4 && fields[1] == "comment":
+		return true
+	case len(fields) == 4 && fields[1] == "state":
+		// first packet of a connection is never established.
+		return false
+	case len(fields) == 5 && fields[1] == "set" && fields[4] == "src":
+		return inSets(f.srcSets, fields[3])
+	case len(fields) == 5 && fields[1] == "set" && fields[4] == "dst":
+		return inSets(f.dstSets, fields[3])
+	case len(fields) == 2 && fields[0] == "-d":
+		_, cidr, err := net.ParseCIDR(fields[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cidr.Contains(f.dst)
+	case fields[0] == "-p" && (len(fields) == 2 || len(fields) == 4):
+		if fields[1] != f.protocol {
+			return false
+		}
+		return len(fields) == 2 || fields[3] == fmt.Sprint(f.port)
+	}
+
+	t.Fatalf("unsupported match %q", body)
+	return false
+}
+
+// verdict follows the flow through the chain and the chains it jumps to,
+// and returns ACCEPT, DROP or RETURN if the flow leaves the chain.
+func (f testFlow) verdict(t *testing.T, filter *iptsave.IPtable, chainName string) string {
+	chain := filter.ChainByName(chainName)
+	if chain == nil {
+		t.Fatalf("jump to missing chain %s", chainName)
+	}
+
+	for _, rule := range chain.Rules {
+		matched := true
+		for _, match := range rule.Match {
+			if !f.matches(t, match.Body) {
+				matched = false
+				break
+			}
+		}
+		if !matched {
+			continue
+		}
+
+		switch rule.Action.Body {
+		case "ACCEPT", "DROP", "RETURN":
+			return rule.Action.Body
+		default:
+			if result := f.verdict(t, filter, rule.Action.Body); result != "RETURN" {
+				return result
+			}
+		}
+	}
+
+	return "RETURN"
+}
+
+// TestEgressSameHost checks that Egress sections apply to the traffic
+// between endpoints on the same host, and that such traffic is still
+// subject to ingress policies of the destination.
+func TestEgressSameHost(t *testing.T) {
+	iptables := iptsave.IPtables{
+		Tables: []*iptsave.IPtable{
+			&iptsave.IPtable{
+				Name: "filter",
+			},
+		},
+	}
+	makeBase(&iptables)
+
+	frontend := api.Endpoint{TenantID: "tenant-a", SegmentID: "frontend"}
+	backend := api.Endpoint{TenantID: "tenant-a", SegmentID: "backend"}
+	db := api.Endpoint{TenantID: "tenant-a", SegmentID: "db"}
+	postgres := []api.Rule{api.Rule{Protocol: "tcp", Ports: []uint{5432}}}
+
+	policies := []api.Policy{
+		api.Policy{
+			ID:        "frontend-egress",
+			Direction: api.PolicyDirectionEgress,
+			AppliedTo: []api.Endpoint{frontend},
+			Egress:    []api.RomanaEgress{{Peers: []api.Endpoint{backend}, Rules: postgres}},
+		},
+		api.Policy{
+			ID:        "backend-ingress",
+			Direction: api.PolicyDirectionIngress,
+			AppliedTo: []api.Endpoint{backend},
+			Ingress:   []api.RomanaIngress{{Peers: []api.Endpoint{frontend}, Rules: postgres}},
+		},
+		api.Policy{
+			ID:        "db-ingress",
+			Direction: api.PolicyDirectionIngress,
+			AppliedTo: []api.Endpoint{db},
+			Ingress:   []api.RomanaIngress{{Peers: []api.Endpoint{api.Endpoint{Peer: "any"}}, Rules: postgres}},
+		},
+	}
+
+	noop := func(target api.Endpoint) bool { return true }
+	makePolicies(policies, noop, &iptables)
+	t.Log(iptables.Render())
+
+	sets := func(e api.Endpoint, local bool) []string {
+		result := []string{
+			policytools.MakeTenantSetName(e.TenantID, ""),
+			policytools.MakeTenantSetName(e.TenantID, e.SegmentID),
+		}
+		if local {
+			result = append(result, LocalBlockSetName)
+		}
+		return result
+	}
+
+	testCases := []struct {
+		name   string
+		flow   testFlow
+		expect string
+	}{
+		{
+			"allowed by egress and ingress",
+			testFlow{sets(frontend, true), sets(backend, true), net.ParseIP("10.0.0.3"), "tcp", 5432},
+			"ACCEPT",
+		},
+		{
+			"denied by egress",
+			testFlow{sets(frontend, true), sets(backend, true), net.ParseIP("10.0.0.3"), "tcp", 80},
+			"DROP",
+		},
+		{
+			"allowed by ingress but denied by egress",
+			testFlow{sets(frontend, true), sets(db, true), net.ParseIP("10.0.0.4"), "tcp", 5432},
+			"DROP",
+		},
+		{
+			"allowed by ingress without egress policy",
+			testFlow{sets(backend, true), sets(db, true), net.ParseIP("10.0.0.4"), "tcp", 5432},
+			"ACCEPT",
+		},
+		{
+			"allowed by egress but denied by ingress",
+			testFlow{sets(frontend, true), sets(backend, true), net.ParseIP("10.0.0.3"), "udp", 5432},
+			"DROP",
+		},
+		{
+			"remote destination denied by egress",
+			testFlow{sets(frontend, true), nil, net.ParseIP("8.8.8.8"), "tcp", 5432},
+			"DROP",
+		},
+	}
+
+	filter := iptables.TableByName("filter")
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result := tc.flow.verdict(t, filter, firewall.ChainNameEndpointEgress)
+			if result != tc.expect {
+				t.Errorf("expected %s, got %s", tc.expect, result)
+			}
+		})
+	}
+}
+
 func TestTargetValid(t *testing.T) {
 	testCases := []struct {
 		name   string
@@ -505,3 +765,67 @@ func TestTargetValid(t *testing.T) {
 		})
 	}
 }
+
+func TestRender(t *testing.T) {
+	makeCIDR := func(s string) api.IPNet {
+		_, ipnet, _ := net.ParseCIDR(s)
+		return api.IPNet{IPNet: *ipnet}
+	}
+
+	blocks := []api.IPAMBlockResponse{
+		api.IPAMBlockResponse{
+			Tenant:  "tenant-a",
+			Segment: "backend",
+			CIDR:    makeCIDR("10.0.0.0/28"),
+			Host:    "host1",
+		},
+		api.IPAMBlockResponse{
+			Tenant:  "tenant-b",
+			Segment: "backend",
+			CIDR:    makeCIDR("10.1.0.0/28"),
+			Host:    "host2",
+		},
+	}
+
+	makePolicy := func(id, tenant string) api.Policy {
+		return api.Policy{
+			ID:        id,
+			Direction: api.PolicyDirectionIngress,
+			AppliedTo: []api.Endpoint{{TenantID: tenant, SegmentID: "backend"}},
+			Ingress: []api.RomanaIngress{
+				{
+					Peers: []api.Endpoint{{Cidr: "192.168.0.0/24"}},
+					Rules: []api.Rule{{Protocol: "tcp", Ports: []uint{80}}},
+				},
+			},
+		}
+	}
+	local := makePolicy("local", "tenant-a")
+	remote := makePolicy("remote", "tenant-b")
+
+	iptables, sets, err := Render([]api.Policy{local, remote}, blocks, "host1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// policies only produce chains on hosts with their targets.
+	filter := iptables.TableByName("filter")
+	if filter.ChainByName(policytools.MakeRomanaPolicyName(local)) == nil {
+		t.Errorf("expected chain for policy %s in\n%s", local.ID, iptables.Render())
+	}
+	if filter.ChainByName(policytools.MakeRomanaPolicyName(remote)) != nil {
+		t.Errorf("unexpected chain for policy %s in\n%s", remote.ID, iptables.Render())
+	}
+
+	// sets for all policies and blocks are rendered regardless of host.
+	for _, name := range []string{
+		policytools.MakeRomanaPolicyNameSetSrc(local),
+		policytools.MakeRomanaPolicyNameSetSrc(remote),
+		policytools.MakeTenantSetName("tenant-b", "backend"),
+		LocalBlockSetName,
+	} {
+		if sets.SetByName(name) == nil {
+			t.Errorf("expected set %s", name)
+		}
+	}
+}
@@ -47,6 +47,18 @@ func InsertNormalRule(chain *iptsave.IPchain, rule *iptsave.IPrule) {
 	chain.InsertRule(normalIndex, rule)
 }
 
+// InsertDefaultDropRule discovers position in a chain just above the trailing
+// ACCEPT rule, if any. Useful for the default drops that must be evaluated
+// after all the normal rules but before the chain terminator.
+func InsertDefaultDropRule(chain *iptsave.IPchain, rule *iptsave.IPrule) {
+	dropIndex := len(chain.Rules)
+	if dropIndex > 0 && chain.Rules[dropIndex-1].Action.Body == "ACCEPT" {
+		dropIndex--
+	}
+
+	chain.InsertRule(dropIndex, rule)
+}
+
 // EnsureChainExists ensures that IPchain exists in IPtable.
 func EnsureChainExists(table *iptsave.IPtable, chainName string) *iptsave.IPchain {
 	chain := table.ChainByName(chainName)
@@ -46,6 +46,30 @@ var (
 			Help: "Number of errors attempting to apply iptables.",
 		},
 	)
+	ErrValidateNftables = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "romana_err_validate_nftables_total",
+			Help: "Number of errors when rendering or validating nftables.",
+		},
+	)
+	ErrApplyNftables = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "romana_err_apply_nftables_total",
+			Help: "Number of errors attempting to apply nftables.",
+		},
+	)
+	ErrPolicyCounters = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "romana_err_policy_counters_total",
+			Help: "Number of errors attempting to read iptables counters of policy chains.",
+		},
+	)
+	ErrDenyLog = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "romana_err_deny_log_total",
+			Help: "Number of errors attempting to log denied packets.",
+		},
+	)
 	NumPolicyUpdates = prometheus.NewCounter(
 		prometheus.CounterOpts{
 			Name: "romana_policy_updates_total",
@@ -64,6 +88,12 @@ var (
 			Help: "Number of enforcer ticks since start.",
 		},
 	)
+	NumDeniedPackets = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "romana_denied_packets_logged_total",
+			Help: "Number of denied packets logged.",
+		},
+	)
 	NumManagedSets = prometheus.NewGauge(
 		prometheus.GaugeOpts{
 			Name: "romana_managed_sets",
@@ -76,6 +106,20 @@ var (
 			Help: "Number of Romana policy rules applied to the host.",
 		},
 	)
+	NumPolicyPackets = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "romana_policy_packets_total",
+			Help: "Number of packets matched by Romana policy rules.",
+		},
+		[]string{"policy_id", "direction", "verdict"},
+	)
+	NumPolicyBytes = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "romana_policy_bytes_total",
+			Help: "Number of bytes matched by Romana policy rules.",
+		},
+		[]string{"policy_id", "direction", "verdict"},
+	)
 )
 
 // MetricsRegister registers package global metrics into registry provided,
@@ -90,9 +134,14 @@ func MetricsRegister(registry *prometheus.Registry) error {
 		ErrApplySets,
 		ErrValidateIptables,
 		ErrApplyIptables,
+		ErrValidateNftables,
+		ErrApplyNftables,
+		ErrPolicyCounters,
+		ErrDenyLog,
 		NumPolicyUpdates,
 		NumBlockUpdates,
 		NumEnforcerTick,
+		NumDeniedPackets,
 		NumManagedSets,
 		NumPolicyRules,
 	} {
@@ -102,5 +151,15 @@ func MetricsRegister(registry *prometheus.Registry) error {
 		}
 	}
 
+	for _, vec := range []*prometheus.CounterVec{
+		NumPolicyPackets,
+		NumPolicyBytes,
+	} {
+		err := registry.Register(vec)
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
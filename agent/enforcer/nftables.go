@@ -0,0 +1,436 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package enforcer
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	utilexec "github.com/romana/core/agent/exec"
+	"github.com/romana/core/agent/iptsave"
+	"github.com/romana/core/common/log/trace"
+
+	"github.com/romana/ipset"
+	log "github.com/romana/rlog"
+)
+
+// Policy enforcement backends.
+const (
+	BackendIptables = "iptables"
+	BackendNftables = "nftables"
+)
+
+const (
+	// NftablesTable is the nftables table that hosts romana policies.
+	NftablesTable = "romana"
+
+	// nftablesPodInterfaces matches host side of pod interfaces,
+	// see cni.K8sArgs.MakeVethName.
+	nftablesPodInterfaces = "romana-*"
+)
+
+var NftBin string
+
+// nftablesHooks describes base chains that divert pod traffic into
+// romana chains, the same way cni divert rules do for iptables.
+var nftablesHooks = []struct {
+	hook  string
+	rules []string
+}{
+	{"input", []string{
+		fmt.Sprintf("iifname %q jump ROMANA-INPUT", nftablesPodInterfaces),
+	}},
+	{"forward", []string{
+		fmt.Sprintf("iifname %q jump ROMANA-FORWARD-OUT", nftablesPodInterfaces),
+		fmt.Sprintf("oifname %q jump ROMANA-FORWARD-IN", nftablesPodInterfaces),
+	}},
+	{"output", []string{
+		fmt.Sprintf("oifname %q jump ROMANA-OUTPUT", nftablesPodInterfaces),
+	}},
+}
+
+// renderNftables translates iptables rules and ipsets produced from
+// policy blueprints into a single nftables ruleset. The ruleset replaces
+// romana table as a whole when applied with `nft -f`.
+func renderNftables(iptables *iptsave.IPtables, sets *ipset.Ipset) (string, error) {
+	filter := iptables.TableByName("filter")
+	if filter == nil {
+		return "", fmt.Errorf("no filter table to translate")
+	}
+
+	var buf bytes.Buffer
+
+	// creating table before deleting it makes the
+	// ruleset work whether romana table exists or not.
+	fmt.Fprintf(&buf, "table ip %s\n", NftablesTable)
+	fmt.Fprintf(&buf, "delete table ip %s\n", NftablesTable)
+	fmt.Fprintf(&buf, "table ip %s {\n", NftablesTable)
+
+	for _, set := range sets.Sets {
+		fmt.Fprintf(&buf, "\tset %s {\n", set.Name)
+		fmt.Fprintf(&buf, "\t\ttype ipv4_addr\n")
+		fmt.Fprintf(&buf, "\t\tflags interval\n")
+		fmt.Fprintf(&buf, "\t\tauto-merge\n")
+		elems, err := nftablesSetElements(set, sets, 0)
+		if err != nil {
+			return "", err
+		}
+		if len(elems) > 0 {
+			fmt.Fprintf(&buf, "\t\telements = { %s }\n", strings.Join(elems, ", "))
+		}
+		fmt.Fprintf(&buf, "\t}\n")
+	}
+
+	// nft requires chains to exist before they are jumped to,
+	// so every chain is rendered after the chains it jumps to.
+	rendered := make(map[string]bool)
+	var renderChain func(chain *iptsave.IPchain) error
+	renderChain = func(chain *iptsave.IPchain) error {
+		if rendered[chain.Name] {
+			return nil
+		}
+		rendered[chain.Name] = true
+
+		var rules []string
+		for _, rule := range chain.Rules {
+			if target := filter.ChainByName(rule.Action.Body); target != nil {
+				if err := renderChain(target); err != nil {
+					return err
+				}
+			}
+
+			nftRule, err := translateNftablesRule(rule, filter)
+			if err != nil {
+				return fmt.Errorf("can not translate rule %s in chain %s, %s", rule, chain.Name, err)
+			}
+			rules = append(rules, nftRule)
+		}
+
+		fmt.Fprintf(&buf, "\tchain %s {\n", chain.Name)
+		for _, rule := range rules {
+			fmt.Fprintf(&buf, "\t\t%s\n", rule)
+		}
+		fmt.Fprintf(&buf, "\t}\n")
+		return nil
+	}
+
+	for _, chain := range filter.Chains {
+		if err := renderChain(chain); err != nil {
+			return "", err
+		}
+	}
+
+	for _, hook := range nftablesHooks {
+		fmt.Fprintf(&buf, "\tchain %s {\n", hook.hook)
+		fmt.Fprintf(&buf, "\t\ttype filter hook %s priority 0; policy accept;\n", hook.hook)
+		for _, rule := range hook.rules {
+			fmt.Fprintf(&buf, "\t\t%s\n", rule)
+		}
+		fmt.Fprintf(&buf, "\t}\n")
+	}
+
+	fmt.Fprintf(&buf, "}\n")
+
+	return buf.String(), nil
+}
+
+// nftablesSetElements returns elements of the set. Since nftables has no
+// sets of sets, members of list:set sets are replaced with their elements.
+func nftablesSetElements(set *ipset.Set, sets *ipset.Ipset, depth int) ([]string, error) {
+	if depth > 1 {
+		return nil, fmt.Errorf("set %s is nested too deep", set.Name)
+	}
+
+	var elems []string
+	for _, member := range set.Members {
+		if set.Type != ipset.SetListSet {
+			elems = append(elems, member.Elem)
+			continue
+		}
+
+		memberSet := sets.SetByName(member.Elem)
+		if memberSet == nil {
+			return nil, fmt.Errorf("set %s has unknown member set %s", set.Name, member.Elem)
+		}
+		memberElems, err := nftablesSetElements(memberSet, sets, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		elems = append(elems, memberElems...)
+	}
+
+	return elems, nil
+}
+
+// translateNftablesRule translates iptables rule into nftables rule.
+// Only the matches produced by policy blueprints and base rules
+// are supported.
+func translateNftablesRule(rule *iptsave.IPrule, filter *iptsave.IPtable) (string, error) {
+	var statements []string
+	var comment string
+
+	for _, match := range rule.Match {
+		if match.Negated {
+			return "", fmt.Errorf("negated match %s is not supported", match)
+		}
+
+		fields := strings.Fields(match.Body)
+		var protocol string
+		var protocolIdx, limitIdx int
+		for i := 0; i < len(fields); i++ {
+			// value returns the argument of the current option.
+			value := func() (string, error) {
+				if i+1 >= len(fields) {
+					return "", fmt.Errorf("option %s requires a value", fields[i])
+				}
+				i++
+				return fields[i], nil
+			}
+
+			switch fields[i] {
+			case "-m":
+				// matches are identified by their options.
+				if _, err := value(); err != nil {
+					return "", err
+				}
+			case "--comment":
+				v, err := value()
+				if err != nil {
+					return "", err
+				}
+				comment = v
+			case "--state", "--ctstate":
+				v, err := value()
+				if err != nil {
+					return "", err
+				}
+				statements = append(statements, "ct state "+strings.ToLower(v))
+			case "--match-set":
+				name, err := value()
+				if err != nil {
+					return "", err
+				}
+				direction, err := value()
+				if err != nil {
+					return "", err
+				}
+				switch direction {
+				case "src":
+					statements = append(statements, "ip saddr @"+name)
+				case "dst":
+					statements = append(statements, "ip daddr @"+name)
+				default:
+					return "", fmt.Errorf("unsupported set direction %s", direction)
+				}
+			case "-s", "-d":
+				option := fields[i]
+				v, err := value()
+				if err != nil {
+					return "", err
+				}
+				if option == "-s" {
+					statements = append(statements, "ip saddr "+v)
+				} else {
+					statements = append(statements, "ip daddr "+v)
+				}
+			case "-i", "-o":
+				option := fields[i]
+				v, err := value()
+				if err != nil {
+					return "", err
+				}
+				if option == "-i" {
+					statements = append(statements, fmt.Sprintf("iifname %q", v))
+				} else {
+					statements = append(statements, fmt.Sprintf("oifname %q", v))
+				}
+			case "-p":
+				v, err := value()
+				if err != nil {
+					return "", err
+				}
+				protocol = v
+				protocolIdx = len(statements)
+				statements = append(statements, "meta l4proto "+protocol)
+			case "--limit":
+				v, err := value()
+				if err != nil {
+					return "", err
+				}
+				limitIdx = len(statements)
+				statements = append(statements, "limit rate "+v)
+			case "--limit-burst":
+				v, err := value()
+				if err != nil {
+					return "", err
+				}
+				if limitIdx >= len(statements) || !strings.HasPrefix(statements[limitIdx], "limit rate ") {
+					return "", fmt.Errorf("--limit-burst requires --limit")
+				}
+				statements[limitIdx] += fmt.Sprintf(" burst %s packets", v)
+			case "--dport":
+				v, err := value()
+				if err != nil {
+					return "", err
+				}
+				if protocol == "" {
+					return "", fmt.Errorf("--dport requires protocol")
+				}
+				// port match implies the protocol.
+				statements[protocolIdx] = fmt.Sprintf("%s dport %s",
+					protocol, strings.Replace(v, ":", "-", 1))
+			default:
+				return "", fmt.Errorf("unsupported option %s", fields[i])
+			}
+		}
+	}
+
+	switch action := rule.Action.Body; {
+	case action == "ACCEPT", action == "DROP", action == "RETURN":
+		statements = append(statements, strings.ToLower(action))
+	case strings.HasPrefix(action, "NFLOG "):
+		statement, err := translateNflogAction(action)
+		if err != nil {
+			return "", err
+		}
+		statements = append(statements, statement)
+	default:
+		if filter.ChainByName(action) == nil {
+			return "", fmt.Errorf("unsupported action %s", action)
+		}
+		statements = append(statements, "jump "+action)
+	}
+
+	if comment != "" {
+		statements = append(statements, fmt.Sprintf("comment %q", comment))
+	}
+
+	return strings.Join(statements, " "), nil
+}
+
+// translateNflogAction translates NFLOG target into nftables log statement.
+func translateNflogAction(action string) (string, error) {
+	var group, prefix string
+	fields := strings.Fields(action)
+	for i := 1; i < len(fields); i++ {
+		if i+1 >= len(fields) {
+			return "", fmt.Errorf("option %s requires a value", fields[i])
+		}
+		switch fields[i] {
+		case "--nflog-group":
+			group = fields[i+1]
+		case "--nflog-prefix":
+			prefix = fields[i+1]
+		default:
+			return "", fmt.Errorf("unsupported NFLOG option %s", fields[i])
+		}
+		i++
+	}
+
+	if group == "" {
+		return "", fmt.Errorf("NFLOG action requires --nflog-group")
+	}
+
+	if prefix == "" {
+		return "log group " + group, nil
+	}
+	return fmt.Sprintf("log prefix %q group %s", prefix, group), nil
+}
+
+// flushIptablesPolicies flushes romana chains left in iptables by
+// iptables backend. Cni divert rules still jump into these chains,
+// so chains are emptied rather than deleted.
+func flushIptablesPolicies(exec utilexec.Executable) error {
+	if IptablesSaveBin == "" || IptablesRestoreBin == "" {
+		log.Infof("No iptables found, skipping flush of romana iptables chains")
+		return nil
+	}
+
+	current, err := LoadIPtables(exec)
+	if err != nil {
+		return err
+	}
+
+	flush := iptablesFlushRules(current)
+	if flush == nil {
+		return nil
+	}
+
+	log.Infof("Flushing romana iptables chains left by iptables backend\n%s", flush.Render())
+	return ApplyIPtables(flush, exec)
+}
+
+// iptablesFlushRules returns iptables which flush non empty
+// romana chains of filter table when applied with --noflush,
+// or nil if there is nothing to flush.
+func iptablesFlushRules(current *iptsave.IPtables) *iptsave.IPtables {
+	filter := current.TableByName("filter")
+	if filter == nil {
+		return nil
+	}
+
+	flushFilter := &iptsave.IPtable{Name: "filter"}
+	for _, chain := range filter.Chains {
+		if !strings.HasPrefix(chain.Name, "ROMANA-") || len(chain.Rules) == 0 {
+			continue
+		}
+		flushFilter.Chains = append(flushFilter.Chains,
+			&iptsave.IPchain{Name: chain.Name, Policy: "-", Counters: "[0:0]"})
+	}
+
+	if len(flushFilter.Chains) == 0 {
+		return nil
+	}
+	return &iptsave.IPtables{Tables: []*iptsave.IPtable{flushFilter}}
+}
+
+// ValidateNftables calls nft in check mode to validate the ruleset.
+func ValidateNftables(ruleset string, exec utilexec.Executable) bool {
+	err := ApplyNftables(ruleset, exec, "--check")
+	if err != nil {
+		log.Errorf("nftables ruleset validation failed, %s", err)
+		return false
+	}
+
+	return true
+}
+
+// ApplyNftables calls nft to apply the ruleset atomically.
+func ApplyNftables(ruleset string, exec utilexec.Executable, nftFlags ...string) error {
+	file, err := ioutil.TempFile("", "romana-nft")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	_, err = file.WriteString(ruleset)
+	file.Close()
+	if err != nil {
+		return err
+	}
+
+	args := append(nftFlags, "-f", file.Name())
+	log.Tracef(trace.Inside, "In ApplyNftables calling %s %s", NftBin, strings.Join(args, " "))
+	out, err := exec.Exec(NftBin, args)
+	if err != nil {
+		return fmt.Errorf("%s, %s", err, out)
+	}
+
+	return nil
+}
@@ -0,0 +1,200 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package enforcer
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	utilexec "github.com/romana/core/agent/exec"
+	"github.com/romana/core/agent/iptsave"
+	"github.com/romana/core/common/api"
+	"github.com/romana/core/pkg/policytools"
+)
+
+func TestTranslateNftablesRule(t *testing.T) {
+	filter := &iptsave.IPtable{
+		Name:   "filter",
+		Chains: []*iptsave.IPchain{{Name: "ROMANA-P-1"}},
+	}
+
+	makeRule := func(action string, bodies ...string) *iptsave.IPrule {
+		rule := &iptsave.IPrule{Action: iptsave.IPtablesAction{Body: action}}
+		for _, body := range bodies {
+			rule.Match = append(rule.Match, &iptsave.Match{Body: body})
+		}
+		return rule
+	}
+
+	testCases := []struct {
+		rule   *iptsave.IPrule
+		expect string
+	}{
+		{makeRule("ACCEPT"), "accept"},
+		{makeRule("RETURN", "-m comment --comment POLICY_CHAIN_FOOTER"), `return comment "POLICY_CHAIN_FOOTER"`},
+		{makeRule("ACCEPT", "-m comment --comment Egress", "-m state --state RELATED,ESTABLISHED"),
+			`ct state related,established accept comment "Egress"`},
+		{makeRule("ROMANA-P-1", "-m set --match-set ROMANA-abc dst"), "ip daddr @ROMANA-abc jump ROMANA-P-1"},
+		{makeRule("ROMANA-P-1", "-s 10.0.0.0/8"), "ip saddr 10.0.0.0/8 jump ROMANA-P-1"},
+		{makeRule("ACCEPT", "-p tcp --dport 80"), "tcp dport 80 accept"},
+		{makeRule("ACCEPT", "-p udp --dport 1000:2000"), "udp dport 1000-2000 accept"},
+		{makeRule("ACCEPT", "-p icmp"), "meta l4proto icmp accept"},
+		{makeRule("DROP", ""), "drop"},
+	}
+
+	for _, tc := range testCases {
+		result, err := translateNftablesRule(tc.rule, filter)
+		if err != nil {
+			t.Errorf("unexpected error for %s, %s", tc.rule, err)
+			continue
+		}
+		if result != tc.expect {
+			t.Errorf("expected %q for %s, got %q", tc.expect, tc.rule, result)
+		}
+	}
+
+	for _, rule := range []*iptsave.IPrule{
+		makeRule("ROMANA-UNKNOWN"),
+		makeRule("ACCEPT", "-m mark --mark 1"),
+		makeRule("ACCEPT", "--dport 80"),
+		makeRule("ACCEPT", "-s"),
+	} {
+		if _, err := translateNftablesRule(rule, filter); err == nil {
+			t.Errorf("expected error for %s", rule)
+		}
+	}
+}
+
+func TestRenderNftables(t *testing.T) {
+	makeCIDR := func(s string) api.IPNet {
+		_, ipnet, _ := net.ParseCIDR(s)
+		return api.IPNet{IPNet: *ipnet}
+	}
+
+	blocks := []api.IPAMBlockResponse{
+		{Tenant: "tenant-a", Segment: "backend", CIDR: makeCIDR("10.0.0.0/28"), Host: "host1"},
+		{Tenant: "tenant-a", Segment: "frontend", CIDR: makeCIDR("10.0.1.0/28"), Host: "host2"},
+	}
+	policy := api.Policy{
+		ID:        "pol1",
+		Direction: api.PolicyDirectionIngress,
+		AppliedTo: []api.Endpoint{{TenantID: "tenant-a", SegmentID: "backend"}},
+		Ingress: []api.RomanaIngress{
+			{
+				Peers: []api.Endpoint{{Cidr: "192.168.0.0/24"}},
+				Rules: []api.Rule{{Protocol: "tcp", Ports: []uint{80}}},
+			},
+		},
+	}
+
+	iptables, sets, err := Render([]api.Policy{policy}, blocks, "host1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ruleset, err := renderNftables(iptables, sets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Log(ruleset)
+
+	tenantSet := policytools.MakeTenantSetName("tenant-a", "")
+	segmentSet := policytools.MakeTenantSetName("tenant-a", "backend")
+	for _, expect := range []string{
+		"table ip romana\ndelete table ip romana\ntable ip romana {\n",
+		// list:set is flattened into elements of its member sets.
+		fmt.Sprintf("\tset %s {\n\t\ttype ipv4_addr\n\t\tflags interval\n\t\tauto-merge\n\t\telements = { 10.0.0.0/28, 10.0.1.0/28 }\n", tenantSet),
+		fmt.Sprintf("ip daddr @%s jump %s", segmentSet, policytools.MakeRomanaPolicyNameExtended(policy)),
+		"ip saddr 192.168.0.0/24 jump",
+		"tcp dport 80 accept",
+		"type filter hook forward priority 0; policy accept;",
+		`oifname "romana-*" jump ROMANA-FORWARD-IN`,
+	} {
+		if !strings.Contains(ruleset, expect) {
+			t.Errorf("expected %q in ruleset", expect)
+		}
+	}
+
+	// chains must be defined before they are jumped to.
+	for _, pair := range [][2]string{
+		{"chain ROMANA-FORWARD-IN {", "chain ROMANA-FORWARD-OUT {"},
+		{"chain " + policytools.MakeRomanaPolicyName(policy) + " {", "chain ROMANA-FORWARD-IN {"},
+		{"chain ROMANA-FORWARD-OUT {", "chain forward {"},
+	} {
+		if strings.Index(ruleset, pair[0]) > strings.Index(ruleset, pair[1]) {
+			t.Errorf("expected %q before %q", pair[0], pair[1])
+		}
+	}
+}
+
+func TestApplyNftables(t *testing.T) {
+	NftBin = "nft"
+	exec := &utilexec.FakeExecutor{}
+
+	if !ValidateNftables("table ip romana\n", exec) {
+		t.Fatal("validation failed")
+	}
+	if err := ApplyNftables("table ip romana\n", exec); err != nil {
+		t.Fatal(err)
+	}
+
+	commands := strings.Split(*exec.Commands, "\n")
+	if len(commands) != 2 ||
+		!strings.HasPrefix(commands[0], "nft --check -f ") ||
+		!strings.HasPrefix(commands[1], "nft -f ") {
+		t.Errorf("unexpected commands %q", commands)
+	}
+
+	exec = &utilexec.FakeExecutor{Error: fmt.Errorf("syntax error")}
+	if ValidateNftables("bad", exec) {
+		t.Error("expected validation to fail")
+	}
+}
+
+func TestIptablesFlushRules(t *testing.T) {
+	current := &iptsave.IPtables{}
+	current.Parse(strings.NewReader(`*filter
+:INPUT ACCEPT [0:0]
+:ROMANA-INPUT - [0:0]
+:ROMANA-FORWARD-IN - [0:0]
+:ROMANA-OUTPUT - [0:0]
+-A INPUT -i romana-12345678 -j ROMANA-INPUT
+-A ROMANA-INPUT -j ACCEPT
+-A ROMANA-FORWARD-IN -j DROP
+COMMIT
+`))
+
+	flush := iptablesFlushRules(current)
+	if flush == nil {
+		t.Fatal("expected romana chains to flush")
+	}
+	expected := `*filter
+:ROMANA-INPUT - [0:0]
+:ROMANA-FORWARD-IN - [0:0]
+COMMIT
+`
+	if flush.Render() != expected {
+		t.Errorf("unexpected flush rules\n%s\nexpected\n%s", flush.Render(), expected)
+	}
+
+	current = &iptsave.IPtables{}
+	current.Parse(strings.NewReader("*filter\n:INPUT ACCEPT [0:0]\n:ROMANA-INPUT - [0:0]\nCOMMIT\n"))
+	if flush := iptablesFlushRules(current); flush != nil {
+		t.Errorf("expected nothing to flush, got\n%s", flush.Render())
+	}
+}
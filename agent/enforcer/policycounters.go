@@ -0,0 +1,176 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package enforcer
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"time"
+
+	utilexec "github.com/romana/core/agent/exec"
+	"github.com/romana/core/agent/iptsave"
+	"github.com/romana/core/common/api"
+	"github.com/romana/core/pkg/policytools"
+
+	log "github.com/romana/rlog"
+)
+
+// policyCountersInterval is how often iptables counters
+// of policy chains are collected.
+const policyCountersInterval = 30 * time.Second
+
+// policyCounterKey identifies a set of rules in policy chains
+// which are reported together.
+type policyCounterKey struct {
+	policyID  string
+	direction string
+	verdict   string
+}
+
+// policyCounterValues is a number of packets and bytes
+// matched by the rules.
+type policyCounterValues struct {
+	packets uint64
+	bytes   uint64
+}
+
+// readPolicyCounters sums up counters of the rules in policy chains
+// produced by policytools.MakeRomanaPolicyNameRules, rules are grouped
+// by policy ID, direction and their verdict e.g. accept.
+func readPolicyCounters(iptables *iptsave.IPtables, policies []api.Policy) map[policyCounterKey]policyCounterValues {
+	result := make(map[policyCounterKey]policyCounterValues)
+
+	filter := iptables.TableByName("filter")
+	if filter == nil {
+		return result
+	}
+
+	for _, policy := range policies {
+		for _, directed := range policytools.SplitPolicy(policy) {
+			chain := filter.ChainByName(policytools.MakeRomanaPolicyNameRules(directed))
+			if chain == nil {
+				continue
+			}
+
+			for _, rule := range chain.Rules {
+				if rule.Counters == "" {
+					continue
+				}
+
+				packets, bytes, err := iptsave.ParseCounters(rule.Counters)
+				if err != nil {
+					log.Debugf("Skipping counters of rule %s in chain %s, %s", rule, chain.Name, err)
+					continue
+				}
+
+				key := policyCounterKey{
+					policyID:  policy.ID,
+					direction: directed.Direction,
+					verdict:   strings.ToLower(rule.Action.Body),
+				}
+				values := result[key]
+				values.packets += packets
+				values.bytes += bytes
+				result[key] = values
+			}
+		}
+	}
+
+	return result
+}
+
+// policyCounters converts iptables counters, which are reset whenever
+// policy chains are re-applied, into monotonic prometheus counters.
+type policyCounters struct {
+	last map[policyCounterKey]policyCounterValues
+}
+
+func newPolicyCounters() *policyCounters {
+	return &policyCounters{last: make(map[policyCounterKey]policyCounterValues)}
+}
+
+// delta returns the difference between current and last seen values.
+// Values lower than the last seen ones mean that iptables counters
+// were reset, in which case current values are returned as is.
+func (p *policyCounters) delta(current map[policyCounterKey]policyCounterValues) map[policyCounterKey]policyCounterValues {
+	result := make(map[policyCounterKey]policyCounterValues)
+	for key, values := range current {
+		last := p.last[key]
+		if values.packets >= last.packets && values.bytes >= last.bytes {
+			values.packets -= last.packets
+			values.bytes -= last.bytes
+		}
+		result[key] = values
+	}
+
+	return result
+}
+
+// update adds counters of policy chains to prometheus metrics
+// and removes metrics of the policies that are gone.
+func (p *policyCounters) update(current map[policyCounterKey]policyCounterValues) {
+	for key, values := range p.delta(current) {
+		NumPolicyPackets.WithLabelValues(key.policyID, key.direction, key.verdict).Add(float64(values.packets))
+		NumPolicyBytes.WithLabelValues(key.policyID, key.direction, key.verdict).Add(float64(values.bytes))
+	}
+
+	for key := range p.last {
+		if _, ok := current[key]; !ok {
+			NumPolicyPackets.DeleteLabelValues(key.policyID, key.direction, key.verdict)
+			NumPolicyBytes.DeleteLabelValues(key.policyID, key.direction, key.verdict)
+		}
+	}
+
+	p.last = current
+}
+
+// LoadIPtablesCounters calls iptables-save -c for the filter table
+// and parses the result, with counters, into iptsave.IPtables.
+func LoadIPtablesCounters(exec utilexec.Executable) (*iptsave.IPtables, error) {
+	iptables := &iptsave.IPtables{}
+	rawIptablesSave, err := exec.Exec(IptablesSaveBin, []string{"-c", "-t", "filter"})
+	if err != nil {
+		return iptables, err
+	}
+
+	iptables.Parse(bytes.NewReader(rawIptablesSave))
+
+	return iptables, nil
+}
+
+// collectPolicyCounters periodically exports counters
+// of policy chains as prometheus metrics.
+func (a *Enforcer) collectPolicyCounters(ctx context.Context) {
+	counters := newPolicyCounters()
+	ticker := time.NewTicker(policyCountersInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			iptables, err := LoadIPtablesCounters(a.exec)
+			if err != nil {
+				log.Errorf("Failed to read iptables counters, %s", err)
+				ErrPolicyCounters.Inc()
+				continue
+			}
+			counters.update(readPolicyCounters(iptables, a.policyCache.List()))
+		case <-ctx.Done():
+			return
+		}
+	}
+}
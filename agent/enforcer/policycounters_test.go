@@ -0,0 +1,117 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package enforcer
+
+import (
+	"fmt"
+	"testing"
+
+	utilexec "github.com/romana/core/agent/exec"
+	"github.com/romana/core/common/api"
+	"github.com/romana/core/pkg/policytools"
+)
+
+func TestReadPolicyCounters(t *testing.T) {
+	policy := api.Policy{
+		ID:        "pol1",
+		Direction: api.PolicyDirectionIngress,
+		AppliedTo: []api.Endpoint{{TenantID: "tenant-a"}},
+		Ingress: []api.RomanaIngress{
+			{
+				Peers: []api.Endpoint{{Peer: "any"}},
+				Rules: []api.Rule{{Protocol: "tcp", Ports: []uint{80, 443}}},
+			},
+		},
+		Egress: []api.RomanaEgress{
+			{
+				Peers: []api.Endpoint{{Cidr: "10.0.0.0/8"}},
+				Rules: []api.Rule{{Protocol: "udp"}},
+			},
+		},
+	}
+
+	var egressChain, ingressChain string
+	for _, directed := range policytools.SplitPolicy(policy) {
+		if directed.Direction == api.PolicyDirectionEgress {
+			egressChain = policytools.MakeRomanaPolicyNameRules(directed)
+		} else {
+			ingressChain = policytools.MakeRomanaPolicyNameRules(directed)
+		}
+	}
+
+	iptablesSave := fmt.Sprintf(`*filter
+:INPUT ACCEPT [100:10000]
+:%[1]s - [0:0]
+:%[2]s - [0:0]
+[1:100] -A INPUT -j ACCEPT
+[10:1000] -A %[1]s -p udp -j ACCEPT
+[2:200] -A %[2]s -p tcp -m tcp --dport 80 -j ACCEPT
+[3:300] -A %[2]s -p tcp -m tcp --dport 443 -j ACCEPT
+COMMIT
+`, egressChain, ingressChain)
+
+	IptablesSaveBin = "iptables-save"
+	exec := &utilexec.FakeExecutor{Output: []byte(iptablesSave)}
+	iptables, err := LoadIPtablesCounters(exec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *exec.Commands != "iptables-save -c -t filter" {
+		t.Errorf("unexpected command %q", *exec.Commands)
+	}
+
+	counters := readPolicyCounters(iptables, []api.Policy{policy})
+	expect := map[policyCounterKey]policyCounterValues{
+		{"pol1", api.PolicyDirectionEgress, "accept"}:  {10, 1000},
+		{"pol1", api.PolicyDirectionIngress, "accept"}: {5, 500},
+	}
+	if len(counters) != len(expect) {
+		t.Fatalf("expected %v, got %v", expect, counters)
+	}
+	for key, values := range expect {
+		if counters[key] != values {
+			t.Errorf("expected %v for %v, got %v", values, key, counters[key])
+		}
+	}
+}
+
+func TestPolicyCountersDelta(t *testing.T) {
+	key := policyCounterKey{"pol1", api.PolicyDirectionIngress, "accept"}
+	counters := newPolicyCounters()
+
+	for i, tc := range []struct {
+		current policyCounterValues
+		expect  policyCounterValues
+	}{
+		{policyCounterValues{5, 500}, policyCounterValues{5, 500}},
+		{policyCounterValues{7, 700}, policyCounterValues{2, 200}},
+		{policyCounterValues{7, 700}, policyCounterValues{0, 0}},
+		// counters were reset when rules were re-applied.
+		{policyCounterValues{1, 100}, policyCounterValues{1, 100}},
+	} {
+		current := map[policyCounterKey]policyCounterValues{key: tc.current}
+		delta := counters.delta(current)
+		if delta[key] != tc.expect {
+			t.Errorf("step %d: expected %v, got %v", i, tc.expect, delta[key])
+		}
+		counters.update(current)
+	}
+
+	counters.update(map[policyCounterKey]policyCounterValues{})
+	if len(counters.last) != 0 {
+		t.Errorf("expected counters of removed policy to be forgotten, got %v", counters.last)
+	}
+}
@@ -35,6 +35,14 @@ const (
 	ChainNameHostToEndpoint  = "ROMANA-FORWARD-IN"
 	ChainNameEndpointEgress  = "ROMANA-FORWARD-OUT"
 	ChainNameEndpointIngress = "ROMANA-FORWARD-IN"
+
+	// ChainNameEgressPolicy hosts policies of Egress sections
+	// and default drops for their targets.
+	ChainNameEgressPolicy = "ROMANA-EGRESS"
+	// ChainNameEgressAllowed takes the traffic allowed by Egress
+	// sections, traffic to local endpoints still needs to pass
+	// ingress policies.
+	ChainNameEgressAllowed = "ROMANA-EGRESS-ALLOWED"
 )
 
 var (
@@ -0,0 +1,112 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package agent
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// garpCount is the number of gratuitous ARP packets sent
+	// after romana VIP is added, in case some of them are lost.
+	garpCount    = 3
+	garpInterval = 200 * time.Millisecond
+
+	etherTypeARP  = 0x0806
+	etherTypeIPv4 = 0x0800
+	arpRequest    = 1
+)
+
+// htons converts a short from host to network byte order.
+func htons(i uint16) uint16 {
+	return i<<8&0xff00 | i>>8
+}
+
+// gratuitousARP builds an ethernet frame with ARP request
+// announcing that ip belongs to the hardware address.
+func gratuitousARP(hwAddr net.HardwareAddr, ip net.IP) ([]byte, error) {
+	ip4 := ip.To4()
+	if ip4 == nil {
+		return nil, fmt.Errorf("gratuitous ARP is only supported for IPv4 addresses, got %s", ip)
+	}
+	if len(hwAddr) != 6 {
+		return nil, fmt.Errorf("unsupported hardware address %s", hwAddr)
+	}
+
+	frame := make([]byte, 0, 42)
+
+	// Ethernet header
+	frame = append(frame, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff)
+	frame = append(frame, hwAddr...)
+	frame = append(frame, etherTypeARP>>8, etherTypeARP&0xff)
+
+	// ARP request where sender and target protocol
+	// addresses are both set to the announced ip.
+	arp := make([]byte, 8)
+	binary.BigEndian.PutUint16(arp[0:], 1) // ethernet
+	binary.BigEndian.PutUint16(arp[2:], etherTypeIPv4)
+	arp[4] = 6
+	arp[5] = 4
+	binary.BigEndian.PutUint16(arp[6:], arpRequest)
+	frame = append(frame, arp...)
+	frame = append(frame, hwAddr...)
+	frame = append(frame, ip4...)
+	frame = append(frame, 0, 0, 0, 0, 0, 0)
+	frame = append(frame, ip4...)
+
+	return frame, nil
+}
+
+// SendGratuitousARP announces ip on the link, so that neighbours
+// update their ARP caches when romana VIP moves to this node.
+func SendGratuitousARP(link netlink.Link, ip net.IP) error {
+	attrs := link.Attrs()
+	frame, err := gratuitousARP(attrs.HardwareAddr, ip)
+	if err != nil {
+		return err
+	}
+
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_RAW, int(htons(etherTypeARP)))
+	if err != nil {
+		return fmt.Errorf("error opening packet socket: %s", err)
+	}
+	defer unix.Close(fd)
+
+	addr := &unix.SockaddrLinklayer{
+		Protocol: htons(etherTypeARP),
+		Ifindex:  attrs.Index,
+		Halen:    6,
+	}
+	copy(addr.Addr[:], []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+
+	for i := 0; i < garpCount; i++ {
+		if i > 0 {
+			time.Sleep(garpInterval)
+		}
+		if err := unix.Sendto(fd, frame, 0, addr); err != nil {
+			return fmt.Errorf("error sending gratuitous ARP for %s on %s: %s",
+				ip, attrs.Name, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,53 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package agent
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+func TestGratuitousARP(t *testing.T) {
+	hwAddr, _ := net.ParseMAC("02:42:ac:11:00:02")
+	frame, err := gratuitousARP(hwAddr, net.ParseIP("192.168.99.200"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []byte{
+		// ethernet header
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+		0x02, 0x42, 0xac, 0x11, 0x00, 0x02,
+		0x08, 0x06,
+		// arp request
+		0x00, 0x01, 0x08, 0x00, 0x06, 0x04, 0x00, 0x01,
+		0x02, 0x42, 0xac, 0x11, 0x00, 0x02,
+		192, 168, 99, 200,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		192, 168, 99, 200,
+	}
+	if !bytes.Equal(frame, expected) {
+		t.Errorf("expected\n%v\ngot\n%v", expected, frame)
+	}
+
+	if _, err = gratuitousARP(hwAddr, net.ParseIP("fd00::1")); err == nil {
+		t.Errorf("expected error for IPv6 address")
+	}
+	if _, err = gratuitousARP(nil, net.ParseIP("192.168.99.200")); err == nil {
+		t.Errorf("expected error for link without hardware address")
+	}
+}
@@ -34,6 +34,10 @@ var BuiltinChains = []string{"INPUT", "OUTPUT", "FORWARD", "PREROUTING", "POSTRO
 type IPtables struct {
 	Tables      []*IPtable
 	currentRule *IPrule
+
+	// currentCounters holds counters of the rule
+	// that is about to be parsed.
+	currentCounters string
 }
 
 // lastTable returns pointer to the last IPtable in IPtables.
@@ -216,6 +220,10 @@ type IPrule struct {
 	// match = -m matchname [per-match-options]
 	Match  []*Match
 	Action IPtablesAction
+
+	// Counters of the rule as reported by iptables-save -c,
+	// e.g. [12:3456]. Counters are not rendered.
+	Counters string
 }
 
 type RenderState int
@@ -354,6 +362,9 @@ func (i *IPtables) parseItem(item Item) {
 		} // TODO crash here
 
 		chain.Counters = item.Body
+	case itemRuleCounter:
+		// If item is a rule counter, keep it for the rule that follows.
+		i.currentCounters = item.Body
 	case itemCommit:
 		// Ignore COMMIT items.
 		return // TODO, ignored for now, should probably be in the model
@@ -366,8 +377,9 @@ func (i *IPtables) parseItem(item Item) {
 			panic("Rule before table/chain")
 		} // TODO crash here
 
-		newRule := new(IPrule)
+		newRule := &IPrule{Counters: i.currentCounters}
 		chain.Rules = append(chain.Rules, newRule)
+		i.currentCounters = ""
 
 		i.currentRule = newRule
 	case itemRuleMatch:
@@ -389,6 +401,16 @@ func (i *IPtables) parseItem(item Item) {
 	return
 }
 
+// ParseCounters parses packet and byte counters of a chain
+// or a rule, e.g. [12:3456].
+func ParseCounters(counters string) (packets uint64, bytes uint64, err error) {
+	_, err = fmt.Sscanf(counters, "[%d:%d]", &packets, &bytes)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid counters %q, %s", counters, err)
+	}
+	return packets, bytes, nil
+}
+
 // Render produces iptables-restore compatible representation of current structure.
 func (i *IPtables) Render() string {
 	var result string
@@ -103,3 +103,47 @@ func TestRuleParser(t *testing.T) {
 		t.Errorf("%s\n%s", chain.Name, chain.Rules[0].String())
 	}
 }
+
+func TestParseRuleCounters(t *testing.T) {
+	input := `*filter
+:INPUT ACCEPT [10:2000]
+:MYCHAIN - [0:0]
+[5:300] -A MYCHAIN -p tcp --dport 80 -j ACCEPT
+-A MYCHAIN -j RETURN
+COMMIT
+`
+	iptables := IPtables{}
+	iptables.Parse(bytes.NewReader([]byte(input)))
+
+	filter := iptables.TableByName("filter")
+	if filter == nil {
+		t.Fatalf("filter table not parsed from\n%s", input)
+	}
+
+	chain := filter.ChainByName("MYCHAIN")
+	if chain == nil || len(chain.Rules) != 2 {
+		t.Fatalf("unexpected chain %v", chain)
+	}
+
+	if chain.Rules[0].Counters != "[5:300]" || chain.Rules[1].Counters != "" {
+		t.Errorf("unexpected rule counters %q, %q", chain.Rules[0].Counters, chain.Rules[1].Counters)
+	}
+
+	if chain.Rules[0].String() != "-p tcp --dport 80 -j ACCEPT" {
+		t.Errorf("unexpected rule %s", chain.Rules[0])
+	}
+
+	packets, bytes, err := ParseCounters(chain.Rules[0].Counters)
+	if err != nil || packets != 5 || bytes != 300 {
+		t.Errorf("unexpected counters %d:%d, %v", packets, bytes, err)
+	}
+
+	packets, bytes, err = ParseCounters(filter.ChainByName("INPUT").Counters)
+	if err != nil || packets != 10 || bytes != 2000 {
+		t.Errorf("unexpected chain counters %d:%d, %v", packets, bytes, err)
+	}
+
+	if _, _, err := ParseCounters("[5]"); err == nil {
+		t.Error("expected error for invalid counters")
+	}
+}
@@ -143,7 +143,7 @@ func rootState(l *Lexer) stateFn {
 	for {
 		b := l.nextByte()
 
-		// There are 5 states we can go from root.
+		// There are 6 states we can go from root.
 		switch string(b) {
 		case string(endOfText):
 			return l.errorEof("EOF reached in root section")
@@ -162,6 +162,9 @@ func rootState(l *Lexer) stateFn {
 				log.Trace(trace.Inside, "In root state, switching into the rule state")
 				return stateInRule
 			}
+		case "[":
+			log.Trace(trace.Inside, "In root state, switching into the rule counter state")
+			return stateInRuleCounter
 		case "C":
 			// Whenever we arrive at "C" we need to check if it is a "COMMIT" token.
 			if l.accept("OMMIT\n") {
@@ -307,6 +310,34 @@ func stateInChainCounter(l *Lexer) stateFn {
 	}
 }
 
+// stateInRuleCounter consumes counters of the rule produced
+// by iptables-save -c, e.g. [12:3456] -A MYCHAIN ...
+func stateInRuleCounter(l *Lexer) stateFn {
+	log.Trace(trace.Private, "In rule counter state")
+
+	item := Item{Type: itemRuleCounter, Body: "["}
+	for {
+		b := l.nextByte()
+		c := string(b)
+
+		switch c {
+		case string(endOfText):
+			return l.errorf("Error: unexpected EOF in rule counter section")
+		case "\n":
+			return l.errorf("Unexpectend end of line in rule counter state")
+		case "]":
+			item.Body += c
+			l.items <- item
+			if l.accept(" -A ") {
+				return stateInRule
+			}
+			return l.errorf("Error: rule counter is not followed by a rule")
+		default:
+			item.Body += c
+		}
+	}
+}
+
 func stateInRule(l *Lexer) stateFn {
 	log.Trace(trace.Private, "In rule state")
 
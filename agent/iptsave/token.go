@@ -41,6 +41,7 @@ const (
 	itemAction
 	itemOptions
 	itemCommit
+	itemRuleCounter
 )
 
 func (i ItemType) String() string {
@@ -71,6 +72,8 @@ func (i ItemType) String() string {
 		return fmt.Sprintf("Options")
 	case itemCommit:
 		return fmt.Sprintf("Commit")
+	case itemRuleCounter:
+		return fmt.Sprintf("RuleCounter")
 	default:
 		return fmt.Sprintf("Unknown item")
 	}
@@ -32,6 +32,30 @@ var (
 			Help: "Number of routes managed by Romana agent on the host.",
 		},
 	)
+	NumRoutesAdded = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "romana_routes_added_total",
+			Help: "Number of routes added to Romana route table.",
+		},
+	)
+	NumRoutesReplaced = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "romana_routes_replaced_total",
+			Help: "Number of routes in Romana route table replaced due to gateway change.",
+		},
+	)
+	NumRoutesRemoved = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "romana_routes_removed_total",
+			Help: "Number of routes removed from Romana route table.",
+		},
+	)
+	NumRoutesUnchanged = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "romana_routes_unchanged",
+			Help: "Number of routes left unchanged by the last route reconciliation.",
+		},
+	)
 )
 
 func MetricStart(port int) error {
@@ -45,9 +69,17 @@ func MetricStart(port int) error {
 		return err
 	}
 
-	err = registry.Register(NumManagedRoutes)
-	if err != nil {
-		return err
+	for _, collector := range []prometheus.Collector{
+		NumManagedRoutes,
+		NumRoutesAdded,
+		NumRoutesReplaced,
+		NumRoutesRemoved,
+		NumRoutesUnchanged,
+	} {
+		err = registry.Register(collector)
+		if err != nil {
+			return err
+		}
 	}
 
 	handler := promhttp.HandlerFor(registry, promhttp.HandlerOpts{ErrorHandling: promhttp.HTTPErrorOnError})
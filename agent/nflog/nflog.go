@@ -0,0 +1,300 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+// Package nflog receives packets logged by iptables NFLOG target
+// through netfilter netlink socket.
+package nflog
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Constants from linux/netfilter/nfnetlink_log.h.
+const (
+	nfnlSubsysUlog = 4
+
+	nfulnlMsgPacket = 0
+	nfulnlMsgConfig = 1
+
+	nfulaPayload = 9
+	nfulaPrefix  = 10
+
+	nfulaCfgCmd  = 1
+	nfulaCfgMode = 2
+
+	nfulnlCfgCmdBind     = 1
+	nfulnlCfgCmdPfBind   = 3
+	nfulnlCfgCmdPfUnbind = 4
+
+	nfulnlCopyPacket = 2
+
+	// nlaTypeMask strips nested and byte order flags from attribute type.
+	nlaTypeMask = 0x3fff
+
+	nfgenmsgLen = 4
+
+	// copyRange is how many bytes of a packet are copied to userspace,
+	// enough for IP and transport headers.
+	copyRange = 128
+
+	receiveBufferSize = 65536
+)
+
+var nativeEndian binary.ByteOrder = func() binary.ByteOrder {
+	var x uint16 = 1
+	if *(*byte)(unsafe.Pointer(&x)) == 1 {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}()
+
+// Packet is a packet logged by NFLOG target.
+type Packet struct {
+	// Prefix is the value of --nflog-prefix of the rule that logged the packet.
+	Prefix string
+
+	// Payload holds the beginning of the packet starting with IP header.
+	Payload []byte
+}
+
+// Conn is a netlink connection bound to NFLOG group.
+type Conn struct {
+	fd    int
+	group uint16
+	seq   uint32
+}
+
+// Open binds to the NFLOG group, packets logged with
+// --nflog-group group are then available through Receive.
+func Open(group uint16) (*Conn, error) {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW, unix.NETLINK_NETFILTER)
+	if err != nil {
+		return nil, fmt.Errorf("error opening netfilter netlink socket: %s", err)
+	}
+
+	if err := unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("error binding netfilter netlink socket: %s", err)
+	}
+
+	conn := &Conn{fd: fd, group: group}
+
+	// binding protocol family is only required by old kernels
+	// and is a no-op on the new ones.
+	for _, cmd := range []uint8{nfulnlCfgCmdPfUnbind, nfulnlCfgCmdPfBind} {
+		if err := conn.config(unix.AF_INET, 0, nfulaCfgCmd, []byte{cmd}); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	if err := conn.config(unix.AF_UNSPEC, group, nfulaCfgCmd, []byte{nfulnlCfgCmdBind}); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	// struct nfulnl_msg_config_mode
+	mode := make([]byte, 6)
+	binary.BigEndian.PutUint32(mode, copyRange)
+	mode[4] = nfulnlCopyPacket
+	if err := conn.config(unix.AF_UNSPEC, group, nfulaCfgMode, mode); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+// config sends NFULNL_MSG_CONFIG message with one attribute.
+func (c *Conn) config(family uint8, resID uint16, attrType uint16, attrData []byte) error {
+	c.seq++
+	msg := makeConfigMessage(c.seq, family, resID, attrType, attrData)
+	if err := unix.Sendto(c.fd, msg, 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return fmt.Errorf("error configuring nflog group %d: %s", c.group, err)
+	}
+
+	return nil
+}
+
+// SetReadTimeout limits how long Receive waits for packets,
+// after the timeout Receive returns unix.EAGAIN.
+func (c *Conn) SetReadTimeout(timeout time.Duration) error {
+	tv := unix.NsecToTimeval(timeout.Nanoseconds())
+	return unix.SetsockoptTimeval(c.fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &tv)
+}
+
+// Receive blocks until logged packets are available.
+func (c *Conn) Receive() ([]Packet, error) {
+	buf := make([]byte, receiveBufferSize)
+	n, _, err := unix.Recvfrom(c.fd, buf, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseMessages(buf[:n])
+}
+
+// Close closes netlink connection.
+func (c *Conn) Close() error {
+	return unix.Close(c.fd)
+}
+
+// makeConfigMessage builds netlink message that configures nflog.
+func makeConfigMessage(seq uint32, family uint8, resID uint16, attrType uint16, attrData []byte) []byte {
+	attrLen := unix.NLA_HDRLEN + len(attrData)
+	msgLen := unix.NLMSG_HDRLEN + nfgenmsgLen + align(attrLen)
+
+	msg := make([]byte, msgLen)
+	nativeEndian.PutUint32(msg[0:], uint32(msgLen))
+	nativeEndian.PutUint16(msg[4:], nfnlSubsysUlog<<8|nfulnlMsgConfig)
+	nativeEndian.PutUint16(msg[6:], unix.NLM_F_REQUEST)
+	nativeEndian.PutUint32(msg[8:], seq)
+
+	// struct nfgenmsg
+	genmsg := msg[unix.NLMSG_HDRLEN:]
+	genmsg[0] = family
+	genmsg[1] = unix.NFNETLINK_V0
+	binary.BigEndian.PutUint16(genmsg[2:], resID)
+
+	attr := genmsg[nfgenmsgLen:]
+	nativeEndian.PutUint16(attr[0:], uint16(attrLen))
+	nativeEndian.PutUint16(attr[2:], attrType)
+	copy(attr[unix.NLA_HDRLEN:], attrData)
+
+	return msg
+}
+
+// parseMessages extracts logged packets from netlink messages.
+func parseMessages(buf []byte) ([]Packet, error) {
+	msgs, err := syscall.ParseNetlinkMessage(buf)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing netlink message: %s", err)
+	}
+
+	var packets []Packet
+	for _, msg := range msgs {
+		switch msg.Header.Type {
+		case unix.NLMSG_ERROR:
+			if len(msg.Data) >= 4 {
+				if errno := int32(nativeEndian.Uint32(msg.Data)); errno != 0 {
+					return packets, fmt.Errorf("netlink error: %s", syscall.Errno(-errno))
+				}
+			}
+			continue
+		case nfnlSubsysUlog<<8 | nfulnlMsgPacket:
+		default:
+			continue
+		}
+
+		if len(msg.Data) < nfgenmsgLen {
+			return packets, fmt.Errorf("nflog message is too short")
+		}
+
+		var packet Packet
+		data := msg.Data[nfgenmsgLen:]
+		for len(data) >= unix.NLA_HDRLEN {
+			attrLen := int(nativeEndian.Uint16(data[0:]))
+			attrType := nativeEndian.Uint16(data[2:]) & nlaTypeMask
+			if attrLen < unix.NLA_HDRLEN || attrLen > len(data) {
+				return packets, fmt.Errorf("malformed nflog attribute")
+			}
+
+			value := data[unix.NLA_HDRLEN:attrLen]
+			switch attrType {
+			case nfulaPayload:
+				packet.Payload = append([]byte(nil), value...)
+			case nfulaPrefix:
+				packet.Prefix = cString(value)
+			}
+
+			if align(attrLen) >= len(data) {
+				break
+			}
+			data = data[align(attrLen):]
+		}
+
+		packets = append(packets, packet)
+	}
+
+	return packets, nil
+}
+
+// Flow describes a logged packet.
+type Flow struct {
+	Src      net.IP
+	Dst      net.IP
+	Protocol string
+	SrcPort  uint16
+	DstPort  uint16
+}
+
+// ParseFlow decodes IPv4 header of the packet, and the ports
+// of TCP and UDP packets.
+func ParseFlow(payload []byte) (Flow, error) {
+	var flow Flow
+
+	if len(payload) < 20 || payload[0]>>4 != 4 {
+		return flow, fmt.Errorf("not an IPv4 packet")
+	}
+
+	headerLen := int(payload[0]&0x0f) * 4
+	if headerLen < 20 || headerLen > len(payload) {
+		return flow, fmt.Errorf("malformed IPv4 header")
+	}
+
+	flow.Src = net.IP(append([]byte(nil), payload[12:16]...))
+	flow.Dst = net.IP(append([]byte(nil), payload[16:20]...))
+
+	switch proto := payload[9]; proto {
+	case unix.IPPROTO_TCP, unix.IPPROTO_UDP:
+		flow.Protocol = "tcp"
+		if proto == unix.IPPROTO_UDP {
+			flow.Protocol = "udp"
+		}
+		// ports are not available in fragments other than the first one.
+		fragmentOffset := binary.BigEndian.Uint16(payload[6:]) & 0x1fff
+		if fragmentOffset == 0 && len(payload) >= headerLen+4 {
+			flow.SrcPort = binary.BigEndian.Uint16(payload[headerLen:])
+			flow.DstPort = binary.BigEndian.Uint16(payload[headerLen+2:])
+		}
+	case unix.IPPROTO_ICMP:
+		flow.Protocol = "icmp"
+	default:
+		flow.Protocol = fmt.Sprintf("%d", proto)
+	}
+
+	return flow, nil
+}
+
+func align(length int) int {
+	return (length + unix.NLA_ALIGNTO - 1) & ^(unix.NLA_ALIGNTO - 1)
+}
+
+// cString converts null terminated string.
+func cString(b []byte) string {
+	for i, c := range b {
+		if c == 0 {
+			return string(b[:i])
+		}
+	}
+	return string(b)
+}
@@ -0,0 +1,145 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package nflog
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// makeAttr builds netlink attribute padded to alignment.
+func makeAttr(attrType uint16, value []byte) []byte {
+	attr := make([]byte, align(unix.NLA_HDRLEN+len(value)))
+	nativeEndian.PutUint16(attr[0:], uint16(unix.NLA_HDRLEN+len(value)))
+	nativeEndian.PutUint16(attr[2:], attrType)
+	copy(attr[unix.NLA_HDRLEN:], value)
+	return attr
+}
+
+// makePacketMessage builds NFULNL_MSG_PACKET message.
+func makePacketMessage(attrs ...[]byte) []byte {
+	body := []byte{unix.AF_INET, unix.NFNETLINK_V0, 0, 100}
+	for _, attr := range attrs {
+		body = append(body, attr...)
+	}
+
+	msg := make([]byte, unix.NLMSG_HDRLEN, unix.NLMSG_HDRLEN+len(body))
+	nativeEndian.PutUint32(msg[0:], uint32(unix.NLMSG_HDRLEN+len(body)))
+	nativeEndian.PutUint16(msg[4:], nfnlSubsysUlog<<8|nfulnlMsgPacket)
+	return append(msg, body...)
+}
+
+// makeIPv4 builds IPv4 header followed by TCP ports.
+func makeIPv4(proto uint8, src, dst []byte, srcPort, dstPort uint16) []byte {
+	packet := make([]byte, 24)
+	packet[0] = 0x45
+	packet[9] = proto
+	copy(packet[12:], src)
+	copy(packet[16:], dst)
+	binary.BigEndian.PutUint16(packet[20:], srcPort)
+	binary.BigEndian.PutUint16(packet[22:], dstPort)
+	return packet
+}
+
+func TestParseMessages(t *testing.T) {
+	payload := makeIPv4(unix.IPPROTO_TCP, []byte{10, 0, 0, 1}, []byte{10, 0, 1, 1}, 40000, 80)
+
+	var buf []byte
+	buf = append(buf, makePacketMessage(
+		makeAttr(1, []byte{0, 8, 3, 0}),
+		makeAttr(nfulaPrefix, []byte("ROMANA-FORWARD-IN\x00")),
+		makeAttr(nfulaPayload, payload),
+	)...)
+	buf = append(buf, makePacketMessage(
+		makeAttr(nfulaPayload, payload[:21]),
+	)...)
+
+	packets, err := parseMessages(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(packets) != 2 {
+		t.Fatalf("expected 2 packets, got %d", len(packets))
+	}
+	if packets[0].Prefix != "ROMANA-FORWARD-IN" {
+		t.Errorf("unexpected prefix %q", packets[0].Prefix)
+	}
+	if !bytes.Equal(packets[0].Payload, payload) {
+		t.Errorf("unexpected payload %v", packets[0].Payload)
+	}
+	if packets[1].Prefix != "" || len(packets[1].Payload) != 21 {
+		t.Errorf("unexpected packet %+v", packets[1])
+	}
+
+	// attribute longer than the message.
+	malformed := makePacketMessage(makeAttr(nfulaPayload, payload))
+	nativeEndian.PutUint16(malformed[unix.NLMSG_HDRLEN+nfgenmsgLen:], 200)
+	if _, err := parseMessages(malformed); err == nil {
+		t.Error("expected error for malformed attribute")
+	}
+}
+
+func TestMakeConfigMessage(t *testing.T) {
+	msg := makeConfigMessage(1, unix.AF_UNSPEC, 100, nfulaCfgCmd, []byte{nfulnlCfgCmdBind})
+	if len(msg) != unix.NLMSG_HDRLEN+nfgenmsgLen+8 {
+		t.Fatalf("unexpected message length %d", len(msg))
+	}
+	if nativeEndian.Uint32(msg) != uint32(len(msg)) {
+		t.Errorf("unexpected length in header %d", nativeEndian.Uint32(msg))
+	}
+	if nativeEndian.Uint16(msg[4:]) != nfnlSubsysUlog<<8|nfulnlMsgConfig {
+		t.Errorf("unexpected message type %x", nativeEndian.Uint16(msg[4:]))
+	}
+	if binary.BigEndian.Uint16(msg[unix.NLMSG_HDRLEN+2:]) != 100 {
+		t.Errorf("expected group 100 in resource id")
+	}
+	attr := msg[unix.NLMSG_HDRLEN+nfgenmsgLen:]
+	if nativeEndian.Uint16(attr) != 5 || nativeEndian.Uint16(attr[2:]) != nfulaCfgCmd || attr[4] != nfulnlCfgCmdBind {
+		t.Errorf("unexpected attribute %v", attr)
+	}
+}
+
+func TestParseFlow(t *testing.T) {
+	flow, err := ParseFlow(makeIPv4(unix.IPPROTO_UDP, []byte{10, 0, 0, 1}, []byte{8, 8, 8, 8}, 5353, 53))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flow.Src.String() != "10.0.0.1" || flow.Dst.String() != "8.8.8.8" ||
+		flow.Protocol != "udp" || flow.SrcPort != 5353 || flow.DstPort != 53 {
+		t.Errorf("unexpected flow %+v", flow)
+	}
+
+	flow, err = ParseFlow(makeIPv4(unix.IPPROTO_ICMP, []byte{10, 0, 0, 1}, []byte{10, 0, 0, 2}, 0, 0)[:20])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flow.Protocol != "icmp" || flow.DstPort != 0 {
+		t.Errorf("unexpected flow %+v", flow)
+	}
+
+	for _, payload := range [][]byte{
+		nil,
+		make([]byte, 20),
+		append([]byte{0x4f}, make([]byte, 19)...),
+	} {
+		if _, err := ParseFlow(payload); err == nil {
+			t.Errorf("expected error for %v", payload)
+		}
+	}
+}
@@ -34,14 +34,15 @@ const (
 )
 
 func Run(ctx context.Context, key string, client *client.Client, storage policycache.Interface) (<-chan api.Policy, error) {
-	policies, err := client.Store.GetExt(key, store.GetOptions{Recursive: true})
-	if err != nil {
+	// There are no policies to list until the first one is created.
+	policies, err := client.Store.List(key)
+	if err != nil && err != store.ErrKeyNotFound {
 		return nil, errors.Wrap(err, "controller init fail")
 	}
 
-	for _, val := range policies.GetResponse().Node.Nodes {
+	for _, val := range policies {
 		var policy api.Policy
-		err := json.Unmarshal([]byte(val.Value), &policy)
+		err := json.Unmarshal(val.Value, &policy)
 		if err != nil {
 			return nil, errors.Wrap(err, "failed to unmarshal policy")
 		}
@@ -47,21 +47,13 @@ func HashRomanaPolicy(policy api.Policy) string {
 	}
 
 	for _, i := range sorted.Ingress {
-		for _, e := range i.Peers {
-			data = fmt.Sprintf("%s.%s", data, EndpointToString(e))
-		}
-
-		for _, r := range i.Rules {
-			data = fmt.Sprintf("%s.%s%d%d%t", data, r.Protocol, r.IcmpType, r.IcmpCode, r.IsStateful)
-
-			for _, p := range r.Ports {
-				data = fmt.Sprintf("%s%d", data, p)
-			}
+		data = hashSection(data, i.Peers, i.Rules)
+	}
 
-			for _, p := range r.PortRanges {
-				data = fmt.Sprintf("%s%d%d", data, p[0], p[1])
-			}
-		}
+	// Egress is marked separately to keep hashes of policies
+	// without egress section unchanged.
+	for _, e := range sorted.Egress {
+		data = hashSection(fmt.Sprintf("%s.egress", data), e.Peers, e.Rules)
 	}
 
 	hasher := sha1.New()
@@ -72,6 +64,27 @@ func HashRomanaPolicy(policy api.Policy) string {
 
 }
 
+// hashSection appends peers and rules of a policy section to the data.
+func hashSection(data string, peers []api.Endpoint, rules []api.Rule) string {
+	for _, e := range peers {
+		data = fmt.Sprintf("%s.%s", data, EndpointToString(e))
+	}
+
+	for _, r := range rules {
+		data = fmt.Sprintf("%s.%s%d%d%t", data, r.Protocol, r.IcmpType, r.IcmpCode, r.IsStateful)
+
+		for _, p := range r.Ports {
+			data = fmt.Sprintf("%s%d", data, p)
+		}
+
+		for _, p := range r.PortRanges {
+			data = fmt.Sprintf("%s%d%d", data, p[0], p[1])
+		}
+	}
+
+	return data
+}
+
 // HashListOfStrings generates sha1 hash from a list of strings.
 func HashListOfStrings(hashes []string) string {
 	data := strings.Join(hashes, "")
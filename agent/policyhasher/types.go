@@ -31,7 +31,7 @@ import (
 	"github.com/romana/core/common/api"
 )
 
-// PolicyToCanonical sorts romana policy Ingress and AppliedTo fields.
+// PolicyToCanonical sorts romana policy Ingress, Egress and AppliedTo fields.
 func PolicyToCanonical(unsorted api.Policy) api.Policy {
 	sorted := api.Policy{
 		Direction:   unsorted.Direction,
@@ -45,6 +45,10 @@ func PolicyToCanonical(unsorted api.Policy) api.Policy {
 		sorted.Ingress = append(sorted.Ingress, IngressToCanonical(ingress))
 	}
 
+	for _, egress := range unsorted.Egress {
+		sorted.Egress = append(sorted.Egress, EgressToCanonical(egress))
+	}
+
 	return sorted
 }
 
@@ -114,6 +118,11 @@ func IngressToCanonical(unsorted api.RomanaIngress) api.RomanaIngress {
 	return sorted
 }
 
+// EgressToCanonical returns canonical version of common.RomanaEgress.
+func EgressToCanonical(unsorted api.RomanaEgress) api.RomanaEgress {
+	return api.RomanaEgress(IngressToCanonical(api.RomanaIngress(unsorted)))
+}
+
 // UintSlice satisfies sort.Interface to allow sorting of a []uint.
 type UintSlice []uint
 
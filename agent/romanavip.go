@@ -33,6 +33,10 @@ import (
 
 const (
 	defaultWatcherReconnectTime = 5 * time.Second
+
+	// romanaVIPResyncInterval is how often romana VIPs on the
+	// link are reconciled with romana VIPs in the kvstore.
+	romanaVIPResyncInterval = 60 * time.Second
 )
 
 func GetDefaultLink() (netlink.Link, error) {
@@ -92,8 +96,11 @@ func GetIPs(link netlink.Link) ([]string, error) {
 	return addresses, nil
 }
 
+// linkAddDeleteIP adds romana VIP of the event to the link, or deletes
+// it, if it is for this node, keeping track of romana VIPs on the link
+// in added.
 func linkAddDeleteIP(kvpair *kvstore.KVPairExt, toAdd bool,
-	defaultLink netlink.Link, defaultLinkAddressList []string) error {
+	defaultLink netlink.Link, defaultLinkAddressList []string, added map[string]bool) error {
 	var value string
 	var IPAddressOnThisNode bool
 
@@ -138,16 +145,187 @@ func linkAddDeleteIP(kvpair *kvstore.KVPairExt, toAdd bool,
 	}
 
 	if toAdd {
-		return netlink.AddrAdd(defaultLink, ipAddress)
+		if err := netlink.AddrAdd(defaultLink, ipAddress); err != nil {
+			return err
+		}
+		added[ipAddress.IP.String()] = true
+
+		// romana VIP may have been on another node before,
+		// so announce it to make failover quick.
+		go func() {
+			if err := SendGratuitousARP(defaultLink, ipAddress.IP); err != nil {
+				log.Errorf("error announcing romana VIP (%s): %s", ipAddress.IP, err)
+			}
+		}()
+		return nil
+	}
+	if err := netlink.AddrDel(defaultLink, ipAddress); err != nil {
+		return err
+	}
+	delete(added, ipAddress.IP.String())
+	return nil
+}
+
+// romanaVIPChanges compares romana VIPs which should be on this node,
+// i.e. the ones with node IP address among nodeAddresses, with /32
+// addresses on the link, and returns addresses to add to and delete
+// from the link. Invalid romana VIPs are skipped.
+//
+// Only /32 addresses which are romana VIPs of other nodes, or which
+// were added by this agent, i.e. are in added, are deleted. Others,
+// e.g. /32 node address on GCE or VIPs of keepalived or MetalLB,
+// are left alone.
+func romanaVIPChanges(exposedIPs map[string]api.ExposedIPSpec, nodeAddresses []string,
+	linkAddrs []netlink.Addr, added map[string]bool) ([]*netlink.Addr, []*netlink.Addr) {
+
+	isNodeAddress := make(map[string]bool)
+	for _, address := range nodeAddresses {
+		isNodeAddress[address] = true
+	}
+
+	wanted := make(map[string]bool)
+	romanaVIP := make(map[string]bool)
+	var toAdd []*netlink.Addr
+	for key, exposedIP := range exposedIPs {
+		ipAddress, err := netlink.ParseAddr(exposedIP.RomanaVIP.IP + "/32")
+		if err != nil {
+			log.Errorf("error parsing romana VIP (%s) for service (%s): %s",
+				exposedIP.RomanaVIP.IP, key, err)
+			continue
+		}
+		romanaVIP[ipAddress.IP.String()] = true
+
+		if !isNodeAddress[exposedIP.NodeIPAddress] {
+			continue
+		}
+
+		if wanted[ipAddress.IP.String()] {
+			continue
+		}
+		wanted[ipAddress.IP.String()] = true
+		toAdd = append(toAdd, ipAddress)
+	}
+
+	present := make(map[string]bool)
+	var toDelete []*netlink.Addr
+	for i := range linkAddrs {
+		addr := linkAddrs[i]
+		if addr.IPNet == nil {
+			continue
+		}
+		present[addr.IP.String()] = true
+
+		ones, bits := addr.Mask.Size()
+		if ones != 32 || bits != 32 {
+			continue
+		}
+		ip := addr.IP.String()
+		if wanted[ip] || isNodeAddress[ip] {
+			continue
+		}
+		if !romanaVIP[ip] && !added[ip] {
+			continue
+		}
+		toDelete = append(toDelete, &addr)
+	}
+
+	var missing []*netlink.Addr
+	for _, addr := range toAdd {
+		if !present[addr.IP.String()] {
+			missing = append(missing, addr)
+		}
+	}
+
+	return missing, toDelete
+}
+
+// nodeAddresses returns addresses identifying this node, that is
+// addresses of the romana host with given name and addresses on the
+// link which are not /32, since /32 addresses may be romana VIPs.
+// It fails if the romana host is not found, as romana VIPs of this
+// node can't be told apart from the rest then.
+func nodeAddresses(romanaClient *client.Client, hostname string, linkAddrs []netlink.Addr) ([]string, error) {
+	var addresses []string
+
+	host, err := romanaClient.IPAM.GetHost(hostname)
+	if err != nil {
+		return nil, fmt.Errorf("romana host %s not found: %s", hostname, err)
 	}
-	return netlink.AddrDel(defaultLink, ipAddress)
+	for _, ip := range host.Addresses() {
+		addresses = append(addresses, ip.String())
+	}
+
+	for _, addr := range linkAddrs {
+		if addr.IPNet == nil {
+			continue
+		}
+		if ones, bits := addr.Mask.Size(); ones != bits {
+			addresses = append(addresses, addr.IP.String())
+		}
+	}
+
+	return addresses, nil
 }
 
-func StartRomanaVIPSync(ctx context.Context, store *client.Store,
-	defaultLink netlink.Link) error {
+// reconcileRomanaVIPs brings romana VIPs on the link in line with
+// romana VIPs in the kvstore, adding missing ones and deleting the
+// ones which are not for this node anymore, see romanaVIPChanges.
+// Nothing is changed if this node is not a romana host.
+func reconcileRomanaVIPs(romanaClient *client.Client, hostname string, defaultLink netlink.Link, added map[string]bool) error {
+	exposedIPs, err := romanaClient.ListRomanaVIPs()
+	if err != nil {
+		return fmt.Errorf("error listing romana VIPs: %s", err)
+	}
+
+	linkAddrs, err := netlink.AddrList(defaultLink, unix.AF_INET)
+	if err != nil {
+		return fmt.Errorf("error listing addresses on link (%s): %s",
+			defaultLink.Attrs().Name, err)
+	}
+
+	addresses, err := nodeAddresses(romanaClient, hostname, linkAddrs)
+	if err != nil {
+		return err
+	}
+	toAdd, toDelete := romanaVIPChanges(exposedIPs, addresses, linkAddrs, added)
+
+	var failed int
+	for _, addr := range toDelete {
+		log.Infof("deleting stale romana VIP (%s) from link (%s)", addr.IP, defaultLink.Attrs().Name)
+		if err := netlink.AddrDel(defaultLink, addr); err != nil {
+			log.Errorf("error deleting romana VIP (%s): %s", addr.IP, err)
+			failed++
+			continue
+		}
+		delete(added, addr.IP.String())
+	}
+
+	for _, addr := range toAdd {
+		log.Infof("adding romana VIP (%s) to link (%s)", addr.IP, defaultLink.Attrs().Name)
+		if err := netlink.AddrAdd(defaultLink, addr); err != nil {
+			log.Errorf("error adding romana VIP (%s): %s", addr.IP, err)
+			failed++
+			continue
+		}
+		added[addr.IP.String()] = true
+		if err := SendGratuitousARP(defaultLink, addr.IP); err != nil {
+			log.Errorf("error announcing romana VIP (%s): %s", addr.IP, err)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("failed to apply %d out of %d romana VIP changes",
+			failed, len(toAdd)+len(toDelete))
+	}
+
+	return nil
+}
+
+func StartRomanaVIPSync(ctx context.Context, romanaClient *client.Client,
+	hostname string, defaultLink netlink.Link) error {
 	var err error
 
-	if store == nil || ctx == nil || defaultLink == nil {
+	if romanaClient == nil || ctx == nil || defaultLink == nil {
 		return fmt.Errorf("error store/context or link empty")
 	}
 
@@ -159,19 +337,35 @@ func StartRomanaVIPSync(ctx context.Context, store *client.Store,
 		return fmt.Errorf("failed to get default link's IP address")
 	}
 
-	go romanaVIPWatcher(ctx, store, defaultLink, defaultLinkAddressList)
+	go romanaVIPWatcher(ctx, romanaClient, hostname, defaultLink, defaultLinkAddressList)
 
 	return nil
 }
 
-func romanaVIPWatcher(ctx context.Context, store *client.Store,
+func romanaVIPWatcher(ctx context.Context, romanaClient *client.Client, hostname string,
 	defaultLink netlink.Link, defaultLinkAddressList []string) {
 	var storeError error
 	var events <-chan *kvstore.KVPairExt
+	store := romanaClient.Store
+
+	// romana VIPs added to the link by this agent.
+	added := make(map[string]bool)
+
+	resync := func() {
+		if err := reconcileRomanaVIPs(romanaClient, hostname, defaultLink, added); err != nil {
+			log.Errorf("error reconciling romana VIPs: %s", err)
+		}
+	}
+
+	resyncTicker := time.NewTicker(romanaVIPResyncInterval)
+	defer resyncTicker.Stop()
 
 	// Initial kvstore connection, ignore error since it is always nil.
 	events, _ = store.WatchTreeExt(client.DefaultEtcdPrefix+client.RomanaVIPPrefix, ctx.Done())
 
+	// romana VIPs may have changed while agent wasn't running.
+	resync()
+
 	for {
 		if storeError != nil {
 			log.Errorf("romana VIP watcher store error: %s", storeError)
@@ -181,6 +375,10 @@ func romanaVIPWatcher(ctx context.Context, store *client.Store,
 			events, _ = store.WatchTreeExt(
 				client.DefaultEtcdPrefix+client.RomanaVIPPrefix,
 				ctx.Done())
+			storeError = nil
+
+			// events may have been missed while reconnecting.
+			resync()
 		}
 
 		select {
@@ -193,22 +391,20 @@ func romanaVIPWatcher(ctx context.Context, store *client.Store,
 			switch pair.Action {
 			case "create", "set", "update", "compareAndSwap":
 				log.Debugf("creating/updating romana VIP: %#v\n", pair)
-				err := linkAddDeleteIP(pair, true, defaultLink, defaultLinkAddressList)
+				err := linkAddDeleteIP(pair, true, defaultLink, defaultLinkAddressList, added)
 				if err != nil {
 					log.Errorf("error adding romana VIP to the link: %s", err)
 					continue
 				}
 			case "delete":
 				if pair.Dir {
-					// TODO: currently if the whole "/romana/romanavip" kvstore
-					// directory is deleted, then we need to delete all romana VIPs,
-					// but currently we do nothing here and handle only single
-					// romana VIP deletion event below.
-					log.Infof("should be deleting ALL romana VIPs(%#v) here, ignoring currently",
-						pair)
+					// whole romana VIP directory or part of it
+					// is gone, so reconcile everything.
+					log.Infof("romana VIP directory deleted (%#v), resynchronizing", pair)
+					resync()
 				} else {
 					log.Debugf("deleting romana VIP: %#v\n", pair)
-					err := linkAddDeleteIP(pair, false, defaultLink, defaultLinkAddressList)
+					err := linkAddDeleteIP(pair, false, defaultLink, defaultLinkAddressList, added)
 					if err != nil {
 						log.Errorf("error deleting romana VIP from the link: %s", err)
 						continue
@@ -218,6 +414,9 @@ func romanaVIPWatcher(ctx context.Context, store *client.Store,
 				log.Infof("missed romana VIP event type: %s", pair.Action)
 			}
 
+		case <-resyncTicker.C:
+			resync()
+
 		case <-ctx.Done():
 			log.Printf("Stopping romana VIP watcher module.")
 			return
@@ -0,0 +1,83 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package agent
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/romana/core/common/api"
+	"github.com/vishvananda/netlink"
+)
+
+func mustParseAddr(s string) netlink.Addr {
+	addr, err := netlink.ParseAddr(s)
+	if err != nil {
+		panic(err)
+	}
+	return *addr
+}
+
+func addrStrings(addrs []*netlink.Addr) []string {
+	var result []string
+	for _, addr := range addrs {
+		result = append(result, addr.IPNet.String())
+	}
+	sort.Strings(result)
+	return result
+}
+
+func TestRomanaVIPChanges(t *testing.T) {
+	exposedIPs := map[string]api.ExposedIPSpec{
+		"present.default": {RomanaVIP: api.RomanaVIP{IP: "192.168.99.200"}, NodeIPAddress: "192.168.99.10"},
+		"missing.default": {RomanaVIP: api.RomanaVIP{IP: "192.168.99.201"}, NodeIPAddress: "192.168.99.10"},
+		"other.default":   {RomanaVIP: api.RomanaVIP{IP: "192.168.99.202"}, NodeIPAddress: "192.168.99.11"},
+	}
+
+	linkAddrs := []netlink.Addr{
+		mustParseAddr("192.168.99.10/24"),
+		mustParseAddr("10.128.0.5/32"),
+		mustParseAddr("192.168.99.200/32"),
+		mustParseAddr("192.168.99.202/32"),
+		mustParseAddr("192.168.99.203/32"),
+		mustParseAddr("192.168.99.204/32"),
+	}
+
+	// 192.168.99.204 was not added by the agent, e.g. it's a VIP of keepalived.
+	ownVIPs := map[string]bool{"192.168.99.200": true, "192.168.99.203": true}
+
+	exposedIPs["bogus.default"] = api.ExposedIPSpec{RomanaVIP: api.RomanaVIP{IP: "bogus"}, NodeIPAddress: "192.168.99.10"}
+
+	toAdd, toDelete := romanaVIPChanges(exposedIPs,
+		[]string{"192.168.99.10", "10.128.0.5"}, linkAddrs, ownVIPs)
+
+	if added := addrStrings(toAdd); len(added) != 1 || added[0] != "192.168.99.201/32" {
+		t.Errorf("unexpected romana VIPs to add %v", added)
+	}
+
+	deleted := addrStrings(toDelete)
+	if len(deleted) != 2 || deleted[0] != "192.168.99.202/32" || deleted[1] != "192.168.99.203/32" {
+		t.Errorf("unexpected romana VIPs to delete %v", deleted)
+	}
+
+	// Directory with romana VIPs is gone.
+	toAdd, toDelete = romanaVIPChanges(nil, []string{"192.168.99.10", "10.128.0.5"}, linkAddrs, ownVIPs)
+	deleted = addrStrings(toDelete)
+	if len(toAdd) != 0 || len(deleted) != 2 || deleted[0] != "192.168.99.200/32" || deleted[1] != "192.168.99.203/32" {
+		t.Errorf("unexpected changes for empty romana VIP list, add %v, delete %v",
+			addrStrings(toAdd), addrStrings(toDelete))
+	}
+}
@@ -18,6 +18,7 @@ package agent
 import (
 	"fmt"
 	"net"
+	"sort"
 
 	"github.com/pkg/errors"
 	"github.com/romana/core/common/api"
@@ -25,15 +26,38 @@ import (
 	"github.com/vishvananda/netlink"
 )
 
-// CreateRouteToBlocks loops over list of blocks and creates routes when needed.
-func CreateRouteToBlocks(blocks []api.IPAMBlockResponse,
+// RouteReconcileResult summarizes changes made to Romana route table
+// by ReconcileRoutesToBlocks.
+type RouteReconcileResult struct {
+	Added     int
+	Replaced  int
+	Removed   int
+	Unchanged int
+}
+
+// ReconcileRoutesToBlocks brings routes in Romana route table in line
+// with block->host routes derived from the list of blocks. Routes that
+// are already correct stay untouched, routes with a wrong gateway are
+// replaced, missing routes are added and all other routes in the table
+// are deleted.
+func ReconcileRoutesToBlocks(blocks []api.IPAMBlockResponse,
 	hosts IpamHosts,
 	romanaRouteTableId int,
 	hostname string,
 	multihop bool,
-	nlHandle nlHandleRoute) {
+	multipath MultipathPolicy,
+	nlHandle nlHandleRoute) (RouteReconcileResult, error) {
+
+	var result RouteReconcileResult
+
+	// desired routes by destination.
+	desired := make(map[string]*netlink.Route)
+
+	// destinations of the routes that couldn't be verified
+	// due to transient errors, existing routes for them
+	// are left alone.
+	keep := make(map[string]bool)
 
-	var managedRoutes int
 	for _, block := range blocks {
 		if block.Host == hostname {
 			log.Debugf("Block %v is local and does not require a route on that host", block)
@@ -46,56 +70,223 @@ func CreateRouteToBlocks(blocks []api.IPAMBlockResponse,
 			continue
 		}
 
-		if err := createRouteToBlock(block, host, romanaRouteTableId, multihop, nlHandle); err != nil {
-			_, ok := err.(RouteAdjacencyError)
-			if ok {
+		route, err := routeToBlock(block, host, romanaRouteTableId, multihop, multipath, nlHandle)
+		if err != nil {
+			if _, ok := err.(RouteAdjacencyError); ok {
 				// Lower severity for expected error
 				log.Tracef(4, "%s", err)
 				continue
 			}
 
 			log.Errorf("%s", err)
+			keep[block.CIDR.String()] = true
 			continue
 		}
-		managedRoutes += 1
+
+		desired[route.Dst.String()] = route
+	}
+
+	current, err := nlHandle.RouteListFiltered(netlink.FAMILY_ALL,
+		&netlink.Route{Table: romanaRouteTableId}, netlink.RT_FILTER_TABLE)
+	if err != nil {
+		return result, errors.Wrapf(err, "couldn't list routes in table %d", romanaRouteTableId)
+	}
+
+	var failed int
+	installed := make(map[string]bool)
+	for i := range current {
+		route := &current[i]
+		if route.Dst == nil {
+			continue
+		}
+		dst := route.Dst.String()
+
+		want, ok := desired[dst]
+		switch {
+		case ok && sameGateways(route, want):
+			result.Unchanged++
+		case ok:
+			log.Debugf("About to replace route %v with %v", route, want)
+			if err := nlHandle.RouteReplace(want); err != nil {
+				log.Errorf("failed to replace route %v, err=(%s)", want, err)
+				failed++
+				break
+			}
+			result.Replaced++
+		case keep[dst]:
+			log.Debugf("Keeping route %v until it can be verified", route)
+		default:
+			log.Debugf("About to delete route %v", route)
+			if err := nlHandle.RouteDel(route); err != nil {
+				log.Errorf("failed to delete route %v, err=(%s)", route, err)
+				failed++
+				break
+			}
+			result.Removed++
+		}
+		installed[dst] = true
 	}
 
-	NumManagedRoutes.Set(float64(managedRoutes))
+	for dst, route := range desired {
+		if installed[dst] {
+			continue
+		}
+
+		log.Debugf("About to create route %v", route)
+		if err := nlHandle.RouteAdd(route); err != nil {
+			log.Errorf("failed to create route %v, err=(%s)", route, err)
+			failed++
+			continue
+		}
+		result.Added++
+	}
+
+	NumRoutesAdded.Add(float64(result.Added))
+	NumRoutesReplaced.Add(float64(result.Replaced))
+	NumRoutesRemoved.Add(float64(result.Removed))
+	NumRoutesUnchanged.Set(float64(result.Unchanged))
+	NumManagedRoutes.Set(float64(result.Added + result.Replaced + result.Unchanged))
+
+	if failed > 0 {
+		return result, fmt.Errorf("failed to apply %d route changes", failed)
+	}
+
+	return result, nil
 }
 
+// nlHandleRoute subset of netlink.Handle methods isolated for mocking.
 type nlHandleRoute interface {
 	RouteGet(net.IP) ([]netlink.Route, error)
 	RouteAdd(*netlink.Route) error
+	RouteReplace(*netlink.Route) error
+	RouteDel(*netlink.Route) error
+	RouteListFiltered(int, *netlink.Route, uint64) ([]netlink.Route, error)
 }
 
-// createRouteToBlock creates ip route for given block->host pair in Romana routing table,
-// the function will fail if requested block is not directly adjacent and multihop false.
-func createRouteToBlock(block api.IPAMBlockResponse, host *api.Host, romanaRouteTableId int, multihop bool, nlHandle nlHandleRoute) error {
-	testRoutes, err := nlHandle.RouteGet(host.IP)
-	if err != nil {
-		return errors.Wrapf(err, "couldn't test host %s adjacency", host.IP)
-	}
+// MultipathPolicy defines how routes to blocks are installed
+// when remote host can be reached over more than one path.
+type MultipathPolicy string
+
+const (
+	// MultipathFirstPath installs route via the first usable path.
+	MultipathFirstPath MultipathPolicy = "first-path"
+
+	// MultipathAllPaths installs ECMP route with a nexthop
+	// for every usable path.
+	MultipathAllPaths MultipathPolicy = "all-paths"
+)
 
-	if len(testRoutes) > 1 {
-		return errors.New(fmt.Sprintf("more then one path available for host %s, multipath not currently supported", host.IP))
+// ParseMultipathPolicy validates the name of multipath policy.
+func ParseMultipathPolicy(name string) (MultipathPolicy, error) {
+	switch policy := MultipathPolicy(name); policy {
+	case MultipathFirstPath, MultipathAllPaths:
+		return policy, nil
 	}
+	return "", fmt.Errorf("unknown multipath policy %q, expected %s or %s", name, MultipathFirstPath, MultipathAllPaths)
+}
+
+// routeToBlock makes ip route for given block->host pair in Romana routing table,
+// the function will fail if requested block is not directly adjacent and multihop false.
+//
+// Every address of the host is tested and every path to the address
+// that satisfies multihop setting becomes a nexthop candidate. Depending on
+// multipath policy, the route uses either the first candidate or all of them.
+func routeToBlock(block api.IPAMBlockResponse, host *api.Host, romanaRouteTableId int, multihop bool, multipath MultipathPolicy, nlHandle nlHandleRoute) (*netlink.Route, error) {
+	var nexthops []*netlink.NexthopInfo
+	var firstErr error
+	seen := make(map[string]bool)
+
+	for _, addr := range host.Addresses() {
+		// Gateway must be of the same address family as the block.
+		if (addr.To4() == nil) != (block.CIDR.IP.To4() == nil) {
+			continue
+		}
+
+		testRoutes, err := nlHandle.RouteGet(addr)
+		if err != nil {
+			err = errors.Wrapf(err, "couldn't test host %s adjacency", addr)
+		} else if len(testRoutes) == 0 {
+			err = errors.New(fmt.Sprintf("no way to reach %s, no default gateway?", addr))
+		}
+		if err != nil {
+			log.Debugf("Skipping address %s of host %s, %s", addr, host.Name, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+
+		for _, testRoute := range testRoutes {
+			if testRoute.Gw != nil && multihop == false {
+				if firstErr == nil {
+					firstErr = RouteAdjacencyError{}
+				}
+				continue
+			}
+
+			key := fmt.Sprintf("%s@%d", addr, testRoute.LinkIndex)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
 
-	if len(testRoutes) == 0 {
-		return errors.New(fmt.Sprintf("no way to reach %s, no default gateway?", host.IP))
+			nexthops = append(nexthops, &netlink.NexthopInfo{
+				Gw:        addr,
+				LinkIndex: testRoute.LinkIndex,
+			})
+		}
 	}
 
-	if testRoutes[0].Gw != nil && multihop == false {
-		return RouteAdjacencyError{}
+	if len(nexthops) == 0 {
+		if firstErr == nil {
+			firstErr = errors.New(fmt.Sprintf("host %s has no addresses", host.Name))
+		}
+		return nil, firstErr
 	}
 
 	route := netlink.Route{
 		Dst:   &block.CIDR.IPNet,
-		Gw:    host.IP,
 		Table: romanaRouteTableId,
 	}
 
-	log.Debugf("About to create route %v", route)
-	return nlHandle.RouteAdd(&route)
+	if multipath == MultipathAllPaths && len(nexthops) > 1 {
+		route.MultiPath = nexthops
+	} else {
+		if len(nexthops) > 1 {
+			log.Debugf("More then one path available for host %s, using first path via %s", host, nexthops[0].Gw)
+		}
+		route.Gw = nexthops[0].Gw
+	}
+
+	return &route, nil
+}
+
+// routeGateways returns sorted list of gateways used by the route,
+// including gateways of every nexthop of multipath route.
+func routeGateways(route *netlink.Route) []string {
+	var gws []string
+	if route.Gw != nil {
+		gws = append(gws, route.Gw.String())
+	}
+	for _, nh := range route.MultiPath {
+		gws = append(gws, nh.Gw.String())
+	}
+	sort.Strings(gws)
+	return gws
+}
+
+// sameGateways returns true when both routes use the same gateways.
+func sameGateways(a, b *netlink.Route) bool {
+	aGws, bGws := routeGateways(a), routeGateways(b)
+	if len(aGws) != len(bGws) {
+		return false
+	}
+	for i := range aGws {
+		if aGws[i] != bGws[i] {
+			return false
+		}
+	}
+	return true
 }
 
 type RouteAdjacencyError struct{}
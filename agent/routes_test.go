@@ -36,6 +36,38 @@ func (h testHandle) RouteGet(ip net.IP) ([]netlink.Route, error) {
 func (h testHandle) RouteAdd(r *netlink.Route) error {
 	return h.re
 }
+func (h testHandle) RouteReplace(r *netlink.Route) error {
+	return h.re
+}
+func (h testHandle) RouteDel(r *netlink.Route) error {
+	return h.re
+}
+func (h testHandle) RouteListFiltered(family int, filter *netlink.Route, mask uint64) ([]netlink.Route, error) {
+	return nil, h.re
+}
+
+// reconcileHandle records route changes made through it.
+type reconcileHandle struct {
+	testHandle
+	table                    []netlink.Route
+	added, replaced, removed []string
+}
+
+func (h *reconcileHandle) RouteListFiltered(family int, filter *netlink.Route, mask uint64) ([]netlink.Route, error) {
+	return h.table, nil
+}
+func (h *reconcileHandle) RouteAdd(r *netlink.Route) error {
+	h.added = append(h.added, r.Dst.String()+" via "+r.Gw.String())
+	return nil
+}
+func (h *reconcileHandle) RouteReplace(r *netlink.Route) error {
+	h.replaced = append(h.replaced, r.Dst.String()+" via "+r.Gw.String())
+	return nil
+}
+func (h *reconcileHandle) RouteDel(r *netlink.Route) error {
+	h.removed = append(h.removed, r.Dst.String())
+	return nil
+}
 
 func TestCreateRouteToBlock(t *testing.T) {
 	_, ipnet, _ := net.ParseCIDR("100.31.0.0/4")
@@ -61,19 +93,19 @@ func TestCreateRouteToBlock(t *testing.T) {
 			expect:     func(err error) bool { return strings.Contains(err.Error(), "no default gateway") },
 		},
 		{
-			name:       "detect error when RouteGet() returns more then one result",
-			message:    "failed to detect situation when RouteGet() returns more then one result",
+			name:       "use first path when RouteGet() returns more then one result",
+			message:    "failed to use first path when RouteGet() returns more then one result",
 			block:      testBlock,
-			host:       &api.Host{},
+			host:       &api.Host{IP: net.ParseIP("192.168.99.20")},
 			multihop:   false,
-			testHandle: testHandle{rg: []netlink.Route{netlink.Route{}, netlink.Route{}}},
-			expect:     func(err error) bool { return strings.Contains(err.Error(), "multipath not currently supported") },
+			testHandle: testHandle{rg: []netlink.Route{netlink.Route{LinkIndex: 2}, netlink.Route{LinkIndex: 3}}},
+			expect:     func(err error) bool { return err == nil },
 		},
 		{
 			name:       "RouteGet() returns an error",
 			message:    "failed to detect an error from RouteGet()",
 			block:      testBlock,
-			host:       &api.Host{},
+			host:       &api.Host{IP: net.ParseIP("192.168.99.20")},
 			multihop:   false,
 			testHandle: testHandle{re: errors.New("Dummy error")},
 			expect:     func(err error) bool { return strings.Contains(err.Error(), "Dummy error") },
@@ -82,7 +114,7 @@ func TestCreateRouteToBlock(t *testing.T) {
 			name:       "detect fail with not adjacent block and multihop disabled",
 			message:    "failed detect fail with not adjacent block and multihop disabled",
 			block:      testBlock,
-			host:       &api.Host{},
+			host:       &api.Host{IP: net.ParseIP("192.168.99.20")},
 			multihop:   false,
 			testHandle: testHandle{rg: []netlink.Route{netlink.Route{Gw: net.ParseIP("192.168.99.1")}}},
 			expect:     func(err error) bool { _, ok := err.(RouteAdjacencyError); return ok },
@@ -91,7 +123,7 @@ func TestCreateRouteToBlock(t *testing.T) {
 			name:       "confirm success for nont adjacent block with multihop enabled",
 			message:    "failed confirm success for nont adjacent block with multihop enabled",
 			block:      testBlock,
-			host:       &api.Host{},
+			host:       &api.Host{IP: net.ParseIP("192.168.99.20")},
 			multihop:   true,
 			testHandle: testHandle{re: nil, rg: []netlink.Route{netlink.Route{Gw: net.ParseIP("192.168.99.1")}}},
 			expect:     func(err error) bool { return err == nil },
@@ -100,7 +132,7 @@ func TestCreateRouteToBlock(t *testing.T) {
 			name:       "confirm success for adjacent block without multihop",
 			message:    "failed confirm success for adjacent block without multihop",
 			block:      testBlock,
-			host:       &api.Host{},
+			host:       &api.Host{IP: net.ParseIP("192.168.99.20")},
 			multihop:   true,
 			testHandle: testHandle{re: nil, rg: []netlink.Route{netlink.Route{Gw: nil}}},
 			expect:     func(err error) bool { return err == nil },
@@ -109,10 +141,153 @@ func TestCreateRouteToBlock(t *testing.T) {
 
 	for _, tc := range cases {
 		t.Run(tc.name, func(t *testing.T) {
-			err := createRouteToBlock(tc.block, tc.host, 10, tc.multihop, tc.testHandle)
+			_, err := routeToBlock(tc.block, tc.host, 10, tc.multihop, MultipathFirstPath, tc.testHandle)
 			if !tc.expect(err) {
 				t.Fatalf("Result: %s, message: %s", err, tc.message)
 			}
 		})
 	}
 }
+
+// pathHandle returns one path per link for each of the known addresses.
+type pathHandle struct {
+	testHandle
+	paths map[string][]netlink.Route
+}
+
+func (h pathHandle) RouteGet(ip net.IP) ([]netlink.Route, error) {
+	return h.paths[ip.String()], nil
+}
+
+func TestRouteToBlockMultipath(t *testing.T) {
+	_, ipnet, _ := net.ParseCIDR("10.0.0.16/28")
+	block := api.IPAMBlockResponse{CIDR: api.IPNet{IPNet: *ipnet}}
+	host := &api.Host{
+		Name: "host2",
+		IP:   net.ParseIP("192.168.1.11"),
+		IPs:  []net.IP{net.ParseIP("192.168.2.11"), net.ParseIP("fd00::11"), net.ParseIP("192.168.1.11")},
+	}
+	handle := pathHandle{paths: map[string][]netlink.Route{
+		"192.168.1.11": {netlink.Route{LinkIndex: 2}},
+		"192.168.2.11": {netlink.Route{LinkIndex: 3}},
+		"fd00::11":     {netlink.Route{LinkIndex: 2}},
+	}}
+
+	route, err := routeToBlock(block, host, 10, false, MultipathFirstPath, handle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !route.Gw.Equal(host.IP) || route.MultiPath != nil {
+		t.Errorf("expected single path route via %s, got %s", host.IP, route)
+	}
+
+	route, err = routeToBlock(block, host, 10, false, MultipathAllPaths, handle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if route.Gw != nil || len(route.MultiPath) != 2 {
+		t.Fatalf("expected route with 2 nexthops, got %s", route)
+	}
+	if !route.MultiPath[0].Gw.Equal(net.ParseIP("192.168.1.11")) || route.MultiPath[0].LinkIndex != 2 ||
+		!route.MultiPath[1].Gw.Equal(net.ParseIP("192.168.2.11")) || route.MultiPath[1].LinkIndex != 3 {
+		t.Errorf("unexpected nexthops %s %s", route.MultiPath[0], route.MultiPath[1])
+	}
+
+	// Reordered nexthops of installed route are the same route.
+	installed := *route
+	installed.MultiPath = []*netlink.NexthopInfo{route.MultiPath[1], route.MultiPath[0]}
+	if !sameGateways(&installed, route) {
+		t.Errorf("expected %s to match %s", &installed, route)
+	}
+
+	// Address without adjacent paths is skipped.
+	handle.paths["192.168.2.11"] = []netlink.Route{netlink.Route{Gw: net.ParseIP("192.168.1.1")}}
+	route, err = routeToBlock(block, host, 10, false, MultipathAllPaths, handle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !route.Gw.Equal(host.IP) || route.MultiPath != nil {
+		t.Errorf("expected single path route via %s, got %s", host.IP, route)
+	}
+}
+
+func TestParseMultipathPolicy(t *testing.T) {
+	if _, err := ParseMultipathPolicy("all-paths"); err != nil {
+		t.Error(err)
+	}
+	if _, err := ParseMultipathPolicy("random"); err == nil {
+		t.Error("expected error for unknown policy")
+	}
+}
+
+func TestReconcileRoutesToBlocks(t *testing.T) {
+	block := func(cidr, host string) api.IPAMBlockResponse {
+		_, ipnet, _ := net.ParseCIDR(cidr)
+		return api.IPAMBlockResponse{CIDR: api.IPNet{IPNet: *ipnet}, Host: host}
+	}
+	route := func(cidr, gw string) netlink.Route {
+		_, ipnet, _ := net.ParseCIDR(cidr)
+		return netlink.Route{Dst: ipnet, Gw: net.ParseIP(gw), Table: 10}
+	}
+
+	hosts := IpamHosts{
+		api.Host{Name: "host1", IP: net.ParseIP("192.168.99.10")},
+		api.Host{Name: "host2", IP: net.ParseIP("192.168.99.11")},
+		api.Host{Name: "host3", IP: net.ParseIP("192.168.99.12")},
+	}
+
+	blocks := []api.IPAMBlockResponse{
+		block("10.0.0.0/28", "host1"),  // local
+		block("10.0.0.16/28", "host2"), // unchanged
+		block("10.0.0.32/28", "host3"), // replaced, moved from host2
+		block("10.0.0.48/28", "host3"), // added
+		block("10.0.0.64/28", "host4"), // unknown host
+	}
+
+	handle := &reconcileHandle{
+		testHandle: testHandle{rg: []netlink.Route{netlink.Route{}}},
+		table: []netlink.Route{
+			route("10.0.0.16/28", "192.168.99.11"),
+			route("10.0.0.32/28", "192.168.99.11"),
+			route("10.0.0.80/28", "192.168.99.12"), // removed
+		},
+	}
+
+	result, err := ReconcileRoutesToBlocks(blocks, hosts, 10, "host1", false, MultipathFirstPath, handle)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := RouteReconcileResult{Added: 1, Replaced: 1, Removed: 1, Unchanged: 1}
+	if result != expected {
+		t.Errorf("expected %+v, got %+v", expected, result)
+	}
+
+	if len(handle.added) != 1 || handle.added[0] != "10.0.0.48/28 via 192.168.99.12" {
+		t.Errorf("unexpected routes added %v", handle.added)
+	}
+	if len(handle.replaced) != 1 || handle.replaced[0] != "10.0.0.32/28 via 192.168.99.12" {
+		t.Errorf("unexpected routes replaced %v", handle.replaced)
+	}
+	if len(handle.removed) != 1 || handle.removed[0] != "10.0.0.80/28" {
+		t.Errorf("unexpected routes removed %v", handle.removed)
+	}
+
+	// Second pass over the reconciled table changes nothing.
+	handle = &reconcileHandle{
+		testHandle: testHandle{rg: []netlink.Route{netlink.Route{}}},
+		table: []netlink.Route{
+			route("10.0.0.16/28", "192.168.99.11"),
+			route("10.0.0.32/28", "192.168.99.12"),
+			route("10.0.0.48/28", "192.168.99.12"),
+		},
+	}
+	result, err = ReconcileRoutesToBlocks(blocks, hosts, 10, "host1", false, MultipathFirstPath, handle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = RouteReconcileResult{Unchanged: 3}
+	if result != expected {
+		t.Errorf("expected %+v, got %+v", expected, result)
+	}
+}
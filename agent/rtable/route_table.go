@@ -19,7 +19,6 @@ import (
 	"bufio"
 	"fmt"
 	"os"
-	"os/exec"
 
 	"github.com/pkg/errors"
 	"github.com/romana/rlog"
@@ -104,16 +103,3 @@ func EnsureRomanaRouteRule(romanaRouteTableId int, nl nlRuleHandle) error {
 
 	return nil
 }
-
-// FlushRomanaTable attempts to delete all routes from table called romana.
-func FlushRomanaTable() error {
-	command := exec.Command("ip", "ro", "flush", "table", "romana")
-
-	out, err := command.CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("failed to flush romana route table out=%s, err=%s", string(out), err)
-	}
-
-	return nil
-
-}
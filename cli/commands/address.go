@@ -0,0 +1,131 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"text/tabwriter"
+
+	"github.com/romana/core/cli/util"
+	"github.com/romana/core/common/api"
+
+	"github.com/go-resty/resty"
+	cli "github.com/spf13/cobra"
+	config "github.com/spf13/viper"
+)
+
+// addressCmd represents the address commands
+var addressCmd = &cli.Command{
+	Use:   "address [show]",
+	Short: "Show addresses allocated by romana services.",
+	Long: `Show addresses allocated by romana services.
+
+address requires a subcommand, e.g. ` + "`romana address show`." + `
+
+For more information, please check http://romana.io
+`,
+}
+
+func init() {
+	addressCmd.AddCommand(addressShowCmd)
+}
+
+var addressShowCmd = &cli.Command{
+	Use:   "show [address name|ip 1][address name|ip 2]...",
+	Short: "Show who owns an address.",
+	Long: `Show who owns an address.
+
+Address can be specified either by IP or by the name
+it was allocated under, e.g. pod name.`,
+	RunE:         addressShow,
+	SilenceUsage: true,
+}
+
+func addressShow(cmd *cli.Command, args []string) error {
+	if len(args) == 0 {
+		return util.UsageError(cmd,
+			"address show takes at-least one argument i.e address name/s or ip/s")
+	}
+
+	rootURL := config.GetString("RootURL")
+	addresses := make([]api.IPAMAddressResponse, 0, len(args))
+	for _, arg := range args {
+		var resp *resty.Response
+		var err error
+		if net.ParseIP(arg) != nil {
+			resp, err = resty.R().SetQueryParam("ip", arg).Get(rootURL + "/address")
+		} else {
+			resp, err = resty.R().Get(rootURL + "/address/" + url.PathEscape(arg))
+		}
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode() != http.StatusOK {
+			fmt.Printf("Error fetching address %s: ", arg)
+			return showResult(resp, "")
+		}
+
+		var address api.IPAMAddressResponse
+		err = json.Unmarshal(resp.Body(), &address)
+		if err != nil {
+			return err
+		}
+		addresses = append(addresses, address)
+	}
+
+	if config.GetString("Format") == "json" {
+		body, err := json.MarshalIndent(addresses, "", "\t")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(body))
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 0, '\t', 0)
+	fmt.Println("Address List")
+	fmt.Fprintf(w, "Name\tIP\tHost\tTenant\tSegment\tNetwork\tBlock\n")
+	for _, address := range addresses {
+		name := address.Name
+		if name == "" {
+			name = "(not allocated)"
+		}
+		var block string
+		if address.Block != nil {
+			block = address.Block.String()
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			name,
+			address.IP.String(),
+			address.Host,
+			address.Tenant,
+			address.Segment,
+			address.Network,
+			block,
+		)
+		if address.SecondaryIP != nil {
+			fmt.Fprintf(w, "%s\t%s\t\t\t\t\t\n", name, address.SecondaryIP.String())
+		}
+	}
+	w.Flush()
+
+	return nil
+}
@@ -18,10 +18,15 @@ package commands
 import (
 	"encoding/json"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
 	"text/tabwriter"
 
+	"github.com/romana/core/cli/util"
 	"github.com/romana/core/common/api"
 
 	"github.com/go-resty/resty"
@@ -46,10 +51,15 @@ func init() {
 	hostCmd.AddCommand(hostShowCmd)
 	hostCmd.AddCommand(hostListCmd)
 	hostCmd.AddCommand(hostRemoveCmd)
+
+	hostAddCmd.Flags().StringSliceVarP(&hostTags, "tags", "t", nil,
+		"Host tags as key=value pairs, used to assign the host to a group.")
 }
 
+var hostTags []string
+
 var hostAddCmd = &cli.Command{
-	Use:          "add [hostip][(optional)romana cidr][(optional)agent port]",
+	Use:          "add [hostname][hostip][(optional)agent port]",
 	Short:        "Add a new host.",
 	Long:         `Add a new host.`,
 	RunE:         hostAdd,
@@ -57,7 +67,7 @@ var hostAddCmd = &cli.Command{
 }
 
 var hostShowCmd = &cli.Command{
-	Use:          "show [hostip1][hostip2]...",
+	Use:          "show [hostname1|hostip1][hostname2|hostip2]...",
 	Short:        "Show details for a specific host.",
 	Long:         `Show details for a specific host.`,
 	RunE:         hostShow,
@@ -73,7 +83,7 @@ var hostListCmd = &cli.Command{
 }
 
 var hostRemoveCmd = &cli.Command{
-	Use:          "remove [hostip]",
+	Use:          "remove [hostname|hostip]",
 	Short:        "Remove a host.",
 	Long:         `Remove a host.`,
 	RunE:         hostRemove,
@@ -81,12 +91,101 @@ var hostRemoveCmd = &cli.Command{
 }
 
 func hostAdd(cmd *cli.Command, args []string) error {
-	fmt.Println("Unimplemented: Add host/s.")
-	return nil
+	if len(args) < 2 || len(args) > 3 {
+		return util.UsageError(cmd,
+			"host add takes host name, host ip and optional agent port")
+	}
+
+	host := api.Host{Name: args[0], IP: net.ParseIP(args[1])}
+	if host.IP == nil {
+		return util.UsageError(cmd, "invalid host ip %s", args[1])
+	}
+	if len(args) == 3 {
+		port, err := strconv.ParseUint(args[2], 10, 16)
+		if err != nil {
+			return util.UsageError(cmd, "invalid agent port %s", args[2])
+		}
+		host.AgentPort = uint(port)
+	}
+	if len(hostTags) > 0 {
+		host.Tags = make(map[string]string)
+		for _, tag := range hostTags {
+			kv := strings.SplitN(tag, "=", 2)
+			if len(kv) != 2 || kv[0] == "" {
+				return util.UsageError(cmd, "invalid tag %s, expected key=value", tag)
+			}
+			host.Tags[kv[0]] = kv[1]
+		}
+	}
+
+	rootURL := config.GetString("RootURL")
+	resp, err := resty.R().
+		SetHeader("Content-Type", "application/json").
+		SetBody(host).Post(rootURL + "/hosts")
+	if err != nil {
+		return err
+	}
+
+	return showResult(resp, fmt.Sprintf("Host %s (%s) added successfully.", host.Name, host.IP))
 }
 
 func hostShow(cmd *cli.Command, args []string) error {
-	fmt.Println("Unimplemented: Show host details.")
+	if len(args) == 0 {
+		return util.UsageError(cmd,
+			"host show takes at-least one argument i.e host name/s or ip/s")
+	}
+
+	rootURL := config.GetString("RootURL")
+	hosts := make([]api.Host, 0, len(args))
+	for _, name := range args {
+		resp, err := resty.R().Get(rootURL + "/hosts/" + name)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode() != http.StatusOK {
+			fmt.Printf("Error fetching host %s: ", name)
+			return showResult(resp, "")
+		}
+
+		var host api.Host
+		err = json.Unmarshal(resp.Body(), &host)
+		if err != nil {
+			return err
+		}
+		hosts = append(hosts, host)
+	}
+
+	if config.GetString("Format") == "json" {
+		body, err := json.MarshalIndent(hosts, "", "\t")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(body))
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 0, '\t', 0)
+	for _, host := range hosts {
+		fmt.Fprintf(w, "Host Name:\t%s\n", host.Name)
+		fmt.Fprintf(w, "Host IP:\t%s\n", host.IP.String())
+		if len(host.IPs) > 0 {
+			var ips []string
+			for _, ip := range host.IPs {
+				ips = append(ips, ip.String())
+			}
+			fmt.Fprintf(w, "Additional IPs:\t%s\n", strings.Join(ips, ", "))
+		}
+		fmt.Fprintf(w, "Agent Port:\t%d\n", host.AgentPort)
+		var tags []string
+		for k, v := range host.Tags {
+			tags = append(tags, k+"="+v)
+		}
+		sort.Strings(tags)
+		fmt.Fprintf(w, "Tags:\t%s\n", strings.Join(tags, ", "))
+		fmt.Fprintln(w, "")
+	}
+	w.Flush()
+
 	return nil
 }
 
@@ -133,6 +232,16 @@ func hostList(cmd *cli.Command, args []string) error {
 }
 
 func hostRemove(cmd *cli.Command, args []string) error {
-	fmt.Println("Unimplemented: Remove a host.")
-	return nil
+	if len(args) != 1 {
+		return util.UsageError(cmd,
+			"host remove takes exactly one argument i.e host name or ip")
+	}
+
+	rootURL := config.GetString("RootURL")
+	resp, err := resty.R().Delete(rootURL + "/hosts/" + args[0])
+	if err != nil {
+		return err
+	}
+
+	return showResult(resp, fmt.Sprintf("Host %s removed successfully.", args[0]))
 }
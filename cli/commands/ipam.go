@@ -0,0 +1,251 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package commands
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"text/tabwriter"
+
+	"github.com/romana/core/cli/util"
+	"github.com/romana/core/common/api"
+
+	"github.com/go-resty/resty"
+	cli "github.com/spf13/cobra"
+	config "github.com/spf13/viper"
+)
+
+// ipamCmd represents the ipam commands
+var ipamCmd = &cli.Command{
+	Use:   "ipam [export|import|fsck]",
+	Short: "Export, Import or Check IPAM of romana services.",
+	Long: `Export, Import or Check IPAM of romana services.
+
+ipam requires a subcommand, e.g. ` + "`romana ipam export`." + `
+
+For more information, please check http://docs.romana.io
+`,
+}
+
+func init() {
+	ipamCmd.AddCommand(ipamExportCmd)
+	ipamCmd.AddCommand(ipamImportCmd)
+	ipamCmd.AddCommand(ipamFsckCmd)
+
+	ipamImportCmd.Flags().BoolVarP(&ipamImportDryRun, "dry-run", "", false,
+		"Only show the changes the import would make.")
+	ipamFsckCmd.Flags().BoolVarP(&ipamFsckRepair, "repair", "", false,
+		"Repair the problems found where possible.")
+}
+
+var (
+	ipamImportDryRun bool
+	ipamFsckRepair   bool
+)
+
+var ipamExportCmd = &cli.Command{
+	Use:   "export [file name]",
+	Short: "Export romana IPAM.",
+	Long: `Export romana IPAM.
+
+Writes a snapshot of networks, topology, blocks, blackouts
+and address allocations to the file, or to standard output.`,
+	RunE:         ipamExport,
+	SilenceUsage: true,
+}
+
+var ipamImportCmd = &cli.Command{
+	Use:   "import [file name]",
+	Short: "Import romana IPAM.",
+	Long: `Import romana IPAM.
+
+Replaces IPAM with the snapshot read from the file, or from
+standard input, as written by ` + "`romana ipam export`.",
+	RunE:         ipamImport,
+	SilenceUsage: true,
+}
+
+var ipamFsckCmd = &cli.Command{
+	Use:   "fsck",
+	Short: "Check romana IPAM for consistency.",
+	Long: `Check romana IPAM for consistency.
+
+Looks for blocks without owners, blocks of removed hosts,
+allocated IPs without address names, address names without
+allocated IPs or outside of all networks, allocations that
+are blacked out, and indexes of blocks that disagree.`,
+	RunE:         ipamFsck,
+	SilenceUsage: true,
+}
+
+// ipamExport writes IPAM snapshot to the file
+// given or to standard output (STDOUT).
+func ipamExport(cmd *cli.Command, args []string) error {
+	if len(args) > 1 {
+		return util.UsageError(cmd,
+			"At most one SNAPSHOT FILE name expected.")
+	}
+
+	rootURL := config.GetString("RootURL")
+	resp, err := resty.R().Get(rootURL + "/ipam/export")
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode() != http.StatusOK {
+		return showResult(resp, "")
+	}
+
+	var snapshot bytes.Buffer
+	err = json.Indent(&snapshot, resp.Body(), "", "\t")
+	if err != nil {
+		return err
+	}
+	snapshot.WriteString("\n")
+
+	if len(args) == 0 {
+		_, err = snapshot.WriteTo(os.Stdout)
+		return err
+	}
+	err = ioutil.WriteFile(args[0], snapshot.Bytes(), 0600)
+	if err != nil {
+		return fmt.Errorf("file error: %s", err)
+	}
+	fmt.Printf("IPAM exported to %s.\n", args[0])
+	return nil
+}
+
+// ipamImport restores IPAM from the snapshot in the file
+// given or in standard input (STDIN).
+func ipamImport(cmd *cli.Command, args []string) error {
+	var buf []byte
+	var err error
+
+	switch len(args) {
+	case 0:
+		buf, err = ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			return fmt.Errorf("cannot read 'STDIN': %s", err)
+		}
+	case 1:
+		buf, err = ioutil.ReadFile(args[0])
+		if err != nil {
+			return fmt.Errorf("file error: %s", err)
+		}
+	default:
+		return util.UsageError(cmd,
+			"SNAPSHOT FILE name or piped input from 'STDIN' expected.")
+	}
+
+	var snapshot api.IPAMSnapshot
+	err = json.Unmarshal(buf, &snapshot)
+	if err != nil {
+		return err
+	}
+
+	rootURL := config.GetString("RootURL")
+	resp, err := resty.R().SetHeader("Content-Type", "application/json").
+		SetQueryParam("dry_run", fmt.Sprint(ipamImportDryRun)).
+		SetBody(snapshot).Post(rootURL + "/ipam/import")
+	if err != nil {
+		return err
+	}
+
+	if config.GetString("Format") == "json" || resp.StatusCode() != http.StatusOK {
+		return showResult(resp, "")
+	}
+
+	var result api.IPAMImportResponse
+	err = json.Unmarshal(resp.Body(), &result)
+	if err != nil {
+		return err
+	}
+
+	if len(result.Changes) > 0 {
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 0, '\t', 0)
+		fmt.Fprintf(w, "Kind\tName\tAction\tOld\tNew\n")
+		for _, c := range result.Changes {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+				c.Kind, c.Name, c.Action, c.Old, c.New)
+		}
+		w.Flush()
+	}
+
+	if result.DryRun {
+		fmt.Printf("%d changes would be made by the import.\n", len(result.Changes))
+	} else {
+		fmt.Printf("IPAM imported with %d changes (topology revision %d).\n",
+			len(result.Changes), result.TopologyRevision)
+	}
+	return nil
+}
+
+// ipamFsck lists problems found in IPAM, repairing them
+// if --repair is given.
+func ipamFsck(cmd *cli.Command, args []string) error {
+	if len(args) > 0 {
+		return util.UsageError(cmd, "IPAM fsck takes no arguments.")
+	}
+
+	rootURL := config.GetString("RootURL")
+	var resp *resty.Response
+	var err error
+	if ipamFsckRepair {
+		resp, err = resty.R().Post(rootURL + "/ipam/repair")
+	} else {
+		resp, err = resty.R().Get(rootURL + "/ipam/verify")
+	}
+	if err != nil {
+		return err
+	}
+
+	if config.GetString("Format") == "json" || resp.StatusCode() != http.StatusOK {
+		return showResult(resp, "")
+	}
+
+	var result api.IPAMVerifyResponse
+	err = json.Unmarshal(resp.Body(), &result)
+	if err != nil {
+		return err
+	}
+
+	if len(result.Problems) == 0 {
+		fmt.Println("No problems found in IPAM.")
+		return nil
+	}
+
+	repaired := 0
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 0, '\t', 0)
+	fmt.Fprintf(w, "Kind\tNetwork\tBlock\tAddress\tDetails\tRepaired\n")
+	for _, p := range result.Problems {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%t\n",
+			p.Kind, p.Network, p.Block, p.Address, p.Details, p.Repaired)
+		if p.Repaired {
+			repaired++
+		}
+	}
+	w.Flush()
+
+	if result.Repair {
+		fmt.Printf("%d problems found in IPAM, %d repaired.\n", len(result.Problems), repaired)
+	} else {
+		fmt.Printf("%d problems found in IPAM, use --repair to repair them.\n", len(result.Problems))
+	}
+	return nil
+}
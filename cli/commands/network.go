@@ -20,8 +20,11 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"strings"
 	"text/tabwriter"
 
+	"github.com/romana/core/cli/util"
+	"github.com/romana/core/common"
 	"github.com/romana/core/common/api"
 
 	"github.com/go-resty/resty"
@@ -46,12 +49,29 @@ func init() {
 	networkCmd.AddCommand(networkShowCmd)
 	networkCmd.AddCommand(networkListCmd)
 	networkCmd.AddCommand(networkRemoveCmd)
+	networkCmd.AddCommand(networkBlackoutCmd)
+	networkBlackoutCmd.AddCommand(networkBlackoutAddCmd)
+	networkBlackoutCmd.AddCommand(networkBlackoutRemoveCmd)
+	networkBlackoutCmd.AddCommand(networkBlackoutListCmd)
+
+	networkAddCmd.Flags().UintVarP(&networkBlockMask, "block-mask", "b", 0,
+		"Mask of blocks allocated from the network (default depends on address family).")
+	networkAddCmd.Flags().StringSliceVarP(&networkTenants, "tenants", "t", nil,
+		"Tenants allowed to use the network (default all tenants).")
 }
 
+var (
+	networkBlockMask uint
+	networkTenants   []string
+)
+
 var networkAddCmd = &cli.Command{
-	Use:          "add [network name][network cidr]",
-	Short:        "Add a new network.",
-	Long:         `Add a new network.`,
+	Use:   "add [network name][network cidr]",
+	Short: "Add a new network.",
+	Long: `Add a new network.
+
+The network is added with a single group containing
+all hosts currently known to romana.`,
 	RunE:         networkAdd,
 	SilenceUsage: true,
 }
@@ -80,13 +100,137 @@ var networkRemoveCmd = &cli.Command{
 	SilenceUsage: true,
 }
 
+// networkBlackoutCmd represents the network blackout commands
+var networkBlackoutCmd = &cli.Command{
+	Use:   "blackout [add|remove|list]",
+	Short: "Add, Remove or List CIDRs excluded from allocation in a network.",
+	Long: `Add, Remove or List CIDRs excluded from allocation in a network.
+
+Blacked out CIDRs are never used for address allocations, which
+allows to reserve ranges of the network for gateways, VIPs etc.
+CIDR can only be blacked out if no addresses are allocated in it.
+`,
+}
+
+var networkBlackoutAddCmd = &cli.Command{
+	Use:          "add [network name] [cidr]",
+	Short:        "Black out a CIDR in the network.",
+	Long:         `Black out a CIDR in the network.`,
+	RunE:         networkBlackoutAdd,
+	SilenceUsage: true,
+}
+
+var networkBlackoutRemoveCmd = &cli.Command{
+	Use:          "remove [network name] [cidr]",
+	Short:        "Return blacked out CIDR back to the network.",
+	Long:         `Return blacked out CIDR back to the network.`,
+	RunE:         networkBlackoutRemove,
+	SilenceUsage: true,
+}
+
+var networkBlackoutListCmd = &cli.Command{
+	Use:          "list [network name]",
+	Short:        "List CIDRs blacked out in the network.",
+	Long:         `List CIDRs blacked out in the network.`,
+	RunE:         networkBlackoutList,
+	SilenceUsage: true,
+}
+
 func networkAdd(cmd *cli.Command, args []string) error {
-	fmt.Println("Unimplemented: Add network/s.")
+	if len(args) != 2 {
+		return util.UsageError(cmd,
+			"network add takes exactly two arguments i.e network name and cidr")
+	}
+
+	req := api.NetworkAddRequest{
+		NetworkDefinition: api.NetworkDefinition{
+			Name:      args[0],
+			CIDR:      args[1],
+			BlockMask: networkBlockMask,
+			Tenants:   networkTenants,
+		},
+	}
+
+	rootURL := config.GetString("RootURL")
+	resp, err := resty.R().
+		SetHeader("Content-Type", "application/json").
+		SetBody(req).Post(rootURL + "/networks")
+	if err != nil {
+		return err
+	}
+
+	if config.GetString("Format") == "json" || resp.StatusCode() != http.StatusOK {
+		return showResult(resp, "")
+	}
+
+	var network api.IPAMNetworkResponse
+	err = json.Unmarshal(resp.Body(), &network)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Network %s (%s) added successfully.\n", network.Name, network.CIDR.String())
 	return nil
 }
 
 func networkShow(cmd *cli.Command, args []string) error {
-	fmt.Println("Unimplemented: Show network details.")
+	if len(args) == 0 {
+		return util.UsageError(cmd,
+			"network show takes at-least one argument i.e network name/s")
+	}
+
+	rootURL := config.GetString("RootURL")
+	networks := make([]api.IPAMNetworkResponse, 0, len(args))
+	for _, name := range args {
+		resp, err := resty.R().Get(rootURL + "/networks/" + name)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode() != http.StatusOK {
+			fmt.Printf("Error fetching network %s: ", name)
+			return showResult(resp, "")
+		}
+
+		var network api.IPAMNetworkResponse
+		err = json.Unmarshal(resp.Body(), &network)
+		if err != nil {
+			return err
+		}
+		networks = append(networks, network)
+	}
+
+	if config.GetString("Format") == "json" {
+		body, err := json.MarshalIndent(networks, "", "\t")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(body))
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 0, '\t', 0)
+	for _, network := range networks {
+		fmt.Fprintf(w, "Network Name:\t%s\n", network.Name)
+		fmt.Fprintf(w, "Network CIDR:\t%s\n", network.CIDR.String())
+		fmt.Fprintf(w, "Block Mask:\t%d\n", network.BlockMask)
+		fmt.Fprintf(w, "Revision:\t%d\n", network.Revision)
+		fmt.Fprintf(w, "Tenants:\t%s\n", strings.Join(network.Tenants, ", "))
+		fmt.Fprintf(w, "Blocks:\t%d\n", network.Blocks)
+		var blackedOut []string
+		for _, cidr := range network.BlackedOut {
+			blackedOut = append(blackedOut, cidr.String())
+		}
+		fmt.Fprintf(w, "Blacked Out:\t%s\n", strings.Join(blackedOut, ", "))
+		if len(network.Hosts) > 0 {
+			fmt.Fprintf(w, "Hosts:\n")
+			fmt.Fprintf(w, "\tHost Name\tHost IP\n")
+			for _, host := range network.Hosts {
+				fmt.Fprintf(w, "\t%s\t%s\n", host.Name, host.IP.String())
+			}
+		}
+		fmt.Fprintln(w, "")
+	}
+	w.Flush()
+
 	return nil
 }
 
@@ -134,6 +278,133 @@ func networkList(cmd *cli.Command, args []string) error {
 }
 
 func networkRemove(cmd *cli.Command, args []string) error {
-	fmt.Println("Unimplemented: Remove a network.")
+	if len(args) != 1 {
+		return util.UsageError(cmd,
+			"network remove takes exactly one argument i.e network name")
+	}
+
+	rootURL := config.GetString("RootURL")
+	resp, err := resty.R().Delete(rootURL + "/networks/" + args[0])
+	if err != nil {
+		return err
+	}
+
+	return showResult(resp, fmt.Sprintf("Network %s removed successfully.", args[0]))
+}
+
+// showResult displays outcome of the request that doesn't
+// return any data on success, in tabular or json format.
+func showResult(resp *resty.Response, success string) error {
+	if config.GetString("Format") == "json" {
+		if string(resp.Body()) == "" || string(resp.Body()) == "null" {
+			h := common.HttpError{
+				StatusCode: resp.StatusCode(),
+				Details:    resp.Status(),
+			}
+			status, _ := json.MarshalIndent(h, "", "\t")
+			fmt.Println(string(status))
+		} else {
+			JSONFormat(resp.Body(), os.Stdout)
+		}
+		return nil
+	}
+
+	if resp.StatusCode() == http.StatusOK {
+		fmt.Println(success)
+		return nil
+	}
+
+	var h common.HttpError
+	err := json.Unmarshal(resp.Body(), &h)
+	if err != nil || h.Details == nil {
+		fmt.Printf("Error: %s\n", resp.Status())
+	} else {
+		fmt.Printf("Error: %s: %v\n", resp.Status(), h.Details)
+	}
+	return nil
+}
+
+func networkBlackoutAdd(cmd *cli.Command, args []string) error {
+	if len(args) != 2 {
+		return util.UsageError(cmd,
+			"network blackout add takes exactly two arguments i.e network name and cidr")
+	}
+
+	rootURL := config.GetString("RootURL")
+	resp, err := resty.R().
+		SetHeader("Content-Type", "application/json").
+		SetBody(api.IPAMBlackoutRequest{CIDR: args[1]}).
+		Post(rootURL + "/networks/" + args[0] + "/blackout")
+	if err != nil {
+		return err
+	}
+
+	return showBlackout(resp)
+}
+
+func networkBlackoutRemove(cmd *cli.Command, args []string) error {
+	if len(args) != 2 {
+		return util.UsageError(cmd,
+			"network blackout remove takes exactly two arguments i.e network name and cidr")
+	}
+
+	rootURL := config.GetString("RootURL")
+	resp, err := resty.R().
+		SetQueryParam("cidr", args[1]).
+		Delete(rootURL + "/networks/" + args[0] + "/blackout")
+	if err != nil {
+		return err
+	}
+
+	return showBlackout(resp)
+}
+
+func networkBlackoutList(cmd *cli.Command, args []string) error {
+	if len(args) != 1 {
+		return util.UsageError(cmd,
+			"network blackout list takes exactly one argument i.e network name")
+	}
+
+	rootURL := config.GetString("RootURL")
+	resp, err := resty.R().Get(rootURL + "/networks/" + args[0] + "/blackout")
+	if err != nil {
+		return err
+	}
+
+	return showBlackout(resp)
+}
+
+// showBlackout displays the list of blacked out CIDRs
+// returned by romana service in tabular or json format.
+func showBlackout(resp *resty.Response) error {
+	if config.GetString("Format") == "json" {
+		JSONFormat(resp.Body(), os.Stdout)
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 0, '\t', 0)
+	if resp.StatusCode() == http.StatusOK {
+		var blackout api.IPAMBlackoutResponse
+		err := json.Unmarshal(resp.Body(), &blackout)
+		if err == nil {
+			fmt.Printf("Blacked out CIDRs of network %s (%s)\n", blackout.Network, blackout.CIDR.String())
+			fmt.Fprintf(w, "CIDR\n")
+			for _, cidr := range blackout.BlackedOut {
+				fmt.Fprintf(w, "%s\n", cidr.String())
+			}
+		} else {
+			fmt.Printf("Error: %s \n", err)
+		}
+	} else {
+		var e Error
+		json.Unmarshal(resp.Body(), &e)
+
+		fmt.Println("Network Blackout Error")
+		fmt.Fprintf(w, "Fields\t%s\n", e.Fields)
+		fmt.Fprintf(w, "Message\t%s\n", e.Message)
+		fmt.Fprintf(w, "Status\t%d\n", resp.StatusCode())
+	}
+	w.Flush()
+
 	return nil
 }
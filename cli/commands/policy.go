@@ -18,9 +18,12 @@ package commands
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"os"
+	"strings"
 	"text/tabwriter"
 
 	"github.com/romana/core/cli/util"
@@ -44,7 +47,7 @@ type Policies struct {
 
 // policyCmd represents the policy commands
 var policyCmd = &cli.Command{
-	Use:   "policy [add|show|list|remove]",
+	Use:   "policy [add|show|list|remove|render|check]",
 	Short: "Add, Remove or Show policies for romana services.",
 	Long: `Add, Remove or Show policies for romana services.
 
@@ -57,8 +60,31 @@ func init() {
 	policyCmd.AddCommand(policyRemoveCmd)
 	policyCmd.AddCommand(policyListCmd)
 	policyCmd.AddCommand(policyShowCmd)
+	policyCmd.AddCommand(policyRenderCmd)
+	policyCmd.AddCommand(policyCheckCmd)
+
+	policyRenderCmd.Flags().StringVarP(&policyRenderHost, "host", "", "",
+		"Name or IP of the host to render policies for.")
+
+	policyCheckCmd.Flags().StringVarP(&policyCheckSource, "source", "s", "",
+		"Source of the traffic, an IP or tenant[/segment].")
+	policyCheckCmd.Flags().StringVarP(&policyCheckDestination, "destination", "d", "",
+		"Destination of the traffic, an IP or tenant[/segment].")
+	policyCheckCmd.Flags().StringVarP(&policyCheckProtocol, "protocol", "p", "tcp",
+		"Protocol of the traffic, one of tcp, udp or icmp.")
+	policyCheckCmd.Flags().UintVarP(&policyCheckPort, "port", "", 0,
+		"Destination port of the traffic.")
 }
 
+var (
+	policyRenderHost string
+
+	policyCheckSource      string
+	policyCheckDestination string
+	policyCheckProtocol    string
+	policyCheckPort        uint
+)
+
 var policyAddCmd = &cli.Command{
 	Use:   "add [policyFile][STDIN]",
 	Short: "Add a new policy.",
@@ -103,6 +129,32 @@ var policyShowCmd = &cli.Command{
 	SilenceUsage: true,
 }
 
+var policyRenderCmd = &cli.Command{
+	Use:   "render --host HOST [policyFile][STDIN]",
+	Short: "Render iptables rules for policies without applying them.",
+	Long: `Render iptables rules for policies without applying them.
+
+Shows iptables rules and ipsets romana agent on the host
+would apply for the policies in policyFile or input pipe,
+given the current blocks. The policies are not added.
+`,
+	RunE:         policyRender,
+	SilenceUsage: true,
+}
+
+var policyCheckCmd = &cli.Command{
+	Use:   "check --source SOURCE --destination DESTINATION [--protocol PROTOCOL] [--port PORT]",
+	Short: "Check if policies allow traffic between endpoints.",
+	Long: `Check if policies allow traffic between endpoints.
+
+Source and destination are either IP addresses or tenants
+with optional segments, e.g. tenant-a/frontend. Shows whether
+current policies allow the traffic and which policies allow it.
+`,
+	RunE:         policyCheck,
+	SilenceUsage: true,
+}
+
 // policyAdd adds romana policy for a specific tenant
 // using the policyFile provided or through input pipe.
 // The features supported are:
@@ -114,54 +166,14 @@ var policyShowCmd = &cli.Command{
 //  * Tabular and json output for indication of policy
 //    addition
 func policyAdd(cmd *cli.Command, args []string) error {
-	var buf []byte
-	var policyFile string
-	var err error
-	isFile := true
 	isJSON := config.GetString("Format") == "json"
-
-	if len(args) == 0 {
-		isFile = false
-		buf, err = ioutil.ReadAll(os.Stdin)
-		if err != nil {
-			util.UsageError(cmd,
-				"POLICY FILE name or piped input from 'STDIN' expected.")
-			return fmt.Errorf("cannot read 'STDIN': %s", err)
-		}
-	} else if len(args) != 1 {
-		return util.UsageError(cmd,
-			"POLICY FILE name or piped input from 'STDIN' expected.")
-	}
-
-	if isFile {
-		policyFile = args[0]
-	}
-
 	rootURL := config.GetString("RootURL")
 
+	var err error
 	reqPolicies := Policies{}
-	if isFile {
-		pBuf, err := ioutil.ReadFile(policyFile)
-		if err != nil {
-			return fmt.Errorf("file error: %s", err)
-		}
-		err = json.Unmarshal(pBuf, &reqPolicies.SecurityPolicies)
-		if err != nil || len(reqPolicies.SecurityPolicies) == 0 {
-			reqPolicies.SecurityPolicies = make([]api.Policy, 1)
-			err = json.Unmarshal(pBuf, &reqPolicies.SecurityPolicies[0])
-			if err != nil {
-				return err
-			}
-		}
-	} else {
-		err = json.Unmarshal(buf, &reqPolicies.SecurityPolicies)
-		if err != nil || len(reqPolicies.SecurityPolicies) == 0 {
-			reqPolicies.SecurityPolicies = make([]api.Policy, 1)
-			err = json.Unmarshal(buf, &reqPolicies.SecurityPolicies[0])
-			if err != nil {
-				return err
-			}
-		}
+	reqPolicies.SecurityPolicies, err = readPolicies(cmd, args)
+	if err != nil {
+		return err
 	}
 
 	result := make([]map[string]interface{}, len(reqPolicies.SecurityPolicies))
@@ -216,6 +228,148 @@ func policyAdd(cmd *cli.Command, args []string) error {
 	return nil
 }
 
+// readPolicies reads a single policy or a list of policies from
+// the policyFile provided in args or from the input pipe.
+func readPolicies(cmd *cli.Command, args []string) ([]api.Policy, error) {
+	var buf []byte
+	var err error
+
+	if len(args) == 0 {
+		buf, err = ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			util.UsageError(cmd,
+				"POLICY FILE name or piped input from 'STDIN' expected.")
+			return nil, fmt.Errorf("cannot read 'STDIN': %s", err)
+		}
+	} else if len(args) != 1 {
+		return nil, util.UsageError(cmd,
+			"POLICY FILE name or piped input from 'STDIN' expected.")
+	} else {
+		buf, err = ioutil.ReadFile(args[0])
+		if err != nil {
+			return nil, fmt.Errorf("file error: %s", err)
+		}
+	}
+
+	var policies []api.Policy
+	err = json.Unmarshal(buf, &policies)
+	if err != nil || len(policies) == 0 {
+		policies = make([]api.Policy, 1)
+		err = json.Unmarshal(buf, &policies[0])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return policies, nil
+}
+
+// policyRender shows iptables rules and ipsets rendered by romanad
+// for the host from the policies provided.
+func policyRender(cmd *cli.Command, args []string) error {
+	if policyRenderHost == "" {
+		return util.UsageError(cmd, "--host is required.")
+	}
+
+	policies, err := readPolicies(cmd, args)
+	if err != nil {
+		return err
+	}
+
+	rootURL := config.GetString("RootURL")
+	resp, err := resty.R().SetHeader("Content-Type", "application/json").
+		SetQueryParam("host", policyRenderHost).
+		SetBody(policies).Post(rootURL + "/policies/render")
+	if err != nil {
+		return err
+	}
+
+	if config.GetString("Format") == "json" || resp.StatusCode() != http.StatusOK {
+		return showResult(resp, "")
+	}
+
+	var render api.PolicyRenderResponse
+	err = json.Unmarshal(resp.Body(), &render)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("# ipsets for host %s\n", render.Host)
+	fmt.Print(render.Ipsets)
+	fmt.Printf("# iptables for host %s\n", render.Host)
+	fmt.Print(render.IPtables)
+
+	return nil
+}
+
+// parseCheckEndpoint converts an IP or tenant[/segment]
+// into api.PolicyCheckEndpoint.
+func parseCheckEndpoint(s string) api.PolicyCheckEndpoint {
+	if net.ParseIP(s) != nil {
+		return api.PolicyCheckEndpoint{IP: s}
+	}
+	parts := strings.SplitN(s, "/", 2)
+	e := api.PolicyCheckEndpoint{TenantID: parts[0]}
+	if len(parts) == 2 {
+		e.SegmentID = parts[1]
+	}
+	return e
+}
+
+// policyCheck shows whether policies allow the traffic
+// between source and destination.
+func policyCheck(cmd *cli.Command, args []string) error {
+	if len(args) > 0 {
+		return util.UsageError(cmd, "Policy check takes no arguments.")
+	}
+	if policyCheckSource == "" || policyCheckDestination == "" {
+		return util.UsageError(cmd, "--source and --destination are required.")
+	}
+
+	req := api.PolicyCheckRequest{
+		Source:      parseCheckEndpoint(policyCheckSource),
+		Destination: parseCheckEndpoint(policyCheckDestination),
+		Protocol:    policyCheckProtocol,
+		Port:        policyCheckPort,
+	}
+
+	rootURL := config.GetString("RootURL")
+	resp, err := resty.R().SetHeader("Content-Type", "application/json").
+		SetBody(req).Post(rootURL + "/policies/check")
+	if err != nil {
+		return err
+	}
+
+	if config.GetString("Format") == "json" || resp.StatusCode() != http.StatusOK {
+		return showResult(resp, "")
+	}
+
+	var check api.PolicyCheckResponse
+	err = json.Unmarshal(resp.Body(), &check)
+	if err != nil {
+		return err
+	}
+
+	verdict := "denied"
+	if check.Allowed {
+		verdict = "allowed"
+	}
+	fmt.Printf("Traffic from %s to %s is %s: %s\n",
+		check.Source, check.Destination, verdict, check.Reason)
+
+	if len(check.Matches) > 0 {
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 0, '\t', 0)
+		fmt.Fprintf(w, "Policy Id\tDirection\tTarget\tPeer\tRule\n")
+		for _, m := range check.Matches {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+				m.PolicyID, m.Direction, m.Target, m.Peer, m.Rule)
+		}
+		w.Flush()
+	}
+
+	return nil
+}
+
 // policyRemove removes policy using the policy name provided
 // as argument through args. It returns error if policy is not
 // found, or returns a list of policy ID's if multiple policies
@@ -343,6 +497,10 @@ func policyListShow(listOnly bool, args []string) error {
 					noOfPeers += len(p.Ingress[i].Peers)
 					noOfRules += len(p.Ingress[i].Rules)
 				}
+				for i := range p.Egress {
+					noOfPeers += len(p.Egress[i].Peers)
+					noOfRules += len(p.Egress[i].Rules)
+				}
 
 				fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t%s\n",
 					p.ID,
@@ -367,30 +525,11 @@ func policyListShow(listOnly bool, args []string) error {
 						fmt.Fprintf(w, "\tSegmentID:\t%s\n", ato.SegmentID)
 					}
 				}
-				if len(p.Ingress) > 0 {
-					for _, ingress := range p.Ingress {
-						if len(ingress.Peers) > 0 {
-							fmt.Fprintln(w, "Peers:")
-							for _, peer := range ingress.Peers {
-								fmt.Fprintf(w, "\tPeer:\t%s\n", peer.Peer)
-								fmt.Fprintf(w, "\tCidr:\t%s\n", peer.Cidr)
-								fmt.Fprintf(w, "\tDestination:\t%s\n", peer.Dest)
-								fmt.Fprintf(w, "\tTenantID:\t%s\n", peer.TenantID)
-								fmt.Fprintf(w, "\tSegmentID:\t%s\n", peer.SegmentID)
-							}
-						}
-						if len(ingress.Rules) > 0 {
-							fmt.Fprintln(w, "Rules:")
-							for _, rule := range ingress.Rules {
-								fmt.Fprintf(w, "\tProtocol:\t%s\n", rule.Protocol)
-								fmt.Fprintf(w, "\tIsStateful:\t%t\n", rule.IsStateful)
-								fmt.Fprintf(w, "\tPorts:\t%v\n", rule.Ports)
-								fmt.Fprintf(w, "\tPortRanges:\t%v\n", rule.PortRanges)
-								fmt.Fprintf(w, "\tIcmpType:\t%d\n", rule.IcmpType)
-								fmt.Fprintf(w, "\tIcmpCode:\t%d\n", rule.IcmpCode)
-							}
-						}
-					}
+				for _, ingress := range p.Ingress {
+					printPolicySection(w, "", ingress.Peers, ingress.Rules)
+				}
+				for _, egress := range p.Egress {
+					printPolicySection(w, "Egress ", egress.Peers, egress.Rules)
 				}
 				fmt.Fprint(w, "\n")
 			}
@@ -400,3 +539,29 @@ func policyListShow(listOnly bool, args []string) error {
 
 	return nil
 }
+
+// printPolicySection prints peers and rules of the policy
+// ingress or egress section.
+func printPolicySection(w io.Writer, prefix string, peers []api.Endpoint, rules []api.Rule) {
+	if len(peers) > 0 {
+		fmt.Fprintf(w, "%sPeers:\n", prefix)
+		for _, peer := range peers {
+			fmt.Fprintf(w, "\tPeer:\t%s\n", peer.Peer)
+			fmt.Fprintf(w, "\tCidr:\t%s\n", peer.Cidr)
+			fmt.Fprintf(w, "\tDestination:\t%s\n", peer.Dest)
+			fmt.Fprintf(w, "\tTenantID:\t%s\n", peer.TenantID)
+			fmt.Fprintf(w, "\tSegmentID:\t%s\n", peer.SegmentID)
+		}
+	}
+	if len(rules) > 0 {
+		fmt.Fprintf(w, "%sRules:\n", prefix)
+		for _, rule := range rules {
+			fmt.Fprintf(w, "\tProtocol:\t%s\n", rule.Protocol)
+			fmt.Fprintf(w, "\tIsStateful:\t%t\n", rule.IsStateful)
+			fmt.Fprintf(w, "\tPorts:\t%v\n", rule.Ports)
+			fmt.Fprintf(w, "\tPortRanges:\t%v\n", rule.PortRanges)
+			fmt.Fprintf(w, "\tIcmpType:\t%d\n", rule.IcmpType)
+			fmt.Fprintf(w, "\tIcmpCode:\t%d\n", rule.IcmpCode)
+		}
+	}
+}
@@ -39,6 +39,11 @@ var (
 	verbose  bool
 	format   string
 	platform string
+	caCert   string
+	cert     string
+	key      string
+
+	credential *common.Credential
 )
 
 // type Error contains information for
@@ -83,6 +88,8 @@ func init() {
 	RootCmd.AddCommand(networkCmd)
 	RootCmd.AddCommand(blockCmd)
 	RootCmd.AddCommand(topologyCmd)
+	RootCmd.AddCommand(addressCmd)
+	RootCmd.AddCommand(ipamCmd)
 
 	RootCmd.Flags().BoolVarP(&version, "version", "",
 		false, "Build and Versioning Information.")
@@ -97,6 +104,13 @@ func init() {
 		"P", "", "Use platforms like [openstack|kubernetes], etc.")
 	RootCmd.PersistentFlags().BoolVarP(&verbose, "verbose",
 		"v", false, "Verbose output.")
+	RootCmd.PersistentFlags().StringVarP(&caCert, "ca-cert",
+		"", "", "CA certificates to verify root service with.")
+	RootCmd.PersistentFlags().StringVarP(&cert, "cert",
+		"", "", "Client certificate to present to root service.")
+	RootCmd.PersistentFlags().StringVarP(&key, "key",
+		"", "", "Key of the client certificate.")
+	credential = common.NewCredentialCobra(RootCmd)
 
 	RootCmd.PersistentPreRun = preConfig
 	RootCmd.Run = versionInfo
@@ -134,6 +148,31 @@ func preConfig(cmd *cli.Command, args []string) {
 		platform = "kubernetes"
 	}
 	config.Set("Platform", platform)
+
+	if caCert == "" {
+		caCert = config.GetString("CACert")
+	}
+	if cert == "" {
+		cert = config.GetString("Cert")
+	}
+	if key == "" {
+		key = config.GetString("Key")
+	}
+	if caCert != "" || cert != "" || key != "" {
+		tlsConfig, err := common.NewClientTLSConfig(caCert, cert, key)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading TLS configuration: %s\n", err)
+			os.Exit(1)
+		}
+		resty.SetTLSClientConfig(tlsConfig)
+	}
+
+	// send authentication token, if any, with every request.
+	if err := credential.Initialize(); err != nil {
+		log.Printf("Error initializing credentials: %s", err)
+	} else if credential.Type == common.CredentialToken {
+		resty.SetAuthToken(credential.Token)
+	}
 }
 
 // versionInfo displays the build and versioning information.
@@ -19,6 +19,7 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"math"
 	"os"
 	"os/exec"
 	"strings"
@@ -54,6 +55,10 @@ var (
 
 func main() {
 	var err error
+	var romanaConfig common.Config
+	romanaConfig.AddEtcdTLSFlags(flag.CommandLine)
+	romanaConfig.AddEtcdAPIFlag(flag.CommandLine)
+	romanaConfig.AddStoreFlags(flag.CommandLine)
 
 	etcdEndpoints := flag.String("endpoints", "", "csv list of etcd endpoints to romana storage")
 	etcdPrefix := flag.String("prefix", "", "string that prefixes all romana keys in etcd")
@@ -65,21 +70,37 @@ func main() {
 	romanaRouteTableId := flag.Int("route-table-id", DefaultRouteTableId,
 		"id that romana route table should have in /etc/iproute2/rt_tables")
 	multihop := flag.Bool("multihop-blocks", false, "allows multihop blocks")
+	multipathName := flag.String("multipath", string(agent.MultipathFirstPath),
+		"how to route blocks of hosts reachable over several paths, first-path or all-paths (ECMP)")
 	policyEnforcer := flag.Bool("policy", false, "enable romana policies")
+	policyBackend := flag.String("policy-backend", enforcer.BackendIptables,
+		"backend used to enforce romana policies, iptables or nftables")
+	logDenies := flag.String("log-denies", enforcer.DenyLogNone,
+		"log traffic denied by romana policies, none, policy (only policies with log_denies set) or all")
+	logDeniesGroup := flag.Uint("log-denies-group", enforcer.DefaultNflogGroup,
+		"NFLOG group used to pass denied packets to the agent")
+	logDeniesRate := flag.String("log-denies-rate", enforcer.DefaultDenyLogRate,
+		"maximum rate of denied packets logged by every rule, e.g. 10/second")
+	logDeniesFile := flag.String("log-denies-file", "",
+		"file to append denied packets to as JSON lines, standard output when empty")
 	metricsPort := flag.Int("metrics", 9607, "tcp port to expose prometheus metrics, -1 means disable")
 	flag.Parse()
 
 	fmt.Println(common.BuildInfo())
 
+	multipath, err := agent.ParseMultipathPolicy(*multipathName)
+	if err != nil {
+		log.Errorf("Invalid -multipath flag, %s", err)
+		os.Exit(2)
+	}
+
 	if err := agent.MetricStart(*metricsPort); err != nil {
 		log.Errorf("Failed to start metrics collector")
 		os.Exit(2)
 	}
 
-	romanaConfig := common.Config{
-		EtcdEndpoints: strings.Split(*etcdEndpoints, ","),
-		EtcdPrefix:    *etcdPrefix,
-	}
+	romanaConfig.EtcdEndpoints = strings.Split(*etcdEndpoints, ",")
+	romanaConfig.EtcdPrefix = *etcdPrefix
 
 	if *hostname == "" {
 		*hostname, err = os.Hostname()
@@ -88,6 +109,11 @@ func main() {
 		}
 	}
 
+	if err := romanaConfig.ValidateSharedStore(); err != nil {
+		log.Errorf("Invalid -store flag, %s", err)
+		os.Exit(2)
+	}
+
 	romanaClient, err := client.NewClient(&romanaConfig)
 	if err != nil {
 		log.Errorf("Failed to initialize romana client: %v", err)
@@ -166,7 +192,7 @@ func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	err = agent.StartRomanaVIPSync(ctx, romanaClient.Store, defaultLink)
+	err = agent.StartRomanaVIPSync(ctx, romanaClient, *hostname, defaultLink)
 	if err != nil {
 		log.Errorf("failed to start romanaVIP syncing mechanism: %s\n", err)
 		os.Exit(4)
@@ -179,12 +205,14 @@ func main() {
 	}
 
 	if *policyEnforcer {
-		// ipset is needed by enforcer below, so fail here
+		// ipset is needed by iptables enforcer below, so fail here
 		// instead of later during run time.
-		_, err := exec.LookPath("ipset")
-		if err != nil {
-			log.Errorf("failed to find ipset, %s", err)
-			os.Exit(2)
+		if *policyBackend == enforcer.BackendIptables {
+			_, err := exec.LookPath("ipset")
+			if err != nil {
+				log.Errorf("failed to find ipset, %s", err)
+				os.Exit(2)
+			}
 		}
 
 		ctx := context.Background()
@@ -204,7 +232,27 @@ func main() {
 		var extraBlocksChannel <-chan api.IPAMBlocksResponse
 		blocksChannel, extraBlocksChannel = fanOut(ctx, blocksChannel)
 
-		enforcer, err := enforcer.New(policyCache, policies, *blocksList, extraBlocksChannel, *hostname, new(utilexec.DefaultExecutor), 10)
+		if *logDeniesGroup > math.MaxUint16 {
+			log.Errorf("Invalid NFLOG group %d", *logDeniesGroup)
+			os.Exit(2)
+		}
+		denyLog := enforcer.DenyLogConfig{
+			Mode:   *logDenies,
+			Group:  uint16(*logDeniesGroup),
+			Rate:   *logDeniesRate,
+			Output: os.Stdout,
+		}
+		if *logDeniesFile != "" && *logDenies != enforcer.DenyLogNone {
+			denyLogFile, err := os.OpenFile(*logDeniesFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640)
+			if err != nil {
+				log.Errorf("Failed to open deny log file, %s", err)
+				os.Exit(2)
+			}
+			defer denyLogFile.Close()
+			denyLog.Output = denyLogFile
+		}
+
+		enforcer, err := enforcer.New(policyCache, policies, *blocksList, extraBlocksChannel, *hostname, new(utilexec.DefaultExecutor), 10, *policyBackend, denyLog)
 		if err != nil {
 			log.Errorf("Failed to create policy enforcer, %s", err)
 			os.Exit(2)
@@ -224,24 +272,39 @@ func main() {
 	initialHosts := <-hostsChannel
 	hosts := agent.IpamHosts(initialHosts.Hosts)
 
+	// Routes are reconciled whenever blocks or hosts change,
+	// but not before the first list of blocks is received.
+	var blocks []api.IPAMBlockResponse
+	var haveBlocks bool
 	for {
 		select {
-		case blocks := <-blocksChannel:
-			startTime := time.Now()
-			err := rtable.FlushRomanaTable()
-			if err != nil {
-				log.Errorf("failed to flush romana route table err=(%s)", err)
-				continue
-			}
-
-			agent.CreateRouteToBlocks(blocks.Blocks, hosts, *romanaRouteTableId, *hostname, *multihop, nlHandle)
-			runTime := time.Now().Sub(startTime)
-			log.Tracef(4, "Time between route table flush and route table rebuild %s", runTime)
+		case newBlocks := <-blocksChannel:
+			blocks = newBlocks.Blocks
+			haveBlocks = true
 
 		case newHosts := <-hostsChannel:
 			// TODO need mutex for this.
 			hosts = agent.IpamHosts(newHosts.Hosts)
 		}
+
+		if !haveBlocks {
+			continue
+		}
+
+		routedBlocks, err := romanaClient.RoutedBlocks(blocks)
+		if err != nil {
+			log.Errorf("failed to filter blocks routed to hosts err=(%s)", err)
+			continue
+		}
+
+		startTime := time.Now()
+		result, err := agent.ReconcileRoutesToBlocks(routedBlocks, hosts, *romanaRouteTableId, *hostname, *multihop, multipath, nlHandle)
+		if err != nil {
+			log.Errorf("failed to reconcile romana route table err=(%s)", err)
+		}
+		runTime := time.Now().Sub(startTime)
+		log.Tracef(4, "Route table reconciled in %s, %d added, %d replaced, %d removed, %d unchanged",
+			runTime, result.Added, result.Replaced, result.Removed, result.Unchanged)
 	}
 }
 
@@ -16,12 +16,18 @@
 package main
 
 import (
+	"os"
+
 	"github.com/romana/core/cni"
 
 	"github.com/containernetworking/cni/pkg/skel"
-	"github.com/containernetworking/cni/pkg/version"
 )
 
 func main() {
-	skel.PluginMain(cni.CmdAdd, cni.CmdDel, version.All)
+	// Vendored skel only dispatches ADD and DEL.
+	if os.Getenv("CNI_COMMAND") == "CHECK" {
+		cni.CheckMain()
+		return
+	}
+	skel.PluginMain(cni.CmdAdd, cni.CmdDel, cni.VersionInfo)
 }
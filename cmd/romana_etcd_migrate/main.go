@@ -0,0 +1,85 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+// Command for copying romana data stored with etcd v2 API to etcd v3 API.
+//
+// Romana services must be stopped while the data is migrated, and
+// started with -etcd-api v3 afterwards.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/romana/core/common"
+	"github.com/romana/core/common/client"
+	log "github.com/romana/rlog"
+)
+
+func main() {
+	var config common.Config
+	config.AddEtcdTLSFlags(flag.CommandLine)
+
+	endpointsStr := flag.String("etcd-endpoints", client.DefaultEtcdEndpoints, "Comma-separated list of etcd endpoints.")
+	v3EndpointsStr := flag.String("etcd-v3-endpoints", "",
+		"Comma-separated list of etcd endpoints to copy the data to, same as -etcd-endpoints if not specified.")
+	prefix := flag.String("etcd-prefix", client.DefaultEtcdPrefix, "Prefix of romana data in etcd.")
+	overwrite := flag.Bool("overwrite", false, "Replace keys that already exist in etcd v3.")
+	flag.Parse()
+
+	fmt.Println(common.BuildInfo())
+
+	pr := *prefix
+	if !strings.HasPrefix(pr, "/") {
+		pr = "/" + pr
+	}
+
+	v2Config := config
+	v2Config.EtcdAPI = common.EtcdAPIv2
+	v2Config.EtcdEndpoints = strings.Split(*endpointsStr, ",")
+	v2Config.EtcdPrefix = pr
+
+	v3Config := v2Config
+	v3Config.EtcdAPI = common.EtcdAPIv3
+	if *v3EndpointsStr != "" {
+		v3Config.EtcdEndpoints = strings.Split(*v3EndpointsStr, ",")
+	}
+
+	v2, err := client.NewStoreFromConfig(&v2Config)
+	if err != nil {
+		log.Errorf("Failed to connect to etcd v2 API: %s", err)
+		os.Exit(1)
+	}
+	defer v2.Close()
+
+	v3, err := client.NewStoreFromConfig(&v3Config)
+	if err != nil {
+		log.Errorf("Failed to connect to etcd v3 API: %s", err)
+		os.Exit(1)
+	}
+	defer v3.Close()
+
+	result, err := client.MigrateV2ToV3(v2, v3, *overwrite)
+	for _, key := range result.Skipped {
+		fmt.Printf("skipped %s\n", key)
+	}
+	fmt.Printf("copied %d keys under %s\n", len(result.Copied), pr)
+	if err != nil {
+		log.Errorf("Failed to migrate romana data: %s", err)
+		os.Exit(2)
+	}
+}
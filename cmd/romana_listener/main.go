@@ -30,14 +30,26 @@ import (
 )
 
 func main() {
+	var config common.Config
+	config.AddEtcdTLSFlags(flag.CommandLine)
+	config.AddEtcdAPIFlag(flag.CommandLine)
+	config.AddStoreFlags(flag.CommandLine)
+	config.AddServerTLSFlags(flag.CommandLine)
+
 	endpointsStr := flag.String("etcd-endpoints", client.DefaultEtcdEndpoints, "Comma-separated list of etcd endpoints.")
 	host := flag.String("host", "localhost", "Host to listen on.")
 	port := flag.Int("port", 9602, "Port to listen on.")
 	prefix := flag.String("etcd-prefix", client.DefaultEtcdPrefix, "Prefix to use for etcd data.")
+	metricsPort := flag.Int("metrics", 9608, "tcp port to expose prometheus metrics, -1 means disable")
 	flag.Parse()
 
 	fmt.Println(common.BuildInfo())
 
+	if err := listener.MetricStart(*metricsPort); err != nil {
+		log.Errorf("Failed to start metrics collector, %s", err)
+		os.Exit(2)
+	}
+
 	if endpointsStr == nil {
 		log.Errorf("No etcd endpoints specified")
 		os.Exit(1)
@@ -49,9 +61,13 @@ func main() {
 	if !strings.HasPrefix(pr, "/") {
 		pr = "/" + pr
 	}
-	config := common.Config{EtcdEndpoints: endpoints,
-		EtcdPrefix: pr,
+	config.EtcdEndpoints = endpoints
+	config.EtcdPrefix = pr
+	if err := config.ValidateSharedStore(); err != nil {
+		log.Errorf("Invalid -store flag, %s", err)
+		os.Exit(2)
 	}
+
 	svcInfo, err := common.InitializeService(listener, config)
 	if err != nil {
 		log.Error(err)
@@ -24,9 +24,13 @@ import (
 
 	"github.com/romana/core/common"
 	"github.com/romana/core/common/client"
-	"github.com/romana/core/routepublisher/bird"
 	"github.com/romana/core/routepublisher/publisher"
 
+	// Route publisher implementations, registered by name.
+	_ "github.com/romana/core/routepublisher/bgp"
+	_ "github.com/romana/core/routepublisher/bird"
+	_ "github.com/romana/core/routepublisher/frr"
+
 	log "github.com/romana/rlog"
 )
 
@@ -83,6 +87,9 @@ func GetGroupByHost(ipam *client.IPAM, hostname string) map[string]*client.Group
 
 func main() {
 	var err error
+	var romanaConfig common.Config
+	romanaConfig.AddEtcdTLSFlags(flag.CommandLine)
+	romanaConfig.AddEtcdAPIFlag(flag.CommandLine)
 
 	etcdEndpoints := flag.String("endpoints", "", "csv list of etcd endpoints to romana storage")
 	etcdPrefix := flag.String("prefix", "", "string that prefixes all romana keys in etcd")
@@ -92,6 +99,15 @@ func main() {
 	flagBirdPidFile := flag.String("pid", "/var/run/bird.pid", "location of bird pid file")
 	flagDebug := flag.String("debug", "", "set to yes or true to enable debug output")
 	flagLocalAS := flag.String("as", "65534", "local as number")
+	flagPublisher := flag.String("publisher", "bird",
+		fmt.Sprintf("route publisher implementation, one of %s", strings.Join(publisher.Names(), ", ")))
+	flagNeighbors := flag.String("neighbors", "", "csv list of bgp neighbors as ip:as, used by frr and bgp publishers")
+	flagRouterID := flag.String("router-id", "", "bgp router id, used by frr and bgp publishers")
+	flagNextHop := flag.String("next-hop", "", "next hop for announced networks, used by bgp publisher, defaults to local address of the session")
+	flagHoldTime := flag.String("hold-time", "90", "bgp hold time in seconds, used by bgp publisher")
+	flagFrrTemplateFile := flag.String("frr-template", "", "template file for frr bgpd config, built in template is used when empty")
+	flagFrrConfigFile := flag.String("frr-config", "/etc/frr/bgpd.conf", "location of the frr bgpd config file")
+	flagFrrReload := flag.String("frr-reload", "/usr/lib/frr/frr-reload.py --reload --daemon bgpd", "command to reload frr, config file name is appended to it")
 	flag.Parse()
 
 	fmt.Println(common.BuildInfo())
@@ -102,16 +118,21 @@ func main() {
 	config["pidFile"] = *flagBirdPidFile
 	config["localAS"] = *flagLocalAS
 	config["debug"] = *flagDebug
-
-	bird, err := bird.New(publisher.Config(config))
+	config["neighbors"] = *flagNeighbors
+	config["routerID"] = *flagRouterID
+	config["nextHop"] = *flagNextHop
+	config["holdTime"] = *flagHoldTime
+	config["frrTemplateFileName"] = *flagFrrTemplateFile
+	config["frrConfigName"] = *flagFrrConfigFile
+	config["reloadCommand"] = *flagFrrReload
+
+	routePublisher, err := publisher.New(*flagPublisher, publisher.Config(config))
 	if err != nil {
 		panic(err)
 	}
 
-	romanaConfig := common.Config{
-		EtcdEndpoints: strings.Split(*etcdEndpoints, ","),
-		EtcdPrefix:    *etcdPrefix,
-	}
+	romanaConfig.EtcdEndpoints = strings.Split(*etcdEndpoints, ",")
+	romanaConfig.EtcdPrefix = *etcdPrefix
 
 	if *hostname == "" {
 		*hostname, err = os.Hostname()
@@ -139,6 +160,12 @@ func main() {
 	for {
 		select {
 		case blocks := <-blocksChannel:
+			routedBlocks, err := romanaClient.RoutedBlocks(blocks.Blocks)
+			if err != nil {
+				log.Errorf("Failed to filter blocks routed to hosts, %s", err)
+				continue
+			}
+
 			startTime := time.Now()
 
 			hostGroups := GetGroupByHost(romanaClient.IPAM, *hostname)
@@ -148,7 +175,7 @@ func main() {
 				args["HostGroups"] = hostGroups
 			}
 
-			createRouteToBlocks(blocks.Blocks, args, *hostname, bird)
+			createRouteToBlocks(routedBlocks, args, *hostname, routePublisher)
 			runTime := time.Now().Sub(startTime)
 			log.Tracef(4, "Time between route table flush and route table rebuild %s", runTime)
 
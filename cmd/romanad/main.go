@@ -30,11 +30,19 @@ import (
 )
 
 func main() {
+	var config common.Config
+	config.AddEtcdTLSFlags(flag.CommandLine)
+	config.AddEtcdAPIFlag(flag.CommandLine)
+	config.AddStoreFlags(flag.CommandLine)
+	config.AddServerTLSFlags(flag.CommandLine)
+
 	endpointsStr := flag.String("etcd-endpoints", client.DefaultEtcdEndpoints, "Comma-separated list of etcd endpoints.")
 	host := flag.String("host", "localhost", "Host to listen on.")
 	port := flag.Int("port", 9600, "Port to listen on.")
 	prefix := flag.String("etcd-prefix", client.DefaultEtcdPrefix, "Prefix to use for etcd data.")
 	topologyFile := flag.String("initial-topology-file", "", "Initial topology")
+	authPublicKey := flag.String("auth-public-key", "",
+		"PEM encoded RSA public key to verify authentication tokens with, authentication is off if not specified")
 	flag.Parse()
 
 	fmt.Println(common.BuildInfo())
@@ -51,10 +59,11 @@ func main() {
 		pr = "/" + pr
 	}
 
-	config := common.Config{EtcdEndpoints: endpoints,
-		EtcdPrefix:          pr,
-		InitialTopologyFile: topologyFile,
-	}
+	config.EtcdEndpoints = endpoints
+	config.EtcdPrefix = pr
+	config.InitialTopologyFile = topologyFile
+	config.AuthPublicKey = *authPublicKey
+
 	svcInfo, err := common.InitializeService(romanad, config)
 	if err != nil {
 		log.Error(err)
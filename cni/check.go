@@ -0,0 +1,355 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package cni
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+
+	"github.com/romana/core/common/client"
+
+	"github.com/containernetworking/cni/pkg/ns"
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/cni/pkg/types"
+	"github.com/containernetworking/cni/pkg/types/current"
+	log "github.com/romana/rlog"
+	"github.com/vishvananda/netlink"
+)
+
+// CheckMain handles CHECK method the way skel.PluginMain handles
+// ADD and DEL, since vendored skel doesn't dispatch CHECK.
+// On error it prints the error to stdout and exits.
+func CheckMain() {
+	args, err := checkArgs(os.Getenv, os.Stdin)
+	if err == nil {
+		err = CmdCheck(args)
+	}
+	if err != nil {
+		e, ok := err.(*types.Error)
+		if !ok {
+			e = &types.Error{Code: 100, Msg: err.Error()}
+		}
+		if err := e.Print(); err != nil {
+			log.Errorf("Failed to print error, err=(%s)", err)
+		}
+		os.Exit(1)
+	}
+}
+
+// checkArgs collects arguments of CHECK method from
+// environment variables and stdin.
+func checkArgs(getenv func(string) string, stdin io.Reader) (*skel.CmdArgs, error) {
+	args := &skel.CmdArgs{
+		ContainerID: getenv("CNI_CONTAINERID"),
+		Netns:       getenv("CNI_NETNS"),
+		IfName:      getenv("CNI_IFNAME"),
+		Args:        getenv("CNI_ARGS"),
+		Path:        getenv("CNI_PATH"),
+	}
+
+	for _, v := range []struct {
+		name  string
+		value string
+	}{
+		{"CNI_CONTAINERID", args.ContainerID},
+		{"CNI_NETNS", args.Netns},
+		{"CNI_IFNAME", args.IfName},
+		{"CNI_PATH", args.Path},
+	} {
+		if v.value == "" {
+			return nil, &types.Error{Code: 100, Msg: fmt.Sprintf("required env variable %s missing", v.name)}
+		}
+	}
+
+	var err error
+	args.StdinData, err = ioutil.ReadAll(stdin)
+	if err != nil {
+		return nil, fmt.Errorf("error reading from stdin, err=(%s)", err)
+	}
+	return args, nil
+}
+
+// CmdCheck is a callback function that gets called by CheckMain
+// in response to CHECK method. It rebuilds the result of CmdAdd from
+// the current state of the pod network and Romana IPAM, and compares
+// it to the result the runtime got from CmdAdd.
+func CmdCheck(args *skel.CmdArgs) error {
+	var err error
+	netConf, err := loadConf(args.StdinData)
+	if err != nil {
+		return err
+	}
+
+	err = checkVersion(netConf.CNIVersion)
+	if err != nil {
+		return err
+	}
+
+	prevResult, err := parsePrevResult(args.StdinData)
+	if err != nil {
+		return err
+	}
+
+	k8sargs := K8sArgs{}
+	err = types.LoadArgs(args.Args, &k8sargs)
+	if err != nil {
+		return fmt.Errorf("Failed to types.LoadArgs, err=(%s)", err)
+	}
+	podName := k8sargs.MakePodName()
+	vethName := k8sargs.MakeVethName()
+	log.Debugf("Checking network of pod %s", podName)
+
+	romanaClient, err := MakeRomanaClient(netConf)
+	if err != nil {
+		return err
+	}
+	podAddress, err := podAllocation(romanaClient.IPAM, podName)
+	if err != nil {
+		return err
+	}
+
+	hostVeth, err := checkHostVeth(vethName)
+	if err != nil {
+		return err
+	}
+
+	err = CheckEndpointRoute(vethName, podAddress, nil)
+	if err != nil {
+		return err
+	}
+
+	netns, err := ns.GetNS(args.Netns)
+	if err != nil {
+		return fmt.Errorf("failed to open netns %q: %v", args.Netns, err)
+	}
+	defer netns.Close()
+
+	err = netns.Do(func(_ ns.NetNS) error {
+		return checkContainerVeth(podIfName, podAddress, hostVeth.Attrs().Index)
+	})
+	if err != nil {
+		return err
+	}
+
+	hostIface := &current.Interface{
+		Name: hostVeth.Attrs().Name,
+		Mac:  hostVeth.Attrs().HardwareAddr.String(),
+	}
+	result := makeResult(hostIface, podAddress)
+
+	return compareResults(result, prevResult)
+}
+
+// checkVersion rejects network configs with versions of
+// CNI spec that predate CHECK method.
+func checkVersion(cniVersion string) error {
+	if cniVersion != cniVersionCheck {
+		return &types.Error{
+			Code:    1, // incompatible CNI version
+			Msg:     fmt.Sprintf("CHECK is not supported in CNI version %q", cniVersion),
+			Details: fmt.Sprintf("CHECK requires CNI version %s", cniVersionCheck),
+		}
+	}
+	return nil
+}
+
+// parsePrevResult returns the result of CmdAdd passed
+// to CHECK method in prevResult field of network config.
+func parsePrevResult(stdinData []byte) (*current.Result, error) {
+	conf := struct {
+		PrevResult json.RawMessage `json:"prevResult"`
+	}{}
+	err := json.Unmarshal(stdinData, &conf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse prevResult, err=(%s)", err)
+	}
+	if len(conf.PrevResult) == 0 || string(conf.PrevResult) == "null" {
+		return nil, fmt.Errorf("required prevResult is missing")
+	}
+
+	result, err := current.NewResult(conf.PrevResult)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse prevResult, err=(%s)", err)
+	}
+	prevResult, err := current.GetResult(result)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert prevResult, err=(%s)", err)
+	}
+	return prevResult, nil
+}
+
+// makeResult builds the result of CmdAdd, which lists
+// the host side of veth pair only.
+func makeResult(hostIface *current.Interface, podAddress *net.IPNet) *current.Result {
+	ipVersion := "4"
+	if podAddress.IP.To4() == nil {
+		ipVersion = "6"
+	}
+	return &current.Result{
+		IPs: []*current.IPConfig{
+			&current.IPConfig{
+				Version:   ipVersion,
+				Address:   *podAddress,
+				Interface: 0,
+			},
+		},
+		Interfaces: []*current.Interface{hostIface},
+	}
+}
+
+// compareResults checks that the result reconstructed by CmdCheck
+// matches the one returned by CmdAdd. Previous result may list
+// interfaces added by other plugins in the chain, so only interfaces
+// of the reconstructed result are looked up in it.
+func compareResults(result *current.Result, prevResult *current.Result) error {
+	podAddress := result.IPs[0].Address
+	found := false
+	for _, ipConfig := range prevResult.IPs {
+		if ipConfig.Address.String() == podAddress.String() {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return fmt.Errorf("pod address %s allocated in IPAM not found in previous result %v", podAddress.String(), prevResult.IPs)
+	}
+
+	for _, iface := range result.Interfaces {
+		var prevIface *current.Interface
+		for _, i := range prevResult.Interfaces {
+			if i.Name == iface.Name {
+				prevIface = i
+				break
+			}
+		}
+		if prevIface == nil {
+			return fmt.Errorf("interface %s not found in previous result", iface.Name)
+		}
+		if prevIface.Mac != "" && prevIface.Mac != iface.Mac {
+			return fmt.Errorf("interface %s has MAC address %s, expected %s", iface.Name, iface.Mac, prevIface.Mac)
+		}
+		if prevIface.Sandbox != "" && prevIface.Sandbox != iface.Sandbox {
+			return fmt.Errorf("interface %s is in sandbox %s, expected %s", iface.Name, iface.Sandbox, prevIface.Sandbox)
+		}
+	}
+
+	return nil
+}
+
+// podAllocation returns the address allocated in IPAM for the pod.
+func podAllocation(ipam *client.IPAM, podName string) (*net.IPNet, error) {
+	ip, ok := ipam.AddressNameToIP[podName]
+	if !ok {
+		return nil, fmt.Errorf("no IPAM allocation found for pod %s", podName)
+	}
+	bits := 8 * net.IPv6len
+	if ip.To4() != nil {
+		ip = ip.To4()
+		bits = 8 * net.IPv4len
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+}
+
+// checkHostVeth verifies that host side of the veth pair exists and is up.
+func checkHostVeth(name string) (netlink.Link, error) {
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find host veth %s, err=(%s)", name, err)
+	}
+	if _, ok := link.(*netlink.Veth); !ok {
+		return nil, fmt.Errorf("host interface %s is %s, expected veth", name, link.Type())
+	}
+	if link.Attrs().Flags&net.FlagUp == 0 {
+		return nil, fmt.Errorf("host veth %s is down", name)
+	}
+	return link, nil
+}
+
+// checkContainerVeth verifies, from inside the pod namespace, that the pod
+// interface is peered with the host veth, has the pod address, and has
+// the transport and default routes. Must be called inside pod netns.
+func checkContainerVeth(ifName string, podAddress *net.IPNet, hostVethIndex int) error {
+	link, err := netlink.LinkByName(ifName)
+	if err != nil {
+		return fmt.Errorf("failed to find %s in pod namespace, err=(%s)", ifName, err)
+	}
+	veth, ok := link.(*netlink.Veth)
+	if !ok {
+		return fmt.Errorf("pod interface %s is %s, expected veth", ifName, link.Type())
+	}
+	if link.Attrs().Flags&net.FlagUp == 0 {
+		return fmt.Errorf("pod interface %s is down", ifName)
+	}
+	peerIndex, err := netlink.VethPeerIndex(veth)
+	if err != nil {
+		return fmt.Errorf("failed to discover peer of %s, err=(%s)", ifName, err)
+	}
+	if peerIndex != hostVethIndex {
+		return fmt.Errorf("pod interface %s is peered with interface %d, expected %d", ifName, peerIndex, hostVethIndex)
+	}
+
+	family := netlink.FAMILY_V4
+	if podAddress.IP.To4() == nil {
+		family = netlink.FAMILY_V6
+	}
+
+	addrs, err := netlink.AddrList(link, family)
+	if err != nil {
+		return fmt.Errorf("failed to list addresses on %s, err=(%s)", ifName, err)
+	}
+	found := false
+	for _, addr := range addrs {
+		if addr.IPNet.String() == podAddress.String() {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return fmt.Errorf("address %s not found on %s, found %v", podAddress, ifName, addrs)
+	}
+
+	routes, err := netlink.RouteList(link, family)
+	if err != nil {
+		return fmt.Errorf("failed to list routes on %s, err=(%s)", ifName, err)
+	}
+	var transportFound, defaultFound bool
+	for _, route := range routes {
+		if route.Dst == nil {
+			defaultFound = true
+			continue
+		}
+		ones, _ := route.Dst.Mask.Size()
+		if ones == 0 {
+			defaultFound = true
+		}
+		if ones == 32 && route.Dst.IP.Equal(net.ParseIP(podGateway)) {
+			transportFound = true
+		}
+	}
+	// Transport route to the gateway is only installed for IPv4 pods.
+	if family == netlink.FAMILY_V4 && !transportFound {
+		return fmt.Errorf("transport route to %s via %s not found in pod namespace", podGateway, ifName)
+	}
+	if !defaultFound {
+		return fmt.Errorf("default route via %s not found in pod namespace", ifName)
+	}
+
+	return nil
+}
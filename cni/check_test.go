@@ -0,0 +1,160 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package cni
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/containernetworking/cni/pkg/types"
+	"github.com/containernetworking/cni/pkg/types/current"
+)
+
+func TestMakeResult(t *testing.T) {
+	podAddress := &net.IPNet{IP: net.ParseIP("10.0.0.5").To4(), Mask: net.CIDRMask(32, 32)}
+	hostIface := &current.Interface{Name: "romana-12345678"}
+	result := makeResult(hostIface, podAddress)
+
+	// Result of CmdAdd lists host interface only, and pod
+	// address refers to it.
+	if len(result.Interfaces) != 1 || result.Interfaces[0] != hostIface {
+		t.Fatalf("expected host interface only, got %v", result.Interfaces)
+	}
+	if len(result.IPs) != 1 {
+		t.Fatalf("expected one address, got %v", result.IPs)
+	}
+	ipConfig := result.IPs[0]
+	if ipConfig.Version != "4" || ipConfig.Interface != 0 || ipConfig.Address.String() != podAddress.String() {
+		t.Fatalf("expected version 4 address %s on interface 0, got version %s address %s on interface %d",
+			podAddress, ipConfig.Version, ipConfig.Address.String(), ipConfig.Interface)
+	}
+}
+
+func TestCompareResults(t *testing.T) {
+	podAddress := &net.IPNet{IP: net.ParseIP("10.0.0.5").To4(), Mask: net.CIDRMask(32, 32)}
+	hostIface := &current.Interface{Name: "romana-12345678", Mac: "aa:aa:aa:aa:aa:aa"}
+	result := makeResult(hostIface, podAddress)
+
+	otherAddress := &net.IPNet{IP: net.ParseIP("10.0.0.6").To4(), Mask: net.CIDRMask(32, 32)}
+	cases := []struct {
+		name       string
+		prevResult *current.Result
+		test       func(error) bool
+	}{
+		{
+			"identical result",
+			makeResult(hostIface, podAddress),
+			func(err error) bool { return err == nil },
+		},
+		{
+			"result without MAC address",
+			makeResult(&current.Interface{Name: "romana-12345678"}, podAddress),
+			func(err error) bool { return err == nil },
+		},
+		{
+			"result with interfaces of other plugins",
+			&current.Result{
+				Interfaces: []*current.Interface{{Name: "romana-12345678"}, {Name: "ifb0"}},
+				IPs:        []*current.IPConfig{{Version: "4", Address: *podAddress}},
+			},
+			func(err error) bool { return err == nil },
+		},
+		{
+			"detect address mismatch",
+			makeResult(hostIface, otherAddress),
+			func(err error) bool { return strings.Contains(err.Error(), "not found in previous result") },
+		},
+		{
+			"detect MAC mismatch",
+			makeResult(&current.Interface{Name: "romana-12345678", Mac: "cc:cc:cc:cc:cc:cc"}, podAddress),
+			func(err error) bool { return strings.Contains(err.Error(), "has MAC address") },
+		},
+		{
+			"detect sandbox mismatch",
+			makeResult(&current.Interface{Name: "romana-12345678", Sandbox: "/var/run/netns/other"}, podAddress),
+			func(err error) bool { return strings.Contains(err.Error(), "is in sandbox") },
+		},
+		{
+			"detect missing interface",
+			makeResult(&current.Interface{Name: "romana-87654321"}, podAddress),
+			func(err error) bool {
+				return strings.Contains(err.Error(), "romana-12345678 not found in previous result")
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := compareResults(result, tc.prevResult)
+			if !tc.test(err) {
+				t.Fatalf("%s", err)
+			}
+		})
+	}
+}
+
+func TestParsePrevResult(t *testing.T) {
+	for _, conf := range []string{
+		`{"cniVersion": "0.4.0", "name": "romana-k8s-network"}`,
+		`{"cniVersion": "0.4.0", "name": "romana-k8s-network", "prevResult": null}`,
+	} {
+		_, err := parsePrevResult([]byte(conf))
+		if err == nil || !strings.Contains(err.Error(), "prevResult is missing") {
+			t.Fatalf("expected missing prevResult error for %s, got %v", conf, err)
+		}
+	}
+}
+
+func TestCheckVersion(t *testing.T) {
+	if err := checkVersion("0.4.0"); err != nil {
+		t.Fatalf("expected version 0.4.0 to be accepted, got %s", err)
+	}
+	for _, v := range []string{"", "0.2.0", "0.3.1"} {
+		err := checkVersion(v)
+		e, ok := err.(*types.Error)
+		if !ok || e.Code != 1 {
+			t.Fatalf("expected incompatible version error for %q, got %v", v, err)
+		}
+	}
+}
+
+func TestCheckArgs(t *testing.T) {
+	env := map[string]string{
+		"CNI_CONTAINERID": "12345678",
+		"CNI_NETNS":       "/var/run/netns/test",
+		"CNI_IFNAME":      "eth0",
+		"CNI_ARGS":        "K8S_POD_NAMESPACE=default;K8S_POD_NAME=test",
+		"CNI_PATH":        "/opt/cni/bin",
+	}
+	getenv := func(name string) string { return env[name] }
+
+	args, err := checkArgs(getenv, strings.NewReader(`{"name": "romana-k8s-network"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if args.ContainerID != "12345678" || args.Netns != "/var/run/netns/test" ||
+		args.IfName != "eth0" || args.Path != "/opt/cni/bin" ||
+		args.Args != env["CNI_ARGS"] || string(args.StdinData) != `{"name": "romana-k8s-network"}` {
+		t.Fatalf("unexpected arguments %+v", args)
+	}
+
+	delete(env, "CNI_NETNS")
+	_, err = checkArgs(getenv, strings.NewReader(""))
+	if err == nil || !strings.Contains(err.Error(), "CNI_NETNS missing") {
+		t.Fatalf("expected missing CNI_NETNS error, got %v", err)
+	}
+}
@@ -72,6 +72,14 @@ type NetConf struct {
 
 	RomanaClientConfig common.Config `json:"romana_client_config"`
 
+	// EtcdCAFile, EtcdCertFile and EtcdKeyFile are paths to PEM encoded
+	// CA certificates to verify etcd servers with, and client certificate
+	// and key to present to them. When set, they override the ones
+	// in RomanaClientConfig, see cni/README.md for an example.
+	EtcdCAFile   string `json:"etcd_ca_file"`
+	EtcdCertFile string `json:"etcd_cert_file"`
+	EtcdKeyFile  string `json:"etcd_key_file"`
+
 	// Name of a current host in romana.
 	// If omitted, current hostname will be used.
 	RomanaHostName   string `json:"romana_host_name"`
@@ -31,6 +31,7 @@ import (
 	"github.com/containernetworking/cni/pkg/skel"
 	"github.com/containernetworking/cni/pkg/types"
 	"github.com/containernetworking/cni/pkg/types/current"
+	"github.com/containernetworking/cni/pkg/version"
 	log "github.com/romana/rlog"
 	"github.com/vishvananda/netlink"
 	"golang.org/x/sys/unix"
@@ -46,8 +47,23 @@ func init() {
 const (
 	DefaultCNILogFile     = "/var/log/romana/cni.log"
 	AlternativeCNILogFile = "/var/tmp/romana-cni.log"
+
+	// podGateway is the address pods use as their next hop, it is
+	// routed via the container side of the veth pair.
+	podGateway = "172.142.0.1"
+
+	// podIfName is the name of the interface inside the pod.
+	podIfName = "eth0"
+
+	// cniVersionCheck is the version of CNI spec that introduced
+	// CHECK method, its results are the same as of version 0.3.1.
+	cniVersionCheck = "0.4.0"
 )
 
+// VersionInfo lists versions of CNI spec supported by the plugin,
+// vendored version.All predates version 0.4.0.
+var VersionInfo = version.PluginSupports("0.1.0", "0.2.0", "0.3.0", "0.3.1", cniVersionCheck)
+
 // cmdAdd is a callback functions that gets called by skel.PluginMain
 // in response to ADD method.
 func CmdAdd(args *skel.CmdArgs) error {
@@ -121,7 +137,7 @@ func CmdAdd(args *skel.CmdArgs) error {
 	}
 
 	// Networking setup
-	gwAddr := &net.IPNet{IP: net.ParseIP("172.142.0.1"), Mask: net.IPMask([]byte{0xff, 0xff, 0xff, 0xff})}
+	gwAddr := &net.IPNet{IP: net.ParseIP(podGateway), Mask: net.IPMask([]byte{0xff, 0xff, 0xff, 0xff})}
 
 	netns, err := ns.GetNS(args.Netns)
 	if err != nil {
@@ -132,12 +148,13 @@ func CmdAdd(args *skel.CmdArgs) error {
 	// Magic variables for callback.
 	contIface := &current.Interface{}
 	hostIface := &current.Interface{}
-	ifName := "eth0"
+	ifName := podIfName
 	mtu := 1500
 	if netConf.MTU > 0 {
 		mtu = netConf.MTU
 	}
 	_, defaultNet, _ := net.ParseCIDR("0.0.0.0/0")
+	_, defaultNet6, _ := net.ParseCIDR("::/0")
 
 	// And this is a callback inside the callback, it sets up networking
 	// withing a pod namespace, nice thing it save us from shellouts
@@ -171,6 +188,16 @@ func CmdAdd(args *skel.CmdArgs) error {
 		if err != nil {
 			return fmt.Errorf("route add default error=(%s)", err)
 		}
+		if podAddress.IP.To4() == nil {
+			defaultRoute6 := netlink.Route{
+				Dst:       defaultNet6,
+				LinkIndex: containerVeth.Index,
+			}
+			err = netlink.RouteAdd(&defaultRoute6)
+			if err != nil {
+				return fmt.Errorf("route add IPv6 default error=(%s)", err)
+			}
+		}
 
 		containerVethLink, err := netlink.LinkByIndex(containerVeth.Index)
 		if err != nil {
@@ -211,17 +238,7 @@ func CmdAdd(args *skel.CmdArgs) error {
 		return err
 	}
 
-	result := &current.Result{
-		IPs: []*current.IPConfig{
-			&current.IPConfig{
-				Version:   "4",
-				Address:   *podAddress,
-				Interface: 0,
-			},
-		},
-	}
-
-	result.Interfaces = []*current.Interface{hostIface}
+	result := makeResult(hostIface, podAddress)
 
 	if netConf.Policy {
 		err := enablePodPolicy(k8sargs.MakeVethName())
@@ -233,7 +250,19 @@ func CmdAdd(args *skel.CmdArgs) error {
 	}
 
 	deallocateOnExit = false
-	return types.PrintResult(result, cniVersion)
+	return printResult(result, cniVersion)
+}
+
+// printResult prints the result in the version of network config.
+// Vendored types can't convert results to version 0.4.0, these
+// have the same format as current results so only the version is set.
+func printResult(result *current.Result, cniVersion string) error {
+	if cniVersion != cniVersionCheck {
+		return types.PrintResult(result, cniVersion)
+	}
+	versioned := *result
+	versioned.CNIVersion = cniVersionCheck
+	return versioned.Print()
 }
 
 // cmdDel is a callback functions that gets called by skel.PluginMain
@@ -352,6 +381,38 @@ func AddEndpointRoute(ifaceName string, ip *net.IPNet, nl nlRouteHandle) error {
 	return nil
 }
 
+// CheckEndpointRoute verifies that the return route from host to pod,
+// as created by AddEndpointRoute, goes via the provided interface.
+// This function is designed to take nil as nlRouteHandle argument.
+func CheckEndpointRoute(ifaceName string, ip *net.IPNet, nl nlRouteHandle) error {
+	if nl == nil {
+		var nlErr error
+		nl, nlErr = netlink.NewHandle()
+		if nlErr != nil {
+			return fmt.Errorf("couldn't create netlink handle, err=(%s)", nlErr)
+		}
+		defer nl.Delete()
+	}
+
+	veth, err := nl.LinkByName(ifaceName)
+	if err != nil {
+		return fmt.Errorf("couldn't find interface %s, err=(%s)", ifaceName, err)
+	}
+
+	routes, err := nl.RouteGet(ip.IP)
+	if err != nil {
+		return fmt.Errorf("couldn't get route to %s, err=(%s)", ip, err)
+	}
+
+	for _, route := range routes {
+		if route.LinkIndex == veth.Attrs().Index {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("route to %s doesn't go via interface %s, found %v", ip, ifaceName, routes)
+}
+
 // loadConf initializes romana config from stdin.
 func loadConf(bytes []byte) (*NetConf, error) {
 	n := &NetConf{}
@@ -361,6 +422,16 @@ func loadConf(bytes []byte) (*NetConf, error) {
 
 	setLogOutput(n.LogFile)
 
+	if n.EtcdCAFile != "" {
+		n.RomanaClientConfig.EtcdCAFile = n.EtcdCAFile
+	}
+	if n.EtcdCertFile != "" {
+		n.RomanaClientConfig.EtcdCertFile = n.EtcdCertFile
+	}
+	if n.EtcdKeyFile != "" {
+		n.RomanaClientConfig.EtcdKeyFile = n.EtcdKeyFile
+	}
+
 	// TODO for stas
 	// verify config here
 	if n.RomanaHostName == "" {
@@ -17,7 +17,9 @@ package cni
 
 import (
 	"fmt"
+	"io/ioutil"
 	"net"
+	"os"
 	"strings"
 	"testing"
 
@@ -29,6 +31,8 @@ type mockNlRouteHandle struct {
 	linkByNameErr   error
 	addRouteErr     error
 	replaceRouteErr error
+	getRouteErr     error
+	routes          []netlink.Route
 }
 
 func (m mockNlRouteHandle) LinkByName(name string) (netlink.Link, error) {
@@ -46,7 +50,10 @@ func (m mockNlRouteHandle) RouteReplace(*netlink.Route) error {
 func (m mockNlRouteHandle) Delete() {}
 
 func (m mockNlRouteHandle) RouteGet(net.IP) ([]netlink.Route, error) {
-	return []netlink.Route{}, nil
+	if m.routes == nil {
+		return []netlink.Route{}, m.getRouteErr
+	}
+	return m.routes, m.getRouteErr
 }
 
 func TestAddEndpointRoute(t *testing.T) {
@@ -116,3 +123,83 @@ func TestAddEndpointRoute(t *testing.T) {
 		})
 	}
 }
+
+func TestCheckEndpointRoute(t *testing.T) {
+	dummyIpnet := &net.IPNet{IP: net.ParseIP("127.0.0.1"), Mask: net.IPMask([]byte{0xff, 0xff, 0xff, 0xff})}
+
+	cases := []struct {
+		name string
+		mock mockNlRouteHandle
+		test func(error) bool
+	}{
+		{
+			"detect failure during LinkByName",
+			mockNlRouteHandle{linkByNameErr: fmt.Errorf("bang")},
+			func(err error) bool { return strings.Contains(err.Error(), "couldn't find interface") },
+		},
+		{
+			"detect failure during route get",
+			mockNlRouteHandle{getRouteErr: fmt.Errorf("bang")},
+			func(err error) bool { return strings.Contains(err.Error(), "couldn't get route") },
+		},
+		{
+			"detect route via other interface",
+			mockNlRouteHandle{routes: []netlink.Route{{LinkIndex: 5}}},
+			func(err error) bool { return strings.Contains(err.Error(), "doesn't go via interface") },
+		},
+		{
+			"detect missing route",
+			mockNlRouteHandle{},
+			func(err error) bool { return strings.Contains(err.Error(), "doesn't go via interface") },
+		},
+		{
+			"check route via interface",
+			mockNlRouteHandle{routes: []netlink.Route{{LinkIndex: 0}}},
+			func(err error) bool { return err == nil },
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := CheckEndpointRoute("dummy", dummyIpnet, tc.mock)
+			if !tc.test(err) {
+				t.Fatalf("%s", err)
+			}
+		})
+	}
+}
+
+func TestLoadConfEtcdTLS(t *testing.T) {
+	logFile, err := ioutil.TempFile("", "romana-cni")
+	if err != nil {
+		t.Fatal(err)
+	}
+	logFile.Close()
+	defer os.Remove(logFile.Name())
+
+	conf := fmt.Sprintf(`{
+		"cniVersion": "0.4.0",
+		"name": "romana-k8s-network",
+		"type": "romana",
+		"log_file": %q,
+		"romana_host_name": "host1",
+		"romana_client_config": {"EtcdEndpoints": ["192.168.99.10:2379"], "EtcdKeyFile": "/old.key"},
+		"etcd_ca_file": "/etc/romana/etcd-ca.pem",
+		"etcd_cert_file": "/etc/romana/etcd-client.pem",
+		"etcd_key_file": "/etc/romana/etcd-client-key.pem"
+	}`, logFile.Name())
+
+	netConf, err := loadConf([]byte(conf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := netConf.RomanaClientConfig
+	if config.EtcdCAFile != "/etc/romana/etcd-ca.pem" ||
+		config.EtcdCertFile != "/etc/romana/etcd-client.pem" ||
+		config.EtcdKeyFile != "/etc/romana/etcd-client-key.pem" {
+		t.Errorf("etcd TLS files not set in romana client config, %+v", config)
+	}
+	if len(config.EtcdEndpoints) != 1 || config.EtcdEndpoints[0] != "192.168.99.10:2379" {
+		t.Errorf("unexpected etcd endpoints %v", config.EtcdEndpoints)
+	}
+}
@@ -16,6 +16,7 @@
 package api
 
 import (
+	"encoding/json"
 	"fmt"
 	"net"
 )
@@ -31,9 +32,18 @@ type Segment struct {
 	Blocks []IPNet `json:"blocks"`
 }
 
+// IPAMAddressResponse describes an allocated address.
 type IPAMAddressResponse struct {
 	Name string `json:"id"`
 	IP   net.IP `json:"ip"`
+	// IP of the other address family allocated under
+	// the same name on dual-stack networks.
+	SecondaryIP net.IP `json:"secondary_ip,omitempty"`
+	Host        string `json:"host,omitempty"`
+	Tenant      string `json:"tenant,omitempty"`
+	Segment     string `json:"segment,omitempty"`
+	Network     string `json:"network,omitempty"`
+	Block       *IPNet `json:"block,omitempty"`
 }
 
 type IPAMAddressRequest struct {
@@ -41,12 +51,45 @@ type IPAMAddressRequest struct {
 	Host    string `json:"host"`
 	Tenant  string `json:"tenant"`
 	Segment string `json:"segment"`
+	// If true, one address per address family (IPv4 and IPv6)
+	// available to the tenant is allocated.
+	DualStack bool `json:"dual_stack,omitempty"`
 }
 
 type IPAMNetworkResponse struct {
 	Revision int    `json:"revision"`
 	Name     string `json:"id"`
 	CIDR     IPNet  `json:"cidr"`
+
+	// Below are only provided when details
+	// of a single network are requested.
+	BlockMask  uint     `json:"block_mask,omitempty"`
+	Tenants    []string `json:"tenants,omitempty"`
+	BlackedOut []IPNet  `json:"blacked_out,omitempty"`
+	Hosts      []Host   `json:"hosts,omitempty"`
+	Blocks     int      `json:"blocks,omitempty"`
+}
+
+// NetworkAddRequest describes a network to add to existing
+// topology. If Map is empty network gets a single group
+// with all hosts currently known to IPAM.
+type NetworkAddRequest struct {
+	NetworkDefinition
+	Map []GroupOrHost `json:"map,omitempty"`
+}
+
+// IPAMBlackoutRequest asks to black out (or un-black out) CIDR
+// within a network, so that it is not used for allocations.
+type IPAMBlackoutRequest struct {
+	CIDR string `json:"cidr"`
+}
+
+// IPAMBlackoutResponse lists CIDRs blacked out in a network.
+type IPAMBlackoutResponse struct {
+	Revision   int     `json:"revision"`
+	Network    string  `json:"network"`
+	CIDR       IPNet   `json:"cidr"`
+	BlackedOut []IPNet `json:"blacked_out"`
 }
 
 type IPAMBlocksResponse struct {
@@ -63,15 +106,95 @@ type IPAMBlockResponse struct {
 	AllocatedIPCount int    `json:"allocated_ip_count"`
 }
 
+// IPAMSnapshotVersion is the version of IPAMSnapshot format.
+const IPAMSnapshotVersion = 1
+
+// IPAMSnapshot is the complete IPAM state, i.e. networks, topology,
+// blocks, blackouts and address allocations, as exported by
+// GET /ipam/export and restored by POST /ipam/import.
+type IPAMSnapshot struct {
+	Version int `json:"version"`
+	// IPAM is the IPAM document in the format used by romanad.
+	IPAM json.RawMessage `json:"ipam"`
+}
+
+// IPAMChange is a difference between the current IPAM
+// and the snapshot being imported.
+type IPAMChange struct {
+	// Kind is one of network, blackout, host, block or address.
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+	// Action is one of add, remove or change.
+	Action string `json:"action"`
+	Old    string `json:"old,omitempty"`
+	New    string `json:"new,omitempty"`
+}
+
+// IPAMImportResponse lists the changes made by POST /ipam/import,
+// or the changes that would be made if DryRun is set.
+type IPAMImportResponse struct {
+	DryRun             bool         `json:"dry_run"`
+	AllocationRevision int          `json:"allocation_revision"`
+	TopologyRevision   int          `json:"topology_revision"`
+	Changes            []IPAMChange `json:"changes"`
+}
+
+// Kinds of IPAMProblem.
+const (
+	// IPAMProblemIndex means redundant indexes of blocks in a group
+	// (block_to_owner, owner_to_block, block_to_host, reusable_blocks)
+	// disagree with each other.
+	IPAMProblemIndex = "index"
+	// IPAMProblemOrphanedBlock means a block that has no owner is not
+	// reusable, or still has addresses allocated.
+	IPAMProblemOrphanedBlock = "orphaned_block"
+	// IPAMProblemRemovedHost means a block is owned by a host that
+	// is not in the topology.
+	IPAMProblemRemovedHost = "removed_host_block"
+	// IPAMProblemUnnamedIP means an IP is allocated in a block's
+	// pool but there is no address name for it.
+	IPAMProblemUnnamedIP = "unnamed_ip"
+	// IPAMProblemUnallocatedName means an address name refers to an IP
+	// that is not allocated in any block.
+	IPAMProblemUnallocatedName = "unallocated_name"
+	// IPAMProblemNameOutsideNetwork means an address name refers
+	// to an IP that is not within any network.
+	IPAMProblemNameOutsideNetwork = "name_outside_network"
+	// IPAMProblemBlackedOut means an allocated IP is blacked out.
+	IPAMProblemBlackedOut = "blacked_out_allocation"
+)
+
+// IPAMProblem is an inconsistency found in IPAM.
+type IPAMProblem struct {
+	Kind    string `json:"kind"`
+	Network string `json:"network,omitempty"`
+	Block   string `json:"block,omitempty"`
+	// Address is the name of the address, or the IP if it has no name.
+	Address string `json:"address,omitempty"`
+	Details string `json:"details"`
+	// Repaired is set if the problem was repaired.
+	Repaired bool `json:"repaired"`
+}
+
+// IPAMVerifyResponse lists the problems found by GET /ipam/verify,
+// or found and possibly repaired by POST /ipam/repair.
+type IPAMVerifyResponse struct {
+	Repair   bool          `json:"repair"`
+	Problems []IPAMProblem `json:"problems"`
+}
+
 type TopologyUpdateRequest struct {
 	Networks   []NetworkDefinition  `json:"networks"`
 	Topologies []TopologyDefinition `json:"topologies"`
 }
 
 type NetworkDefinition struct {
-	Name      string `json:"name"`
-	CIDR      string `json:"cidr"`
-	BlockMask uint   `json:"block_mask"`
+	Name string `json:"name"`
+	// CIDR of the network, either IPv4 or IPv6.
+	CIDR string `json:"cidr"`
+	// Mask of blocks allocated from this network; for IPv6
+	// networks it cannot be less than 64.
+	BlockMask uint `json:"block_mask"`
 	// List of allowed tenants.
 	Tenants []string `json:"tenants,omitempty"`
 }
@@ -102,14 +225,39 @@ type GroupOrHost struct {
 }
 
 type Host struct {
-	IP        net.IP `json:"ip"`
-	Name      string `json:"name"`
-	AgentPort uint   `json:"agent_port"`
+	IP net.IP `json:"ip"`
+	// IPs are additional addresses the host can be reached at,
+	// e.g. when host is connected to more than one switch.
+	IPs       []net.IP `json:"ips,omitempty"`
+	Name      string   `json:"name"`
+	AgentPort uint     `json:"agent_port"`
 	// TODO this is a placeholder for now so that agent builds
 	Tags    map[string]string      `json:"tags"`
 	K8SInfo map[string]interface{} `json:"k8s_info"`
 }
 
+// Addresses returns primary IP of the host followed by
+// additional IPs, without duplicates.
+func (h Host) Addresses() []net.IP {
+	var addrs []net.IP
+	for _, ip := range append([]net.IP{h.IP}, h.IPs...) {
+		if ip == nil {
+			continue
+		}
+		seen := false
+		for _, addr := range addrs {
+			if addr.Equal(ip) {
+				seen = true
+				break
+			}
+		}
+		if !seen {
+			addrs = append(addrs, ip)
+		}
+	}
+	return addrs
+}
+
 func (h Host) String() string {
 	val := fmt.Sprintf("%s (%s)", h.IP, h.Name)
 	if h.Tags != nil && len(h.Tags) > 0 {
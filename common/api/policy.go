@@ -100,6 +100,16 @@ type Policy struct {
 	// Datacenter describes a Romana deployment.
 	AppliedTo []Endpoint      `json:"applied_to,omitempty"`
 	Ingress   []RomanaIngress `json:"ingress,omitempty"`
+	// Egress restricts traffic originating from AppliedTo endpoints,
+	// Peers of the egress section are destinations of that traffic.
+	// Rules of the Ingress section follow the Direction field, and with
+	// egress Direction they drop matching traffic. Egress section is always
+	// applied in egress direction, it allows matching traffic and drops
+	// the rest of the traffic from AppliedTo endpoints.
+	Egress []RomanaEgress `json:"egress,omitempty"`
+	// LogDenies asks agents to log the traffic of AppliedTo endpoints
+	// which is denied because no policy allows it.
+	LogDenies bool `json:"log_denies,omitempty"`
 	//	Tags       []Tag      `json:"tags,omitempty"`
 }
 
@@ -108,6 +118,70 @@ type RomanaIngress struct {
 	Rules []Rule     `json:"rules,omitempty"`
 }
 
+// RomanaEgress describes destinations that AppliedTo endpoints
+// are allowed to reach.
+type RomanaEgress struct {
+	Peers []Endpoint `json:"peers,omitempty"`
+	Rules []Rule     `json:"rules,omitempty"`
+}
+
 func (p Policy) String() string {
 	return common.String(p)
 }
+
+// PolicyRenderResponse holds iptables rules and ipsets rendered
+// for a host from a proposed set of policies, see POST /policies/render.
+type PolicyRenderResponse struct {
+	Host     string `json:"host"`
+	IPtables string `json:"iptables"`
+	Ipsets   string `json:"ipsets"`
+}
+
+// PolicyCheckEndpoint is one side of the traffic checked against
+// policies, either an IP address or a tenant and optional segment.
+type PolicyCheckEndpoint struct {
+	IP        string `json:"ip,omitempty"`
+	TenantID  string `json:"tenant_id,omitempty"`
+	SegmentID string `json:"segment_id,omitempty"`
+}
+
+func (e PolicyCheckEndpoint) String() string {
+	if e.IP != "" {
+		return e.IP
+	}
+	if e.SegmentID != "" {
+		return e.TenantID + "/" + e.SegmentID
+	}
+	return e.TenantID
+}
+
+// PolicyCheckRequest describes the traffic checked against policies,
+// see POST /policies/check.
+type PolicyCheckRequest struct {
+	Source      PolicyCheckEndpoint `json:"source"`
+	Destination PolicyCheckEndpoint `json:"destination"`
+	Protocol    string              `json:"protocol"`
+	Port        uint                `json:"port,omitempty"`
+}
+
+// PolicyCheckMatch is a combination of policy target, peer and rule
+// that allows the checked traffic.
+type PolicyCheckMatch struct {
+	PolicyID  string   `json:"policy_id"`
+	Direction string   `json:"direction"`
+	Target    Endpoint `json:"target"`
+	Peer      Endpoint `json:"peer"`
+	Rule      Rule     `json:"rule"`
+}
+
+// PolicyCheckResponse reports whether policies allow the traffic
+// described by PolicyCheckRequest, and which policies allow it.
+type PolicyCheckResponse struct {
+	Allowed bool   `json:"allowed"`
+	Reason  string `json:"reason"`
+	// Source and Destination have tenant and segment
+	// filled in when endpoint was found in IPAM blocks.
+	Source      PolicyCheckEndpoint `json:"source"`
+	Destination PolicyCheckEndpoint `json:"destination"`
+	Matches     []PolicyCheckMatch  `json:"matches,omitempty"`
+}
@@ -21,7 +21,9 @@ import (
 	"crypto/rsa"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"net/http"
+	"strings"
 
 	"github.com/dgrijalva/jwt-go"
 	"github.com/gorilla/context"
@@ -33,9 +35,10 @@ import (
 )
 
 const (
-	RoleAdmin   = "admin"
-	RoleService = "service"
-	RoleTenant  = "tenant"
+	RoleAdmin    = "admin"
+	RoleService  = "service"
+	RoleTenant   = "tenant"
+	RoleReadOnly = "read-only"
 )
 
 // DefaultAdminUser is a dummy user having admin role. It is used when
@@ -70,6 +73,29 @@ type User struct {
 	Attributes         []Attribute `gorm:"many2many:user_attributes;ForeignKey:user_id"`
 }
 
+// HasRole returns true if user has any of the provided roles.
+func (u User) HasRole(names ...string) bool {
+	for _, role := range u.Roles {
+		for _, name := range names {
+			if role.Name == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// GetAttribute returns value of user's attribute with the
+// provided key, or empty string if user doesn't have it.
+func (u User) GetAttribute(key string) string {
+	for _, attr := range u.Attributes {
+		if attr.AttributeKey == key {
+			return attr.AttributeValue
+		}
+	}
+	return ""
+}
+
 // An Attribute of a user is something that is used in the ABAC
 // part of our AuthZ scheme. Not every role would be checked for
 // atributes. For now, only if the user has a role of tenant,
@@ -87,10 +113,12 @@ type CredentialType string
 
 const (
 	CredentialUsernamePassword = "userPass"
+	CredentialToken            = "token"
 	CredentialNone             = "none"
 
 	UsernameKey = "ROMANA_USERNAME"
 	PasswordKey = "ROMANA_PASSWORD"
+	TokenKey    = "ROMANA_TOKEN"
 )
 
 // Container for various credentials. Currently containing Username/Password
@@ -104,14 +132,19 @@ type Credential struct {
 	assumeFlagParsed bool
 	Username         string
 	Password         string
-	userFlag         string
-	passFlag         string
+	// Token is a JWT sent as a bearer token.
+	Token     string
+	userFlag  string
+	passFlag  string
+	tokenFlag string
 }
 
 func (c *Credential) String() string {
 	switch c.Type {
 	case CredentialUsernamePassword:
 		return fmt.Sprintf("Type: %s, user: %s", c.Type, c.Username)
+	case CredentialToken:
+		return fmt.Sprintf("Type: %s", c.Type)
 	default:
 		return "None"
 	}
@@ -121,6 +154,7 @@ func NewCredentialCobra(cmd *cli.Command) *Credential {
 	cred := &Credential{cmd: cmd, assumeFlagParsed: true}
 	cmd.PersistentFlags().StringVarP(&cred.userFlag, "username", "u", "", "Username")
 	cmd.PersistentFlags().StringVarP(&cred.passFlag, "password", "", "", "Password")
+	cmd.PersistentFlags().StringVarP(&cred.tokenFlag, "token", "", "", "Authentication token")
 	return cred
 }
 
@@ -130,8 +164,10 @@ func NewCredential(flagSet *flag.FlagSet) *Credential {
 	cred.flagSet = flagSet
 	flagSet.StringVar(&cred.userFlag, "username", "", "Username")
 	flagSet.StringVar(&cred.passFlag, "password", "", "Password")
+	flagSet.StringVar(&cred.tokenFlag, "token", "", "Authentication token")
 	config.SetDefault(UsernameKey, "")
 	config.SetDefault(PasswordKey, "")
+	config.SetDefault(TokenKey, "")
 	return cred
 }
 
@@ -150,22 +186,32 @@ func GetPasswd() (string, error) {
 // Initialize constructs appropriate Credential structure based on
 // provided data, which includes, in the following precedence (later
 // superseding earlier):
-// * In case of username/password auth:
-//   1. As keys UsernameKey and PasswordKey in ~/.romana.yaml file
-//   2. As environment variables whose names are UsernameKey and PasswordKey values
-//   3. As --username and --password command-line flags.
-//      If --username flag is specified but --password flag is omitted,
-//      the user will be prompted for the password.
-// Notes:
-// 1. The first two precedence steps (~/.romana.yaml and environment variables)
-//    are taken care by the config module (github.com/spf13/viper)
-// 2. If flag.Parsed() is false at the time of this call, the command-line values are
-//    ignored.
 //
+// In case of token auth, which takes precedence over username/password:
+//  1. As key TokenKey in ~/.romana.yaml file
+//  2. As environment variable whose name is TokenKey value
+//  3. As --token command-line flag.
+//
+// In case of username/password auth:
+//  1. As keys UsernameKey and PasswordKey in ~/.romana.yaml file
+//  2. As environment variables whose names are UsernameKey and PasswordKey values
+//  3. As --username and --password command-line flags.
+//     If --username flag is specified but --password flag is omitted,
+//     the user will be prompted for the password.
+//
+// Notes:
+//  1. The first two precedence steps (~/.romana.yaml and environment variables)
+//     are taken care by the config module (github.com/spf13/viper)
+//  2. If flag.Parsed() is false at the time of this call, the command-line values are
+//     ignored.
 func (c *Credential) Initialize() error {
 	username := config.GetString(UsernameKey)
 	password := config.GetString(PasswordKey)
+	token := config.GetString(TokenKey)
 	if c.assumeFlagParsed || c.flagSet.Parsed() {
+		if c.tokenFlag != "" {
+			token = c.tokenFlag
+		}
 		if c.userFlag != "" {
 			username = c.userFlag
 			if c.passFlag == "" {
@@ -180,7 +226,10 @@ func (c *Credential) Initialize() error {
 			}
 		}
 	}
-	if username != "" {
+	if token != "" {
+		c.Token = token
+		c.Type = CredentialToken
+	} else if username != "" {
 		c.Username = username
 		c.Password = password
 		c.Type = CredentialUsernamePassword
@@ -196,77 +245,99 @@ func (c *Credential) Initialize() error {
 // by wrapHandler(), which will provide RestContext.
 type AuthZChecker func(ctx RestContext) bool
 
+// AllowRoles returns AuthZChecker which allows access
+// to users having any of the provided roles.
+func AllowRoles(roles ...string) AuthZChecker {
+	return func(ctx RestContext) bool {
+		return ctx.User.HasRole(roles...)
+	}
+}
+
+var (
+	// AuthZReadOnly allows access to any authenticated user,
+	// it is intended for routes which do not modify anything.
+	AuthZReadOnly = AllowRoles(RoleAdmin, RoleService, RoleTenant, RoleReadOnly)
+
+	// AuthZTenant allows access to admins and services, as well
+	// as to tenants. Handlers are responsible for checking that
+	// tenants only access their own resources, see CheckTenant.
+	AuthZTenant = AllowRoles(RoleAdmin, RoleService, RoleTenant)
+)
+
+// CheckTenant returns a 403 error if the user in the context is
+// neither admin nor service and doesn't belong to the provided tenant,
+// which is recorded in the user's attribute with the key "tenant".
+func CheckTenant(ctx RestContext, tenant string) error {
+	if ctx.User.HasRole(RoleAdmin, RoleService) {
+		return nil
+	}
+	if ctx.User.HasRole(RoleTenant) && tenant != "" && ctx.User.GetAttribute(RoleTenant) == tenant {
+		return nil
+	}
+	return NewError403()
+}
+
+// authorized returns true if the user in the context is allowed to
+// access the route. Without AuthZChecker on the route, only admins
+// and services are allowed.
+func authorized(route Route, ctx RestContext) bool {
+	if route.AuthZChecker == nil {
+		return ctx.User.HasRole(RoleAdmin, RoleService)
+	}
+	return route.AuthZChecker(ctx)
+}
+
 // AuthMiddleware wrapper for auth.
 type AuthMiddleware struct {
 	PublicKey   *rsa.PublicKey
 	AllowedURLs []string
 }
 
-// NewAuthMiddleware creates new AuthMiddleware to use.
-// Its behavior depends on whether it is for root (in which case
-// the public key is gotten from the config file) or another
-// service (in which case the public key is gotten from the root).
-func NewAuthMiddleware(service Service) (AuthMiddleware, error) {
+// NewAuthMiddleware creates new AuthMiddleware to use. If the config
+// specifies AuthPublicKey, the RSA public key is read from that file
+// and used to verify tokens; otherwise authentication is off and all
+// requests are treated as coming from an admin.
+func NewAuthMiddleware(config Config) (AuthMiddleware, error) {
 	authMiddleware := AuthMiddleware{}
+	if config.AuthPublicKey == "" {
+		log.Infof("Authentication is off, no public key configured")
+		return authMiddleware, nil
+	}
+
+	log.Debugf("Creating AuthMiddleware: reading public key from %s", config.AuthPublicKey)
+	data, err := ioutil.ReadFile(config.AuthPublicKey)
+	if err != nil {
+		return authMiddleware, err
+	}
+	key, err := jwt.ParseRSAPublicKeyFromPEM(data)
+	if err != nil {
+		log.Errorf("Error parsing RSA public key from %s: %T: %s", config.AuthPublicKey, err, err)
+		return authMiddleware, err
+	}
+	authMiddleware.PublicKey = key
 	return authMiddleware, nil
-	//	var err error
-	//
-	//	// If we are in the Root service...
-	//	if service.Name() == ServiceNameRoot {
-	//		// Really it would be most convenient to just use root.Root.publicKey but that
-	//		// would create a circular import dependency.
-	//		fullConfig := config.ServiceSpecific[FullConfigKey].(Config)
-	//		rootConfig := fullConfig.Services[ServiceNameRoot].ServiceSpecific
-	//		auth, err := ToBool(rootConfig["auth"])
-	//		if err != nil {
-	//			return authMiddleware, err
-	//		}
-	//		if auth {
-	//			// If authentication is on, get the public key from local file
-	//			// and parse and store it.
-	//			publicKeyLocation := config.Common.Api.AuthPublic
-	//			log.Debugf("Creating AuthMiddleware for Root: reading public key from %s", publicKeyLocation)
-	//			data, err := ioutil.ReadFile(publicKeyLocation)
-	//			if err != nil {
-	//				return authMiddleware, err
-	//			}
-	//			key, err := jwt.ParseRSAPublicKeyFromPEM(data)
-	//			if err != nil {
-	//				log.Errorf("Error parsing RSA public key from %s: %T: %s", publicKeyLocation, err, err)
-	//				return authMiddleware, err
-	//			}
-	//			authMiddleware.PublicKey = key
-	//			// These URLs for Root are allowed to be accessed w/o authentication
-	//			authMiddleware.AllowedURLs = []string{"/", "/auth", "/publicKey"}
-	//		} else {
-	//			// If the authentication is not turned on, just
-	//			// set this to nil
-	//			authMiddleware.PublicKey = nil
-	//		}
-	//		return authMiddleware, nil
-	//	}
-	//	// This is NOT root service - in this path
-	//	// we are constructing AuthMiddleware for some other service.
-	//	// So, first, get the public key to verify tokens with
-	//	// from Root:
-	//	authMiddleware.PublicKey, err = client.GetPublicKey()
-	//	if err != nil {
-	//		return authMiddleware, err
-	//	}
-	//	return authMiddleware, nil
 }
 
 // Keyfunc implements jwt.Keyfunc (https://godoc.org/github.com/dgrijalva/jwt-go#Keyfunc)
-// by returning the public key
-func (am AuthMiddleware) Keyfunc(*jwt.Token) (interface{}, error) {
+// by returning the public key. Only RSA signed tokens are accepted,
+// otherwise the public key could be used as a HMAC secret to forge tokens.
+func (am AuthMiddleware) Keyfunc(token *jwt.Token) (interface{}, error) {
+	if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
+		return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+	}
 	return am.PublicKey, nil
 }
 
 // ServeHTTP implements the middleware contract as follows:
 //  1. If the path of request is one of the AllowedURLs, then this is a no-op.
-//  2 Otherwise, checks token from request. If the token is not valid,
-//  returns a 403 FORBIDDEN status.
+//  2. Otherwise, checks token from request. If the token is not valid,
+//     returns a 403 FORBIDDEN status.
+//
+// The token is expected in Authorization header, optionally prefixed
+// with "Bearer ".
 func (am AuthMiddleware) ServeHTTP(writer http.ResponseWriter, request *http.Request, next http.HandlerFunc) {
+	defer context.Clear(request)
+
 	for _, url := range am.AllowedURLs {
 		if request.URL.Path == url {
 			// If the requested path is one that the AuthMiddleware
@@ -288,9 +359,11 @@ func (am AuthMiddleware) ServeHTTP(writer http.ResponseWriter, request *http.Req
 		context.Set(request, ContextKeyUser, DefaultAdminUser)
 	} else {
 		headerToken := request.Header.Get("Authorization")
+		if strings.HasPrefix(headerToken, "Bearer ") {
+			headerToken = strings.TrimPrefix(headerToken, "Bearer ")
+		}
 		user := &User{}
 		token, err := jwt.ParseWithClaims(headerToken, user, am.Keyfunc)
-		log.Debugf("Token received from %s: %v", headerToken, token)
 
 		if err != nil {
 			writer.WriteHeader(http.StatusForbidden)
@@ -306,7 +379,7 @@ func (am AuthMiddleware) ServeHTTP(writer http.ResponseWriter, request *http.Req
 			writer.Write(outData)
 			return
 		}
-		log.Debugf("Token parsed: %+v vs %+v", token.Claims, user)
+		log.Debugf("Token parsed for user %s with roles %v", user.Username, user.Roles)
 		context.Set(request, ContextKeyUser, *user)
 	}
 	next(writer, request)
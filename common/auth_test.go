@@ -0,0 +1,161 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package common
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gorilla/context"
+)
+
+func TestAuthMiddleware(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile, err := ioutil.TempFile("", "romana-auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(keyFile.Name())
+	pem.Encode(keyFile, &pem.Block{Type: "PUBLIC KEY", Bytes: der})
+	keyFile.Close()
+
+	am, err := NewAuthMiddleware(Config{AuthPublicKey: keyFile.Name()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serve := func(token string) (int, *User) {
+		request := httptest.NewRequest("GET", "/networks", nil)
+		if token != "" {
+			request.Header.Set("Authorization", "Bearer "+token)
+		}
+		recorder := httptest.NewRecorder()
+		recorder.Header().Set("Content-Type", "application/json")
+
+		var user *User
+		am.ServeHTTP(recorder, request, func(w http.ResponseWriter, r *http.Request) {
+			u := context.Get(r, ContextKeyUser).(User)
+			user = &u
+		})
+		return recorder.Code, user
+	}
+
+	claims := User{
+		Username: "tenant1",
+		Roles:    []Role{{Name: RoleTenant}},
+		StandardClaims: jwt.StandardClaims{
+			ExpiresAt: time.Now().Add(time.Hour).Unix(),
+		},
+	}
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodRS256, claims).SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code, user := serve(signed)
+	if code != http.StatusOK || user == nil || user.Username != "tenant1" || !user.HasRole(RoleTenant) {
+		t.Errorf("expected tenant1 to be authenticated, got %d, %+v", code, user)
+	}
+
+	if code, user = serve(""); code != http.StatusForbidden || user != nil {
+		t.Errorf("expected request without token to be forbidden, got %d", code)
+	}
+
+	claims.ExpiresAt = time.Now().Add(-time.Hour).Unix()
+	expired, _ := jwt.NewWithClaims(jwt.SigningMethodRS256, claims).SignedString(key)
+	if code, user = serve(expired); code != http.StatusForbidden || user != nil {
+		t.Errorf("expected expired token to be forbidden, got %d", code)
+	}
+
+	// Token signed with public key as HMAC secret must be rejected.
+	claims.ExpiresAt = time.Now().Add(time.Hour).Unix()
+	keyPEM, _ := ioutil.ReadFile(keyFile.Name())
+	forged, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(keyPEM)
+	if code, user = serve(forged); code != http.StatusForbidden || user != nil {
+		t.Errorf("expected HMAC signed token to be forbidden, got %d", code)
+	}
+
+	// Without public key authentication is off and everyone is admin.
+	am, err = NewAuthMiddleware(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code, user = serve(""); code != http.StatusOK || user == nil || !user.HasRole(RoleAdmin) {
+		t.Errorf("expected admin without authentication, got %d, %+v", code, user)
+	}
+}
+
+func TestAuthorized(t *testing.T) {
+	admin := User{Roles: []Role{{Name: RoleAdmin}}}
+	readOnly := User{Roles: []Role{{Name: RoleReadOnly}}}
+	tenant := User{
+		Roles:      []Role{{Name: RoleTenant}},
+		Attributes: []Attribute{{AttributeKey: RoleTenant, AttributeValue: "t1"}},
+	}
+
+	adminRoute := Route{}
+	readRoute := Route{AuthZChecker: AuthZReadOnly}
+	tenantRoute := Route{AuthZChecker: AuthZTenant}
+
+	cases := []struct {
+		user     User
+		route    Route
+		expected bool
+	}{
+		{admin, adminRoute, true},
+		{admin, readRoute, true},
+		{readOnly, adminRoute, false},
+		{readOnly, readRoute, true},
+		{readOnly, tenantRoute, false},
+		{tenant, adminRoute, false},
+		{tenant, readRoute, true},
+		{tenant, tenantRoute, true},
+		{User{}, readRoute, false},
+	}
+
+	for i, tc := range cases {
+		if got := authorized(tc.route, RestContext{User: tc.user}); got != tc.expected {
+			t.Errorf("case %d: expected %t, got %t", i, tc.expected, got)
+		}
+	}
+
+	if err := CheckTenant(RestContext{User: tenant}, "t1"); err != nil {
+		t.Errorf("expected tenant t1 to access its resources, %s", err)
+	}
+	if err := CheckTenant(RestContext{User: tenant}, "t2"); err == nil {
+		t.Errorf("expected tenant t1 to be denied access to tenant t2")
+	}
+	if err := CheckTenant(RestContext{User: admin}, "t2"); err != nil {
+		t.Errorf("expected admin to access any tenant, %s", err)
+	}
+}
@@ -19,11 +19,14 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/romana/core/common"
 	"github.com/romana/core/common/api"
+	"github.com/romana/core/common/api/errors"
 	"github.com/romana/core/common/log/trace"
 
 	libkvStore "github.com/docker/libkv/store"
@@ -38,6 +41,11 @@ const (
 	PoliciesPrefix        = "/policies"
 	RomanaVIPPrefix       = "/romanavip"
 	defaultTopologyLevels = 20
+	ipamWatchRetryDelay   = 5 * time.Second
+
+	// RomanaVIPNetworkKey names the IPAM network romana
+	// VIPs are allocated from in auto mode.
+	RomanaVIPNetworkKey = "/kubelistener/config/romanaVIPNetwork"
 )
 
 type Client struct {
@@ -46,6 +54,16 @@ type Client struct {
 	Store       *Store
 	ipamLocker  Locker
 	IPAM        *IPAM
+
+	// shards caches IPAM keys as last seen in the store, and
+	// deletedShards the indexes of keys deleted since, see applyShard.
+	// Both are guarded by savingMutex.
+	shards          map[string]*libkvStore.KVPair
+	deletedShards   map[string]uint64
+	ipamSubscribers map[chan *IPAM]struct{}
+
+	allocationMutex   *sync.Mutex
+	allocationLockers map[string]Locker
 }
 
 // NewClient creates a new Client object based on provided config
@@ -53,15 +71,20 @@ func NewClient(config *common.Config) (*Client, error) {
 	if config.EtcdPrefix == "" {
 		config.EtcdPrefix = DefaultEtcdPrefix
 	}
-	store, err := NewStore(config.EtcdEndpoints, config.EtcdPrefix)
+	store, err := NewStoreFromConfig(config)
 	if err != nil {
 		return nil, err
 	}
 
 	c := &Client{
-		config:      config,
-		Store:       store,
-		savingMutex: &sync.RWMutex{},
+		config:            config,
+		Store:             store,
+		savingMutex:       &sync.RWMutex{},
+		shards:            make(map[string]*libkvStore.KVPair),
+		deletedShards:     make(map[string]uint64),
+		ipamSubscribers:   make(map[chan *IPAM]struct{}),
+		allocationMutex:   &sync.Mutex{},
+		allocationLockers: make(map[string]Locker),
 	}
 
 	err = c.initIPAM(config.InitialTopologyFile)
@@ -123,18 +146,15 @@ func (c *Client) WatchBlocksWithCallback(cb BlocksCallback) error {
 	return nil
 }
 
-// WatchBlocks is similar to Watch of libkv store, but specific
-// to watching for blocks.
+// WatchBlocks sends the list of blocks to the returned channel every
+// time it changes.
 func (c *Client) WatchBlocks(stopCh <-chan struct{}) (<-chan api.IPAMBlocksResponse, error) {
 	log.Tracef(trace.Public, "Entering WatchBlocks.")
-	ch, err := c.Store.ReconnectingWatch(ipamDataKey, stopCh)
-	if err != nil {
-		return nil, err
-	}
+	ch := c.subscribeIPAM(stopCh)
 	outCh := make(chan api.IPAMBlocksResponse)
-	// Since for now everything is stored in a single blob, we are going to get
-	// notification on all changes. We can filter them out by checking for
-	// the revision in the block list.
+	// We are going to get notification on all changes of IPAM.
+	// We can filter them out by checking for the revision in the
+	// block list.
 	lastBlockListRevision := -1
 
 	go func() {
@@ -144,26 +164,18 @@ func (c *Client) WatchBlocks(stopCh <-chan struct{}) (<-chan api.IPAMBlocksRespo
 			case <-stopCh:
 				log.Tracef(trace.Inside, "WatchBlocks: Stop message received")
 				return
-			case kv := <-ch:
-				ipamJson := string(kv.Value)
-				log.Tracef(trace.Inside, "WatchBlocks: got JSON [%s]", ipamJson)
-
-				ipam, err := parseIPAM(ipamJson)
-				if err != nil {
-					if ipamJson == "" {
-						log.Warnf("WatchBlocks: Received empty IPAM JSON from KV store")
-					} else {
-						log.Errorf("WatchBlocks: Error parsing IPAM JSON ```%s ```: %s", ipamJson, err)
-					}
-					break
-				}
+			case ipam := <-ch:
 				blocks := ipam.ListAllBlocks()
 				if blocks.Revision <= lastBlockListRevision {
 					log.Debugf("WatchBlocks: Received revision %d smaller than last reported %d, ignoring.", blocks.Revision, lastBlockListRevision)
-				} else {
-					lastBlockListRevision = blocks.Revision
-					log.Tracef(trace.Inside, "WatchBlocks: sending block list revision %d to out channel", blocks.Revision)
-					outCh <- *blocks
+					break
+				}
+				lastBlockListRevision = blocks.Revision
+				log.Tracef(trace.Inside, "WatchBlocks: sending block list revision %d to out channel", blocks.Revision)
+				select {
+				case outCh <- *blocks:
+				case <-stopCh:
+					return
 				}
 			}
 		}
@@ -171,18 +183,14 @@ func (c *Client) WatchBlocks(stopCh <-chan struct{}) (<-chan api.IPAMBlocksRespo
 	return outCh, nil
 }
 
-// WatchHosts is similar to Watch of libkv store, but specific
-// to watching for host list.
+// WatchHosts sends the list of hosts to the returned channel every
+// time it changes.
 func (c *Client) WatchHosts(stopCh <-chan struct{}) (<-chan api.HostList, error) {
 	log.Tracef(trace.Public, "Entering WatchHosts.")
-	ch, err := c.Store.ReconnectingWatch(ipamDataKey, stopCh)
-	if err != nil {
-		return nil, err
-	}
+	ch := c.subscribeIPAM(stopCh)
 	outCh := make(chan api.HostList)
-	// Since for now everything is stored in a single blob, we are going to get
-	// notification on all changes. We can filter them out by checking for
-	// IPAM's TopologyRevision.
+	// We are going to get notification on all changes of IPAM.
+	// We can filter them out by checking for IPAM's TopologyRevision.
 	lastHostListRevision := -1
 
 	go func() {
@@ -192,21 +200,18 @@ func (c *Client) WatchHosts(stopCh <-chan struct{}) (<-chan api.HostList, error)
 			case <-stopCh:
 				log.Tracef(trace.Inside, "WatchHosts: Stop message received")
 				return
-			case kv := <-ch:
-				ipamJson := string(kv.Value)
-				ipam, err := parseIPAM(ipamJson)
-				log.Tracef(trace.Inside, "WatchHosts: got %s", ipamJson)
-				if err != nil {
-					log.Errorf("WatchHosts: Error parsing IPAM: %s", err)
-					continue
-				}
+			case ipam := <-ch:
 				hostList := ipam.ListHosts()
 				if hostList.Revision <= lastHostListRevision {
 					log.Debugf("WatchHosts: Received revision %d smaller than last reported %d, ignoring.", hostList.Revision, lastHostListRevision)
-				} else {
-					lastHostListRevision = hostList.Revision
-					log.Tracef(trace.Inside, "WatchHosts: sending host list revision %d to out channel", hostList.Revision)
-					outCh <- hostList
+					break
+				}
+				lastHostListRevision = hostList.Revision
+				log.Tracef(trace.Inside, "WatchHosts: sending host list revision %d to out channel", hostList.Revision)
+				select {
+				case outCh <- hostList:
+				case <-stopCh:
+					return
 				}
 			}
 		}
@@ -330,111 +335,114 @@ func (c *Client) initIPAM(initialTopologyFile *string) error {
 	log.Tracef(trace.Inside, "initIPAM(): Got lock")
 	defer c.ipamLocker.Unlock()
 
-	// Check if IPAM info exists in the store
-	var ipamExists bool
-	ipamExists, err = c.Store.Exists(ipamDataKey)
+	shards, err := c.readShards()
 	if err != nil {
 		return err
 	}
-	log.Infof("IPAM exists at %s: %t", ipamDataKey, ipamExists)
-	// make sure there is sane data in ipam.
+	c.savingMutex.Lock()
+	c.resetShards(shards)
+	c.savingMutex.Unlock()
+
+	// Meta is written last, so IPAM exists if meta does.
+	_, ipamExists := shards[ipamMetaKey]
+	log.Infof("IPAM exists at %s: %t", c.Store.getKey(ipamKey), ipamExists)
 	if ipamExists {
-		ipamData, err := c.Store.GetString(ipamDataKey, "")
-		if err != nil {
-			log.Errorf("Error while fetching ipam data: %s", err)
-			return err
-		}
-		log.Debugf("IPAM data: %s", ipamData)
-		if ipamData == "" {
-			log.Trace(trace.Inside, "Setting ipamExists to false because ipamData = \"\"")
-			ipamExists = false
-		} else {
-			ipam := &IPAM{}
-			err := json.Unmarshal([]byte(ipamData), ipam)
-			if err != nil {
-				log.Errorf("Error while un-marshalling ipam data: %s", err)
-				return err
-			}
-			if ipam.AllocationRevision < 1 && ipam.TopologyRevision < 1 {
-				log.Warnf("Allocation revision: %d, Topology revision %d, deleting", ipam.AllocationRevision, ipam.TopologyRevision)
-				c.Store.Delete(ipamDataKey)
-				log.Trace(trace.Inside, "Setting ipamExists to false because deleted")
-				ipamExists = false
-			}
+		if initialTopologyFile != nil && *initialTopologyFile != "" {
+			log.Infof("Ignoring initial topology file %s as IPAM already exists", *initialTopologyFile)
 		}
+		c.IPAM, err = c.newIPAM(shards)
+		return err
 	}
 
-	if ipamExists {
+	legacyIPAM, err := c.loadLegacyIPAM()
+	if err != nil {
+		return err
+	}
+	if legacyIPAM != nil {
 		if initialTopologyFile != nil && *initialTopologyFile != "" {
 			log.Infof("Ignoring initial topology file %s as IPAM already exists", *initialTopologyFile)
 		}
-		// Load if exists
-		log.Infof("Loading IPAM data from %s", c.Store.getKey(ipamDataKey))
-		kv, err := c.Store.Get(ipamDataKey)
+		log.Infof("Moving IPAM data from %s to keys under %s", c.Store.getKey(ipamDataKey), c.Store.getKey(ipamKey))
+		c.IPAM = legacyIPAM
+		c.attachIPAM(c.IPAM)
+		// Keys left by an interrupted attempt are overwritten.
+		c.IPAM.shards = shards
+		return c.save(c.IPAM, ch)
+	}
+
+	// If does not exist -- initialize with initial topology.
+	log.Infof("No IPAM data found at %s, initializing", c.Store.getKey(ipamKey))
+	c.IPAM = &IPAM{}
+	c.attachIPAM(c.IPAM)
+	c.IPAM.shards = shards
+
+	if initialTopologyFile != nil && *initialTopologyFile != "" {
+		topoData, err := ioutil.ReadFile(*initialTopologyFile)
 		if err != nil {
 			return err
 		}
-		c.IPAM, err = parseIPAM(string(kv.Value))
+		topoReq := &api.TopologyUpdateRequest{}
+		err = json.Unmarshal(topoData, topoReq)
 		if err != nil {
-			return err
-		}
-		c.IPAM.save = c.save
-		c.IPAM.load = c.load
-		c.IPAM.locker = c.ipamLocker
-		c.IPAM.SetPrevKVPair(kv)
-	} else {
-		// If does not exist -- initialize with initial topology.
-
-		log.Infof("No IPAM data found at %s, initializing", c.Store.getKey(ipamDataKey))
-		c.IPAM = &IPAM{locker: c.ipamLocker,
-			save: c.save,
-			load: c.load,
-		}
-
-		if initialTopologyFile != nil && *initialTopologyFile != "" {
-			topoData, err := ioutil.ReadFile(*initialTopologyFile)
-			if err != nil {
-				return err
-			}
-			topoReq := &api.TopologyUpdateRequest{}
-			err = json.Unmarshal(topoData, topoReq)
-			if err != nil {
-				return fmt.Errorf("error processing %s: %s", *initialTopologyFile, err)
-			}
-			err = c.IPAM.UpdateTopology(*topoReq, false)
-			if err != nil {
-				return err
-			}
-			log.Infof("Initialized IPAM with %s", *initialTopologyFile)
+			return fmt.Errorf("error processing %s: %s", *initialTopologyFile, err)
 		}
-		err = c.save(c.IPAM, ch)
+		err = c.IPAM.UpdateTopology(*topoReq, false)
 		if err != nil {
 			return err
 		}
-
+		log.Infof("Initialized IPAM with %s", *initialTopologyFile)
 	}
-	return nil
+	return c.save(c.IPAM, ch)
 }
 
-func (c *Client) load(ipam *IPAM, ch <-chan struct{}) error {
-	kv, err := c.Store.Get(ipamDataKey)
+// loadLegacyIPAM loads IPAM stored as a single document by earlier
+// versions, returning nil if there is none. The document is left in
+// place, but is no longer used once IPAM is saved.
+func (c *Client) loadLegacyIPAM() (*IPAM, error) {
+	ipamData, err := c.Store.GetString(ipamDataKey, "")
 	if err != nil {
-		return err
+		log.Errorf("Error while fetching ipam data: %s", err)
+		return nil, err
+	}
+	if ipamData == "" {
+		return nil, nil
 	}
-	parsedIPAM, err := parseIPAM(string(kv.Value))
+	log.Debugf("IPAM data: %s", ipamData)
+
+	// make sure there is sane data in ipam.
+	ipam, err := parseIPAM(ipamData)
+	if err != nil {
+		log.Errorf("Error while un-marshalling ipam data: %s", err)
+		return nil, err
+	}
+	if ipam.AllocationRevision < 1 && ipam.TopologyRevision < 1 {
+		log.Warnf("Allocation revision: %d, Topology revision %d, deleting", ipam.AllocationRevision, ipam.TopologyRevision)
+		c.Store.Delete(ipamDataKey)
+		return nil, nil
+	}
+	return ipam, nil
+}
+
+// load implements the Loader interface of IPAM. IPAM is loaded
+// from the cache kept up to date by watchIPAM, keys that turn out
+// to be stale are reloaded when saving them fails.
+func (c *Client) load(ipam *IPAM, ch <-chan struct{}) error {
+	c.savingMutex.RLock()
+	shards := c.snapshotShards()
+	c.savingMutex.RUnlock()
+
+	parsedIPAM, err := parseShards(shards)
 	if err != nil {
 		return err
 	}
 	*ipam = *parsedIPAM
-	ipam.SetPrevKVPair(kv)
+	ipam.shards = shards
 	return nil
 }
 
-// save implements the Saver interface of IPAM.
+// save implements the Saver interface of IPAM. Only the keys that
+// differ from the ones IPAM was loaded from are written.
 func (c *Client) save(ipam *IPAM, ch <-chan struct{}) error {
-	c.savingMutex.Lock()
-	defer c.savingMutex.Unlock()
-	var err error
 	log.Tracef(trace.Inside, "Entering save() from %d", getGID())
 	select {
 	case msg := <-ch:
@@ -451,22 +459,44 @@ func (c *Client) save(ipam *IPAM, ch <-chan struct{}) error {
 		log.Warn(fmt.Sprintf("Lost lock while saving in %d: %p", getGID(), &msg))
 		return nil
 	default:
-		err = c.Store.AtomicPut(ipamDataKey, ipam)
-		if err != nil {
-			log.Errorf("Error saving IPAM: %s: %d", err, getGID())
-			return err
+	}
+
+	shards, err := ipam.marshalShards()
+	if err != nil {
+		return err
+	}
+	writes := diffShards(ipam.shards, shards)
+	written, err := c.writeShards(writes)
+	if err != nil {
+		log.Errorf("Error saving IPAM: %s: %d", err, getGID())
+		return err
+	}
+
+	c.savingMutex.Lock()
+	defer c.savingMutex.Unlock()
+	base := make(map[string]*libkvStore.KVPair, len(shards))
+	for key, kv := range ipam.shards {
+		base[key] = kv
+	}
+	for _, w := range writes {
+		if kv := written[w.key]; kv != nil {
+			base[w.key] = kv
+			c.applyShard(kv)
+		} else {
+			delete(base, w.key)
+			c.applyShard(&libkvStore.KVPair{Key: w.key, LastIndex: w.previous.LastIndex})
 		}
-		log.Debugf("%d: Saved IPAM (Alloc rev: %d, Topo rev: %d): IPAM rev %d", getGID(), ipam.AllocationRevision, ipam.TopologyRevision, c.IPAM.GetPrevKVPair().LastIndex)
-		return nil
 	}
+	ipam.shards = base
+	log.Debugf("%d: Saved IPAM (Alloc rev: %d, Topo rev: %d): %d keys written", getGID(), ipam.AllocationRevision, ipam.TopologyRevision, len(writes))
+	return nil
 }
 
-// watchIPAM watches the backing store, and if a new IPAM is detected, it will
-// reinitialize itself with the new value.
+// watchIPAM watches IPAM keys in the backing store, keeping the cache
+// of the keys up to date and reinitializing IPAM when they change.
 func (c *Client) watchIPAM() error {
 	log.Tracef(trace.Public, "Entering watchIPAM.")
-	stopCh := make(<-chan struct{})
-	ch, err := c.Store.ReconnectingWatch(ipamDataKey, stopCh)
+	ch, stopCh, err := c.syncShards()
 	if err != nil {
 		return err
 	}
@@ -474,33 +504,37 @@ func (c *Client) watchIPAM() error {
 	go func() {
 		log.Tracef(trace.Inside, "watchIPAM: Entering watchIPAM goroutine: %d", getGID())
 		for {
-
-			select {
-			case kv := <-ch:
-				c.savingMutex.RLock()
-				prevKV := c.IPAM.GetPrevKVPair()
-				if prevKV == nil || kv.LastIndex > prevKV.LastIndex {
-					log.Debugf("Received IPAM with revision %d, current last revision %d", kv.LastIndex, prevKV.LastIndex)
-					if err != nil {
-						log.Error(err)
-						// Nothing to do here, but since there is a new version,
-						// IPAM will continue failing on save until we get another one and
-						// try again
-						c.savingMutex.RUnlock()
-						continue
-					}
-					c.IPAM, err = parseIPAM(string(kv.Value))
-					if err != nil {
-						log.Error(err)
-						c.savingMutex.RUnlock()
-						continue
+			kv, ok := <-ch
+			synced := ok && kv != nil
+			if synced {
+				c.savingMutex.Lock()
+				synced = c.applyEvent(kv)
+				// Apply changes that are already there before reloading IPAM.
+			pending:
+				for synced {
+					select {
+					case kv, ok = <-ch:
+						synced = ok && kv != nil && c.applyEvent(kv)
+					default:
+						break pending
 					}
-					c.IPAM.save = c.save
-					c.IPAM.load = c.load
-					c.IPAM.SetPrevKVPair(kv)
-					log.Debugf("Loaded IPAM with revision %d", kv.LastIndex)
 				}
-				c.savingMutex.RUnlock()
+				c.refreshIPAM()
+				c.savingMutex.Unlock()
+			}
+			if synced {
+				continue
+			}
+
+			log.Infof("watchIPAM: Lost track of changes in %s, reloading IPAM", c.Store.getKey(ipamKey))
+			close(stopCh)
+			for {
+				ch, stopCh, err = c.syncShards()
+				if err == nil {
+					break
+				}
+				log.Errorf("watchIPAM: Error reloading IPAM: %s", err)
+				time.Sleep(ipamWatchRetryDelay)
 			}
 		}
 	}()
@@ -556,6 +590,51 @@ func (c *Client) ListRomanaVIPs() (map[string]api.ExposedIPSpec, error) {
 	return exposedIPs, nil
 }
 
+// ListAddresses returns a copy of the names of allocated
+// addresses mapped to their IPs.
+func (c *Client) ListAddresses() map[string]net.IP {
+	c.savingMutex.RLock()
+	defer c.savingMutex.RUnlock()
+
+	addresses := make(map[string]net.IP, len(c.IPAM.AddressNameToIP))
+	for name, ip := range c.IPAM.AddressNameToIP {
+		addresses[name] = ip
+	}
+	return addresses
+}
+
+// RoutedBlocks returns the provided blocks except those of the romana
+// VIP network. Romana VIPs move between hosts with their services, so
+// their blocks must not be routed to the host they were allocated on.
+func (c *Client) RoutedBlocks(blocks []api.IPAMBlockResponse) ([]api.IPAMBlockResponse, error) {
+	netName, err := c.Store.GetString(RomanaVIPNetworkKey, "")
+	if err != nil {
+		return nil, err
+	}
+	if netName == "" {
+		return blocks, nil
+	}
+
+	c.savingMutex.RLock()
+	network, err := c.IPAM.GetNetwork(netName)
+	c.savingMutex.RUnlock()
+	if err != nil {
+		if _, ok := err.(errors.RomanaNotFoundError); ok {
+			return blocks, nil
+		}
+		return nil, err
+	}
+
+	var routed []api.IPAMBlockResponse
+	for _, block := range blocks {
+		if network.CIDR.Contains(block.CIDR.IP) {
+			continue
+		}
+		routed = append(routed, block)
+	}
+	return routed, nil
+}
+
 // GetTopology returns the representation of latest topology in store.
 func (c *Client) GetTopology() (interface{}, error) {
 	ch, err := c.ipamLocker.Lock()
@@ -564,7 +643,7 @@ func (c *Client) GetTopology() (interface{}, error) {
 	}
 	defer c.ipamLocker.Unlock()
 
-	kv, err := c.Store.Get(ipamDataKey)
+	shards, err := c.readShards()
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch ipam information: %s", err)
 	}
@@ -575,18 +654,11 @@ func (c *Client) GetTopology() (interface{}, error) {
 	default:
 	}
 
-	ipamState := &IPAM{}
-	err = json.Unmarshal(kv.Value, ipamState)
+	ipamState, err := parseShards(shards)
 	if err != nil {
 		return nil, fmt.Errorf("failed to unmarshal ipam information: %s", err)
 	}
 
-	select {
-	case lockError := <-ch:
-		return nil, fmt.Errorf("failed to hold on to ipam lock: %s", lockError)
-	default:
-	}
-
 	return getTopologyFromIPAMState(ipamState), nil
 }
 
@@ -19,18 +19,19 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"math/rand"
+	"net"
 	"reflect"
 	"sort"
 	"testing"
+	"time"
 
+	"github.com/romana/core/common"
 	"github.com/romana/core/common/api"
 )
 
-/*
 var (
 	client *Client
-	// Keep track of state of some tests
-	state int
 )
 
 func init() {
@@ -49,7 +50,7 @@ func tearDown(t *testing.T) {
 
 func initClient(t *testing.T, topoConf string) *Client {
 	var err error
-	cfg := &common.Config{EtcdEndpoints: []string{"localhost:2379"},
+	cfg := &common.Config{StoreBackend: common.StoreBackendMemory,
 		EtcdPrefix: fmt.Sprintf("/romanaTest%d", rand.Int63n(100000)),
 	}
 	client, err := NewClient(cfg)
@@ -62,68 +63,39 @@ func initClient(t *testing.T, topoConf string) *Client {
 		if err != nil {
 			t.Fatalf("Cannot parse %s: %v", topoConf, err)
 		}
-		err = client.IPAM.UpdateTopology(topoReq, false)
+		err = client.IPAM.UpdateTopology(topoReq, true)
 		if err != nil {
 			t.Fatal(err)
 		}
 	}
 	return client
 }
-*/
+
+// watchTimeout is how long the tests wait for the callbacks.
+const watchTimeout = 5 * time.Second
 
 // TestWatchHostsWithCallback tests WatchHostsWithCallback -- and since it
 // uses WatchHosts internally, implicitly also WatchHosts
-/*
 func TestWatchHostsWithCallback(t *testing.T) {
-	client := initClient(t, "")
+	client = initClient(t, "")
 	defer tearDown(t)
-	errCh := make(chan string)
-	okCh := make(chan string)
+	hostsCh := make(chan api.HostList, 10)
 	err := client.WatchHostsWithCallback(func(hl api.HostList) {
-		state++
-
-		var msg string
-		fmt.Printf("HostListCallback: Got host list of length %d and revision %d in state %d\n", len(hl.Hosts), hl.Revision, state)
-		if state == 1 {
-			if len(hl.Hosts) != 0 {
-				msg = fmt.Sprintf("HostListCallback: Expected host length at this point to be 0, got %d", len(hl.Hosts))
-				fmt.Println(msg)
-				errCh <- msg
-				return
-			}
-			if hl.Revision != 0 {
-				msg = fmt.Sprintf("HostListCallback: Expected host list revision at this point to be 0, got %d", hl.Revision)
-				fmt.Println(msg)
-				errCh <- msg
-				return
-			}
-		} else if state == 2 {
-			if len(hl.Hosts) != 2 {
-				msg = fmt.Sprintf("HostListCallback: Expected host length at this point to be 2, got %d", len(hl.Hosts))
-				fmt.Println(msg)
-				errCh <- msg
-				return
-			}
-			if hl.Revision != 1 {
-				msg = fmt.Sprintf("HostListCallback: Expected host list revision at this point to be 1, got %d", hl.Revision)
-				fmt.Println(msg)
-				errCh <- msg
-				return
-			}
-			// Here everything is ok!
-			okCh <- "OK"
-		} else {
-			msg = fmt.Sprintf("HostListCallback: Did not expect HostListCallback to be called in state %d", state)
-			fmt.Println(state)
-			errCh <- msg
-
-		}
-
+		hostsCh <- hl
 	})
 	if err != nil {
 		t.Fatal(err)
 	}
 
+	select {
+	case hl := <-hostsCh:
+		if len(hl.Hosts) != 0 {
+			t.Fatalf("Expected host length at this point to be 0, got %d", len(hl.Hosts))
+		}
+	case <-time.After(watchTimeout):
+		t.Fatal("Expected initial host list")
+	}
+
 	topoConf := `{
 	"networks": [{
 		"name": "net1",
@@ -152,30 +124,27 @@ func TestWatchHostsWithCallback(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Cannot parse %s: %v", topoConf, err)
 	}
-	err = client.IPAM.UpdateTopology(topoReq)
+	err = client.IPAM.UpdateTopology(topoReq, true)
 	if err != nil {
 		t.Fatal(err)
 	}
+
 	for {
 		select {
-		case msg := <-errCh:
-			t.Fatal(msg)
-			break
-		case <-okCh:
-			return
+		case hl := <-hostsCh:
+			if len(hl.Hosts) == 2 {
+				return
+			}
+			t.Logf("Got host list of length %d and revision %d", len(hl.Hosts), hl.Revision)
+		case <-time.After(watchTimeout):
+			t.Fatal("Expected host list of length 2")
 		}
 	}
-
 }
-*/
 
 // TestWatchBlocksWithCallback tests WatchBlocksWithCallback -- and since it
 // uses WatchBlocks internally, implicitly also WatchBlocks
-/*
 func TestWatchBlocksWithCallback(t *testing.T) {
-	defer tearDown(t)
-	errCh := make(chan string)
-	okCh := make(chan string)
 	topoConf := `{
 	"networks": [{
 		"name": "net1",
@@ -200,99 +169,45 @@ func TestWatchBlocksWithCallback(t *testing.T) {
 	}]
 }`
 	client = initClient(t, topoConf)
-	err := client.WatchBlocksWithCallback(func(hl api.IPAMBlocksResponse) {
-		state++
-
-		var msg string
-		fmt.Printf("BlocksListCallback: Got block list of length %d and revision %d in state %d\n", len(hl.Blocks), hl.Revision, state)
-		switch state {
-		case 1:
-			if len(hl.Blocks) != 0 {
-				msg = fmt.Sprintf("BlocksListCallback: Expected block length at this point to be 0, got %d", len(hl.Blocks))
-				fmt.Println(msg)
-				errCh <- msg
-				return
-			}
-			if hl.Revision != 0 {
-				msg = fmt.Sprintf("BlocksListCallback: Expected block list revision at this point to be 0, got %d", hl.Revision)
-				fmt.Println(msg)
-				errCh <- msg
-				return
-			}
-		case 2, 3:
-			if len(hl.Blocks) != 1 {
-				msg = fmt.Sprintf("BlocksListCallback: Expected block length at this point to be 1, got %d", len(hl.Blocks))
-				fmt.Println(msg)
-				errCh <- msg
-				return
-			}
-			if hl.Revision != state-1 {
-				msg = fmt.Sprintf("HostListCallback: Expected block list revision at this point to be %d, got %d", state-1, hl.Revision)
-				fmt.Println(msg)
-				errCh <- msg
-				return
-			}
-
-		case 4, 5:
-			if len(hl.Blocks) != 2 {
-				msg = fmt.Sprintf("BlocksListCallback: Expected block length at this point to be 2, got %d", len(hl.Blocks))
-				fmt.Println(msg)
-				errCh <- msg
-				return
-			}
-			if hl.Revision != state-1 {
-				msg = fmt.Sprintf("HostListCallback: Expected block list revision at this point to be %d, got %d", state-1, hl.Revision)
-				fmt.Println(msg)
-				errCh <- msg
-				return
-			}
-			if state == 5 {
-				// Here everything is ok!
-				okCh <- "OK"
-			}
-		default:
-			msg = fmt.Sprintf("HostListCallback: Did not expect HostListCallback to be called in state %d", state)
-			errCh <- msg
-
-		}
-
+	defer tearDown(t)
+	blocksCh := make(chan api.IPAMBlocksResponse, 10)
+	err := client.WatchBlocksWithCallback(func(bl api.IPAMBlocksResponse) {
+		blocksCh <- bl
 	})
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	_, err = client.IPAM.AllocateIP("addr1", "host1", "t1", "s1")
-	if err != nil {
-		t.Fatal(err)
-	}
-	_, err = client.IPAM.AllocateIP("addr2", "host1", "t1", "s1")
-	if err != nil {
-		t.Fatal(err)
-	}
-	_, err = client.IPAM.AllocateIP("addr3", "host1", "t1", "s1")
-	if err != nil {
-		t.Fatal(err)
-	}
-	_, err = client.IPAM.AllocateIP("addr4", "host1", "t1", "s1")
-	if err != nil {
-		t.Fatal(err)
+	// Blocks have room for two addresses, so four
+	// addresses take two blocks.
+	for _, name := range []string{"addr1", "addr2", "addr3", "addr4"} {
+		_, err = client.IPAM.AllocateIP(name, "host1", "t1", "s1")
+		if err != nil {
+			t.Fatal(err)
+		}
 	}
+
+	lastRevision := -1
 	for {
 		select {
-		case msg := <-errCh:
-			t.Fatal(msg)
-			break
-		case <-okCh:
-			return
+		case bl := <-blocksCh:
+			if bl.Revision <= lastRevision {
+				t.Fatalf("Expected block list revision to grow, got %d after %d", bl.Revision, lastRevision)
+			}
+			lastRevision = bl.Revision
+			if len(bl.Blocks) == 2 && bl.Blocks[0].AllocatedIPCount == 2 && bl.Blocks[1].AllocatedIPCount == 2 {
+				return
+			}
+			t.Logf("Got block list of length %d and revision %d", len(bl.Blocks), bl.Revision)
+		case <-time.After(watchTimeout):
+			t.Fatal("Expected two blocks with two addresses each")
 		}
 	}
 }
-*/
 
-/*
 func TestConcurrency(t *testing.T) {
-	//	defer tearDown(t)
 	client = initClient(t, "")
+	defer tearDown(t)
 
 	barrier := make(chan int)
 	cnt := 8
@@ -302,38 +217,33 @@ func TestConcurrency(t *testing.T) {
 	}
 	for i := 0; i < cnt; i++ {
 		go func(i int) {
-			fmt.Printf("%d %d Entered\n", i, getGID())
-			defer func(i int) {
-				fmt.Printf("%d %d Unlocking\n", i, getGID())
-				locker.Unlock()
-				fmt.Printf("%d %d Unlocked\n", i, getGID())
-			}(i)
-			fmt.Printf("%d %d Waiting for lock\n", i, getGID())
-			locker.Lock()
-			fmt.Printf("%d %d Got lock\n", i, getGID())
+			defer locker.Unlock()
+			_, err := locker.Lock()
+			if err != nil {
+				t.Error(err)
+			}
 			val := fmt.Sprintf("Hello from %d %d", i, getGID())
-			err := client.Store.Put(fmt.Sprintf("/test%d", i), []byte(val), nil)
+			err = client.Store.PutObject(fmt.Sprintf("/test%d", i), []byte(val))
 			if err != nil {
-				fmt.Println(err)
+				t.Error(err)
 			}
 			barrier <- 1
 		}(i)
 	}
-	finishedCnt := 0
 
-WAIT_FOR_FINISH:
-	for {
+	for finishedCnt := 0; finishedCnt < cnt; finishedCnt++ {
 		select {
 		case <-barrier:
-			finishedCnt++
-			fmt.Printf("%d routines finished\n", finishedCnt)
-			if finishedCnt == cnt {
-				break WAIT_FOR_FINISH
-			}
+		case <-time.After(watchTimeout):
+			t.Fatalf("Expected %d routines to finish, %d finished", cnt, finishedCnt)
+		}
+	}
+	for i := 0; i < cnt; i++ {
+		if ok, _ := client.Store.Exists(fmt.Sprintf("/test%d", i)); !ok {
+			t.Errorf("Expected /test%d to be stored", i)
 		}
 	}
 }
-*/
 
 type testcase struct {
 	name string
@@ -430,3 +340,56 @@ func Test_getTopologyFromIPAMState(t *testing.T) {
 		})
 	}
 }
+
+func TestRoutedBlocks(t *testing.T) {
+	topoConf, err := ioutil.ReadFile("testdata/TestAllocateIPInNetwork.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client = initClient(t, string(topoConf))
+	defer tearDown(t)
+
+	var blocks []api.IPAMBlockResponse
+	for _, cidr := range []string{"10.0.0.0/28", "10.1.0.0/30"} {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		blocks = append(blocks, api.IPAMBlockResponse{CIDR: api.IPNet{IPNet: *ipNet}, Host: "host1"})
+	}
+
+	routed, err := client.RoutedBlocks(blocks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(routed) != 2 {
+		t.Fatalf("Expected all blocks routed without romana VIP network, got %v", routed)
+	}
+
+	err = client.Store.PutObject(RomanaVIPNetworkKey, []byte("vips"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	routed, err = client.RoutedBlocks(blocks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(routed) != 1 || routed[0].CIDR.String() != "10.0.0.0/28" {
+		t.Fatalf("Expected blocks of romana VIP network not routed, got %v", routed)
+	}
+
+	ip, err := client.IPAM.AllocateIPInNetwork("vip1", "vips", "host1", "default", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	latest, err := client.IPAM.LookupLatestAddressByName("vip1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !latest.Equal(ip) {
+		t.Fatalf("Expected latest address %s, got %s", ip, latest)
+	}
+	if _, err = client.IPAM.LookupLatestAddressByName("vip2"); err == nil {
+		t.Fatal("Expected error for address not allocated")
+	}
+}
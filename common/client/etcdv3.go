@@ -0,0 +1,579 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"strings"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/clientv3/concurrency"
+	"github.com/coreos/etcd/mvcc/mvccpb"
+	libkvStore "github.com/docker/libkv/store"
+	log "github.com/romana/rlog"
+)
+
+const (
+	etcdV3DialTimeout     = 5 * time.Second
+	etcdV3RequestTimeout  = 10 * time.Second
+	etcdV3WatchRetryDelay = time.Second
+
+	// etcdV3LockTTL is the TTL of the lease that keeps a lock,
+	// the lock is lost if the lease is not renewed within it.
+	etcdV3LockTTL = 20 * time.Second
+)
+
+// etcdV3 implements libkv Store with etcd v3 API, so that Store
+// behaves the same with either version of etcd API.
+//
+// Revisions take the place of v2 indexes, LastIndex of a KVPair is
+// the ModRevision of the key. Keys are flat in v3, directories are
+// emulated with the keys prefixed by the directory name and "/".
+type etcdV3 struct {
+	client *clientv3.Client
+}
+
+func newEtcdV3(endpoints []string, tlsConfig *tls.Config) (*etcdV3, error) {
+	client, err := clientv3.New(clientv3.Config{
+		Endpoints:   endpoints,
+		DialTimeout: etcdV3DialTimeout,
+		TLS:         tlsConfig,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &etcdV3{client: client}, nil
+}
+
+// dirPrefix returns the prefix of the keys in the directory.
+func dirPrefix(dir string) string {
+	return strings.TrimSuffix(dir, "/") + "/"
+}
+
+// ttlSeconds rounds TTL up to the seconds used by etcd leases.
+func ttlSeconds(ttl time.Duration) int64 {
+	seconds := int64((ttl + time.Second - 1) / time.Second)
+	if seconds < 1 {
+		return 1
+	}
+	return seconds
+}
+
+func kvPair(kv *mvccpb.KeyValue) *libkvStore.KVPair {
+	return &libkvStore.KVPair{
+		Key:       string(kv.Key),
+		Value:     kv.Value,
+		LastIndex: uint64(kv.ModRevision),
+	}
+}
+
+// directChildren returns the keys directly in the directory with
+// the prefix, keys of its subdirectories are skipped.
+func directChildren(prefix string, kvs []*mvccpb.KeyValue) []*libkvStore.KVPair {
+	var pairs []*libkvStore.KVPair
+	for _, kv := range kvs {
+		name := strings.TrimPrefix(string(kv.Key), prefix)
+		if name == "" || strings.Contains(name, "/") {
+			continue
+		}
+		pairs = append(pairs, kvPair(kv))
+	}
+	return pairs
+}
+
+// eventToKVPairExt converts watch event to the v2 style event,
+// Action is one of "create", "set" or "delete".
+func eventToKVPairExt(ev *clientv3.Event) *libkvStore.KVPairExt {
+	pair := &libkvStore.KVPairExt{
+		Key:       string(ev.Kv.Key),
+		LastIndex: uint64(ev.Kv.ModRevision),
+	}
+	switch {
+	case ev.Type == clientv3.EventTypeDelete:
+		pair.Action = "delete"
+	case ev.Kv.Version == 1:
+		pair.Action = "create"
+		pair.Value = string(ev.Kv.Value)
+	default:
+		pair.Action = "set"
+		pair.Value = string(ev.Kv.Value)
+	}
+	if ev.PrevKv != nil {
+		pair.PrevValue = string(ev.PrevKv.Value)
+	}
+	return pair
+}
+
+func (e *etcdV3) get(key string, opts ...clientv3.OpOption) (*clientv3.GetResponse, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), etcdV3RequestTimeout)
+	defer cancel()
+	return e.client.Get(ctx, key, opts...)
+}
+
+// writeOptions returns options of a put, a lease is granted
+// for the keys with TTL.
+func (e *etcdV3) writeOptions(ctx context.Context, options *libkvStore.WriteOptions) ([]clientv3.OpOption, error) {
+	if options == nil || options.TTL <= 0 {
+		return nil, nil
+	}
+	lease, err := e.client.Grant(ctx, ttlSeconds(options.TTL))
+	if err != nil {
+		return nil, err
+	}
+	return []clientv3.OpOption{clientv3.WithLease(lease.ID)}, nil
+}
+
+// Put stores value under the key, directories need not
+// be created with v3 API so IsDir puts are ignored.
+func (e *etcdV3) Put(key string, value []byte, options *libkvStore.WriteOptions) error {
+	if options != nil && options.IsDir {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), etcdV3RequestTimeout)
+	defer cancel()
+	opts, err := e.writeOptions(ctx, options)
+	if err != nil {
+		return err
+	}
+	_, err = e.client.Put(ctx, key, string(value), opts...)
+	return err
+}
+
+func (e *etcdV3) Get(key string) (*libkvStore.KVPair, error) {
+	resp, err := e.get(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Kvs) == 0 {
+		return nil, libkvStore.ErrKeyNotFound
+	}
+	return kvPair(resp.Kvs[0]), nil
+}
+
+func (e *etcdV3) Delete(key string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), etcdV3RequestTimeout)
+	defer cancel()
+	resp, err := e.client.Delete(ctx, key)
+	if err != nil {
+		return err
+	}
+	if resp.Deleted == 0 {
+		return libkvStore.ErrKeyNotFound
+	}
+	return nil
+}
+
+func (e *etcdV3) Exists(key string) (bool, error) {
+	resp, err := e.get(key, clientv3.WithCountOnly())
+	if err != nil {
+		return false, err
+	}
+	return resp.Count > 0, nil
+}
+
+// List returns the keys directly in the directory, like etcd v2 it
+// fails with ErrKeyNotFound if there are none.
+func (e *etcdV3) List(dir string) ([]*libkvStore.KVPair, error) {
+	pairs, _, err := e.list(dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(pairs) == 0 {
+		return nil, libkvStore.ErrKeyNotFound
+	}
+	return pairs, nil
+}
+
+// list returns the keys directly in the directory and
+// the revision they were read at.
+func (e *etcdV3) list(dir string) ([]*libkvStore.KVPair, int64, error) {
+	prefix := dirPrefix(dir)
+	resp, err := e.get(prefix, clientv3.WithPrefix())
+	if err != nil {
+		return nil, 0, err
+	}
+	return directChildren(prefix, resp.Kvs), resp.Header.Revision, nil
+}
+
+func (e *etcdV3) DeleteTree(dir string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), etcdV3RequestTimeout)
+	defer cancel()
+	_, err := e.client.Txn(ctx).Then(
+		clientv3.OpDelete(dir),
+		clientv3.OpDelete(dirPrefix(dir), clientv3.WithPrefix()),
+	).Commit()
+	return err
+}
+
+// AtomicPut stores value under the key if it was not modified since
+// previous was read, or if previous is nil, if the key does not exist.
+func (e *etcdV3) AtomicPut(key string, value []byte, previous *libkvStore.KVPair, options *libkvStore.WriteOptions) (bool, *libkvStore.KVPair, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), etcdV3RequestTimeout)
+	defer cancel()
+
+	cmp := clientv3.Compare(clientv3.CreateRevision(key), "=", 0)
+	if previous != nil {
+		cmp = clientv3.Compare(clientv3.ModRevision(key), "=", int64(previous.LastIndex))
+	}
+
+	opts, err := e.writeOptions(ctx, options)
+	if err != nil {
+		return false, nil, err
+	}
+
+	resp, err := e.client.Txn(ctx).If(cmp).
+		Then(clientv3.OpPut(key, string(value), opts...)).
+		Else(clientv3.OpGet(key)).
+		Commit()
+	if err != nil {
+		return false, nil, err
+	}
+	if !resp.Succeeded {
+		if previous == nil {
+			return false, nil, libkvStore.ErrKeyExists
+		}
+		return false, nil, atomicFailure(resp)
+	}
+
+	return true, &libkvStore.KVPair{Key: key, Value: value, LastIndex: uint64(resp.Header.Revision)}, nil
+}
+
+// AtomicDelete deletes the key if it was not modified since previous was read.
+func (e *etcdV3) AtomicDelete(key string, previous *libkvStore.KVPair) (bool, error) {
+	if previous == nil {
+		return false, libkvStore.ErrPreviousNotSpecified
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), etcdV3RequestTimeout)
+	defer cancel()
+
+	resp, err := e.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.ModRevision(key), "=", int64(previous.LastIndex))).
+		Then(clientv3.OpDelete(key)).
+		Else(clientv3.OpGet(key)).
+		Commit()
+	if err != nil {
+		return false, err
+	}
+	if !resp.Succeeded {
+		return false, atomicFailure(resp)
+	}
+
+	return true, nil
+}
+
+// atomicFailure tells if the key compared by failed transaction
+// was modified or deleted, from the get in its else branch.
+func atomicFailure(resp *clientv3.TxnResponse) error {
+	if len(resp.Responses) > 0 {
+		if rangeResp := resp.Responses[0].GetResponseRange(); rangeResp != nil && len(rangeResp.Kvs) > 0 {
+			return libkvStore.ErrKeyModified
+		}
+	}
+	return libkvStore.ErrKeyNotFound
+}
+
+// watch passes the events of the key, or of the keys with the key as
+// prefix, to handle, starting at revision rev. If the watch fails it
+// is established again from the revision following the last event
+// handled, so that no events are missed. If that revision has been
+// compacted, resync is called to catch up and returns the revision to
+// continue with. Watch returns when stopCh is closed, or when handle
+// or resync return false.
+func (e *etcdV3) watch(key string, prefix bool, rev int64, stopCh <-chan struct{},
+	handle func(*clientv3.Event) bool, resync func() (int64, bool)) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-stopCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	opts := []clientv3.OpOption{clientv3.WithPrevKV()}
+	if prefix {
+		opts = append(opts, clientv3.WithPrefix())
+	}
+
+	for {
+		compacted, ok := e.watchOnce(ctx, key, &rev, opts, handle)
+		if !ok || ctx.Err() != nil {
+			return
+		}
+
+		if compacted {
+			log.Infof("Watch of %s lost events compacted after revision %d", key, rev)
+			rev, ok = resync()
+			if !ok {
+				return
+			}
+			continue
+		}
+
+		log.Infof("Watch of %s was lost, re-establishing from revision %d", key, rev)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(etcdV3WatchRetryDelay):
+		}
+	}
+}
+
+// watchOnce passes events to handle until the watch fails or is
+// canceled, rev is updated to the revision following the last
+// event handled. It returns false if handle did.
+func (e *etcdV3) watchOnce(ctx context.Context, key string, rev *int64, opts []clientv3.OpOption,
+	handle func(*clientv3.Event) bool) (compacted bool, ok bool) {
+
+	watchCtx, cancel := context.WithCancel(clientv3.WithRequireLeader(ctx))
+	defer cancel()
+
+	watchCh := e.client.Watch(watchCtx, key, append(opts, clientv3.WithRev(*rev))...)
+	for resp := range watchCh {
+		if resp.CompactRevision != 0 {
+			return true, true
+		}
+		if err := resp.Err(); err != nil {
+			log.Errorf("Watch of %s failed, %s", key, err)
+			return false, true
+		}
+		for _, ev := range resp.Events {
+			if !handle(ev) {
+				return false, false
+			}
+			*rev = ev.Kv.ModRevision + 1
+		}
+	}
+	return false, true
+}
+
+// Watch sends the value of the key and then its new value each time
+// it changes, deleted key is sent with empty value.
+func (e *etcdV3) Watch(key string, stopCh <-chan struct{}) (<-chan *libkvStore.KVPair, error) {
+	resp, err := e.get(key)
+	if err != nil {
+		return nil, err
+	}
+
+	watchCh := make(chan *libkvStore.KVPair)
+	send := func(pair *libkvStore.KVPair) bool {
+		select {
+		case watchCh <- pair:
+			return true
+		case <-stopCh:
+			return false
+		}
+	}
+
+	go func() {
+		defer close(watchCh)
+
+		if len(resp.Kvs) > 0 && !send(kvPair(resp.Kvs[0])) {
+			return
+		}
+
+		e.watch(key, false, resp.Header.Revision+1, stopCh,
+			func(ev *clientv3.Event) bool {
+				return send(kvPair(ev.Kv))
+			},
+			func() (int64, bool) {
+				resp, err := e.get(key)
+				if err != nil {
+					log.Errorf("Failed to read %s, %s", key, err)
+					return 0, false
+				}
+				if len(resp.Kvs) > 0 && !send(kvPair(resp.Kvs[0])) {
+					return 0, false
+				}
+				return resp.Header.Revision + 1, true
+			},
+		)
+	}()
+
+	return watchCh, nil
+}
+
+// WatchTree sends the keys of the directory and then
+// all of them again each time any changes.
+func (e *etcdV3) WatchTree(dir string, stopCh <-chan struct{}) (<-chan []*libkvStore.KVPair, error) {
+	pairs, rev, err := e.list(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	watchCh := make(chan []*libkvStore.KVPair)
+	send := func(pairs []*libkvStore.KVPair) bool {
+		select {
+		case watchCh <- pairs:
+			return true
+		case <-stopCh:
+			return false
+		}
+	}
+	sendList := func() (int64, bool) {
+		pairs, rev, err := e.list(dir)
+		if err != nil {
+			log.Errorf("Failed to list %s, %s", dir, err)
+			return 0, false
+		}
+		return rev + 1, send(pairs)
+	}
+
+	go func() {
+		defer close(watchCh)
+
+		if !send(pairs) {
+			return
+		}
+
+		e.watch(dirPrefix(dir), true, rev+1, stopCh,
+			func(ev *clientv3.Event) bool {
+				_, ok := sendList()
+				return ok
+			},
+			sendList,
+		)
+	}()
+
+	return watchCh, nil
+}
+
+// WatchExt sends v2 style events of the key, or of the keys in the
+// directory if options.Recursive is set, starting after AfterIndex if
+// it is provided. Initial values are never sent, as with NoList.
+//
+// If events were compacted the channel is closed, as v2 watch would
+// fail when its index is cleared, and the caller is expected to read
+// the keys again.
+func (e *etcdV3) WatchExt(key string, options libkvStore.WatcherOptions, stopCh <-chan struct{}) (<-chan *libkvStore.KVPairExt, error) {
+	rev := int64(options.AfterIndex) + 1
+	if options.AfterIndex == 0 {
+		resp, err := e.get(key, clientv3.WithCountOnly())
+		if err != nil {
+			return nil, err
+		}
+		rev = resp.Header.Revision + 1
+	}
+
+	if options.Recursive {
+		key = dirPrefix(key)
+	}
+
+	watchCh := make(chan *libkvStore.KVPairExt)
+	go func() {
+		defer close(watchCh)
+
+		e.watch(key, options.Recursive, rev, stopCh,
+			func(ev *clientv3.Event) bool {
+				select {
+				case watchCh <- eventToKVPairExt(ev):
+					return true
+				case <-stopCh:
+					return false
+				}
+			},
+			func() (int64, bool) {
+				return 0, false
+			},
+		)
+	}()
+
+	return watchCh, nil
+}
+
+func (e *etcdV3) WatchTreeExt(dir string, stopCh <-chan struct{}) (<-chan *libkvStore.KVPairExt, error) {
+	return e.WatchExt(dir, libkvStore.WatcherOptions{Recursive: true}, stopCh)
+}
+
+// GetExt is not supported since v3 API has no directory nodes,
+// List returns the keys of a directory.
+func (e *etcdV3) GetExt(key string, options libkvStore.GetOptions) (*libkvStore.KVPairExt, error) {
+	return nil, libkvStore.ErrCallNotSupported
+}
+
+// NewLock creates a lock held with a lease, which is
+// kept alive for as long as the lock is held.
+func (e *etcdV3) NewLock(key string, options *libkvStore.LockOptions) (libkvStore.Locker, error) {
+	ttl := etcdV3LockTTL
+	if options != nil && options.TTL > 0 {
+		ttl = options.TTL
+	}
+	return &etcdV3Lock{client: e.client, key: key, ttl: ttl}, nil
+}
+
+func (e *etcdV3) Close() {
+	e.client.Close()
+}
+
+// etcdV3Lock implements libkv Locker with etcd v3 concurrency mutex.
+type etcdV3Lock struct {
+	client *clientv3.Client
+	key    string
+	ttl    time.Duration
+
+	session *concurrency.Session
+	mutex   *concurrency.Mutex
+}
+
+// Lock waits for the lock until it is acquired or stopChan is closed.
+// The returned channel is closed when the lease of the lock expires,
+// e.g. after the connection to etcd was lost for longer than TTL.
+func (l *etcdV3Lock) Lock(stopChan chan struct{}) (<-chan struct{}, error) {
+	session, err := concurrency.NewSession(l.client, concurrency.WithTTL(int(ttlSeconds(l.ttl))))
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-stopChan:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	mutex := concurrency.NewMutex(session, l.key)
+	if err := mutex.Lock(ctx); err != nil {
+		session.Close()
+		return nil, err
+	}
+
+	l.session = session
+	l.mutex = mutex
+	return session.Done(), nil
+}
+
+// Unlock releases the lock and revokes its lease.
+func (l *etcdV3Lock) Unlock() error {
+	if l.mutex == nil {
+		return libkvStore.ErrCannotLock
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), etcdV3RequestTimeout)
+	defer cancel()
+	err := l.mutex.Unlock(ctx)
+	l.session.Close()
+	l.session = nil
+	l.mutex = nil
+	return err
+}
@@ -0,0 +1,107 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package client
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/mvcc/mvccpb"
+	libkvStore "github.com/docker/libkv/store"
+)
+
+func TestDirectChildren(t *testing.T) {
+	kvs := []*mvccpb.KeyValue{
+		{Key: []byte("/romana/ipam/networks/"), ModRevision: 1},
+		{Key: []byte("/romana/ipam/networks/net1"), Value: []byte("1"), ModRevision: 2},
+		{Key: []byte("/romana/ipam/networks/net1/nested"), ModRevision: 3},
+		{Key: []byte("/romana/ipam/networks/net2"), Value: []byte("2"), ModRevision: 4},
+	}
+
+	got := directChildren(dirPrefix("/romana/ipam/networks"), kvs)
+	expected := []*libkvStore.KVPair{
+		{Key: "/romana/ipam/networks/net1", Value: []byte("1"), LastIndex: 2},
+		{Key: "/romana/ipam/networks/net2", Value: []byte("2"), LastIndex: 4},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+}
+
+func TestEventToKVPairExt(t *testing.T) {
+	for _, tc := range []struct {
+		event  clientv3.Event
+		expect libkvStore.KVPairExt
+	}{
+		{
+			event: clientv3.Event{
+				Type: clientv3.EventTypePut,
+				Kv:   &mvccpb.KeyValue{Key: []byte("/a"), Value: []byte("1"), ModRevision: 5, Version: 1},
+			},
+			expect: libkvStore.KVPairExt{Key: "/a", Value: "1", Action: "create", LastIndex: 5},
+		},
+		{
+			event: clientv3.Event{
+				Type:   clientv3.EventTypePut,
+				Kv:     &mvccpb.KeyValue{Key: []byte("/a"), Value: []byte("2"), ModRevision: 6, Version: 2},
+				PrevKv: &mvccpb.KeyValue{Key: []byte("/a"), Value: []byte("1"), ModRevision: 5, Version: 1},
+			},
+			expect: libkvStore.KVPairExt{Key: "/a", Value: "2", PrevValue: "1", Action: "set", LastIndex: 6},
+		},
+		{
+			event: clientv3.Event{
+				Type:   clientv3.EventTypeDelete,
+				Kv:     &mvccpb.KeyValue{Key: []byte("/a"), ModRevision: 7},
+				PrevKv: &mvccpb.KeyValue{Key: []byte("/a"), Value: []byte("2"), ModRevision: 6, Version: 2},
+			},
+			expect: libkvStore.KVPairExt{Key: "/a", PrevValue: "2", Action: "delete", LastIndex: 7},
+		},
+	} {
+		got := eventToKVPairExt(&tc.event)
+		if !reflect.DeepEqual(*got, tc.expect) {
+			t.Errorf("Expected %+v, got %+v", tc.expect, *got)
+		}
+	}
+}
+
+func TestTTLSeconds(t *testing.T) {
+	for ttl, expect := range map[time.Duration]int64{
+		0:                       1,
+		500 * time.Millisecond:  1,
+		time.Second:             1,
+		1500 * time.Millisecond: 2,
+		etcdV3LockTTL:           20,
+	} {
+		if got := ttlSeconds(ttl); got != expect {
+			t.Errorf("Expected %d seconds for %s, got %d", expect, ttl, got)
+		}
+	}
+}
+
+func TestIsMigratedKey(t *testing.T) {
+	for key, expect := range map[string]bool{
+		"/romana/ipam/meta":       true,
+		"/romana/lock/ipam":       false,
+		"/romana/lockout":         true,
+		"/romana/policies/policy": true,
+	} {
+		if got := isMigratedKey("/romana", key); got != expect {
+			t.Errorf("Expected %t for %s, got %t", expect, key, got)
+		}
+	}
+}
@@ -23,6 +23,7 @@ import (
 	"net"
 	"reflect"
 	"regexp"
+	"sort"
 	"strings"
 
 	libkvStore "github.com/docker/libkv/store"
@@ -38,15 +39,26 @@ import (
 )
 
 // This provides an implementation of an IPAM that can allocate
-// blocks of IPs for tenant/segment pair. It assumes IPv4.
+// blocks of IPs for tenant/segment pair. Networks may be IPv4 or
+// IPv6; a tenant may have networks of both families (dual-stack).
 //
 // Address blocks may be taken out more then one pre-configured
 // address range (Networks).
 
 const (
-	msgNoAvailableIP = "No available IP."
-	DefaultAgentPort = 9604
-	DefaultBlockMask = 29
+	msgNoAvailableIP     = "No available IP."
+	DefaultAgentPort     = 9604
+	DefaultBlockMask     = 29
+	DefaultIPv6BlockMask = 120
+
+	// MinIPv6BlockMask is the smallest mask (that is, the largest
+	// block) allowed in IPv6 networks, as addresses in a block are
+	// tracked as 64-bit offsets.
+	MinIPv6BlockMask = 64
+
+	// maxListedAddresses limits the number of addresses returned
+	// by Block.ListAvailableAddresses and Block.ListAllocatedAddresses.
+	maxListedAddresses = 65536
 )
 
 var (
@@ -89,7 +101,9 @@ func parseOwner(owner string) (string, string) {
 }
 
 // CIDR represents a CIDR (net.IPNet, effectively) with some
-// extra functionality for convenience.
+// extra functionality for convenience. StartIPInt and EndIPInt
+// are only meaningful for IPv4 CIDRs; IPv6 CIDRs do not fit into
+// uint64, so for those StartIP and EndIP should be used.
 type CIDR struct {
 	// Represents the IPNet object corresponding to this CIDR.
 	*net.IPNet
@@ -107,9 +121,17 @@ func initCIDR(s string, cidr *CIDR) error {
 	}
 	cidr.IPNet = ipNet
 	if ip != nil {
+		ones, bits := ipNet.Mask.Size()
+		if bits == 8*net.IPv6len {
+			cidr.StartIP = ip
+			start := common.IPToBigInt(ip)
+			end := new(big.Int).Lsh(big.NewInt(1), uint(bits-ones))
+			end.Add(end, start).Sub(end, big.NewInt(1))
+			cidr.EndIP = common.BigIntToIP(end, true)
+			return nil
+		}
 		cidr.StartIP = ip
 		cidr.StartIPInt = common.IPv4ToInt(ip)
-		ones, bits := ipNet.Mask.Size()
 		ipCount := 1 << uint(bits-ones)
 		cidr.EndIPInt = cidr.StartIPInt + uint64(ipCount) - 1
 		cidr.EndIP = common.IntToIPv4(cidr.EndIPInt)
@@ -124,9 +146,40 @@ func NewCIDR(s string) (CIDR, error) {
 	return *cidr, err
 }
 
+// IsIPv6 returns true if this is an IPv6 CIDR.
+func (c CIDR) IsIPv6() bool {
+	return c.IPNet != nil && c.IPNet.IP.To4() == nil
+}
+
+// bits returns the total number of bits in an address of
+// this CIDR's family (32 or 128).
+func (c CIDR) bits() uint {
+	if c.IsIPv6() {
+		return 8 * net.IPv6len
+	}
+	return 8 * net.IPv4len
+}
+
+// startBigInt returns the first address of the CIDR as a big.Int.
+func (c CIDR) startBigInt() *big.Int {
+	return common.IPToBigInt(c.StartIP)
+}
+
+// endBigInt returns the last address of the CIDR as a big.Int.
+func (c CIDR) endBigInt() *big.Int {
+	return common.IPToBigInt(c.EndIP)
+}
+
 // Contains returns true if this CIDR fully contains (is equivalent to or a superset
-// of) the provided CIDR.
+// of) the provided CIDR. CIDRs of different address families never
+// contain each other.
 func (c CIDR) Contains(c2 CIDR) bool {
+	if c.IsIPv6() != c2.IsIPv6() {
+		return false
+	}
+	if c.IsIPv6() {
+		return c.startBigInt().Cmp(c2.startBigInt()) <= 0 && c.endBigInt().Cmp(c2.endBigInt()) >= 0
+	}
 	log.Tracef(trace.Private, "%d<=%d && %d>=%d: %t", c.StartIPInt,
 		c2.StartIPInt, c.EndIPInt,
 		c2.EndIPInt,
@@ -135,6 +188,13 @@ func (c CIDR) Contains(c2 CIDR) bool {
 }
 
 func (c CIDR) ContainsIP(ip net.IP) bool {
+	if c.IsIPv6() != (ip.To4() == nil) {
+		return false
+	}
+	if c.IsIPv6() {
+		ipInt := common.IPToBigInt(ip)
+		return c.startBigInt().Cmp(ipInt) <= 0 && c.endBigInt().Cmp(ipInt) >= 0
+	}
 	ipInt := common.IPv4ToInt(ip)
 	log.Tracef(trace.Private, "%d<=%d && %d>=%d: %t", c.StartIPInt,
 		ipInt, c.EndIPInt,
@@ -178,6 +238,7 @@ func (cidr *CIDR) UnmarshalText(data []byte) error {
 type Host struct {
 	Name      string                 `json:"name"`
 	IP        net.IP                 `json:"ip"`
+	IPs       []net.IP               `json:"ips,omitempty"`
 	AgentPort uint                   `json:"agent_port"`
 	Tags      map[string]string      `json:"tags"`
 	K8SInfo   map[string]interface{} `json:"k8s_info"`
@@ -392,28 +453,14 @@ func (hg *Group) allocateSpecificIP(ip net.IP, network *Network, hostName string
 	log.Tracef(trace.Inside, "Network %s has no blocks to reuse for <%s>, creating new block", network.Name, owner)
 
 	for {
-		var newBlockStartIPInt uint64
-		if len(hg.Blocks) > 0 {
-			lastBlock := hg.Blocks[len(hg.Blocks)-1]
-			newBlockStartIPInt = lastBlock.CIDR.EndIPInt + 1
-		} else {
-			newBlockStartIPInt = hg.CIDR.StartIPInt
-		}
-		if newBlockStartIPInt > hg.CIDR.EndIPInt {
-			return fmt.Errorf("No more blocks can be allocated in %s", network.Name)
-		}
-
-		newBlockEndIPInt := newBlockStartIPInt + (1 << (32 - network.BlockMask)) - 1
-		if newBlockEndIPInt > network.CIDR.EndIPInt {
-			return fmt.Errorf("No more blocks can be allocated in %s", network.Name)
-		}
-
-		newBlockCIDRStr := fmt.Sprintf("%s/%d", common.IntToIPv4(newBlockStartIPInt), network.BlockMask)
-		newBlockCIDR, err := NewCIDR(newBlockCIDRStr)
+		newBlockCIDR, err := hg.nextBlockCIDR(network)
 		if err != nil {
 			return err
 		}
-		newBlock := newBlock(newBlockCIDR)
+		if newBlockCIDR == nil {
+			return fmt.Errorf("No more blocks can be allocated in %s", network.Name)
+		}
+		newBlock := newBlock(*newBlockCIDR)
 		hg.Blocks = append(hg.Blocks, newBlock)
 		newBlockID := len(hg.Blocks) - 1
 		if newBlock.CIDR.ContainsIP(ip) {
@@ -433,6 +480,35 @@ func (hg *Group) allocateSpecificIP(ip net.IP, network *Network, hostName string
 	}
 }
 
+// nextBlockCIDR calculates the CIDR of the block that would follow the
+// last block of this group. It returns nil if no more blocks of the
+// network's block size fit in the group.
+func (hg *Group) nextBlockCIDR(network *Network) (*CIDR, error) {
+	var start *big.Int
+	if len(hg.Blocks) > 0 {
+		lastBlock := hg.Blocks[len(hg.Blocks)-1]
+		start = new(big.Int).Add(lastBlock.CIDR.endBigInt(), big.NewInt(1))
+	} else {
+		start = hg.CIDR.startBigInt()
+	}
+	if start.Cmp(hg.CIDR.endBigInt()) > 0 {
+		return nil, nil
+	}
+
+	end := new(big.Int).Lsh(big.NewInt(1), network.CIDR.bits()-network.BlockMask)
+	end.Add(end, start).Sub(end, big.NewInt(1))
+	if end.Cmp(network.CIDR.endBigInt()) > 0 {
+		return nil, nil
+	}
+
+	cidrStr := fmt.Sprintf("%s/%d", common.BigIntToIP(start, network.CIDR.IsIPv6()), network.BlockMask)
+	cidr, err := NewCIDR(cidrStr)
+	if err != nil {
+		return nil, err
+	}
+	return &cidr, nil
+}
+
 func (hg *Group) allocateIP(network *Network, hostName string, owner string) net.IP {
 	ownedBlockIDs := hg.OwnerToBlocks[owner]
 	var ip net.IP
@@ -470,41 +546,25 @@ func (hg *Group) allocateIP(network *Network, hostName string, owner string) net
 	log.Tracef(trace.Inside, "Network %s has no blocks to reuse for <%s>, creating new block", network.Name, owner)
 
 	for {
-		var newBlockStartIPInt uint64
-		if len(hg.Blocks) > 0 {
-			lastBlock := hg.Blocks[len(hg.Blocks)-1]
-			newBlockStartIPInt = lastBlock.CIDR.EndIPInt + 1
-		} else {
-			newBlockStartIPInt = hg.CIDR.StartIPInt
-		}
-		if newBlockStartIPInt > hg.CIDR.EndIPInt {
-			// Cannot allocate any more blocks for this network, move on to another.
-			log.Tracef(trace.Inside, "Cannot allocate any more blocks from network %s", hg.CIDR)
+		newBlockCIDR, err := hg.nextBlockCIDR(network)
+		if err != nil {
+			// This should not really happen...
+			log.Errorf("Error occurred allocating IP for %s in network %s: %s", owner, hg.CIDR, err)
 			return nil
 		}
-
-		newBlockEndIPInt := newBlockStartIPInt + (1 << (32 - network.BlockMask)) - 1
-		if newBlockEndIPInt > network.CIDR.EndIPInt {
+		if newBlockCIDR == nil {
 			// Cannot allocate any more blocks for this network, move on to another.
 			// TODO: Or should we allocate as much as possible?
 			log.Tracef(trace.Inside, "Cannot allocate any more blocks from network %s", hg.CIDR)
 			return nil
 		}
-
-		newBlockCIDRStr := fmt.Sprintf("%s/%d", common.IntToIPv4(newBlockStartIPInt), network.BlockMask)
-		newBlockCIDR, err := NewCIDR(newBlockCIDRStr)
-		if err != nil {
-			// This should not really happen...
-			log.Errorf("Error occurred allocating IP for %s in network %s: %s", owner, hg.CIDR, err)
-			return nil
-		}
-		newBlock := newBlock(newBlockCIDR)
+		newBlock := newBlock(*newBlockCIDR)
 		hg.Blocks = append(hg.Blocks, newBlock)
 		newBlockID := len(hg.Blocks) - 1
 		hg.OwnerToBlocks[owner] = append(hg.OwnerToBlocks[owner], newBlockID)
 		hg.BlockToOwner[newBlockID] = owner
 		hg.BlockToHost[newBlockID] = hostName
-		log.Tracef(trace.Inside, "New block created in %s for owner %s and host %s: %s", hg.CIDR, owner, hostName, newBlockCIDR)
+		log.Tracef(trace.Inside, "New block created in %s for owner %s and host %s: %s", hg.CIDR, owner, hostName, *newBlockCIDR)
 		log.Tracef(trace.Inside, "Group %s BlockToOwner: %v, BlockToHost: %v", hg.CIDR, hg.BlockToOwner, hg.BlockToHost)
 		ip := newBlock.allocateIP(network)
 		if ip == nil {
@@ -518,27 +578,39 @@ func (hg *Group) allocateIP(network *Network, hostName string, owner string) net
 }
 
 func (hg *Group) findIPInfo(ip net.IP) (string, string) {
-	log.Tracef(trace.Inside, "group.findIPInfo(): Looking for %s in %s (%s)", ip, hg.Name, hg.CIDR)
+	group, blockID := hg.findIPBlock(ip)
+	if group == nil {
+		return "", ""
+	}
+	log.Tracef(trace.Inside, "BTW %v %v", group.BlockToHost[blockID], group.BlockToOwner[blockID])
+	return group.BlockToHost[blockID], group.BlockToOwner[blockID]
+}
+
+// findIPBlock returns the group that has a block containing
+// the provided IP and ID of that block in the group, or nil
+// if IP is not in any block. Nil groups, blocks and CIDRs are
+// skipped, so that it can be used on IPAM being verified.
+func (hg *Group) findIPBlock(ip net.IP) (*Group, int) {
+	if hg == nil {
+		return nil, 0
+	}
+	log.Tracef(trace.Inside, "group.findIPBlock(): Looking for %s in %s (%s)", ip, hg.Name, hg.CIDR)
 	if hg.Hosts != nil {
-		log.Tracef(trace.Inside, "group.findIPInfo(): Looking for %s in %d blocks", ip, len(hg.Blocks))
-		var block *Block
-		var blockID int
-		for blockID, block = range hg.Blocks {
-			if block.CIDR.IPNet.Contains(ip) {
-				log.Tracef(trace.Inside, "group.findIPInfo(): Found %s in %s: %d", ip, block.CIDR, blockID)
-				log.Tracef(trace.Inside, "BTW %v %v", hg.BlockToHost[blockID], hg.BlockToOwner[blockID])
-				return hg.BlockToHost[blockID], hg.BlockToOwner[blockID]
+		log.Tracef(trace.Inside, "group.findIPBlock(): Looking for %s in %d blocks", ip, len(hg.Blocks))
+		for blockID, block := range hg.Blocks {
+			if block != nil && block.CIDR.IPNet != nil && block.CIDR.IPNet.Contains(ip) {
+				log.Tracef(trace.Inside, "group.findIPBlock(): Found %s in %s: %d", ip, block.CIDR, blockID)
+				return hg, blockID
 			}
 		}
-		return "", ""
-	} else {
-		for _, group := range hg.Groups {
-			if group.CIDR.IPNet.Contains(ip) {
-				return group.findIPInfo(ip)
-			}
+		return nil, 0
+	}
+	for _, group := range hg.Groups {
+		if group != nil && group.CIDR.IPNet != nil && group.CIDR.IPNet.Contains(ip) {
+			return group.findIPBlock(ip)
 		}
 	}
-	return "", ""
+	return nil, 0
 }
 
 func (hg *Group) deallocateIP(ip net.IP) error {
@@ -637,10 +709,7 @@ func (hg *Group) GetBlocks() []api.IPAMBlockResponse {
 		for blockID, block := range hg.Blocks {
 			owner := hg.BlockToOwner[blockID]
 			tenant, segment := parseOwner(owner)
-			count := 0
-			if block.ListAllocatedAddresses() != nil {
-				count = len(block.ListAllocatedAddresses())
-			}
+			count := block.allocatedCount()
 			br := api.IPAMBlockResponse{
 				CIDR:             api.IPNet{IPNet: *block.CIDR.IPNet},
 				Host:             hg.BlockToHost[blockID],
@@ -749,9 +818,9 @@ func (hg *Group) bitsForGroupElements(numGroups int, cidr CIDR) int {
 // group.
 func (hg *Group) cidrForCurrentGroup(groupIndex int, bitsPerElement int, cidr CIDR) (CIDR, error) {
 	// Calculate CIDR for the current group
-	incr := uint64(groupIndex << uint(bitsPerElement))
-	elementCIDRIP := common.IntToIPv4(cidr.StartIPInt + incr)
-	elementCIDRString := fmt.Sprintf("%s/%d", elementCIDRIP, (32 - bitsPerElement))
+	incr := new(big.Int).Lsh(big.NewInt(int64(groupIndex)), uint(bitsPerElement))
+	elementCIDRIP := common.BigIntToIP(incr.Add(incr, cidr.startBigInt()), cidr.IsIPv6())
+	elementCIDRString := fmt.Sprintf("%s/%d", elementCIDRIP, (int(cidr.bits()) - bitsPerElement))
 	log.Tracef(trace.Inside, "CIDR String for %s %d: %s", elementCIDRIP, bitsPerElement, elementCIDRString)
 	elementCidr, err := NewCIDR(elementCIDRString)
 	if err != nil {
@@ -902,37 +971,77 @@ func (b *Block) clear() {
 	b.Pool.Clear()
 }
 
-// newBlock creates a new Block on the given host.
+// newBlock creates a new Block on the given host. For IPv4 blocks,
+// IDs in the pool are the addresses themselves; for IPv6 blocks they
+// are offsets from the start of the block, which allows blocks as
+// large as /64.
 func newBlock(cidr CIDR) *Block {
-	eb := &Block{CIDR: cidr,
-		Pool: idring.NewIDRing(cidr.StartIPInt, cidr.EndIPInt, nil),
+	eb := &Block{CIDR: cidr}
+	if cidr.IsIPv6() {
+		size := new(big.Int).Sub(cidr.endBigInt(), cidr.startBigInt())
+		eb.Pool = idring.NewIDRing(0, size.Uint64(), nil)
+	} else {
+		eb.Pool = idring.NewIDRing(cidr.StartIPInt, cidr.EndIPInt, nil)
 	}
 	return eb
 }
 
-// ListAvailableAddresses lists all available adresses in the block
-func (b Block) ListAvailableAddresses() []string {
+// ipToID converts an IP in the block to an ID in the block's pool.
+func (b Block) ipToID(ip net.IP) uint64 {
+	if !b.CIDR.IsIPv6() {
+		return common.IPv4ToInt(ip)
+	}
+	return new(big.Int).Sub(common.IPToBigInt(ip), b.CIDR.startBigInt()).Uint64()
+}
+
+// idToIP converts an ID from the block's pool to an IP.
+func (b Block) idToIP(id uint64) net.IP {
+	if !b.CIDR.IsIPv6() {
+		return common.IntToIPv4(id)
+	}
+	ipInt := new(big.Int).SetUint64(id)
+	return common.BigIntToIP(ipInt.Add(ipInt, b.CIDR.startBigInt()), true)
+}
+
+// listRanges lists IPs in the provided ranges of the block's pool,
+// up to maxListedAddresses of them.
+func (b Block) listRanges(ranges []idring.Range) []string {
 	retval := make([]string, 0)
-	for _, r := range b.Pool.Ranges {
-		for i := r.Min; i <= r.Max; i++ {
-			ip := common.IntToIPv4(i)
-			retval = append(retval, ip.String())
+	for _, r := range ranges {
+		for i := r.Min; ; i++ {
+			if len(retval) >= maxListedAddresses {
+				return retval
+			}
+			retval = append(retval, b.idToIP(i).String())
+			if i == r.Max {
+				break
+			}
 		}
 	}
 	return retval
 }
 
+// ListAvailableAddresses lists all available adresses in the block
+// (up to maxListedAddresses, as IPv6 blocks can be huge).
+func (b Block) ListAvailableAddresses() []string {
+	return b.listRanges(b.Pool.Ranges)
+}
+
 // ListAllocatedAddresses lists all allocated adresses in the block
+// (up to maxListedAddresses).
 func (b Block) ListAllocatedAddresses() []string {
 	allocated := b.Pool.Invert()
-	retval := make([]string, 0)
+	return b.listRanges(allocated.Ranges)
+}
+
+// allocatedCount returns the number of allocated addresses in the block.
+func (b Block) allocatedCount() int {
+	allocated := b.Pool.Invert()
+	count := 0
 	for _, r := range allocated.Ranges {
-		for i := r.Min; i <= r.Max; i++ {
-			ip := common.IntToIPv4(i)
-			retval = append(retval, ip.String())
-		}
+		count += int(r.Max-r.Min) + 1
 	}
-	return retval
+	return count
 }
 
 // hasIPInCIDR checks whether it has any allocated IPs that
@@ -940,7 +1049,7 @@ func (b Block) ListAllocatedAddresses() []string {
 func (b Block) hasIPInCIDR(cidr CIDR) bool {
 	allocated := b.Pool.Invert()
 	for _, r := range allocated.Ranges {
-		if r.Min >= cidr.StartIPInt && r.Max <= cidr.EndIPInt {
+		if cidr.ContainsIP(b.idToIP(r.Min)) && cidr.ContainsIP(b.idToIP(r.Max)) {
 			return true
 		}
 	}
@@ -958,7 +1067,7 @@ func (b *Block) allocateSpecificIP(ip net.IP, network *Network) error {
 	if blackedOutBy != nil {
 		return fmt.Errorf("Cannot allocate %s: blacked out by %s", ip, blackedOutBy)
 	}
-	id := common.IPv4ToInt(ip)
+	id := b.ipToID(ip)
 	err = b.Pool.GetSpecificID(id)
 	if err != nil {
 		log.Errorf("Cannot allocate IP %s in block %s: %s", ip, b.CIDR, err)
@@ -974,7 +1083,7 @@ func (b *Block) allocateIP(network *Network) net.IP {
 	for {
 		ipInt, err := b.Pool.GetID()
 		if err == nil {
-			ip = common.IntToIPv4(ipInt)
+			ip = b.idToIP(ipInt)
 			blackedOutBy := network.blackedOutBy(ip)
 			if blackedOutBy == nil {
 				break
@@ -1008,8 +1117,7 @@ func (b *Block) deallocateIP(ip net.IP) error {
 	if !b.CIDR.IPNet.Contains(ip) {
 		return common.NewError("Block.deallocateIP: IP %s not in this block %s", ip, b.CIDR)
 	}
-	ipInt := common.IPv4ToInt(ip)
-	err := b.Pool.ReclaimID(ipInt)
+	err := b.Pool.ReclaimID(b.ipToID(ip))
 	if err != nil {
 		return err
 	}
@@ -1175,23 +1283,24 @@ type IPAM struct {
 
 	// Map of address name to IP
 	AddressNameToIP map[string]net.IP `json:"address_name_to_ip"`
-	load            Loader
-	save            Saver
-	locker          Locker
+	// Map of address name to IP of the other address family, for
+	// names allocated by AllocateIPs on dual-stack networks.
+	AddressNameToSecondaryIP map[string]net.IP `json:"address_name_to_secondary_ip,omitempty"`
+	load                     Loader
+	save                     Saver
+	locker                   Locker
+	// hostLocker, if set, provides lockers for allocations on a host
+	// to be used instead of locker; the Saver then has to detect
+	// conflicting allocations (see ipamConflictError).
+	hostLocker func(host string) Locker
 
 	TenantToNetwork map[string][]string `json:"tenant_to_network"`
 
 	//	OwnerToIP map[string][]string
 	//	IPToOwner map[string]string
-	prevKVPair *libkvStore.KVPair
-}
 
-func (ipam *IPAM) GetPrevKVPair() *libkvStore.KVPair {
-	return ipam.prevKVPair
-}
-
-func (ipam *IPAM) SetPrevKVPair(kvp *libkvStore.KVPair) {
-	ipam.prevKVPair = kvp
+	// shards are the keys IPAM was loaded from, see marshalShards.
+	shards map[string]*libkvStore.KVPair
 }
 
 // injectParents is intended to add references to parent objects where appropriate
@@ -1209,6 +1318,7 @@ func (ipam *IPAM) injectParents() {
 func (ipam *IPAM) clearIPAM() {
 	ipam.Networks = make(map[string]*Network)
 	ipam.AddressNameToIP = make(map[string]net.IP)
+	ipam.AddressNameToSecondaryIP = make(map[string]net.IP)
 	ipam.TenantToNetwork = make(map[string][]string)
 }
 
@@ -1221,6 +1331,7 @@ func (ipam *IPAM) ListHosts() api.HostList {
 			}
 			list = append(list, api.Host{
 				IP:        host.IP,
+				IPs:       host.IPs,
 				Name:      host.Name,
 				AgentPort: host.AgentPort,
 			})
@@ -1244,6 +1355,7 @@ func (ipam *IPAM) GetGroupsForNetwork(netName string) *Group {
 
 // allocateSpecificIP tries to allocate a specific IP. If the specific IP cannot be
 // allocated in the given host/tenant/segment combination, an error is returned.
+// The caller is responsible for recording the IP under the address name.
 func (ipam *IPAM) allocateSpecificIP(addressName string, ip net.IP, host string, tenant string, segment string) error {
 	// Find eligible networks for the specified tenant
 	var err error
@@ -1257,12 +1369,7 @@ func (ipam *IPAM) allocateSpecificIP(addressName string, ip net.IP, host string,
 	owner := makeOwner(tenant, segment)
 	for _, network := range networksForTenant {
 		if network.CIDR.ContainsIP(ip) {
-			err = network.allocateSpecificIP(ip, host, owner)
-			if err != nil {
-				return err
-			}
-			ipam.AddressNameToIP[addressName] = ip
-			return nil
+			return network.allocateSpecificIP(ip, host, owner)
 		}
 	}
 	return fmt.Errorf("No suitable network found to allocate %s", msg)
@@ -1277,40 +1384,191 @@ func (ipam *IPAM) allocateSpecificIP(addressName string, ip net.IP, host string,
 // network is exhausted.
 func (ipam *IPAM) AllocateIP(addressName string, host string, tenant string, segment string) (net.IP, error) {
 	log.Tracef(trace.Inside, "Entering IPAM.AllocateIP()")
-	ch, err := ipam.locker.Lock()
+	var ip net.IP
+	err := ipam.updateAllocations(host, func(latestIPAM *IPAM) error {
+		err := latestIPAM.checkAddressNameFree(addressName)
+		if err != nil {
+			return err
+		}
+
+		// Find eligible networks for the specified tenant
+		networksForTenant, err := latestIPAM.getNetworksForTenant(tenant)
+		if err != nil {
+			return err
+		}
+
+		ip, err = latestIPAM.allocateIPInNetworks(networksForTenant, host, makeOwner(tenant, segment))
+		if err != nil {
+			return err
+		}
+		if ip == nil {
+			return common.NewError(msgNoAvailableIP)
+		}
+
+		latestIPAM.AddressNameToIP[addressName] = ip
+		return nil
+	})
 	if err != nil {
-		log.Error("IPAM.AllocateIP: error acquiring a lock")
 		return nil, err
 	}
-	//	log.Tracef(trace.Inside, "IPAM.AllocateIP: got a lock")
-	defer ipam.locker.Unlock()
+	return ip, nil
+}
 
-	latestIPAM := &IPAM{}
-	err = ipam.load(latestIPAM, ch)
+// AllocateIPs allocates one IP per address family (IPv4 first, then
+// IPv6) for the provided tenant and segment, and associates all of
+// them with the provided name, which can afterwards be used for
+// deallocation. Only address families for which the tenant has eligible
+// networks are considered. If an address cannot be allocated in any
+// of these families, nothing is allocated and an error is returned.
+func (ipam *IPAM) AllocateIPs(addressName string, host string, tenant string, segment string) ([]net.IP, error) {
+	log.Tracef(trace.Inside, "Entering IPAM.AllocateIPs()")
+	var ips []net.IP
+	err := ipam.updateAllocations(host, func(latestIPAM *IPAM) error {
+		err := latestIPAM.checkAddressNameFree(addressName)
+		if err != nil {
+			return err
+		}
+
+		networksForTenant, err := latestIPAM.getNetworksForTenant(tenant)
+		if err != nil {
+			return err
+		}
+		ipv4Networks := make([]*Network, 0)
+		ipv6Networks := make([]*Network, 0)
+		for _, network := range networksForTenant {
+			if network.CIDR.IsIPv6() {
+				ipv6Networks = append(ipv6Networks, network)
+			} else {
+				ipv4Networks = append(ipv4Networks, network)
+			}
+		}
+
+		owner := makeOwner(tenant, segment)
+		ips = make([]net.IP, 0)
+		for _, networks := range [][]*Network{ipv4Networks, ipv6Networks} {
+			if len(networks) == 0 {
+				continue
+			}
+			ip, err := latestIPAM.allocateIPInNetworks(networks, host, owner)
+			if err != nil {
+				return err
+			}
+			if ip == nil {
+				return common.NewError(msgNoAvailableIP)
+			}
+			ips = append(ips, ip)
+		}
+
+		latestIPAM.AddressNameToIP[addressName] = ips[0]
+		if len(ips) > 1 {
+			if latestIPAM.AddressNameToSecondaryIP == nil {
+				latestIPAM.AddressNameToSecondaryIP = make(map[string]net.IP)
+			}
+			latestIPAM.AddressNameToSecondaryIP[addressName] = ips[1]
+		}
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
+	return ips, nil
+}
 
-	if addr, ok := latestIPAM.AddressNameToIP[addressName]; ok {
-		err := errors.NewRomanaExistsErrorWithMessage(
-			fmt.Sprintf("Address with name %s already allocated: %s", addressName, addr),
-			fmt.Sprintf("Address: %s", addressName),
-			"IP",
-			fmt.Sprintf("name=%s", addressName),
-			fmt.Sprintf("IP=%s", addr))
+// AllocateIPInNetwork allocates an IP on the provided host from the
+// network with the provided name, regardless of which tenants the
+// network is intended for, and associates the provided name with it.
+// This is used for dedicated pools, such as the one for romana VIPs,
+// which should not be handed out to pods.
+func (ipam *IPAM) AllocateIPInNetwork(addressName string, netName string, host string, tenant string, segment string) (net.IP, error) {
+	log.Tracef(trace.Inside, "Entering IPAM.AllocateIPInNetwork()")
+	var ip net.IP
+	err := ipam.updateAllocations(host, func(latestIPAM *IPAM) error {
+		err := latestIPAM.checkAddressNameFree(addressName)
+		if err != nil {
+			return err
+		}
 
-		return nil, err
+		network, ok := latestIPAM.Networks[netName]
+		if !ok {
+			return errors.NewRomanaNotFoundError("", "network", fmt.Sprintf("name=%s", netName))
+		}
 
-	}
+		ip, err = network.allocateIP(host, makeOwner(tenant, segment))
+		if err != nil {
+			return err
+		}
+		if ip == nil {
+			return common.NewError(msgNoAvailableIP)
+		}
 
-	// Find eligible networks for the specified tenant
-	networksForTenant, err := latestIPAM.getNetworksForTenant(tenant)
+		latestIPAM.AddressNameToIP[addressName] = ip
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
+	return ip, nil
+}
 
-	owner := makeOwner(tenant, segment)
-	for _, network := range networksForTenant {
+// updateAllocations applies update to the latest state of IPAM and
+// saves it. Allocations on the host are serialized by the locker
+// returned by hostLocker, or by the IPAM locker if there is none.
+// If saving conflicts with allocations made concurrently elsewhere,
+// update is applied again to the reloaded state.
+func (ipam *IPAM) updateAllocations(host string, update func(latestIPAM *IPAM) error) error {
+	locker := ipam.locker
+	if ipam.hostLocker != nil {
+		locker = ipam.hostLocker(host)
+	}
+	ch, err := locker.Lock()
+	if err != nil {
+		log.Errorf("IPAM: error acquiring a lock for allocations on host %s", host)
+		return err
+	}
+	defer locker.Unlock()
+
+	for attempt := 1; ; attempt++ {
+		latestIPAM := &IPAM{}
+		latestIPAM.clearIPAM()
+		err = ipam.load(latestIPAM, ch)
+		if err != nil {
+			return err
+		}
+
+		err = update(latestIPAM)
+		if err != nil {
+			return err
+		}
+
+		latestIPAM.AllocationRevision++
+		log.Tracef(trace.Inside, "Updated AllocationRevision to %d", latestIPAM.AllocationRevision)
+		err = ipam.save(latestIPAM, ch)
+		if _, ok := err.(ipamConflictError); !ok || attempt == maxIPAMConflicts {
+			return err
+		}
+		log.Debugf("IPAM: attempt %d to update allocations on host %s failed: %s, retrying", attempt, host, err)
+	}
+}
+
+// checkAddressNameFree returns a RomanaExistsError if an address
+// with the provided name is already allocated.
+func (ipam *IPAM) checkAddressNameFree(addressName string) error {
+	if addr, ok := ipam.AddressNameToIP[addressName]; ok {
+		return errors.NewRomanaExistsErrorWithMessage(
+			fmt.Sprintf("Address with name %s already allocated: %s", addressName, addr),
+			fmt.Sprintf("Address: %s", addressName),
+			"IP",
+			fmt.Sprintf("name=%s", addressName),
+			fmt.Sprintf("IP=%s", addr))
+	}
+	return nil
+}
+
+// allocateIPInNetworks tries to allocate an IP on the provided host
+// in the provided networks, in order. It returns nil IP if all
+// networks are exhausted.
+func (ipam *IPAM) allocateIPInNetworks(networks []*Network, host string, owner string) (net.IP, error) {
+	for _, network := range networks {
 		log.Tracef(trace.Inside, "Trying to allocate IP for host %s on network %s.", host, network.Name)
 		ip, err := network.allocateIP(host, owner)
 		if err != nil {
@@ -1331,80 +1589,68 @@ func (ipam *IPAM) AllocateIP(addressName string, host string, tenant string, seg
 		}
 
 		if ip != nil {
-			latestIPAM.AddressNameToIP[addressName] = ip
-			latestIPAM.AllocationRevision++
-			log.Tracef(trace.Inside, "Updated AllocationRevision to %d", latestIPAM.AllocationRevision)
-			err = ipam.save(latestIPAM, ch)
-			if err != nil {
-				return nil, err
-			}
 			return ip, nil
 		}
 	}
-	return nil, common.NewError(msgNoAvailableIP)
+	return nil, nil
 }
 
 // DeallocateIP will deallocate the provided IP (returning an
-// error if it never was allocated in the first place).
+// error if it never was allocated in the first place). The provided
+// address name may also be one of the IPs allocated; in either case
+// all IPs allocated under that name are deallocated.
 func (ipam *IPAM) DeallocateIP(addressName string) error {
-	ch, err := ipam.locker.Lock()
-	if err != nil {
-		return err
-	}
-	defer ipam.locker.Unlock()
-
-	latestIPAM := &IPAM{}
-	latestIPAM.clearIPAM()
-	err = ipam.load(latestIPAM, ch)
-	if err != nil {
-		return err
-	}
-
-	if ip, ok := latestIPAM.AddressNameToIP[addressName]; ok {
-		log.Tracef(trace.Inside, "IPAM.DeallocateIP: Request to deallocate %s: %s", addressName, ip)
-		for _, network := range latestIPAM.Networks {
-			if network.CIDR.IPNet.Contains(ip) {
-				log.Tracef(trace.Inside, "IPAM.DeallocateIP: IP %s belongs to network %s", ip, network.Name)
-				err := network.deallocateIP(ip)
-				if err == nil {
-					delete(latestIPAM.AddressNameToIP, addressName)
-					latestIPAM.AllocationRevision++
-					err = ipam.save(latestIPAM, ch)
-					if err != nil {
-						return err
+	// Host of the address is not known until IPAM is loaded,
+	// so deallocations share the locker of an unnamed host.
+	return ipam.updateAllocations("", func(latestIPAM *IPAM) error {
+		name := addressName
+		if _, ok := latestIPAM.AddressNameToIP[name]; !ok {
+			// find by IPAddress instead of name, so that all
+			// platforms are supported.
+			found := false
+			for _, addressMap := range []map[string]net.IP{latestIPAM.AddressNameToIP, latestIPAM.AddressNameToSecondaryIP} {
+				for n, ip := range addressMap {
+					if ip.String() == name {
+						name = n
+						found = true
+						break
 					}
 				}
-				return err
+				if found {
+					break
+				}
+			}
+			if !found {
+				return errors.NewRomanaNotFoundError("", "address", fmt.Sprintf("name=%s", name))
 			}
 		}
-		return errors.NewRomanaNotFoundError("", "IP", fmt.Sprintf("IP=%s", ip))
-	}
-	// find by IPAddress instead of name, so that all
-	// platforms are supported.
-	for name, ip := range latestIPAM.AddressNameToIP {
-		if ip.String() == addressName {
-			for _, network := range latestIPAM.Networks {
-				if network.CIDR.IPNet.Contains(ip) {
-					log.Tracef(trace.Inside,
-						"IPAM.DeallocateIP: IP %s belongs to network %s",
-						ip, network.Name)
-					err := network.deallocateIP(ip)
-					if err == nil {
-						delete(latestIPAM.AddressNameToIP, name)
-						latestIPAM.AllocationRevision++
-						err = ipam.save(latestIPAM, ch)
-						if err != nil {
-							return err
-						}
-					}
-					return err
-				}
+
+		ips := []net.IP{latestIPAM.AddressNameToIP[name]}
+		if ip, ok := latestIPAM.AddressNameToSecondaryIP[name]; ok {
+			ips = append(ips, ip)
+		}
+		log.Tracef(trace.Inside, "IPAM.DeallocateIP: Request to deallocate %s: %s", name, ips)
+		for _, ip := range ips {
+			err := latestIPAM.deallocateIP(ip)
+			if err != nil {
+				return err
 			}
-			return common.NewError404("IP", ip.String())
 		}
-	}
+		delete(latestIPAM.AddressNameToIP, name)
+		delete(latestIPAM.AddressNameToSecondaryIP, name)
+		return nil
+	})
+}
 
-	return errors.NewRomanaNotFoundError("", "address", fmt.Sprintf("name=%s", addressName))
+// deallocateIP deallocates the IP from the network it belongs to.
+func (ipam *IPAM) deallocateIP(ip net.IP) error {
+	for _, network := range ipam.Networks {
+		if network.CIDR.IPNet.Contains(ip) {
+			log.Tracef(trace.Inside, "IPAM.DeallocateIP: IP %s belongs to network %s", ip, network.Name)
+			return network.deallocateIP(ip)
+		}
+	}
+	return errors.NewRomanaNotFoundError("", "IP", fmt.Sprintf("IP=%s", ip))
 }
 
 // getNetworksForTenant gets all eligible networks for the
@@ -1436,6 +1682,56 @@ func (ipam *IPAM) getNetworksForTenant(tenant string) ([]*Network, error) {
 	return networks, nil
 }
 
+// newNetworkFromDefinition validates network definition and
+// creates the network, filling in default block mask if needed.
+func newNetworkFromDefinition(netDef api.NetworkDefinition) (*Network, error) {
+	netDefCIDR, err := NewCIDR(netDef.CIDR)
+	if err != nil {
+		return nil, err
+	}
+	blockMaskMin, blockMaskMax := netDefCIDR.Mask.Size()
+	defaultBlockMask := uint(DefaultBlockMask)
+	if netDefCIDR.IsIPv6() {
+		defaultBlockMask = DefaultIPv6BlockMask
+		if blockMaskMin < MinIPv6BlockMask {
+			blockMaskMin = MinIPv6BlockMask
+		}
+	}
+
+	if netDef.BlockMask == 0 {
+		if defaultBlockMask < uint(blockMaskMin) {
+			netDef.BlockMask = uint(blockMaskMin)
+		} else {
+			netDef.BlockMask = defaultBlockMask
+		}
+	}
+	if netDef.BlockMask < uint(blockMaskMin) || netDef.BlockMask > uint(blockMaskMax) {
+		return nil, common.NewError(
+			"invalid blockmask(%d) for network(%s), must be %d <= blockmask <= %d",
+			netDef.BlockMask, netDef.Name, blockMaskMin, blockMaskMax)
+	}
+
+	for _, tenantName := range netDef.Tenants {
+		if !tenantNameRegexp.MatchString(tenantName) {
+			return nil, common.NewError("Bad tenant name: %s", tenantName)
+		}
+	}
+
+	return newNetwork(netDef.Name, netDefCIDR, netDef.BlockMask), nil
+}
+
+// addNetworkTenants allows provided tenants to use the network,
+// if no tenants provided network is available to all tenants.
+func (ipam *IPAM) addNetworkTenants(netName string, tenants []string) {
+	// If empty, all tenants are allowed.
+	if len(tenants) == 0 {
+		tenants = []string{"*"}
+	}
+	for _, tenantName := range tenants {
+		ipam.TenantToNetwork[tenantName] = append(ipam.TenantToNetwork[tenantName], netName)
+	}
+}
+
 // setTopology clears IPAM and sets existing topology in it.
 func (ipam *IPAM) setTopology(req api.TopologyUpdateRequest) error {
 	ipam.clearIPAM()
@@ -1445,44 +1741,11 @@ func (ipam *IPAM) setTopology(req api.TopologyUpdateRequest) error {
 		if _, ok := ipam.Networks[netDef.Name]; ok {
 			return common.NewError("Network with name %s already defined", netDef.Name)
 		}
-		netDefCIDR, err := NewCIDR(netDef.CIDR)
+		network, err := newNetworkFromDefinition(netDef)
 		if err != nil {
 			return err
 		}
-		blockMaskMin, blockMaskMax := netDefCIDR.Mask.Size()
-
-		if netDef.BlockMask == 0 {
-			if DefaultBlockMask < uint(blockMaskMin) {
-				netDef.BlockMask = uint(blockMaskMin)
-			} else {
-				netDef.BlockMask = DefaultBlockMask
-			}
-		}
-		if netDef.BlockMask < uint(blockMaskMin) || netDef.BlockMask > uint(blockMaskMax) {
-			return common.NewError(
-				"invalid blockmask(%d) for network(%s), must be %d <= blockmask <= %d",
-				netDef.BlockMask, netDef.Name, blockMaskMin, blockMaskMax)
-		}
-
-		// If empty, all tenants are allowed.
-		if netDef.Tenants == nil || len(netDef.Tenants) == 0 {
-			if networksForTenant, ok := ipam.TenantToNetwork["*"]; ok {
-				ipam.TenantToNetwork["*"] = append(networksForTenant, netDef.Name)
-			} else {
-				ipam.TenantToNetwork["*"] = []string{netDef.Name}
-			}
-		} else {
-			for _, tenantName := range netDef.Tenants {
-				if !tenantNameRegexp.MatchString(tenantName) {
-					return common.NewError("Bad tenant name: %s", tenantName)
-				}
-				if _, ok := ipam.TenantToNetwork[tenantName]; !ok {
-					ipam.TenantToNetwork[tenantName] = make([]string, 0)
-				}
-				ipam.TenantToNetwork[tenantName] = append(ipam.TenantToNetwork[tenantName], netDef.Name)
-			}
-		}
-		network := newNetwork(netDef.Name, netDefCIDR, netDef.BlockMask)
+		ipam.addNetworkTenants(network.Name, netDef.Tenants)
 		network.ipam = ipam
 		log.Infof("Adding network %s: %v", netDef.Name, network)
 		ipam.Networks[netDef.Name] = network
@@ -1554,6 +1817,37 @@ func (ipam *IPAM) cloneIPAM() (*IPAM, error) {
 	return newIPAM, nil
 }
 
+// reallocateAddresses allocates addresses from the provided map (which
+// belongs to this IPAM) in the target IPAM, recording them in the
+// target map.
+func (ipam *IPAM) reallocateAddresses(target *IPAM, from map[string]net.IP, to map[string]net.IP) error {
+	var ipFound bool
+	for addressName, ip := range from {
+		log.Debugf("UpdateTopology(): Attempting to allocate %s: %s", addressName, ip)
+		ipFound = false
+		for _, network := range ipam.Networks {
+			if network.CIDR.ContainsIP(ip) {
+				log.Debugf("UpdateTopology(): Attempt to allocate %s in %s (%s)", ip, network.Name, network.CIDR)
+				hostName, owner := network.findIPInfo(ip)
+				if hostName == "" || owner == "" {
+					return fmt.Errorf("Unexpected result when looking up IP %s: host %s, owner %s", ip, hostName, owner)
+				}
+				tenant, segment := parseOwner(owner)
+				err := target.allocateSpecificIP(addressName, ip, hostName, tenant, segment)
+				if err != nil {
+					return err
+				}
+				to[addressName] = ip
+				ipFound = true
+			}
+		}
+		if !ipFound {
+			return fmt.Errorf("Cannot find network for IP %s", ip)
+		}
+	}
+	return nil
+}
+
 // UpdateTopology updates the entire topology, returning an error if the
 // current topology has IPs that cannot be allocated in the new one.
 func (ipam *IPAM) UpdateTopology(req api.TopologyUpdateRequest, lockAndSave bool) error {
@@ -1582,28 +1876,13 @@ func (ipam *IPAM) UpdateTopology(req api.TopologyUpdateRequest, lockAndSave bool
 		return err
 	}
 
-	var ipFound bool
-	for addressName, ip := range backupIPAM.AddressNameToIP {
-		log.Debugf("UpdateTopology(): Attempting to allocate %s: %s", addressName, ip)
-		ipFound = false
-		for _, network := range backupIPAM.Networks {
-			if network.CIDR.ContainsIP(ip) {
-				log.Debugf("UpdateTopology(): Attempt to allocate %s in %s (%s)", ip, network.Name, network.CIDR)
-				hostName, owner := network.findIPInfo(ip)
-				if hostName == "" || owner == "" {
-					return fmt.Errorf("Unexpected result when looking up IP %s: host %s, owner %s", ip, hostName, owner)
-				}
-				tenant, segment := parseOwner(owner)
-				err = ipam.allocateSpecificIP(addressName, ip, hostName, tenant, segment)
-				if err == nil {
-					ipFound = true
-				} else {
-					return err
-				}
-			}
-		}
-		if !ipFound {
-			return fmt.Errorf("Cannot find network for IP %s", ip)
+	for _, addressMaps := range [][2]map[string]net.IP{
+		{backupIPAM.AddressNameToIP, ipam.AddressNameToIP},
+		{backupIPAM.AddressNameToSecondaryIP, ipam.AddressNameToSecondaryIP},
+	} {
+		err = backupIPAM.reallocateAddresses(ipam, addressMaps[0], addressMaps[1])
+		if err != nil {
+			return err
 		}
 	}
 
@@ -1842,6 +2121,7 @@ func (ipam *IPAM) AddHost(host api.Host) error {
 	}
 	for _, net := range ipam.Networks {
 		myHost := &Host{IP: host.IP,
+			IPs:  host.IPs,
 			Name: host.Name,
 			Tags: myTags,
 		}
@@ -1869,6 +2149,245 @@ func (ipam *IPAM) AddHost(host api.Host) error {
 	return nil
 }
 
+// LookupAddress returns information about the provided IP: name
+// it is allocated under, host, tenant and segment of the block
+// it belongs to. Name is empty if IP is in one of the blocks
+// but is not allocated.
+func (ipam *IPAM) LookupAddress(ip net.IP) (*api.IPAMAddressResponse, error) {
+	for _, network := range ipam.Networks {
+		if !network.CIDR.ContainsIP(ip) || network.Group == nil {
+			continue
+		}
+
+		group, blockID := network.Group.findIPBlock(ip)
+		if group == nil {
+			break
+		}
+
+		tenant, segment := parseOwner(group.BlockToOwner[blockID])
+		resp := &api.IPAMAddressResponse{
+			IP:      ip,
+			Host:    group.BlockToHost[blockID],
+			Tenant:  tenant,
+			Segment: segment,
+			Network: network.Name,
+			Block:   &api.IPNet{IPNet: *group.Blocks[blockID].CIDR.IPNet},
+		}
+
+		for _, addressMap := range []map[string]net.IP{ipam.AddressNameToIP, ipam.AddressNameToSecondaryIP} {
+			for name, addr := range addressMap {
+				if addr.Equal(ip) {
+					resp.Name = name
+				}
+			}
+		}
+
+		return resp, nil
+	}
+
+	return nil, errors.NewRomanaNotFoundError(fmt.Sprintf("IP %s is not in any allocated block", ip),
+		"address", fmt.Sprintf("IP=%s", ip))
+}
+
+// LookupAddressByName returns information about the address
+// allocated under the provided name.
+func (ipam *IPAM) LookupAddressByName(addressName string) (*api.IPAMAddressResponse, error) {
+	ip, ok := ipam.AddressNameToIP[addressName]
+	if !ok {
+		return nil, errors.NewRomanaNotFoundError("", "address", fmt.Sprintf("name=%s", addressName))
+	}
+
+	resp, err := ipam.LookupAddress(ip)
+	if err != nil {
+		return nil, err
+	}
+	resp.Name = addressName
+	resp.SecondaryIP = ipam.AddressNameToSecondaryIP[addressName]
+	return resp, nil
+}
+
+// LookupLatestAddressByName returns the IP allocated under the
+// provided name in the latest state of IPAM in the store, which
+// may be ahead of this IPAM until it is refreshed.
+func (ipam *IPAM) LookupLatestAddressByName(addressName string) (net.IP, error) {
+	ch, err := ipam.locker.Lock()
+	if err != nil {
+		return nil, err
+	}
+	defer ipam.locker.Unlock()
+
+	latestIPAM := &IPAM{}
+	latestIPAM.clearIPAM()
+	err = ipam.load(latestIPAM, ch)
+	if err != nil {
+		return nil, err
+	}
+
+	ip, ok := latestIPAM.AddressNameToIP[addressName]
+	if !ok {
+		return nil, errors.NewRomanaNotFoundError("", "address", fmt.Sprintf("name=%s", addressName))
+	}
+	return ip, nil
+}
+
+// GetHost returns host with the provided name or IP.
+func (ipam *IPAM) GetHost(nameOrIP string) (api.Host, error) {
+	for _, host := range ipam.ListHosts().Hosts {
+		if host.Name == nameOrIP || host.IP.String() == nameOrIP {
+			return host, nil
+		}
+	}
+	return api.Host{}, errors.NewRomanaNotFoundError("", "host", fmt.Sprintf("name=%s", nameOrIP))
+}
+
+// GetNetwork returns details of the network with the provided name.
+func (ipam *IPAM) GetNetwork(netName string) (*api.IPAMNetworkResponse, error) {
+	network, ok := ipam.Networks[netName]
+	if !ok {
+		return nil, errors.NewRomanaNotFoundError("", "network", fmt.Sprintf("name=%s", netName))
+	}
+
+	resp := &api.IPAMNetworkResponse{
+		Revision:  network.Revison,
+		Name:      network.Name,
+		CIDR:      api.IPNet{IPNet: *network.CIDR.IPNet},
+		BlockMask: network.BlockMask,
+	}
+
+	for tenant, networks := range ipam.TenantToNetwork {
+		for _, name := range networks {
+			if name == netName {
+				resp.Tenants = append(resp.Tenants, tenant)
+			}
+		}
+	}
+	sort.Strings(resp.Tenants)
+
+	for _, cidr := range network.BlackedOut {
+		resp.BlackedOut = append(resp.BlackedOut, api.IPNet{IPNet: *cidr.IPNet})
+	}
+
+	if network.Group != nil {
+		for _, host := range network.Group.ListHosts() {
+			resp.Hosts = append(resp.Hosts, api.Host{
+				IP:        host.IP,
+				IPs:       host.IPs,
+				Name:      host.Name,
+				AgentPort: host.AgentPort,
+			})
+		}
+		resp.Blocks = len(network.Group.GetBlocks())
+	}
+
+	return resp, nil
+}
+
+// AddNetwork adds a new network to the current topology.
+func (ipam *IPAM) AddNetwork(req api.NetworkAddRequest) error {
+	ch, err := ipam.locker.Lock()
+	if err != nil {
+		return err
+	}
+	defer ipam.locker.Unlock()
+
+	if req.Name == "" {
+		return common.NewError("Network name is required.")
+	}
+	if _, ok := ipam.Networks[req.Name]; ok {
+		return errors.NewRomanaExistsErrorWithMessage(
+			fmt.Sprintf("Network with name %s already defined", req.Name),
+			req.NetworkDefinition, "network", fmt.Sprintf("name=%s", req.Name))
+	}
+
+	network, err := newNetworkFromDefinition(req.NetworkDefinition)
+	if err != nil {
+		return err
+	}
+
+	for _, other := range ipam.Networks {
+		if other.CIDR.Contains(network.CIDR) || network.CIDR.Contains(other.CIDR) {
+			return common.NewError("CIDR %s of network %s overlaps with CIDR %s of network %s",
+				network.CIDR, network.Name, other.CIDR, other.Name)
+		}
+	}
+
+	topoMap := req.Map
+	if len(topoMap) == 0 {
+		group := api.GroupOrHost{Groups: []api.GroupOrHost{}}
+		seen := make(map[string]bool)
+		for _, host := range ipam.ListHosts().Hosts {
+			if seen[host.Name] {
+				continue
+			}
+			seen[host.Name] = true
+			group.Groups = append(group.Groups, api.GroupOrHost{
+				Name:       host.Name,
+				IP:         host.IP,
+				Assignment: host.Tags,
+			})
+		}
+		topoMap = []api.GroupOrHost{group}
+	}
+
+	hg := &Group{}
+	err = hg.parseMap(topoMap, network.CIDR, network)
+	if err != nil {
+		return err
+	}
+	network.Group = hg
+	network.ipam = ipam
+
+	ipam.addNetworkTenants(network.Name, req.Tenants)
+	ipam.Networks[network.Name] = network
+	ipam.TopologyRevision++
+	return ipam.save(ipam, ch)
+}
+
+// RemoveNetwork removes the network with the provided name. It is
+// an error if any addresses are allocated in the network.
+func (ipam *IPAM) RemoveNetwork(netName string) error {
+	ch, err := ipam.locker.Lock()
+	if err != nil {
+		return err
+	}
+	defer ipam.locker.Unlock()
+
+	network, ok := ipam.Networks[netName]
+	if !ok {
+		return errors.NewRomanaNotFoundError("", "network", fmt.Sprintf("name=%s", netName))
+	}
+
+	var allocated int
+	for _, addressMap := range []map[string]net.IP{ipam.AddressNameToIP, ipam.AddressNameToSecondaryIP} {
+		for _, ip := range addressMap {
+			if network.CIDR.ContainsIP(ip) {
+				allocated++
+			}
+		}
+	}
+	if allocated > 0 {
+		return common.NewError("Network %s has %d allocated addresses, cannot remove it", netName, allocated)
+	}
+
+	delete(ipam.Networks, netName)
+	for tenant, networks := range ipam.TenantToNetwork {
+		remaining := make([]string, 0, len(networks))
+		for _, name := range networks {
+			if name != netName {
+				remaining = append(remaining, name)
+			}
+		}
+		if len(remaining) == 0 {
+			delete(ipam.TenantToNetwork, tenant)
+		} else {
+			ipam.TenantToNetwork[tenant] = remaining
+		}
+	}
+
+	ipam.TopologyRevision++
+	return ipam.save(ipam, ch)
+}
+
 // BlackOut removes a CIDR from consideration. It is an error if CIDR
 // is within any of the exising allocated blocks. Fragmentation may
 // result if CIDRs smaller than ipam. Blocks are blacked out and then
@@ -1996,3 +2515,23 @@ func (ipam *IPAM) UnBlackOut(cidrStr string) error {
 	network.Revison++
 	return ipam.save(ipam, ch)
 }
+
+// ListBlackedOut returns CIDRs blacked out in the network
+// with the provided name.
+func (ipam *IPAM) ListBlackedOut(netName string) (*api.IPAMBlackoutResponse, error) {
+	network, ok := ipam.Networks[netName]
+	if !ok {
+		return nil, errors.NewRomanaNotFoundError("", "network", fmt.Sprintf("name=%s", netName))
+	}
+
+	resp := &api.IPAMBlackoutResponse{
+		Revision:   network.Revison,
+		Network:    network.Name,
+		CIDR:       api.IPNet{IPNet: *network.CIDR.IPNet},
+		BlackedOut: make([]api.IPNet, 0, len(network.BlackedOut)),
+	}
+	for _, cidr := range network.BlackedOut {
+		resp.BlackedOut = append(resp.BlackedOut, api.IPNet{IPNet: *cidr.IPNet})
+	}
+	return resp, nil
+}
@@ -32,10 +32,17 @@ var (
 	ipam      *IPAM
 )
 
+// twoHostsTopologyFile is a topology shared by tests, with
+// network net1 10.0.0.0/28 split into /30 blocks, and host1
+// and host2 in one group.
+const twoHostsTopologyFile = "testdata/TwoHostsTopology.json"
+
 func loadTestData(t *testing.T) []byte {
-	testName := t.Name()
-	fileName := fmt.Sprintf("testdata/%s.json", testName)
-	t.Logf("Loading data for %s from %s", testName, fileName)
+	return loadTestFile(t, fmt.Sprintf("testdata/%s.json", t.Name()))
+}
+
+func loadTestFile(t *testing.T, fileName string) []byte {
+	t.Logf("Loading data for %s from %s", t.Name(), fileName)
 	b, err := ioutil.ReadFile(fileName)
 	if err != nil {
 		t.Fatal(err)
@@ -117,6 +124,36 @@ func TestNewCIDR(t *testing.T) {
 	}
 }
 
+func TestNewCIDRIPv6(t *testing.T) {
+	cidr, err := NewCIDR("fd00:1234::/64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cidr.IsIPv6() {
+		t.Fatalf("Expected %s to be IPv6", cidr)
+	}
+	if cidr.StartIP.String() != "fd00:1234::" {
+		t.Fatalf("Expected start to be fd00:1234::, got %s", cidr.StartIP)
+	}
+	if cidr.EndIP.String() != "fd00:1234::ffff:ffff:ffff:ffff" {
+		t.Fatalf("Expected end to be fd00:1234::ffff:ffff:ffff:ffff, got %s", cidr.EndIP)
+	}
+
+	cidr4, err := NewCIDR("10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cidr.Contains(cidr4) || cidr4.Contains(cidr) {
+		t.Fatalf("Expected %s and %s not to contain each other", cidr, cidr4)
+	}
+	if cidr4.ContainsIP(net.ParseIP("fd00:1234::a")) {
+		t.Fatalf("Expected %s not to contain fd00:1234::a", cidr4)
+	}
+	if !cidr.ContainsIP(net.ParseIP("fd00:1234::a")) {
+		t.Fatalf("Expected %s to contain fd00:1234::a", cidr)
+	}
+}
+
 func TestBlackout(t *testing.T) {
 	var err error
 	ipam = initIpam(t, "")
@@ -146,6 +183,17 @@ func TestBlackout(t *testing.T) {
 		t.Fatal(err)
 	}
 
+	blackedOut, err := ipam.ListBlackedOut("net1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blackedOut.BlackedOut) != 1 || blackedOut.BlackedOut[0].String() != "10.0.0.0/31" {
+		t.Fatalf("Expected 10.0.0.0/31 to be blacked out, got %v", blackedOut.BlackedOut)
+	}
+	if _, err = ipam.ListBlackedOut("net2"); err == nil {
+		t.Fatal("Expected error for unknown network")
+	}
+
 	// 4. Allocate IP - should start with 10.0.0.2
 	ip, err := ipam.AllocateIP("1", "host1", "ten1", "seg1")
 	t.Logf("TestChunkBlackout: 1. Allocated %s for ten1:seg1", ip)
@@ -1309,3 +1357,318 @@ func TestLabelUpdate(t *testing.T) {
 	}
 	t.Logf("Got expected error %s", err)
 }
+
+func TestIPv6Allocate(t *testing.T) {
+	ipam = initIpam(t, "")
+
+	ip, err := ipam.AllocateIP("x1", "host1", "tenant1", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ip.String() != "fd00:1234::" {
+		t.Fatalf("Expected fd00:1234::, got %s", ip)
+	}
+
+	ip, err = ipam.AllocateIP("x2", "host1", "tenant1", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ip.String() != "fd00:1234::1" {
+		t.Fatalf("Expected fd00:1234::1, got %s", ip)
+	}
+
+	ip, err = ipam.AllocateIP("x3", "host2", "tenant1", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ip.String() != "fd00:1234:0:8000::" {
+		t.Fatalf("Expected fd00:1234:0:8000::, got %s", ip)
+	}
+
+	ipam.load(ipam, nil)
+	blocks := ipam.ListAllBlocks().Blocks
+	if len(blocks) != 2 {
+		t.Fatalf("Expected 2 blocks, got %v", blocks)
+	}
+	for _, block := range blocks {
+		ones, _ := block.CIDR.Mask.Size()
+		if ones != 64 {
+			t.Fatalf("Expected /64 block, got %s", block.CIDR)
+		}
+	}
+
+	err = ipam.DeallocateIP("x1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ip, err = ipam.AllocateIP("x4", "host1", "tenant1", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ip.String() != "fd00:1234::" {
+		t.Fatalf("Expected fd00:1234:: to be reused, got %s", ip)
+	}
+
+	ipam.load(ipam, nil)
+	err = ipam.BlackOut("fd00:1234::/120")
+	if err == nil {
+		t.Fatal("Expected error blacking out CIDR with allocated IPs")
+	}
+	err = ipam.BlackOut("fd00:1234::100/120")
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDualStackAllocate(t *testing.T) {
+	ipam = initIpam(t, "")
+
+	ips, err := ipam.AllocateIPs("x1", "host1", "tenant1", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ips) != 2 {
+		t.Fatalf("Expected 2 IPs, got %v", ips)
+	}
+	if ips[0].String() != "10.0.0.0" || ips[1].String() != "fd00:1234::" {
+		t.Fatalf("Expected [10.0.0.0 fd00:1234::], got %v", ips)
+	}
+
+	_, err = ipam.AllocateIPs("x1", "host1", "tenant1", "")
+	if err == nil {
+		t.Fatal("Expected error allocating x1 again")
+	}
+
+	// A plain AllocateIP uses the first eligible network.
+	ip, err := ipam.AllocateIP("x2", "host1", "tenant1", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ip.String() != "10.0.0.1" {
+		t.Fatalf("Expected 10.0.0.1, got %s", ip)
+	}
+
+	// Topology update must preserve both addresses.
+	topoReq := api.TopologyUpdateRequest{}
+	err = json.Unmarshal(loadTestData(t), &topoReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ipam.load(ipam, nil)
+	err = ipam.UpdateTopology(topoReq, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ipam.AddressNameToIP["x1"].String() != "10.0.0.0" || ipam.AddressNameToSecondaryIP["x1"].String() != "fd00:1234::" {
+		t.Fatalf("Expected x1 to keep its addresses, got %s and %s", ipam.AddressNameToIP["x1"], ipam.AddressNameToSecondaryIP["x1"])
+	}
+	ipam.save(ipam, nil)
+
+	// Deallocating by the secondary address frees both.
+	err = ipam.DeallocateIP("fd00:1234::")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ips, err = ipam.AllocateIPs("x3", "host1", "tenant1", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ips[0].String() != "10.0.0.0" || ips[1].String() != "fd00:1234::" {
+		t.Fatalf("Expected [10.0.0.0 fd00:1234::], got %v", ips)
+	}
+}
+
+func TestIPv6InvalidBlockMask(t *testing.T) {
+	config := string(loadTestData(t))
+
+	ipam, err := NewIPAM(testSaver.save, nil)
+	if err != nil {
+		t.Fatalf("error initializing ipam: %v", err)
+	}
+	ipam.load = testSaver.load
+
+	topologyRequest := api.TopologyUpdateRequest{}
+	err = json.Unmarshal([]byte(config), &topologyRequest)
+	if err != nil {
+		t.Fatalf("cannot parse %s: %v", config, err)
+	}
+
+	err = ipam.UpdateTopology(topologyRequest, false)
+	if err == nil {
+		t.Fatal("test failed, expected an error")
+	}
+	if !strings.Contains(err.Error(), "invalid blockmask") {
+		t.Fatalf("test case failed, expected 'invalid blockmask...', received '%s'", err)
+	}
+}
+
+func TestAddRemoveNetwork(t *testing.T) {
+	ipam = initIpam(t, "")
+
+	// Overlapping network must be rejected.
+	err := ipam.AddNetwork(api.NetworkAddRequest{
+		NetworkDefinition: api.NetworkDefinition{Name: "net2", CIDR: "10.0.128.0/17"},
+	})
+	if err == nil {
+		t.Fatal("Expected error for network overlapping with net1")
+	}
+
+	err = ipam.AddNetwork(api.NetworkAddRequest{
+		NetworkDefinition: api.NetworkDefinition{Name: "net2", CIDR: "10.1.0.0/16", BlockMask: 30, Tenants: []string{"ten2"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ipam.AddNetwork(api.NetworkAddRequest{
+		NetworkDefinition: api.NetworkDefinition{Name: "net2", CIDR: "10.2.0.0/16"},
+	})
+	if _, ok := err.(errors.RomanaExistsError); !ok {
+		t.Fatalf("Expected exists error for duplicate network, got %v", err)
+	}
+
+	network, err := ipam.GetNetwork("net2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if network.BlockMask != 30 || len(network.Hosts) != 2 || len(network.Tenants) != 1 || network.Tenants[0] != "ten2" {
+		t.Fatalf("Unexpected network %+v", network)
+	}
+
+	// New network gets all known hosts.
+	ip, err := ipam.AllocateIP("x1", "host2", "ten2", "seg1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !network.CIDR.Contains(ip) {
+		t.Fatalf("Expected %s to be allocated from %s", ip, network.CIDR.String())
+	}
+
+	host, err := ipam.GetHost("192.168.0.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if host.Name != "host2" {
+		t.Fatalf("Expected host2, got %s", host)
+	}
+	if _, err = ipam.GetHost("host3"); err == nil {
+		t.Fatal("Expected error for unknown host")
+	}
+
+	ipam.load(ipam, nil)
+	err = ipam.RemoveNetwork("net2")
+	if err == nil {
+		t.Fatal("Expected error removing network with allocated addresses")
+	}
+
+	err = ipam.DeallocateIP("x1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ipam.load(ipam, nil)
+
+	err = ipam.RemoveNetwork("net2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := ipam.Networks["net2"]; ok {
+		t.Fatal("Expected net2 to be removed")
+	}
+	if _, ok := ipam.TenantToNetwork["ten2"]; ok {
+		t.Fatalf("Expected ten2 to be removed from tenant mapping, got %v", ipam.TenantToNetwork)
+	}
+	if _, err = ipam.GetNetwork("net2"); err == nil {
+		t.Fatal("Expected error for removed network")
+	}
+}
+
+func TestLookupAddress(t *testing.T) {
+	ipam = initIpam(t, "")
+
+	ip, err := ipam.AllocateIP("pod1", "host2", "ten1", "seg1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ipam.load(ipam, nil)
+
+	address, err := ipam.LookupAddress(ip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if address.Name != "pod1" || address.Host != "host2" || address.Tenant != "ten1" ||
+		address.Segment != "seg1" || address.Network != "net1" || !address.Block.Contains(ip) {
+		t.Fatalf("Unexpected address %+v", address)
+	}
+
+	byName, err := ipam.LookupAddressByName("pod1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !byName.IP.Equal(ip) || byName.Block.String() != address.Block.String() {
+		t.Fatalf("Expected %+v, got %+v", address, byName)
+	}
+
+	// IP in the same block that is not allocated yet.
+	next := net.ParseIP(ip.String()).To4()
+	next[3]++
+	address, err = ipam.LookupAddress(next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if address.Name != "" || address.Host != "host2" {
+		t.Fatalf("Unexpected address %+v", address)
+	}
+
+	if _, err = ipam.LookupAddress(net.ParseIP("10.0.200.1")); err == nil {
+		t.Fatal("Expected error for IP outside of allocated blocks")
+	}
+	if _, err = ipam.LookupAddressByName("pod2"); err == nil {
+		t.Fatal("Expected error for unknown address name")
+	}
+}
+
+func TestAllocateIPInNetwork(t *testing.T) {
+	ipam = initIpam(t, "")
+
+	_, vips, _ := net.ParseCIDR("10.1.0.0/24")
+
+	ip, err := ipam.AllocateIP("pod1", "host1", "ten1", "seg1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vips.Contains(ip) {
+		t.Fatalf("Expected pod address outside of VIP pool, got %s", ip)
+	}
+
+	ip, err = ipam.AllocateIPInNetwork("vip1", "vips", "host2", "default", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !vips.Contains(ip) {
+		t.Fatalf("Expected address in VIP pool, got %s", ip)
+	}
+	ipam.load(ipam, nil)
+
+	address, err := ipam.LookupAddressByName("vip1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if address.Network != "vips" || address.Host != "host2" {
+		t.Fatalf("Unexpected address %+v", address)
+	}
+
+	if _, err = ipam.AllocateIPInNetwork("vip1", "vips", "host2", "default", ""); err == nil {
+		t.Fatal("Expected error for address name already allocated")
+	}
+	if _, err = ipam.AllocateIPInNetwork("vip2", "nosuchnet", "host2", "default", ""); err == nil {
+		t.Fatal("Expected error for unknown network")
+	}
+	if _, err = ipam.AllocateIPInNetwork("vip2", "vips", "nosuchhost", "default", ""); err == nil {
+		t.Fatal("Expected error for unknown host")
+	}
+
+	err = ipam.DeallocateIP("vip1")
+	if err != nil {
+		t.Fatal(err)
+	}
+}
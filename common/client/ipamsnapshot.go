@@ -0,0 +1,349 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+
+	"github.com/romana/core/common/api"
+	log "github.com/romana/rlog"
+)
+
+// readIPAM reads IPAM from the store while holding IPAM lock,
+// and returns it along with the keys it was read from.
+func (c *Client) readIPAM() (*IPAM, <-chan struct{}, error) {
+	ch, err := c.ipamLocker.Lock()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get ipam lock: %s", err)
+	}
+
+	shards, err := c.readShards()
+	if err != nil {
+		c.ipamLocker.Unlock()
+		return nil, nil, fmt.Errorf("failed to fetch ipam information: %s", err)
+	}
+
+	ipam, err := parseShards(shards)
+	if err != nil {
+		c.ipamLocker.Unlock()
+		return nil, nil, fmt.Errorf("failed to unmarshal ipam information: %s", err)
+	}
+	ipam.shards = shards
+	return ipam, ch, nil
+}
+
+// ExportIPAM returns snapshot of IPAM, which can be
+// restored with ParseIPAMSnapshot and ImportIPAM.
+func (c *Client) ExportIPAM() (api.IPAMSnapshot, error) {
+	snapshot := api.IPAMSnapshot{Version: api.IPAMSnapshotVersion}
+
+	ipam, _, err := c.readIPAM()
+	if err != nil {
+		return snapshot, err
+	}
+	defer c.ipamLocker.Unlock()
+
+	snapshot.IPAM, err = json.Marshal(ipam)
+	return snapshot, err
+}
+
+// ParseIPAMSnapshot restores IPAM from the snapshot and checks
+// that it is consistent enough to be used by romana.
+func ParseIPAMSnapshot(snapshot api.IPAMSnapshot) (*IPAM, error) {
+	if snapshot.Version != api.IPAMSnapshotVersion {
+		return nil, fmt.Errorf("unsupported IPAM snapshot version %d, expected %d",
+			snapshot.Version, api.IPAMSnapshotVersion)
+	}
+	if len(snapshot.IPAM) == 0 {
+		return nil, fmt.Errorf("IPAM snapshot has no IPAM")
+	}
+
+	ipam := &IPAM{}
+	ipam.clearIPAM()
+	err := json.Unmarshal(snapshot.IPAM, ipam)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing IPAM snapshot: %s", err)
+	}
+
+	// Unlike parseIPAM, parents are only injected once the snapshot
+	// is known to have no missing groups or hosts.
+	err = validateIPAM(ipam)
+	if err != nil {
+		return nil, fmt.Errorf("invalid IPAM snapshot: %s", err)
+	}
+	ipam.injectParents()
+	ipam.locker = newMutexLocker()
+	return ipam, nil
+}
+
+// validateIPAM checks that networks and their groups are complete,
+// and that indexes of blocks, tenants and addresses refer to
+// existing blocks and networks.
+func validateIPAM(ipam *IPAM) error {
+	for name, network := range ipam.Networks {
+		if network == nil || network.Name != name {
+			return fmt.Errorf("network %s has no definition", name)
+		}
+		if network.CIDR.IPNet == nil {
+			return fmt.Errorf("network %s has no CIDR", name)
+		}
+		if network.Group == nil {
+			return fmt.Errorf("network %s has no host groups", name)
+		}
+		for _, cidr := range network.BlackedOut {
+			if cidr.IPNet == nil || !network.CIDR.Contains(cidr) {
+				return fmt.Errorf("blackout %s is not within network %s", cidr, name)
+			}
+		}
+		err := validateGroup(network, network.Group)
+		if err != nil {
+			return err
+		}
+	}
+
+	for tenant, networks := range ipam.TenantToNetwork {
+		for _, name := range networks {
+			if _, ok := ipam.Networks[name]; !ok {
+				return fmt.Errorf("tenant %s refers to unknown network %s", tenant, name)
+			}
+		}
+	}
+
+	for _, addresses := range []map[string]net.IP{ipam.AddressNameToIP, ipam.AddressNameToSecondaryIP} {
+		for name, ip := range addresses {
+			if ipam.networkOfIP(ip) == nil {
+				return fmt.Errorf("address %s (%s) is not within any network", name, ip)
+			}
+		}
+	}
+
+	return nil
+}
+
+func validateGroup(network *Network, group *Group) error {
+	if group == nil {
+		return fmt.Errorf("network %s has an empty group", network.Name)
+	}
+
+	for i, block := range group.Blocks {
+		if block == nil || block.Pool == nil || block.CIDR.IPNet == nil {
+			return fmt.Errorf("block %d of group %s in network %s is incomplete", i, group.Name, network.Name)
+		}
+		if !network.CIDR.Contains(block.CIDR) {
+			return fmt.Errorf("block %s is not within network %s", block.CIDR, network.Name)
+		}
+	}
+
+	checkBlockID := func(id int, index string) error {
+		if id < 0 || id >= len(group.Blocks) {
+			return fmt.Errorf("%s of group %s in network %s refers to missing block %d", index, group.Name, network.Name, id)
+		}
+		return nil
+	}
+	for id := range group.BlockToOwner {
+		if err := checkBlockID(id, "block_to_owner"); err != nil {
+			return err
+		}
+	}
+	for id := range group.BlockToHost {
+		if err := checkBlockID(id, "block_to_host"); err != nil {
+			return err
+		}
+	}
+	for _, ids := range group.OwnerToBlocks {
+		for _, id := range ids {
+			if err := checkBlockID(id, "owner_to_block"); err != nil {
+				return err
+			}
+		}
+	}
+	for _, id := range group.ReusableBlocks {
+		if err := checkBlockID(id, "reusable_blocks"); err != nil {
+			return err
+		}
+	}
+
+	for _, host := range group.Hosts {
+		if host == nil || host.Name == "" {
+			return fmt.Errorf("group %s in network %s has a host without name", group.Name, network.Name)
+		}
+	}
+
+	for _, subgroup := range group.Groups {
+		if err := validateGroup(network, subgroup); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// networkOfIP returns the network the IP is in, or nil.
+func (ipam *IPAM) networkOfIP(ip net.IP) *Network {
+	for _, network := range ipam.Networks {
+		if ip != nil && network.CIDR.ContainsIP(ip) {
+			return network
+		}
+	}
+	return nil
+}
+
+// ImportIPAM replaces IPAM with the imported one and returns the
+// changes made, or with dryRun, only returns the changes. Topology
+// revision is bumped so that agents pick the imported hosts.
+func (c *Client) ImportIPAM(imported *IPAM, dryRun bool) (api.IPAMImportResponse, error) {
+	resp := api.IPAMImportResponse{DryRun: dryRun}
+
+	current, ch, err := c.readIPAM()
+	if err != nil {
+		return resp, err
+	}
+	defer c.ipamLocker.Unlock()
+
+	resp.Changes = diffIPAM(current, imported)
+
+	topologyRevision := current.TopologyRevision
+	if imported.TopologyRevision > topologyRevision {
+		topologyRevision = imported.TopologyRevision
+	}
+	resp.TopologyRevision = topologyRevision + 1
+	resp.AllocationRevision = current.AllocationRevision
+	if dryRun {
+		return resp, nil
+	}
+
+	imported.TopologyRevision = resp.TopologyRevision
+	c.attachIPAM(imported)
+	imported.shards = current.shards
+	err = c.save(imported, ch)
+	if err != nil {
+		if _, ok := err.(ipamConflictError); ok {
+			return resp, fmt.Errorf("IPAM was modified during import, try again: %s", err)
+		}
+		return resp, err
+	}
+
+	// Allocation revision is the revision of the latest key written.
+	resp.AllocationRevision = int(shardsRevision(imported.shards))
+	log.Infof("Imported IPAM with %d changes (Alloc rev: %d, Topo rev: %d)",
+		len(resp.Changes), resp.AllocationRevision, resp.TopologyRevision)
+	return resp, nil
+}
+
+// diffIPAM lists differences of networks, blackouts, hosts, blocks
+// and addresses between the IPAMs, sorted by kind and name.
+func diffIPAM(current *IPAM, imported *IPAM) []api.IPAMChange {
+	var changes []api.IPAMChange
+	for _, kind := range []struct {
+		name     string
+		describe func(*IPAM) map[string]string
+	}{
+		{"network", describeNetworks},
+		{"blackout", describeBlackouts},
+		{"host", describeHosts},
+		{"block", describeBlocks},
+		{"address", describeAddresses},
+	} {
+		changes = append(changes, diffDescriptions(kind.name, kind.describe(current), kind.describe(imported))...)
+	}
+	return changes
+}
+
+// diffDescriptions compares descriptions of objects of the kind by name.
+func diffDescriptions(kind string, old map[string]string, new map[string]string) []api.IPAMChange {
+	var names []string
+	for name := range old {
+		names = append(names, name)
+	}
+	for name := range new {
+		if _, ok := old[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var changes []api.IPAMChange
+	for _, name := range names {
+		oldValue, inOld := old[name]
+		newValue, inNew := new[name]
+		change := api.IPAMChange{Kind: kind, Name: name, Old: oldValue, New: newValue}
+		switch {
+		case !inOld:
+			change.Action = "add"
+		case !inNew:
+			change.Action = "remove"
+		case oldValue != newValue:
+			change.Action = "change"
+		default:
+			continue
+		}
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+func describeNetworks(ipam *IPAM) map[string]string {
+	result := make(map[string]string)
+	for name, network := range ipam.Networks {
+		result[name] = fmt.Sprintf("%s, block mask %d", network.CIDR, network.BlockMask)
+	}
+	return result
+}
+
+func describeBlackouts(ipam *IPAM) map[string]string {
+	result := make(map[string]string)
+	for name, network := range ipam.Networks {
+		for _, cidr := range network.BlackedOut {
+			result[name+"/"+cidr.String()] = ""
+		}
+	}
+	return result
+}
+
+func describeHosts(ipam *IPAM) map[string]string {
+	result := make(map[string]string)
+	for name, network := range ipam.Networks {
+		if network.Group == nil {
+			continue
+		}
+		for _, host := range network.Group.ListHosts() {
+			result[name+"/"+host.Name] = host.IP.String()
+		}
+	}
+	return result
+}
+
+func describeBlocks(ipam *IPAM) map[string]string {
+	result := make(map[string]string)
+	for _, block := range ipam.ListAllBlocks().Blocks {
+		result[block.CIDR.String()] = fmt.Sprintf("host %s, tenant %s, segment %s, %d allocated",
+			block.Host, block.Tenant, block.Segment, block.AllocatedIPCount)
+	}
+	return result
+}
+
+func describeAddresses(ipam *IPAM) map[string]string {
+	result := make(map[string]string)
+	for name, ip := range ipam.AddressNameToIP {
+		result[name] = ip.String()
+		if secondary, ok := ipam.AddressNameToSecondaryIP[name]; ok {
+			result[name] += ", " + secondary.String()
+		}
+	}
+	return result
+}
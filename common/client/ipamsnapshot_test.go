@@ -0,0 +1,152 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package client
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+
+	"github.com/romana/core/common"
+	"github.com/romana/core/common/api"
+)
+
+// newTestMemoryClient returns client backed by a memory store,
+// with topology from twoHostsTopologyFile.
+func newTestMemoryClient(t *testing.T) *Client {
+	topologyFile := twoHostsTopologyFile
+	c, err := NewClient(&common.Config{
+		StoreBackend:        common.StoreBackendMemory,
+		InitialTopologyFile: &topologyFile,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func exportTestIPAM(t *testing.T, c *Client) *IPAM {
+	snapshot, err := c.ExportIPAM()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ipam, err := ParseIPAMSnapshot(snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ipam
+}
+
+func TestIPAMExportImport(t *testing.T) {
+	c := newTestMemoryClient(t)
+	for _, name := range []string{"a", "b"} {
+		if _, err := c.IPAM.AllocateIP(name, "host1", "ten1", "seg1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	exported := exportTestIPAM(t, c)
+	topologyRevision := exported.TopologyRevision
+
+	if err := c.IPAM.DeallocateIP("b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.IPAM.AllocateIP("c", "host2", "ten1", "seg1"); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := c.ImportIPAM(exported, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes := make(map[string]string)
+	for _, change := range resp.Changes {
+		changes[change.Kind+" "+change.Name] = change.Action
+	}
+	for key, action := range map[string]string{
+		"address b":         "add",
+		"address c":         "remove",
+		"block 10.0.0.4/30": "remove",
+	} {
+		if changes[key] != action {
+			t.Errorf("Expected %s to %s, got %v", key, action, resp.Changes)
+		}
+	}
+	if _, ok := changes["address a"]; ok {
+		t.Errorf("Expected no change of address a, got %v", resp.Changes)
+	}
+	if current := exportTestIPAM(t, c); current.AddressNameToIP["c"] == nil {
+		t.Errorf("Expected dry run to leave address c")
+	}
+
+	resp, err = c.ImportIPAM(exported, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	imported := exportTestIPAM(t, c)
+	if len(imported.AddressNameToIP) != 2 || imported.AddressNameToIP["c"] != nil ||
+		!imported.AddressNameToIP["b"].Equal(exported.AddressNameToIP["b"]) {
+		t.Errorf("Expected addresses %v, got %v", exported.AddressNameToIP, imported.AddressNameToIP)
+	}
+	if imported.TopologyRevision != resp.TopologyRevision || resp.TopologyRevision <= topologyRevision {
+		t.Errorf("Expected topology revision after %d, got %d (%d reported)",
+			topologyRevision, imported.TopologyRevision, resp.TopologyRevision)
+	}
+	if imported.AllocationRevision != resp.AllocationRevision {
+		t.Errorf("Expected allocation revision %d, got %d", resp.AllocationRevision, imported.AllocationRevision)
+	}
+}
+
+func TestParseIPAMSnapshotInvalid(t *testing.T) {
+	c := newTestMemoryClient(t)
+	if _, err := c.IPAM.AllocateIP("a", "host1", "ten1", "seg1"); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, corrupt := range map[string]func(*IPAM){
+		"address outside networks": func(ipam *IPAM) {
+			ipam.AddressNameToIP["x"] = net.ParseIP("192.168.0.1")
+		},
+		"missing block": func(ipam *IPAM) {
+			ipam.Networks["net1"].Group.BlockToHost = map[int]string{5: "host1"}
+		},
+		"missing group": func(ipam *IPAM) {
+			ipam.Networks["net1"].Group = nil
+		},
+		"unknown tenant network": func(ipam *IPAM) {
+			ipam.TenantToNetwork["ten2"] = []string{"net2"}
+		},
+	} {
+		ipam := exportTestIPAM(t, c)
+		corrupt(ipam)
+		b, err := json.Marshal(ipam)
+		if err != nil {
+			t.Fatal(err)
+		}
+		snapshot := api.IPAMSnapshot{Version: api.IPAMSnapshotVersion, IPAM: b}
+		if _, err = ParseIPAMSnapshot(snapshot); err == nil {
+			t.Errorf("Expected %s to be rejected", name)
+		}
+	}
+
+	snapshot, err := c.ExportIPAM()
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshot.Version++
+	if _, err = ParseIPAMSnapshot(snapshot); err == nil {
+		t.Errorf("Expected version %d to be rejected", snapshot.Version)
+	}
+}
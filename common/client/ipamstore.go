@@ -0,0 +1,617 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package client
+
+// IPAM is kept in the store under several keys instead of a single
+// document, so that an allocation only writes the keys it changes:
+//
+//   /ipam/meta                  topology revision and tenant to network map
+//   /ipam/networks/<net>        network with its groups, blocks without pools
+//   /ipam/blocks/<net>/<cidr>   block with its pool of addresses
+//   /ipam/addresses/<name>      addresses allocated under the name
+//
+// Each key is written with compare-and-swap against the version
+// IPAM was loaded from (see IPAM.shards), so concurrent allocations
+// only conflict when they change the same block.
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+
+	libkvStore "github.com/docker/libkv/store"
+	"github.com/romana/core/common/log/trace"
+	log "github.com/romana/rlog"
+)
+
+const (
+	ipamMetaKey      = ipamKey + "/meta"
+	ipamNetworksKey  = ipamKey + "/networks"
+	ipamBlocksKey    = ipamKey + "/blocks"
+	ipamAddressesKey = ipamKey + "/addresses"
+
+	// maxIPAMConflicts is how many times an allocation is attempted
+	// when it conflicts with concurrent allocations.
+	maxIPAMConflicts = 10
+)
+
+// ipamMeta is the part of IPAM that is not specific to a network.
+type ipamMeta struct {
+	TopologyRevision int                 `json:"topology_revision"`
+	TenantToNetwork  map[string][]string `json:"tenant_to_network"`
+}
+
+// ipamAddress holds addresses allocated under a name.
+type ipamAddress struct {
+	IP          net.IP `json:"ip"`
+	SecondaryIP net.IP `json:"secondary_ip,omitempty"`
+}
+
+// ipamConflictError is returned by Saver when keys of IPAM were
+// modified since IPAM was loaded.
+type ipamConflictError struct {
+	key string
+}
+
+func (e ipamConflictError) Error() string {
+	return fmt.Sprintf("IPAM key %s was modified concurrently", e.key)
+}
+
+func networkKey(netName string) string {
+	return ipamNetworksKey + "/" + url.PathEscape(netName)
+}
+
+func networkBlocksKey(netName string) string {
+	return ipamBlocksKey + "/" + url.PathEscape(netName)
+}
+
+func blockKey(netName string, cidr CIDR) string {
+	return networkBlocksKey(netName) + "/" + strings.Replace(cidr.String(), "/", "-", 1)
+}
+
+func addressKey(addressName string) string {
+	return ipamAddressesKey + "/" + url.PathEscape(addressName)
+}
+
+// isShardKey returns true if key holds a part of IPAM.
+func isShardKey(key string) bool {
+	if key == ipamMetaKey {
+		return true
+	}
+	for _, dir := range []string{ipamNetworksKey, ipamBlocksKey, ipamAddressesKey} {
+		if strings.HasPrefix(key, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// withoutPools returns a copy of the group in which blocks only
+// have CIDRs, as pools are stored under keys of their own.
+func (hg *Group) withoutPools() *Group {
+	group := *hg
+	if hg.Blocks != nil {
+		group.Blocks = make([]*Block, len(hg.Blocks))
+		for i, block := range hg.Blocks {
+			group.Blocks[i] = &Block{CIDR: block.CIDR}
+		}
+	}
+	if hg.Groups != nil {
+		group.Groups = make([]*Group, len(hg.Groups))
+		for i, subgroup := range hg.Groups {
+			group.Groups[i] = subgroup.withoutPools()
+		}
+	}
+	return &group
+}
+
+// marshalShards returns JSON of every key IPAM is stored under.
+func (ipam *IPAM) marshalShards() (map[string][]byte, error) {
+	shards := make(map[string][]byte)
+	put := func(key string, v interface{}) error {
+		b, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		shards[key] = b
+		return nil
+	}
+
+	err := put(ipamMetaKey, ipamMeta{
+		TopologyRevision: ipam.TopologyRevision,
+		TenantToNetwork:  ipam.TenantToNetwork,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for name, network := range ipam.Networks {
+		n := *network
+		// Revision of the network is derived from the indexes
+		// of its keys, see parseShards.
+		n.Revison = 0
+		if network.Group != nil {
+			n.Group = network.Group.withoutPools()
+			for _, block := range network.Group.ListBlocks() {
+				err = put(blockKey(name, block.CIDR), block)
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+		err = put(networkKey(name), n)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for name, ip := range ipam.AddressNameToIP {
+		err = put(addressKey(name), ipamAddress{IP: ip, SecondaryIP: ipam.AddressNameToSecondaryIP[name]})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return shards, nil
+}
+
+// shardsRevision returns the highest store index of the keys.
+func shardsRevision(shards map[string]*libkvStore.KVPair) uint64 {
+	var revision uint64
+	for _, kv := range shards {
+		if kv.LastIndex > revision {
+			revision = kv.LastIndex
+		}
+	}
+	return revision
+}
+
+// parseShards restores IPAM from its keys. AllocationRevision and
+// revisions of networks are the highest store indexes of their keys.
+func parseShards(shards map[string]*libkvStore.KVPair) (*IPAM, error) {
+	ipam := &IPAM{}
+	ipam.clearIPAM()
+
+	ipam.AllocationRevision = int(shardsRevision(shards))
+
+	if kv, ok := shards[ipamMetaKey]; ok {
+		meta := ipamMeta{}
+		err := json.Unmarshal(kv.Value, &meta)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing %s: %s", ipamMetaKey, err)
+		}
+		ipam.TopologyRevision = meta.TopologyRevision
+		if meta.TenantToNetwork != nil {
+			ipam.TenantToNetwork = meta.TenantToNetwork
+		}
+	}
+
+	for key, kv := range shards {
+		switch {
+		case strings.HasPrefix(key, ipamNetworksKey+"/"):
+			network := &Network{}
+			err := json.Unmarshal(kv.Value, network)
+			if err != nil {
+				return nil, fmt.Errorf("error parsing %s: %s", key, err)
+			}
+			revision := kv.LastIndex
+			if network.Group != nil {
+				for _, block := range network.Group.ListBlocks() {
+					blockKV, ok := shards[blockKey(network.Name, block.CIDR)]
+					if !ok {
+						// Blocks are written after the network that
+						// references them, so this one has no addresses yet.
+						*block = *newBlock(block.CIDR)
+						continue
+					}
+					err = json.Unmarshal(blockKV.Value, block)
+					if err != nil {
+						return nil, fmt.Errorf("error parsing %s: %s", blockKV.Key, err)
+					}
+					if blockKV.LastIndex > revision {
+						revision = blockKV.LastIndex
+					}
+				}
+			}
+			network.Revison = int(revision)
+			ipam.Networks[network.Name] = network
+
+		case strings.HasPrefix(key, ipamAddressesKey+"/"):
+			name, err := url.PathUnescape(strings.TrimPrefix(key, ipamAddressesKey+"/"))
+			if err != nil {
+				return nil, fmt.Errorf("error parsing address name of %s: %s", key, err)
+			}
+			addr := ipamAddress{}
+			err = json.Unmarshal(kv.Value, &addr)
+			if err != nil {
+				return nil, fmt.Errorf("error parsing %s: %s", key, err)
+			}
+			ipam.AddressNameToIP[name] = addr.IP
+			if addr.SecondaryIP != nil {
+				ipam.AddressNameToSecondaryIP[name] = addr.SecondaryIP
+			}
+		}
+	}
+
+	ipam.injectParents()
+	ipam.locker = newMutexLocker()
+	return ipam, nil
+}
+
+// shardWrite is a change of a single IPAM key.
+type shardWrite struct {
+	key string
+	// value is nil if the key is deleted.
+	value []byte
+	// previous is nil if the key is created.
+	previous *libkvStore.KVPair
+}
+
+// shardOrder orders writes of IPAM keys: networks are written
+// before their blocks, and meta after everything else, so that
+// meta only exists once IPAM was completely written.
+func shardOrder(key string) int {
+	switch {
+	case strings.HasPrefix(key, ipamNetworksKey+"/"):
+		return 0
+	case strings.HasPrefix(key, ipamBlocksKey+"/"):
+		return 1
+	case strings.HasPrefix(key, ipamAddressesKey+"/"):
+		return 2
+	}
+	return 3
+}
+
+// diffShards returns writes that turn base into shards. Keys are
+// created and updated first, and deleted in reverse order afterwards.
+func diffShards(base map[string]*libkvStore.KVPair, shards map[string][]byte) []shardWrite {
+	var puts, deletes []shardWrite
+	for key, value := range shards {
+		previous := base[key]
+		if previous != nil && bytes.Equal(previous.Value, value) {
+			continue
+		}
+		puts = append(puts, shardWrite{key: key, value: value, previous: previous})
+	}
+	for key, previous := range base {
+		if _, ok := shards[key]; !ok {
+			deletes = append(deletes, shardWrite{key: key, previous: previous})
+		}
+	}
+
+	sort.Slice(puts, func(i, j int) bool {
+		if shardOrder(puts[i].key) != shardOrder(puts[j].key) {
+			return shardOrder(puts[i].key) < shardOrder(puts[j].key)
+		}
+		return puts[i].key < puts[j].key
+	})
+	sort.Slice(deletes, func(i, j int) bool {
+		if shardOrder(deletes[i].key) != shardOrder(deletes[j].key) {
+			return shardOrder(deletes[i].key) > shardOrder(deletes[j].key)
+		}
+		return deletes[i].key < deletes[j].key
+	})
+
+	return append(puts, deletes...)
+}
+
+// isConflict returns true if the atomic operation failed because
+// the key is not at the expected version.
+func isConflict(err error) bool {
+	switch err {
+	case libkvStore.ErrKeyModified, libkvStore.ErrKeyExists, libkvStore.ErrKeyNotFound:
+		return true
+	}
+	return false
+}
+
+// writeShard applies the write, returning the new version
+// of the key, or nil if the key was deleted.
+func (s *Store) writeShard(w shardWrite) (*libkvStore.KVPair, error) {
+	key := s.getKey(w.key)
+	if w.value == nil {
+		ok, err := s.Store.AtomicDelete(key, w.previous)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, libkvStore.ErrKeyModified
+		}
+		return nil, nil
+	}
+
+	ok, kv, err := s.Store.AtomicPut(key, w.value, w.previous, nil)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, libkvStore.ErrKeyModified
+	}
+	return &libkvStore.KVPair{Key: w.key, Value: w.value, LastIndex: kv.LastIndex}, nil
+}
+
+// writeShards applies writes in order. If one of them fails, writes
+// already applied are reverted, unless the keys were modified again
+// in the meantime, and ipamConflictError is returned if the failure
+// was caused by a concurrent update.
+func (c *Client) writeShards(writes []shardWrite) (map[string]*libkvStore.KVPair, error) {
+	written := make(map[string]*libkvStore.KVPair)
+	for i, w := range writes {
+		kv, err := c.Store.writeShard(w)
+		if err == nil {
+			written[w.key] = kv
+			continue
+		}
+
+		log.Debugf("Failed to write IPAM key %s: %s, reverting %d writes", w.key, err, i)
+		refresh := []string{w.key}
+		for j := i - 1; j >= 0; j-- {
+			undo := shardWrite{key: writes[j].key, previous: written[writes[j].key]}
+			if writes[j].previous != nil {
+				undo.value = writes[j].previous.Value
+			}
+			if _, undoErr := c.Store.writeShard(undo); undoErr != nil {
+				log.Errorf("Failed to revert IPAM key %s: %s", undo.key, undoErr)
+			}
+			refresh = append(refresh, undo.key)
+		}
+		for _, key := range refresh {
+			if refreshErr := c.refreshShard(key); refreshErr != nil {
+				log.Errorf("Failed to reload IPAM key %s: %s", key, refreshErr)
+			}
+		}
+
+		if isConflict(err) {
+			return nil, ipamConflictError{key: w.key}
+		}
+		return nil, err
+	}
+	return written, nil
+}
+
+// readShards reads all IPAM keys from the store.
+func (c *Client) readShards() (map[string]*libkvStore.KVPair, error) {
+	shards := make(map[string]*libkvStore.KVPair)
+
+	kv, err := c.Store.GetObject(ipamMetaKey)
+	if err != nil {
+		return nil, err
+	}
+	if kv != nil {
+		shards[ipamMetaKey] = &libkvStore.KVPair{Key: ipamMetaKey, Value: kv.Value, LastIndex: kv.LastIndex}
+	}
+
+	networks := make(map[string]*libkvStore.KVPair)
+	err = c.listShards(ipamNetworksKey, networks)
+	if err != nil {
+		return nil, err
+	}
+	for key, kv := range networks {
+		shards[key] = kv
+		// Network names in the keys are escaped already.
+		err = c.listShards(ipamBlocksKey+"/"+path.Base(key), shards)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = c.listShards(ipamAddressesKey, shards)
+	if err != nil {
+		return nil, err
+	}
+	return shards, nil
+}
+
+// listShards adds keys found in the directory to shards.
+func (c *Client) listShards(dir string, shards map[string]*libkvStore.KVPair) error {
+	kvs, err := c.Store.ListObjects(dir)
+	if err == libkvStore.ErrKeyNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, kv := range kvs {
+		key := dir + "/" + path.Base(kv.Key)
+		shards[key] = &libkvStore.KVPair{Key: key, Value: kv.Value, LastIndex: kv.LastIndex}
+	}
+	return nil
+}
+
+// refreshShard reloads the key from the store into the cache.
+func (c *Client) refreshShard(key string) error {
+	kv, err := c.Store.GetObject(key)
+	if err != nil {
+		return err
+	}
+	c.savingMutex.Lock()
+	defer c.savingMutex.Unlock()
+	if kv == nil {
+		// The version of the deletion is not known, anything
+		// the cache has seen so far is older.
+		if cached, ok := c.shards[key]; ok {
+			c.applyShard(&libkvStore.KVPair{Key: key, LastIndex: cached.LastIndex})
+		}
+		return nil
+	}
+	c.applyShard(&libkvStore.KVPair{Key: key, Value: kv.Value, LastIndex: kv.LastIndex})
+	return nil
+}
+
+// applyShard records the version of the key in the cache, unless
+// a newer one was seen already. KVPair without value records
+// deletion of the key. Callers must hold savingMutex.
+func (c *Client) applyShard(kv *libkvStore.KVPair) {
+	if cached, ok := c.shards[kv.Key]; ok && cached.LastIndex > kv.LastIndex {
+		return
+	}
+	if deleted, ok := c.deletedShards[kv.Key]; ok && deleted >= kv.LastIndex {
+		return
+	}
+	if kv.Value == nil {
+		delete(c.shards, kv.Key)
+		c.deletedShards[kv.Key] = kv.LastIndex
+		return
+	}
+	c.shards[kv.Key] = kv
+	delete(c.deletedShards, kv.Key)
+}
+
+// snapshotShards returns a copy of the cache. Callers must hold
+// savingMutex.
+func (c *Client) snapshotShards() map[string]*libkvStore.KVPair {
+	shards := make(map[string]*libkvStore.KVPair, len(c.shards))
+	for key, kv := range c.shards {
+		shards[key] = kv
+	}
+	return shards
+}
+
+// resetShards replaces the cache with keys read from the store.
+// Callers must hold savingMutex.
+func (c *Client) resetShards(shards map[string]*libkvStore.KVPair) {
+	c.shards = shards
+	c.deletedShards = make(map[string]uint64)
+}
+
+// syncShards starts watching IPAM keys and then reloads the cache,
+// so that keys changed while they are read are caught by the watch.
+func (c *Client) syncShards() (<-chan *libkvStore.KVPairExt, chan struct{}, error) {
+	stopCh := make(chan struct{})
+	options := libkvStore.WatcherOptions{Recursive: true, NoList: true}
+	ch, err := c.Store.WatchExt(c.Store.getKey(ipamKey), options, stopCh)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	shards, err := c.readShards()
+	if err != nil {
+		close(stopCh)
+		return nil, nil, err
+	}
+	c.savingMutex.Lock()
+	c.resetShards(shards)
+	c.refreshIPAM()
+	c.savingMutex.Unlock()
+	return ch, stopCh, nil
+}
+
+// applyEvent applies the change reported by the watch to the cache.
+// It returns false if the change cannot be applied to single keys,
+// e.g. when a directory was deleted. Callers must hold savingMutex.
+func (c *Client) applyEvent(event *libkvStore.KVPairExt) bool {
+	key := c.Store.relativeKey(event.Key)
+	log.Tracef(trace.Inside, "applyEvent: %s %s (%d)", event.Action, key, event.LastIndex)
+
+	// Deletions older than the event won't be reported anymore.
+	for deletedKey, index := range c.deletedShards {
+		if index < event.LastIndex {
+			delete(c.deletedShards, deletedKey)
+		}
+	}
+
+	switch event.Action {
+	case "set", "update", "create", "compareAndSwap":
+		if !event.Dir && isShardKey(key) {
+			c.applyShard(&libkvStore.KVPair{Key: key, Value: []byte(event.Value), LastIndex: event.LastIndex})
+		}
+	case "delete", "compareAndDelete", "expire":
+		if event.Dir {
+			return false
+		}
+		if isShardKey(key) {
+			c.applyShard(&libkvStore.KVPair{Key: key, LastIndex: event.LastIndex})
+		}
+	}
+	return true
+}
+
+// newIPAM parses IPAM from the keys and connects it to the client.
+func (c *Client) newIPAM(shards map[string]*libkvStore.KVPair) (*IPAM, error) {
+	ipam, err := parseShards(shards)
+	if err != nil {
+		return nil, err
+	}
+	c.attachIPAM(ipam)
+	ipam.shards = shards
+	return ipam, nil
+}
+
+// attachIPAM makes IPAM use the client for loading, saving and locking.
+func (c *Client) attachIPAM(ipam *IPAM) {
+	ipam.save = c.save
+	ipam.load = c.load
+	ipam.locker = c.ipamLocker
+	ipam.hostLocker = c.allocationLocker
+}
+
+// allocationLocker returns the locker serializing allocations on the
+// host within this client. Allocations on other hosts, or by other
+// clients, proceed concurrently and conflicts are detected on save.
+func (c *Client) allocationLocker(host string) Locker {
+	c.allocationMutex.Lock()
+	defer c.allocationMutex.Unlock()
+	locker, ok := c.allocationLockers[host]
+	if !ok {
+		locker = newMutexLocker()
+		c.allocationLockers[host] = locker
+	}
+	return locker
+}
+
+// refreshIPAM rebuilds IPAM of the client from the cache and passes
+// it to subscribers. Callers must hold savingMutex.
+func (c *Client) refreshIPAM() {
+	ipam, err := c.newIPAM(c.snapshotShards())
+	if err != nil {
+		log.Errorf("Error parsing IPAM: %s", err)
+		return
+	}
+	c.IPAM = ipam
+	log.Tracef(trace.Inside, "Reloaded IPAM with revision %d", ipam.AllocationRevision)
+
+	for ch := range c.ipamSubscribers {
+		// Subscribers only need the latest IPAM.
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- ipam
+	}
+}
+
+// subscribeIPAM returns a channel receiving IPAM of the client every
+// time it changes, starting with the current one.
+func (c *Client) subscribeIPAM(stopCh <-chan struct{}) <-chan *IPAM {
+	ch := make(chan *IPAM, 1)
+	c.savingMutex.Lock()
+	c.ipamSubscribers[ch] = struct{}{}
+	ch <- c.IPAM
+	c.savingMutex.Unlock()
+
+	go func() {
+		<-stopCh
+		c.savingMutex.Lock()
+		delete(c.ipamSubscribers, ch)
+		c.savingMutex.Unlock()
+	}()
+	return ch
+}
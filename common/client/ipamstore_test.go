@@ -0,0 +1,211 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package client
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	libkvStore "github.com/docker/libkv/store"
+)
+
+// storedShards returns shards as they would be read from the store,
+// all at the provided index.
+func storedShards(t *testing.T, ipam *IPAM, index uint64) map[string]*libkvStore.KVPair {
+	shards, err := ipam.marshalShards()
+	if err != nil {
+		t.Fatal(err)
+	}
+	kvs := make(map[string]*libkvStore.KVPair)
+	for key, value := range shards {
+		kvs[key] = &libkvStore.KVPair{Key: key, Value: value, LastIndex: index}
+	}
+	return kvs
+}
+
+func TestIPAMShards(t *testing.T) {
+	ipam = initIpam(t, string(loadTestFile(t, twoHostsTopologyFile)))
+	for _, addr := range [][2]string{{"a", "host1"}, {"b", "host1"}, {"c", "host2"}} {
+		_, err := ipam.AllocateIP(addr[0], addr[1], "ten1", "seg1")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	ipam.load(ipam, nil)
+
+	kvs := storedShards(t, ipam, 7)
+	for _, key := range []string{
+		ipamMetaKey,
+		"/ipam/networks/net1",
+		"/ipam/blocks/net1/10.0.0.0-30",
+		"/ipam/blocks/net1/10.0.0.4-30",
+		"/ipam/addresses/a",
+	} {
+		if _, ok := kvs[key]; !ok {
+			t.Errorf("Expected key %s in %v", key, kvs)
+		}
+	}
+
+	parsed, err := parseShards(kvs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(parsed.AddressNameToIP, ipam.AddressNameToIP) {
+		t.Errorf("Expected addresses %v, got %v", ipam.AddressNameToIP, parsed.AddressNameToIP)
+	}
+	if parsed.AllocationRevision != 7 || parsed.Networks["net1"].Revison != 7 {
+		t.Errorf("Expected revision 7, got %d and %d", parsed.AllocationRevision, parsed.Networks["net1"].Revison)
+	}
+
+	expected := ipam.ListAllBlocks().Blocks
+	got := parsed.ListAllBlocks().Blocks
+	if len(got) != len(expected) {
+		t.Fatalf("Expected blocks %v, got %v", expected, got)
+	}
+	for i := range expected {
+		if got[i].CIDR.String() != expected[i].CIDR.String() ||
+			got[i].Host != expected[i].Host ||
+			got[i].AllocatedIPCount != expected[i].AllocatedIPCount {
+			t.Errorf("Expected block %v, got %v", expected[i], got[i])
+		}
+	}
+
+	// Blocks are written after their network, so a block
+	// that was not written yet is empty.
+	delete(kvs, "/ipam/blocks/net1/10.0.0.4-30")
+	parsed, err = parseShards(kvs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, block := range parsed.ListAllBlocks().Blocks {
+		if block.CIDR.String() == "10.0.0.4/30" && block.AllocatedIPCount != 0 {
+			t.Errorf("Expected empty block, got %v", block)
+		}
+	}
+}
+
+func TestDiffShards(t *testing.T) {
+	ipam = initIpam(t, string(loadTestFile(t, twoHostsTopologyFile)))
+	_, err := ipam.AllocateIP("a", "host1", "ten1", "seg1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ipam.load(ipam, nil)
+	base := storedShards(t, ipam, 1)
+
+	keys := func(writes []shardWrite) []string {
+		result := make([]string, len(writes))
+		for i, w := range writes {
+			result[i] = w.key
+			if w.value == nil {
+				result[i] = "-" + w.key
+			}
+		}
+		return result
+	}
+
+	for _, tc := range []struct {
+		name   string
+		update func(*IPAM) error
+		expect []string
+	}{
+		{
+			name: "allocate in existing block",
+			update: func(ipam *IPAM) error {
+				_, err := ipam.AllocateIP("b", "host1", "ten1", "seg1")
+				return err
+			},
+			expect: []string{"/ipam/blocks/net1/10.0.0.0-30", "/ipam/addresses/b"},
+		},
+		{
+			name: "allocate in new block",
+			update: func(ipam *IPAM) error {
+				_, err := ipam.AllocateIP("b", "host2", "ten1", "seg1")
+				return err
+			},
+			expect: []string{"/ipam/networks/net1", "/ipam/blocks/net1/10.0.0.4-30", "/ipam/addresses/b"},
+		},
+		{
+			name: "deallocate",
+			update: func(ipam *IPAM) error {
+				return ipam.DeallocateIP("a")
+			},
+			expect: []string{"/ipam/networks/net1", "/ipam/blocks/net1/10.0.0.0-30", "-/ipam/addresses/a"},
+		},
+	} {
+		// Start every case from the base state.
+		parsed, err := parseShards(base)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parsed.save = testSaver.save
+		parsed.load = testSaver.load
+		parsed.save(parsed, nil)
+
+		err = tc.update(parsed)
+		if err != nil {
+			t.Fatalf("%s: %s", tc.name, err)
+		}
+		parsed.load(parsed, nil)
+		shards, err := parsed.marshalShards()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := keys(diffShards(base, shards))
+		if !reflect.DeepEqual(got, tc.expect) {
+			t.Errorf("%s: expected writes %v, got %v", tc.name, tc.expect, got)
+		}
+	}
+}
+
+// conflictingSaver fails the first save with ipamConflictError.
+type conflictingSaver struct {
+	saves int
+}
+
+func (s *conflictingSaver) save(ipam *IPAM, ch <-chan struct{}) error {
+	s.saves++
+	if s.saves == 1 {
+		return ipamConflictError{key: addressKey("a")}
+	}
+	return testSaver.save(ipam, ch)
+}
+
+func TestUpdateAllocationsConflict(t *testing.T) {
+	ipam = initIpam(t, string(loadTestFile(t, twoHostsTopologyFile)))
+	saver := &conflictingSaver{}
+	ipam.save = saver.save
+	var hosts []string
+	ipam.hostLocker = func(host string) Locker {
+		hosts = append(hosts, host)
+		return newMutexLocker()
+	}
+
+	ip, err := ipam.AllocateIP("a", "host1", "ten1", "seg1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saver.saves != 2 {
+		t.Errorf("Expected allocation to be saved on second attempt, got %d attempts", saver.saves)
+	}
+	if !ip.Equal(net.ParseIP("10.0.0.0")) {
+		t.Errorf("Expected 10.0.0.0, got %s", ip)
+	}
+	if !reflect.DeepEqual(hosts, []string{"host1"}) {
+		t.Errorf("Expected lock of host1, got %v", hosts)
+	}
+}
@@ -0,0 +1,494 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package client
+
+import (
+	"fmt"
+	"net"
+	"sort"
+
+	"github.com/romana/core/common/api"
+	log "github.com/romana/rlog"
+)
+
+// Verify checks that the redundant indexes of blocks in groups, the
+// pools of blocks and the address names of IPAM agree with each other,
+// and returns the problems found.
+func (ipam *IPAM) Verify() []api.IPAMProblem {
+	problems := make([]api.IPAMProblem, 0)
+	report := func(kind string, network string, block string, address string, format string, args ...interface{}) {
+		problems = append(problems, api.IPAMProblem{
+			Kind:    kind,
+			Network: network,
+			Block:   block,
+			Address: address,
+			Details: fmt.Sprintf(format, args...),
+		})
+	}
+
+	names := ipam.namesByIP()
+	for _, network := range ipam.sortedNetworks() {
+		if network.Group != nil {
+			network.Group.verify(network, names, report)
+		}
+	}
+
+	for _, addresses := range []map[string]net.IP{ipam.AddressNameToIP, ipam.AddressNameToSecondaryIP} {
+		for _, name := range sortedAddressNames(addresses) {
+			ip := addresses[name]
+			network := ipam.networkOfIP(ip)
+			if network == nil {
+				report(api.IPAMProblemNameOutsideNetwork, "", "", name,
+					"IP %s is not within any network", ip)
+				continue
+			}
+			group, id := network.Group.findIPBlock(ip)
+			if group == nil || !group.Blocks[id].isAllocated(ip) {
+				report(api.IPAMProblemUnallocatedName, network.Name, "", name,
+					"IP %s is not allocated in any block", ip)
+			}
+			if cidr := network.blackedOutBy(ip); cidr != nil {
+				report(api.IPAMProblemBlackedOut, network.Name, "", name,
+					"IP %s is blacked out by %s", ip, cidr)
+			}
+		}
+	}
+	return problems
+}
+
+// verify checks blocks of the group and its subgroups, see Verify.
+func (hg *Group) verify(network *Network, names map[string]string, report func(string, string, string, string, string, ...interface{})) {
+	hosts := make(map[string]bool)
+	for _, host := range hg.Hosts {
+		if host != nil {
+			hosts[host.Name] = true
+		}
+	}
+	isBlockID := func(id int) bool {
+		return id >= 0 && id < len(hg.Blocks)
+	}
+
+	reusable := make(map[int]bool)
+	for _, id := range hg.ReusableBlocks {
+		if !isBlockID(id) {
+			report(api.IPAMProblemIndex, network.Name, "", "",
+				"reusable_blocks of group %s refers to missing block %d", hg.Name, id)
+		} else if reusable[id] {
+			report(api.IPAMProblemIndex, network.Name, hg.Blocks[id].CIDR.String(), "",
+				"block is listed more than once in reusable_blocks")
+		}
+		reusable[id] = true
+	}
+	for id := range hg.BlockToOwner {
+		if !isBlockID(id) {
+			report(api.IPAMProblemIndex, network.Name, "", "",
+				"block_to_owner of group %s refers to missing block %d", hg.Name, id)
+		}
+	}
+	for id := range hg.BlockToHost {
+		if !isBlockID(id) {
+			report(api.IPAMProblemIndex, network.Name, "", "",
+				"block_to_host of group %s refers to missing block %d", hg.Name, id)
+		}
+	}
+	for _, owner := range sortedOwners(hg.OwnerToBlocks) {
+		for _, id := range hg.OwnerToBlocks[owner] {
+			if !isBlockID(id) {
+				report(api.IPAMProblemIndex, network.Name, "", "",
+					"owner_to_block of group %s refers to missing block %d", hg.Name, id)
+			} else if hg.BlockToOwner[id] != owner {
+				report(api.IPAMProblemIndex, network.Name, hg.Blocks[id].CIDR.String(), "",
+					"owner_to_block lists block under %s, but block_to_owner has %q", owner, hg.BlockToOwner[id])
+			}
+		}
+	}
+
+	for id, block := range hg.Blocks {
+		if block == nil || block.Pool == nil {
+			report(api.IPAMProblemIndex, network.Name, "", "",
+				"block %d of group %s has no pool", id, hg.Name)
+			continue
+		}
+		cidr := block.CIDR.String()
+		owner, hasOwner := hg.BlockToOwner[id]
+		host, hasHost := hg.BlockToHost[id]
+
+		switch {
+		case hasOwner && !hasHost:
+			report(api.IPAMProblemIndex, network.Name, cidr, "",
+				"block is owned by %s but has no host", owner)
+		case hasHost && !hasOwner:
+			report(api.IPAMProblemIndex, network.Name, cidr, "",
+				"block is on host %s but has no owner", host)
+		}
+		if hasOwner && !containsInt(hg.OwnerToBlocks[owner], id) {
+			report(api.IPAMProblemIndex, network.Name, cidr, "",
+				"block is owned by %s but not listed in its owner_to_block", owner)
+		}
+		if (hasOwner || hasHost) && reusable[id] {
+			report(api.IPAMProblemIndex, network.Name, cidr, "",
+				"block is owned but listed in reusable_blocks")
+		}
+		if hasHost && !hosts[host] {
+			report(api.IPAMProblemRemovedHost, network.Name, cidr, "",
+				"block is owned by host %s which is not in group %s", host, hg.Name)
+		}
+
+		allocated := block.allocatedIPs()
+		if !hasOwner && !hasHost {
+			if !reusable[id] {
+				report(api.IPAMProblemOrphanedBlock, network.Name, cidr, "",
+					"block has no owner and is not in reusable_blocks")
+			}
+			if len(allocated) > 0 {
+				report(api.IPAMProblemOrphanedBlock, network.Name, cidr, "",
+					"block has no owner but %d addresses allocated", len(allocated))
+			}
+		}
+		for _, ip := range allocated {
+			if _, ok := names[ip.String()]; !ok {
+				report(api.IPAMProblemUnnamedIP, network.Name, cidr, ip.String(),
+					"IP is allocated but has no address name")
+			}
+		}
+	}
+
+	for _, group := range hg.Groups {
+		if group != nil {
+			group.verify(network, names, report)
+		}
+	}
+}
+
+// Repair repairs the problems found by Verify where IPAM itself
+// has enough information to do so, and returns all the problems
+// found with the repaired ones marked. Allocations of blacked out
+// IPs and of blocks without an owner are left to the administrator.
+func (ipam *IPAM) Repair() ([]api.IPAMProblem, error) {
+	var problems []api.IPAMProblem
+	// Host of the problems is not known until IPAM is loaded,
+	// so repairs share the locker of an unnamed host.
+	err := ipam.updateAllocations("", func(latestIPAM *IPAM) error {
+		problems = latestIPAM.Verify()
+		if len(problems) == 0 {
+			return nil
+		}
+		latestIPAM.repair()
+
+		remaining := make(map[string]bool)
+		for _, problem := range latestIPAM.Verify() {
+			remaining[problemKey(problem)] = true
+		}
+		for i := range problems {
+			problems[i].Repaired = !remaining[problemKey(problems[i])]
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("IPAM repair found %d problems", len(problems))
+	return problems, nil
+}
+
+func problemKey(problem api.IPAMProblem) string {
+	return problem.Kind + " " + problem.Network + " " + problem.Block + " " + problem.Address
+}
+
+// repair releases blocks of removed hosts, drops names that are
+// outside of all networks, makes pools agree with address names
+// and rebuilds indexes of blocks from block_to_owner and block_to_host.
+func (ipam *IPAM) repair() {
+	for _, network := range ipam.sortedNetworks() {
+		if network.Group != nil {
+			network.Group.releaseRemovedHostBlocks(ipam)
+		}
+	}
+
+	for _, addresses := range []map[string]net.IP{ipam.AddressNameToIP, ipam.AddressNameToSecondaryIP} {
+		for _, name := range sortedAddressNames(addresses) {
+			ip := addresses[name]
+			network := ipam.networkOfIP(ip)
+			if network == nil {
+				log.Infof("Repair: removing address %s (%s) that is not within any network", name, ip)
+				ipam.removeAddressName(name)
+				continue
+			}
+			group, id := network.Group.findIPBlock(ip)
+			if group == nil || group.Blocks[id].Pool == nil || group.Blocks[id].isAllocated(ip) {
+				continue
+			}
+			// Only the host of the block can be using the IP.
+			block := group.Blocks[id]
+			if _, ok := group.BlockToHost[id]; ok {
+				log.Infof("Repair: allocating %s of address %s in block %s", ip, name, block.CIDR)
+				if err := block.Pool.GetSpecificID(block.ipToID(ip)); err == nil {
+					block.Revision++
+				}
+			}
+		}
+	}
+
+	names := ipam.namesByIP()
+	for _, network := range ipam.sortedNetworks() {
+		if network.Group == nil {
+			continue
+		}
+		for _, block := range network.Group.ListBlocks() {
+			if block == nil || block.Pool == nil {
+				continue
+			}
+			for _, ip := range block.allocatedIPs() {
+				if _, ok := names[ip.String()]; !ok {
+					log.Infof("Repair: deallocating unnamed IP %s in block %s", ip, block.CIDR)
+					if err := block.Pool.ReclaimID(block.ipToID(ip)); err == nil {
+						block.Revision++
+					}
+				}
+			}
+		}
+		network.Group.rebuildIndexes()
+	}
+}
+
+// releaseRemovedHostBlocks returns blocks owned by hosts that are
+// no longer in the group for reuse, along with addresses in them.
+// Blocks that have an owner but no host are released too, as
+// RemoveHost only removes the host of the blocks.
+func (hg *Group) releaseRemovedHostBlocks(ipam *IPAM) {
+	hosts := make(map[string]bool)
+	for _, host := range hg.Hosts {
+		if host != nil {
+			hosts[host.Name] = true
+		}
+	}
+	for id, block := range hg.Blocks {
+		if block == nil || block.Pool == nil {
+			continue
+		}
+		host, hasHost := hg.BlockToHost[id]
+		_, hasOwner := hg.BlockToOwner[id]
+		if hosts[host] || (!hasHost && !hasOwner) {
+			continue
+		}
+		log.Infof("Repair: releasing block %s of removed host %s", block.CIDR, host)
+		for _, addresses := range []map[string]net.IP{ipam.AddressNameToIP, ipam.AddressNameToSecondaryIP} {
+			for name, ip := range addresses {
+				if ip != nil && block.CIDR.ContainsIP(ip) {
+					ipam.removeAddressName(name)
+				}
+			}
+		}
+		block.clear()
+		block.Revision++
+		delete(hg.BlockToHost, id)
+		delete(hg.BlockToOwner, id)
+	}
+
+	for _, group := range hg.Groups {
+		if group != nil {
+			group.releaseRemovedHostBlocks(ipam)
+		}
+	}
+}
+
+// rebuildIndexes makes owner_to_block and reusable_blocks of the group
+// and its subgroups agree with block_to_owner and block_to_host.
+func (hg *Group) rebuildIndexes() {
+	hg.groupStructuresInit(false)
+	isBlock := func(id int) bool {
+		return id >= 0 && id < len(hg.Blocks) && hg.Blocks[id] != nil && hg.Blocks[id].Pool != nil
+	}
+
+	for id := range hg.BlockToOwner {
+		if !isBlock(id) {
+			delete(hg.BlockToOwner, id)
+		}
+	}
+	for id := range hg.BlockToHost {
+		if !isBlock(id) {
+			delete(hg.BlockToHost, id)
+		}
+	}
+	// An empty block with only one of owner and host
+	// can be safely returned for reuse.
+	for id, block := range hg.Blocks {
+		if !isBlock(id) || len(block.allocatedIPs()) > 0 {
+			continue
+		}
+		_, hasOwner := hg.BlockToOwner[id]
+		_, hasHost := hg.BlockToHost[id]
+		if hasOwner != hasHost {
+			delete(hg.BlockToOwner, id)
+			delete(hg.BlockToHost, id)
+		}
+	}
+
+	// Existing order of the indexes is kept, as blocks
+	// are allocated and reused in that order.
+	ownerToBlocks := make(map[string][]int)
+	listed := make(map[int]bool)
+	for _, owner := range sortedOwners(hg.OwnerToBlocks) {
+		for _, id := range hg.OwnerToBlocks[owner] {
+			if isBlock(id) && !listed[id] && hg.BlockToOwner[id] == owner {
+				ownerToBlocks[owner] = append(ownerToBlocks[owner], id)
+				listed[id] = true
+			}
+		}
+	}
+	for id := range hg.Blocks {
+		if owner, ok := hg.BlockToOwner[id]; ok && !listed[id] {
+			ownerToBlocks[owner] = append(ownerToBlocks[owner], id)
+		}
+	}
+	hg.OwnerToBlocks = ownerToBlocks
+
+	isFree := func(id int) bool {
+		_, hasOwner := hg.BlockToOwner[id]
+		_, hasHost := hg.BlockToHost[id]
+		return isBlock(id) && !hasOwner && !hasHost
+	}
+	reusableBlocks := make([]int, 0)
+	listed = make(map[int]bool)
+	for _, id := range hg.ReusableBlocks {
+		if isFree(id) && !listed[id] {
+			reusableBlocks = append(reusableBlocks, id)
+			listed[id] = true
+		}
+	}
+	for id, block := range hg.Blocks {
+		if isFree(id) && !listed[id] && len(block.allocatedIPs()) == 0 {
+			reusableBlocks = append(reusableBlocks, id)
+		}
+	}
+	hg.ReusableBlocks = reusableBlocks
+
+	for _, group := range hg.Groups {
+		if group != nil {
+			group.rebuildIndexes()
+		}
+	}
+}
+
+// removeAddressName removes all IPs named by the address name.
+func (ipam *IPAM) removeAddressName(name string) {
+	delete(ipam.AddressNameToIP, name)
+	delete(ipam.AddressNameToSecondaryIP, name)
+}
+
+// namesByIP maps IPs to address names.
+func (ipam *IPAM) namesByIP() map[string]string {
+	names := make(map[string]string)
+	for _, addresses := range []map[string]net.IP{ipam.AddressNameToIP, ipam.AddressNameToSecondaryIP} {
+		for name, ip := range addresses {
+			names[ip.String()] = name
+		}
+	}
+	return names
+}
+
+func (ipam *IPAM) sortedNetworks() []*Network {
+	names := make([]string, 0, len(ipam.Networks))
+	for name := range ipam.Networks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	networks := make([]*Network, 0, len(names))
+	for _, name := range names {
+		if network := ipam.Networks[name]; network != nil {
+			networks = append(networks, network)
+		}
+	}
+	return networks
+}
+
+func sortedAddressNames(addresses map[string]net.IP) []string {
+	names := make([]string, 0, len(addresses))
+	for name := range addresses {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func sortedOwners(ownerToBlocks map[string][]int) []string {
+	owners := make([]string, 0, len(ownerToBlocks))
+	for owner := range ownerToBlocks {
+		owners = append(owners, owner)
+	}
+	sort.Strings(owners)
+	return owners
+}
+
+func containsInt(ints []int, n int) bool {
+	for _, i := range ints {
+		if i == n {
+			return true
+		}
+	}
+	return false
+}
+
+// isAllocated returns true if the IP is allocated in the block's pool.
+func (b Block) isAllocated(ip net.IP) bool {
+	if b.Pool == nil {
+		return false
+	}
+	id := b.ipToID(ip)
+	if id < b.Pool.OrigMin || id > b.Pool.OrigMax {
+		return false
+	}
+	for _, r := range b.Pool.Ranges {
+		if r.Min <= id && id <= r.Max {
+			return false
+		}
+	}
+	return true
+}
+
+// allocatedIPs lists IPs allocated in the block's pool, up to
+// maxListedAddresses of them. Unlike Pool.Invert it does not
+// assume that the first range of the pool starts after OrigMin.
+func (b Block) allocatedIPs() []net.IP {
+	ips := make([]net.IP, 0)
+	add := func(min uint64, max uint64) bool {
+		for id := min; id <= max; id++ {
+			if len(ips) >= maxListedAddresses {
+				return false
+			}
+			ips = append(ips, b.idToIP(id))
+			if id == max {
+				break
+			}
+		}
+		return true
+	}
+
+	next := b.Pool.OrigMin
+	for _, r := range b.Pool.Ranges {
+		if r.Min > next && !add(next, r.Min-1) {
+			return ips
+		}
+		if r.Max >= b.Pool.OrigMax {
+			return ips
+		}
+		if r.Max+1 > next {
+			next = r.Max + 1
+		}
+	}
+	add(next, b.Pool.OrigMax)
+	return ips
+}
@@ -0,0 +1,180 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package client
+
+import (
+	"net"
+	"testing"
+
+	"github.com/romana/core/common/api"
+)
+
+// allocateTestIPs allocates a and b on host1 and c on host2.
+func allocateTestIPs(t *testing.T, c *Client) {
+	for _, address := range []struct{ name, host string }{{"a", "host1"}, {"b", "host1"}, {"c", "host2"}} {
+		if _, err := c.IPAM.AllocateIP(address.name, address.host, "ten1", "seg1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// removeTestHost removes the host from its group, leaving its blocks.
+func removeTestHost(t *testing.T, ipam *IPAM, name string) {
+	host := ipam.Networks["net1"].Group.findHostByName(name)
+	if host == nil {
+		t.Fatalf("Host %s not found", name)
+	}
+	for i, h := range host.group.Hosts {
+		if h == host {
+			host.group.Hosts = deleteElementHost(host.group.Hosts, i)
+			return
+		}
+	}
+}
+
+func hasProblem(problems []api.IPAMProblem, kind string) bool {
+	for _, problem := range problems {
+		if problem.Kind == kind {
+			return true
+		}
+	}
+	return false
+}
+
+func TestIPAMVerify(t *testing.T) {
+	c := newTestMemoryClient(t)
+	allocateTestIPs(t, c)
+	if problems := exportTestIPAM(t, c).Verify(); len(problems) != 0 {
+		t.Fatalf("Expected no problems, got %+v", problems)
+	}
+
+	for kind, corrupt := range map[string]func(*IPAM){
+		api.IPAMProblemNameOutsideNetwork: func(ipam *IPAM) {
+			ipam.AddressNameToIP["x"] = net.ParseIP("192.168.0.1")
+		},
+		api.IPAMProblemUnnamedIP: func(ipam *IPAM) {
+			delete(ipam.AddressNameToIP, "b")
+		},
+		api.IPAMProblemUnallocatedName: func(ipam *IPAM) {
+			ipam.AddressNameToIP["x"] = net.ParseIP("10.0.0.2")
+		},
+		api.IPAMProblemBlackedOut: func(ipam *IPAM) {
+			cidr, _ := NewCIDR("10.0.0.0/31")
+			ipam.Networks["net1"].BlackedOut = append(ipam.Networks["net1"].BlackedOut, cidr)
+		},
+		api.IPAMProblemRemovedHost: func(ipam *IPAM) {
+			removeTestHost(t, ipam, "host2")
+		},
+		api.IPAMProblemIndex: func(ipam *IPAM) {
+			group := ipam.Networks["net1"].Group.findHostByName("host1").group
+			group.ReusableBlocks = append(group.ReusableBlocks, 0)
+		},
+		api.IPAMProblemOrphanedBlock: func(ipam *IPAM) {
+			group := ipam.Networks["net1"].Group.findHostByName("host1").group
+			delete(group.BlockToOwner, 0)
+			delete(group.BlockToHost, 0)
+			group.OwnerToBlocks = map[string][]int{}
+		},
+	} {
+		ipam := exportTestIPAM(t, c)
+		corrupt(ipam)
+		if problems := ipam.Verify(); !hasProblem(problems, kind) {
+			t.Errorf("Expected %s problem, got %+v", kind, problems)
+		}
+	}
+}
+
+func TestIPAMRepair(t *testing.T) {
+	c := newTestMemoryClient(t)
+	allocateTestIPs(t, c)
+
+	// Host2 goes away the way RemoveHost leaves it, with
+	// the owner of its block and its addresses in place.
+	ipam := exportTestIPAM(t, c)
+	group := ipam.Networks["net1"].Group.findHostByName("host2").group
+	removeTestHost(t, ipam, "host2")
+	for id, host := range group.BlockToHost {
+		if host == "host2" {
+			delete(group.BlockToHost, id)
+			group.Blocks[id].clear()
+			group.ReusableBlocks = append(group.ReusableBlocks, id)
+		}
+	}
+	delete(ipam.AddressNameToIP, "b")
+	ipam.AddressNameToIP["x"] = net.ParseIP("192.168.0.1")
+	if _, err := c.ImportIPAM(ipam, false); err != nil {
+		t.Fatal(err)
+	}
+
+	problems, err := c.IPAM.Repair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, kind := range []string{api.IPAMProblemIndex, api.IPAMProblemUnnamedIP, api.IPAMProblemNameOutsideNetwork} {
+		if !hasProblem(problems, kind) {
+			t.Errorf("Expected %s problem, got %+v", kind, problems)
+		}
+	}
+	for _, problem := range problems {
+		if !problem.Repaired {
+			t.Errorf("Expected %+v to be repaired", problem)
+		}
+	}
+
+	repaired := exportTestIPAM(t, c)
+	if problems := repaired.Verify(); len(problems) != 0 {
+		t.Errorf("Expected no problems after repair, got %+v", problems)
+	}
+	if len(repaired.AddressNameToIP) != 1 || repaired.AddressNameToIP["a"] == nil {
+		t.Errorf("Expected only address a to be left, got %v", repaired.AddressNameToIP)
+	}
+
+	// IP of b is free again.
+	ip, err := c.IPAM.AllocateIP("d", "host1", "ten1", "seg1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ip.Equal(net.ParseIP("10.0.0.1")) {
+		t.Errorf("Expected 10.0.0.1, got %s", ip)
+	}
+}
+
+func TestBlockAllocatedIPs(t *testing.T) {
+	cidr, err := NewCIDR("10.0.0.0/29")
+	if err != nil {
+		t.Fatal(err)
+	}
+	block := newBlock(cidr)
+	for _, ip := range []string{"10.0.0.0", "10.0.0.1", "10.0.0.3", "10.0.0.7"} {
+		if err := block.Pool.GetSpecificID(block.ipToID(net.ParseIP(ip))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got := block.allocatedIPs()
+	expected := []string{"10.0.0.0", "10.0.0.1", "10.0.0.3", "10.0.0.7"}
+	if len(got) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, got)
+	}
+	for i, ip := range got {
+		if ip.String() != expected[i] || !block.isAllocated(ip) {
+			t.Errorf("Expected allocated %s, got %s", expected[i], ip)
+		}
+	}
+	if block.isAllocated(net.ParseIP("10.0.0.2")) {
+		t.Errorf("Expected 10.0.0.2 to be free")
+	}
+}
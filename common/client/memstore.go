@@ -0,0 +1,830 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	libkvStore "github.com/docker/libkv/store"
+	log "github.com/romana/rlog"
+	"golang.org/x/sys/unix"
+)
+
+// memStoreHistorySize is how many of the latest events are kept
+// for WatchExt with AfterIndex.
+const memStoreHistorySize = 1000
+
+// memStorePollInterval is how often the file of memStore is checked for
+// changes made by other processes, and how often a lock held by another
+// process is retried.
+const memStorePollInterval = 100 * time.Millisecond
+
+// memStore implements libkv Store in memory, so that romana can run
+// without etcd, e.g. in tests, demos and single node labs. If file is
+// set, keys are saved to it on every change and loaded from it when
+// the store is created.
+//
+// Stores of processes on the same host that use the same file share
+// the keys: the file is locked while it is read or written, it is read
+// again when another store changes it, and it is polled so that
+// watchers get the events of the other stores. Locks of keys are
+// locks of files next to the file, so they are held across processes
+// too, and are released if the process holding them exits.
+//
+// As with etcd v3, LastIndex of a key is the revision of the store
+// it was modified at, and directories are emulated with the keys
+// prefixed by the directory name and "/".
+type memStore struct {
+	mu       sync.Mutex
+	file     string
+	revision uint64
+	kvs      map[string]*libkvStore.KVPair
+	history  []*libkvStore.KVPairExt
+	watchers map[*memWatcher]struct{}
+
+	// locks are closed when the lock of the key is released.
+	locks map[string]chan struct{}
+
+	// fileLock is locked while the file is read or written,
+	// fileInfo is of the file as it was last read or written.
+	fileLock *os.File
+	fileInfo os.FileInfo
+	stopPoll chan struct{}
+}
+
+// memStoreFile is the format of the file memStore is kept in.
+type memStoreFile struct {
+	Revision uint64                   `json:"revision"`
+	Keys     map[string]memStoreValue `json:"keys"`
+	History  []memStoreEvent          `json:"history,omitempty"`
+}
+
+type memStoreValue struct {
+	Value    string `json:"value"`
+	Revision uint64 `json:"revision"`
+}
+
+type memStoreEvent struct {
+	Key       string `json:"key"`
+	Action    string `json:"action"`
+	Value     string `json:"value,omitempty"`
+	PrevValue string `json:"prev_value,omitempty"`
+	Revision  uint64 `json:"revision"`
+}
+
+func newMemStore(file string) (*memStore, error) {
+	s := &memStore{
+		file:     file,
+		kvs:      make(map[string]*libkvStore.KVPair),
+		watchers: make(map[*memWatcher]struct{}),
+		locks:    make(map[string]chan struct{}),
+	}
+	if file == "" {
+		return s, nil
+	}
+
+	var err error
+	s.fileLock, err = os.OpenFile(file+".lock", os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	err = s.lock()
+	if err != nil {
+		s.fileLock.Close()
+		return nil, err
+	}
+	s.unlock()
+
+	s.stopPoll = make(chan struct{})
+	go s.poll(s.stopPoll)
+	return s, nil
+}
+
+// flock applies the operation to the lock of the file,
+// unlike with LOCK_NB it waits for the lock to be released.
+func flock(file *os.File, how int) error {
+	for {
+		err := unix.Flock(int(file.Fd()), how)
+		if err != unix.EINTR {
+			return err
+		}
+	}
+}
+
+// lock takes mu and, if the store is kept in a file, the lock of
+// the file, and reads the file again if another store changed it.
+func (s *memStore) lock() error {
+	s.mu.Lock()
+	if s.file == "" {
+		return nil
+	}
+	if s.fileLock == nil {
+		s.mu.Unlock()
+		return fmt.Errorf("store file %s is closed", s.file)
+	}
+
+	err := flock(s.fileLock, unix.LOCK_EX)
+	if err != nil {
+		s.mu.Unlock()
+		return fmt.Errorf("error locking %s: %s", s.file, err)
+	}
+	err = s.reload()
+	if err != nil {
+		s.unlock()
+		return err
+	}
+	return nil
+}
+
+// unlock releases the locks taken by lock.
+func (s *memStore) unlock() {
+	if s.fileLock != nil {
+		flock(s.fileLock, unix.LOCK_UN)
+	}
+	s.mu.Unlock()
+}
+
+// poll reads the file again when it is changed by another store,
+// so that watchers get the events of other stores, until stop is closed.
+func (s *memStore) poll(stop <-chan struct{}) {
+	ticker := time.NewTicker(memStorePollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+
+		err := s.lock()
+		if err != nil {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			log.Errorf("Error reading store file %s: %s", s.file, err)
+			continue
+		}
+		s.unlock()
+	}
+}
+
+// reload reads the keys from the file unless it was not changed since
+// it was last read or written, and passes the events of other stores
+// to the watchers. Must be called with mu and the file lock held.
+func (s *memStore) reload() error {
+	info, err := os.Stat(s.file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if s.fileInfo != nil && os.SameFile(info, s.fileInfo) &&
+		info.Size() == s.fileInfo.Size() && info.ModTime().Equal(s.fileInfo.ModTime()) {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(s.file)
+	if err != nil {
+		return err
+	}
+	var stored memStoreFile
+	err = json.Unmarshal(data, &stored)
+	if err != nil {
+		return fmt.Errorf("error loading %s: %s", s.file, err)
+	}
+	s.fileInfo = info
+	if stored.Revision == s.revision {
+		return nil
+	}
+
+	s.kvs = make(map[string]*libkvStore.KVPair, len(stored.Keys))
+	for key, value := range stored.Keys {
+		s.kvs[key] = &libkvStore.KVPair{Key: key, Value: []byte(value.Value), LastIndex: value.Revision}
+	}
+
+	// Watchers have to start over if some of the events
+	// since the revision last seen are no longer kept.
+	if len(stored.History) == 0 || stored.History[0].Revision > s.revision+1 {
+		for w := range s.watchers {
+			w.lose()
+		}
+	}
+	s.history = s.history[:0]
+	for _, e := range stored.History {
+		event := &libkvStore.KVPairExt{Key: e.Key, Action: e.Action, Value: e.Value, PrevValue: e.PrevValue, LastIndex: e.Revision}
+		s.history = append(s.history, event)
+		if event.LastIndex > s.revision {
+			s.notify(event)
+		}
+	}
+	s.revision = stored.Revision
+	return nil
+}
+
+// save writes the keys and the latest events to the file, if there
+// is one. The file is replaced at once, so it is never left half
+// written. Must be called with mu and the file lock held.
+func (s *memStore) save() error {
+	if s.file == "" {
+		return nil
+	}
+
+	stored := memStoreFile{Revision: s.revision, Keys: make(map[string]memStoreValue, len(s.kvs))}
+	for key, kv := range s.kvs {
+		stored.Keys[key] = memStoreValue{Value: string(kv.Value), Revision: kv.LastIndex}
+	}
+	history := s.history
+	if len(history) > memStoreHistorySize {
+		history = history[len(history)-memStoreHistorySize:]
+	}
+	for _, event := range history {
+		stored.History = append(stored.History, memStoreEvent{
+			Key:       event.Key,
+			Action:    event.Action,
+			Value:     event.Value,
+			PrevValue: event.PrevValue,
+			Revision:  event.LastIndex,
+		})
+	}
+	data, err := json.MarshalIndent(stored, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmpFile := s.file + ".tmp"
+	err = ioutil.WriteFile(tmpFile, data, 0600)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmpFile, s.file)
+	if err != nil {
+		return err
+	}
+	s.fileInfo, err = os.Stat(s.file)
+	return err
+}
+
+// notify passes the event to the watchers of its key.
+// Must be called with mu held.
+func (s *memStore) notify(event *libkvStore.KVPairExt) {
+	for w := range s.watchers {
+		if w.match(event.Key) {
+			w.push(event)
+		}
+	}
+}
+
+// commit stores the value of the key, or deletes the key if deleted
+// is set, at the next revision, notifies the watchers of the key and
+// saves the keys. Must be called with lock held.
+func (s *memStore) commit(key string, value []byte, deleted bool) (*libkvStore.KVPair, error) {
+	s.revision++
+	event := &libkvStore.KVPairExt{Key: key, LastIndex: s.revision}
+
+	prev, existed := s.kvs[key]
+	if existed {
+		event.PrevValue = string(prev.Value)
+	}
+
+	var pair *libkvStore.KVPair
+	if deleted {
+		delete(s.kvs, key)
+		event.Action = "delete"
+	} else {
+		pair = &libkvStore.KVPair{Key: key, Value: append([]byte(nil), value...), LastIndex: s.revision}
+		s.kvs[key] = pair
+		event.Action = "set"
+		if !existed {
+			event.Action = "create"
+		}
+		event.Value = string(value)
+	}
+
+	s.history = append(s.history, event)
+	if len(s.history) > 2*memStoreHistorySize {
+		s.history = append([]*libkvStore.KVPairExt(nil), s.history[len(s.history)-memStoreHistorySize:]...)
+	}
+	s.notify(event)
+
+	return copyKVPair(pair), s.save()
+}
+
+func copyKVPair(kv *libkvStore.KVPair) *libkvStore.KVPair {
+	if kv == nil {
+		return nil
+	}
+	pair := *kv
+	return &pair
+}
+
+// list returns the keys directly in the directory with the prefix,
+// sorted by name. Must be called with mu held.
+func (s *memStore) list(prefix string) []*libkvStore.KVPair {
+	var pairs []*libkvStore.KVPair
+	for key, kv := range s.kvs {
+		name := strings.TrimPrefix(key, prefix)
+		if len(name) == len(key) || name == "" || strings.Contains(name, "/") {
+			continue
+		}
+		pairs = append(pairs, copyKVPair(kv))
+	}
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i].Key < pairs[j].Key })
+	return pairs
+}
+
+// Put stores value under the key. Keys never expire, so TTL is not
+// supported, and IsDir puts are ignored as there are no directories.
+func (s *memStore) Put(key string, value []byte, options *libkvStore.WriteOptions) error {
+	if options != nil && options.TTL > 0 {
+		return libkvStore.ErrCallNotSupported
+	}
+	if options != nil && options.IsDir {
+		return nil
+	}
+
+	if err := s.lock(); err != nil {
+		return err
+	}
+	defer s.unlock()
+	_, err := s.commit(key, value, false)
+	return err
+}
+
+func (s *memStore) Get(key string) (*libkvStore.KVPair, error) {
+	if err := s.lock(); err != nil {
+		return nil, err
+	}
+	defer s.unlock()
+	kv, ok := s.kvs[key]
+	if !ok {
+		return nil, libkvStore.ErrKeyNotFound
+	}
+	return copyKVPair(kv), nil
+}
+
+func (s *memStore) Delete(key string) error {
+	if err := s.lock(); err != nil {
+		return err
+	}
+	defer s.unlock()
+	if _, ok := s.kvs[key]; !ok {
+		return libkvStore.ErrKeyNotFound
+	}
+	_, err := s.commit(key, nil, true)
+	return err
+}
+
+func (s *memStore) Exists(key string) (bool, error) {
+	if err := s.lock(); err != nil {
+		return false, err
+	}
+	defer s.unlock()
+	_, ok := s.kvs[key]
+	return ok, nil
+}
+
+// List returns the keys directly in the directory, like etcd v2 it
+// fails with ErrKeyNotFound if there are none.
+func (s *memStore) List(dir string) ([]*libkvStore.KVPair, error) {
+	if err := s.lock(); err != nil {
+		return nil, err
+	}
+	defer s.unlock()
+	pairs := s.list(dirPrefix(dir))
+	if len(pairs) == 0 {
+		return nil, libkvStore.ErrKeyNotFound
+	}
+	return pairs, nil
+}
+
+func (s *memStore) DeleteTree(dir string) error {
+	if err := s.lock(); err != nil {
+		return err
+	}
+	defer s.unlock()
+	prefix := dirPrefix(dir)
+	for key := range s.kvs {
+		if key != dir && !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		if _, err := s.commit(key, nil, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AtomicPut stores value under the key if it was not modified since
+// previous was read, or if previous is nil, if the key does not exist.
+func (s *memStore) AtomicPut(key string, value []byte, previous *libkvStore.KVPair, options *libkvStore.WriteOptions) (bool, *libkvStore.KVPair, error) {
+	if options != nil && options.TTL > 0 {
+		return false, nil, libkvStore.ErrCallNotSupported
+	}
+
+	if err := s.lock(); err != nil {
+		return false, nil, err
+	}
+	defer s.unlock()
+	if err := s.compare(key, previous); err != nil {
+		return false, nil, err
+	}
+	pair, err := s.commit(key, value, false)
+	if err != nil {
+		return false, nil, err
+	}
+	return true, pair, nil
+}
+
+// AtomicDelete deletes the key if it was not modified since previous was read.
+func (s *memStore) AtomicDelete(key string, previous *libkvStore.KVPair) (bool, error) {
+	if previous == nil {
+		return false, libkvStore.ErrPreviousNotSpecified
+	}
+
+	if err := s.lock(); err != nil {
+		return false, err
+	}
+	defer s.unlock()
+	if err := s.compare(key, previous); err != nil {
+		return false, err
+	}
+	if _, err := s.commit(key, nil, true); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// compare checks that the key is still at the revision of previous,
+// or does not exist if previous is nil. Must be called with lock held.
+func (s *memStore) compare(key string, previous *libkvStore.KVPair) error {
+	kv, ok := s.kvs[key]
+	switch {
+	case previous == nil && ok:
+		return libkvStore.ErrKeyExists
+	case previous == nil:
+		return nil
+	case !ok:
+		return libkvStore.ErrKeyNotFound
+	case kv.LastIndex != previous.LastIndex:
+		return libkvStore.ErrKeyModified
+	}
+	return nil
+}
+
+// memWatcher queues events of the keys it matches until they
+// are taken by the goroutine serving the watch.
+type memWatcher struct {
+	match  func(key string) bool
+	mu     sync.Mutex
+	events []*libkvStore.KVPairExt
+	notify chan struct{}
+	// lost is set when some of the events were missed.
+	lost bool
+}
+
+func (w *memWatcher) push(event *libkvStore.KVPairExt) {
+	w.mu.Lock()
+	w.events = append(w.events, event)
+	w.mu.Unlock()
+	select {
+	case w.notify <- struct{}{}:
+	default:
+	}
+}
+
+// lose marks the watcher as having missed some of the events.
+func (w *memWatcher) lose() {
+	w.mu.Lock()
+	w.lost = true
+	w.mu.Unlock()
+	select {
+	case w.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (w *memWatcher) pop() ([]*libkvStore.KVPairExt, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	events, lost := w.events, w.lost
+	w.events, w.lost = nil, false
+	return events, lost
+}
+
+// addWatcher starts queueing events of the keys matching
+// match. Must be called with mu held.
+func (s *memStore) addWatcher(match func(key string) bool) *memWatcher {
+	w := &memWatcher{match: match, notify: make(chan struct{}, 1)}
+	s.watchers[w] = struct{}{}
+	return w
+}
+
+// serve passes events queued by the watcher to handle, and calls
+// resync when the watcher missed some of the events, until stopCh
+// is closed or handle or resync return false.
+func (s *memStore) serve(w *memWatcher, stopCh <-chan struct{}, handle func(*libkvStore.KVPairExt) bool, resync func() bool) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.watchers, w)
+		s.mu.Unlock()
+	}()
+
+	for {
+		events, lost := w.pop()
+		for _, event := range events {
+			if !handle(event) {
+				return
+			}
+		}
+		if lost && !resync() {
+			return
+		}
+		select {
+		case <-w.notify:
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+func matchKey(key string) func(string) bool {
+	return func(k string) bool { return k == key }
+}
+
+func matchPrefix(prefix string) func(string) bool {
+	return func(k string) bool { return strings.HasPrefix(k, prefix) }
+}
+
+// Watch sends the value of the key and then its new value each time
+// it changes, deleted key is sent with empty value.
+func (s *memStore) Watch(key string, stopCh <-chan struct{}) (<-chan *libkvStore.KVPair, error) {
+	if err := s.lock(); err != nil {
+		return nil, err
+	}
+	w := s.addWatcher(matchKey(key))
+	current := copyKVPair(s.kvs[key])
+	s.unlock()
+
+	watchCh := make(chan *libkvStore.KVPair)
+	send := func(pair *libkvStore.KVPair) bool {
+		select {
+		case watchCh <- pair:
+			return true
+		case <-stopCh:
+			return false
+		}
+	}
+
+	go func() {
+		defer close(watchCh)
+		if current != nil && !send(current) {
+			return
+		}
+		s.serve(w, stopCh, func(event *libkvStore.KVPairExt) bool {
+			return send(&libkvStore.KVPair{Key: event.Key, Value: []byte(event.Value), LastIndex: event.LastIndex})
+		}, func() bool {
+			s.mu.Lock()
+			current := copyKVPair(s.kvs[key])
+			s.mu.Unlock()
+			if current == nil {
+				current = &libkvStore.KVPair{Key: key}
+			}
+			return send(current)
+		})
+	}()
+
+	return watchCh, nil
+}
+
+// WatchTree sends the keys of the directory and then
+// all of them again each time any changes.
+func (s *memStore) WatchTree(dir string, stopCh <-chan struct{}) (<-chan []*libkvStore.KVPair, error) {
+	prefix := dirPrefix(dir)
+	if err := s.lock(); err != nil {
+		return nil, err
+	}
+	w := s.addWatcher(matchPrefix(prefix))
+	pairs := s.list(prefix)
+	s.unlock()
+
+	watchCh := make(chan []*libkvStore.KVPair)
+	send := func(pairs []*libkvStore.KVPair) bool {
+		select {
+		case watchCh <- pairs:
+			return true
+		case <-stopCh:
+			return false
+		}
+	}
+
+	go func() {
+		defer close(watchCh)
+		if !send(pairs) {
+			return
+		}
+		sendList := func() bool {
+			s.mu.Lock()
+			pairs := s.list(prefix)
+			s.mu.Unlock()
+			return send(pairs)
+		}
+		s.serve(w, stopCh, func(*libkvStore.KVPairExt) bool {
+			return sendList()
+		}, sendList)
+	}()
+
+	return watchCh, nil
+}
+
+// WatchExt sends v2 style events of the key, or of the keys in the
+// directory if options.Recursive is set, starting after AfterIndex if
+// it is provided. Initial values are never sent, as with NoList.
+//
+// If the events after AfterIndex are no longer kept, or some events of
+// other stores sharing the file were missed, the channel is closed, as
+// v2 watch would fail when its index is cleared, and the caller is
+// expected to read the keys again.
+func (s *memStore) WatchExt(key string, options libkvStore.WatcherOptions, stopCh <-chan struct{}) (<-chan *libkvStore.KVPairExt, error) {
+	match := matchKey(key)
+	if options.Recursive {
+		match = matchPrefix(dirPrefix(key))
+	}
+
+	if err := s.lock(); err != nil {
+		return nil, err
+	}
+	w := s.addWatcher(match)
+	lost := false
+	if options.AfterIndex > 0 && options.AfterIndex < s.revision {
+		if len(s.history) == 0 || s.history[0].LastIndex > options.AfterIndex+1 {
+			lost = true
+		}
+		for _, event := range s.history {
+			if !lost && event.LastIndex > options.AfterIndex && match(event.Key) {
+				w.push(event)
+			}
+		}
+	}
+	s.unlock()
+
+	watchCh := make(chan *libkvStore.KVPairExt)
+	go func() {
+		defer close(watchCh)
+		if lost {
+			s.mu.Lock()
+			delete(s.watchers, w)
+			s.mu.Unlock()
+			return
+		}
+		s.serve(w, stopCh, func(event *libkvStore.KVPairExt) bool {
+			select {
+			case watchCh <- event:
+				return true
+			case <-stopCh:
+				return false
+			}
+		}, func() bool {
+			return false
+		})
+	}()
+
+	return watchCh, nil
+}
+
+func (s *memStore) WatchTreeExt(dir string, stopCh <-chan struct{}) (<-chan *libkvStore.KVPairExt, error) {
+	return s.WatchExt(dir, libkvStore.WatcherOptions{Recursive: true}, stopCh)
+}
+
+// GetExt is not supported since there are no directory
+// nodes, List returns the keys of a directory.
+func (s *memStore) GetExt(key string, options libkvStore.GetOptions) (*libkvStore.KVPairExt, error) {
+	return nil, libkvStore.ErrCallNotSupported
+}
+
+func (s *memStore) NewLock(key string, options *libkvStore.LockOptions) (libkvStore.Locker, error) {
+	return &memLock{store: s, key: key}, nil
+}
+
+// Close stops reading the file, after
+// which the store can no longer be used.
+func (s *memStore) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fileLock != nil {
+		close(s.stopPoll)
+		s.fileLock.Close()
+		s.fileLock = nil
+	}
+}
+
+// memLock implements libkv Locker for memStore. If the store is kept
+// in a file, the lock of a file next to it is held as well, so that
+// the stores of other processes sharing the file are excluded too.
+type memLock struct {
+	store    *memStore
+	key      string
+	released chan struct{}
+	file     *os.File
+}
+
+// Lock waits for the lock until it is acquired or stopChan is closed.
+// The lock is never lost, so the returned channel is never closed.
+func (l *memLock) Lock(stopChan chan struct{}) (<-chan struct{}, error) {
+	for {
+		l.store.mu.Lock()
+		released, held := l.store.locks[l.key]
+		if !held {
+			l.released = make(chan struct{})
+			l.store.locks[l.key] = l.released
+			l.store.mu.Unlock()
+			break
+		}
+		l.store.mu.Unlock()
+
+		select {
+		case <-released:
+		case <-stopChan:
+			return nil, libkvStore.ErrCannotLock
+		}
+	}
+
+	if l.store.file != "" {
+		err := l.lockFile(stopChan)
+		if err != nil {
+			l.Unlock()
+			return nil, err
+		}
+	}
+	return make(chan struct{}), nil
+}
+
+// lockFile waits for the lock of the file of the key until it is
+// acquired or stopChan is closed. The file is only closed, and the
+// lock released, by Unlock or when the process exits.
+func (l *memLock) lockFile(stopChan chan struct{}) error {
+	hash := fnv.New32a()
+	hash.Write([]byte(l.key))
+	file, err := os.OpenFile(fmt.Sprintf("%s.lock.%08x", l.store.file, hash.Sum32()), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+
+	for {
+		err = flock(file, unix.LOCK_EX|unix.LOCK_NB)
+		if err == nil {
+			l.file = file
+			return nil
+		}
+		if err != unix.EWOULDBLOCK {
+			file.Close()
+			return fmt.Errorf("error locking %s: %s", l.key, err)
+		}
+
+		select {
+		case <-time.After(memStorePollInterval):
+		case <-stopChan:
+			file.Close()
+			return libkvStore.ErrCannotLock
+		}
+	}
+}
+
+func (l *memLock) Unlock() error {
+	l.store.mu.Lock()
+	defer l.store.mu.Unlock()
+	if l.released == nil || l.store.locks[l.key] != l.released {
+		return libkvStore.ErrCannotLock
+	}
+	if l.file != nil {
+		l.file.Close()
+		l.file = nil
+	}
+	delete(l.store.locks, l.key)
+	close(l.released)
+	l.released = nil
+	return nil
+}
@@ -0,0 +1,357 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package client
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	libkvStore "github.com/docker/libkv/store"
+	"github.com/romana/core/common"
+)
+
+func newTestMemStore(t *testing.T, file string) *memStore {
+	s, err := newMemStore(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestMemStoreAtomic(t *testing.T) {
+	s := newTestMemStore(t, "")
+
+	ok, kv, err := s.AtomicPut("/a", []byte("1"), nil, nil)
+	if !ok || err != nil {
+		t.Fatalf("Expected key to be created, got %t, %v", ok, err)
+	}
+	if _, _, err = s.AtomicPut("/a", []byte("2"), nil, nil); err != libkvStore.ErrKeyExists {
+		t.Errorf("Expected %s, got %v", libkvStore.ErrKeyExists, err)
+	}
+
+	_, updated, err := s.AtomicPut("/a", []byte("2"), kv, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.LastIndex <= kv.LastIndex {
+		t.Errorf("Expected revision after %d, got %d", kv.LastIndex, updated.LastIndex)
+	}
+	if _, _, err = s.AtomicPut("/a", []byte("3"), kv, nil); err != libkvStore.ErrKeyModified {
+		t.Errorf("Expected %s, got %v", libkvStore.ErrKeyModified, err)
+	}
+	if _, err = s.AtomicDelete("/a", kv); err != libkvStore.ErrKeyModified {
+		t.Errorf("Expected %s, got %v", libkvStore.ErrKeyModified, err)
+	}
+
+	if _, err = s.AtomicDelete("/a", updated); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.AtomicDelete("/a", updated); err != libkvStore.ErrKeyNotFound {
+		t.Errorf("Expected %s, got %v", libkvStore.ErrKeyNotFound, err)
+	}
+	if _, err = s.Get("/a"); err != libkvStore.ErrKeyNotFound {
+		t.Errorf("Expected %s, got %v", libkvStore.ErrKeyNotFound, err)
+	}
+}
+
+func TestMemStoreList(t *testing.T) {
+	s := newTestMemStore(t, "")
+	for _, key := range []string{"/dir/b", "/dir/a", "/dir/sub/c", "/dirx"} {
+		if err := s.Put(key, []byte(key), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pairs, err := s.List("/dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pairs) != 2 || pairs[0].Key != "/dir/a" || pairs[1].Key != "/dir/b" {
+		t.Errorf("Expected /dir/a and /dir/b, got %v", pairs)
+	}
+
+	if err = s.DeleteTree("/dir"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.List("/dir"); err != libkvStore.ErrKeyNotFound {
+		t.Errorf("Expected %s, got %v", libkvStore.ErrKeyNotFound, err)
+	}
+	if ok, _ := s.Exists("/dirx"); !ok {
+		t.Errorf("Expected /dirx to be left")
+	}
+}
+
+// receiveEvent waits for the next event of the watch.
+func receiveEvent(t *testing.T, ch <-chan *libkvStore.KVPairExt) *libkvStore.KVPairExt {
+	select {
+	case event, ok := <-ch:
+		if !ok {
+			t.Fatal("Watch closed unexpectedly")
+		}
+		return event
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for event")
+	}
+	return nil
+}
+
+func TestMemStoreWatchExt(t *testing.T) {
+	s := newTestMemStore(t, "")
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+
+	ch, err := s.WatchExt("/dir", libkvStore.WatcherOptions{Recursive: true, NoList: true}, stopCh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Put("/other", []byte("x"), nil)
+	s.Put("/dir/a", []byte("1"), nil)
+	s.Put("/dir/a", []byte("2"), nil)
+	s.Delete("/dir/a")
+
+	var last uint64
+	for _, expect := range []libkvStore.KVPairExt{
+		{Key: "/dir/a", Value: "1", Action: "create"},
+		{Key: "/dir/a", Value: "2", PrevValue: "1", Action: "set"},
+		{Key: "/dir/a", PrevValue: "2", Action: "delete"},
+	} {
+		event := receiveEvent(t, ch)
+		if event.Key != expect.Key || event.Value != expect.Value ||
+			event.PrevValue != expect.PrevValue || event.Action != expect.Action {
+			t.Errorf("Expected %+v, got %+v", expect, *event)
+		}
+		if event.LastIndex <= last {
+			t.Errorf("Expected revision after %d, got %d", last, event.LastIndex)
+		}
+		last = event.LastIndex
+	}
+
+	// Events after AfterIndex are replayed.
+	ch, err = s.WatchExt("/dir", libkvStore.WatcherOptions{Recursive: true, AfterIndex: last - 1}, stopCh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event := receiveEvent(t, ch); event.Action != "delete" || event.LastIndex != last {
+		t.Errorf("Expected delete at %d, got %+v", last, *event)
+	}
+
+	// Watch from forgotten revision is closed.
+	for i := 0; i <= 2*memStoreHistorySize; i++ {
+		s.Put("/other", []byte("x"), nil)
+	}
+	ch, err = s.WatchExt("/dir", libkvStore.WatcherOptions{Recursive: true, AfterIndex: last - 1}, stopCh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case event, ok := <-ch:
+		if ok {
+			t.Errorf("Expected watch to be closed, got %+v", *event)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Expected watch to be closed")
+	}
+}
+
+func TestMemStoreLock(t *testing.T) {
+	s := newTestMemStore(t, "")
+	first, _ := s.NewLock("/lock/ipam", nil)
+	second, _ := s.NewLock("/lock/ipam", nil)
+
+	if _, err := first.Lock(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	stopChan := make(chan struct{})
+	close(stopChan)
+	if _, err := second.Lock(stopChan); err == nil {
+		t.Fatal("Expected lock to be held")
+	}
+
+	locked := make(chan error)
+	go func() {
+		_, err := second.Lock(nil)
+		locked <- err
+	}()
+	select {
+	case <-locked:
+		t.Fatal("Expected lock to be held")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	if err := first.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-locked:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected lock to be acquired after unlock")
+	}
+	if err := first.Unlock(); err == nil {
+		t.Error("Expected unlock of released lock to fail")
+	}
+}
+
+func TestMemStoreFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "romana")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "store.json")
+
+	s := newTestMemStore(t, file)
+	s.Put("/a", []byte("1"), nil)
+	s.Put("/b", []byte("2"), nil)
+	s.Delete("/b")
+	s.Close()
+
+	loaded := newTestMemStore(t, file)
+	kv, err := loaded.Get("/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(kv.Value) != "1" || kv.LastIndex != 1 {
+		t.Errorf("Expected value 1 at revision 1, got %s at %d", kv.Value, kv.LastIndex)
+	}
+	if ok, _ := loaded.Exists("/b"); ok {
+		t.Error("Expected /b to be deleted")
+	}
+	if loaded.revision != 3 {
+		t.Errorf("Expected revision 3, got %d", loaded.revision)
+	}
+}
+
+func TestMemStoreFileShared(t *testing.T) {
+	dir, err := ioutil.TempDir("", "romana")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "store.json")
+
+	// Stores of romanad and the agent share the keys through the file.
+	first := newTestMemStore(t, file)
+	defer first.Close()
+	second := newTestMemStore(t, file)
+	defer second.Close()
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	watchCh, err := second.WatchExt("/dir", libkvStore.WatcherOptions{Recursive: true}, stopCh)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first.Put("/dir/a", []byte("1"), nil)
+	kv, err := second.Get("/dir/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(kv.Value) != "1" || kv.LastIndex != 1 {
+		t.Errorf("Expected value 1 at revision 1, got %s at %d", kv.Value, kv.LastIndex)
+	}
+
+	select {
+	case event := <-watchCh:
+		if event.Key != "/dir/a" || event.Value != "1" || event.Action != "create" {
+			t.Errorf("Expected create of /dir/a with 1, got %+v", event)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected event of the other store")
+	}
+
+	// Neither store overwrites the changes of the other.
+	second.Put("/dir/a", []byte("2"), nil)
+	if ok, _, err := first.AtomicPut("/dir/a", []byte("3"), kv, nil); ok || err != libkvStore.ErrKeyModified {
+		t.Errorf("Expected ErrKeyModified, got %t, %v", ok, err)
+	}
+	first.Put("/dir/b", []byte("4"), nil)
+	loaded := newTestMemStore(t, file)
+	defer loaded.Close()
+	for key, value := range map[string]string{"/dir/a": "2", "/dir/b": "4"} {
+		kv, err := loaded.Get(key)
+		if err != nil {
+			t.Fatalf("Expected %s to be saved: %s", key, err)
+		}
+		if string(kv.Value) != value {
+			t.Errorf("Expected %s at %s, got %s", value, key, kv.Value)
+		}
+	}
+
+	// Locks are held across the stores.
+	firstLock, _ := first.NewLock("/lock", nil)
+	if _, err := firstLock.Lock(nil); err != nil {
+		t.Fatal(err)
+	}
+	secondLock, _ := second.NewLock("/lock", nil)
+	lockStopCh := make(chan struct{})
+	time.AfterFunc(3*memStorePollInterval, func() { close(lockStopCh) })
+	if _, err := secondLock.Lock(lockStopCh); err != libkvStore.ErrCannotLock {
+		t.Errorf("Expected lock held by the other store to fail with ErrCannotLock, got %v", err)
+	}
+	if err := firstLock.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := secondLock.Lock(nil); err != nil {
+		t.Errorf("Expected lock released by the other store to succeed, got %v", err)
+	}
+	secondLock.Unlock()
+}
+
+func TestMemoryStoreClient(t *testing.T) {
+	dir, err := ioutil.TempDir("", "romana")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	topologyFile := twoHostsTopologyFile
+	config := &common.Config{
+		StoreBackend:        common.StoreBackendMemory,
+		StoreFile:           filepath.Join(dir, "store.json"),
+		InitialTopologyFile: &topologyFile,
+	}
+
+	c, err := NewClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ip, err := c.IPAM.AllocateIP("a", "host1", "ten1", "seg1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ip.Equal(net.ParseIP("10.0.0.0")) {
+		t.Errorf("Expected 10.0.0.0, got %s", ip)
+	}
+
+	// IPAM is loaded from the file by the next client.
+	c, err = NewClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Store.Close()
+	if got := c.IPAM.AddressNameToIP["a"]; !got.Equal(ip) {
+		t.Errorf("Expected %s allocated to a, got %s", ip, got)
+	}
+}
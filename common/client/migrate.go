@@ -0,0 +1,90 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package client
+
+import (
+	"strings"
+
+	libkvStore "github.com/docker/libkv/store"
+	log "github.com/romana/rlog"
+)
+
+// MigrationResult describes keys handled by MigrateV2ToV3.
+type MigrationResult struct {
+	// Copied are the keys stored in v3.
+	Copied []string
+
+	// Skipped are the keys that already existed in v3 and were left
+	// alone, and the locks which are only valid for their v2 holders.
+	Skipped []string
+}
+
+// isMigratedKey tells if the key is copied to v3, locks are not
+// since v3 locks are held with leases instead of TTL keys.
+func isMigratedKey(prefix string, key string) bool {
+	return !strings.HasPrefix(key, normalize(prefix+"/lock")+"/")
+}
+
+// MigrateV2ToV3 copies the keys under the prefix of the v2 store
+// to the v3 store, keeping their names. Keys that already exist in
+// v3 are only replaced if overwrite is set. Revisions of the copied
+// keys start over, so running components must be stopped while
+// the data is migrated.
+func MigrateV2ToV3(v2 *Store, v3 *Store, overwrite bool) (MigrationResult, error) {
+	var result MigrationResult
+
+	root, err := v2.Store.GetExt(v2.getKey("/"), libkvStore.GetOptions{Recursive: true})
+	if err != nil {
+		if err == libkvStore.ErrKeyNotFound {
+			return result, nil
+		}
+		return result, err
+	}
+
+	nodes := root.GetResponse().Node.Nodes
+	for len(nodes) > 0 {
+		node := nodes[0]
+		nodes = nodes[1:]
+		if node.Dir {
+			nodes = append(nodes, node.Nodes...)
+			continue
+		}
+
+		key := v3.getKey(v2.relativeKey(node.Key))
+		if !isMigratedKey(v2.prefix, node.Key) {
+			log.Infof("Skipping lock %s", node.Key)
+			result.Skipped = append(result.Skipped, key)
+			continue
+		}
+
+		if overwrite {
+			err = v3.Store.Put(key, []byte(node.Value), nil)
+		} else {
+			_, _, err = v3.Store.AtomicPut(key, []byte(node.Value), nil, nil)
+			if err == libkvStore.ErrKeyExists {
+				log.Infof("Skipping %s, it already exists", key)
+				result.Skipped = append(result.Skipped, key)
+				continue
+			}
+		}
+		if err != nil {
+			return result, err
+		}
+		result.Copied = append(result.Copied, key)
+	}
+
+	return result, nil
+}
@@ -17,6 +17,7 @@ package client
 
 import (
 	"bytes"
+	"crypto/tls"
 	"encoding/json"
 	"runtime"
 	"strconv"
@@ -37,10 +38,11 @@ import (
 type Store struct {
 	prefix string
 	libkvStore.Store
-	//	etcdCli *clientv3.Client
 }
 
-func NewStore(etcdEndpoints []string, prefix string) (*Store, error) {
+// NewStore creates a Store connected to etcd with v2 API. If
+// tlsConfig is provided, TLS is used to connect to etcd.
+func NewStore(etcdEndpoints []string, prefix string, tlsConfig *tls.Config) (*Store, error) {
 	var err error
 
 	myStore := &Store{prefix: prefix}
@@ -48,31 +50,78 @@ func NewStore(etcdEndpoints []string, prefix string) (*Store, error) {
 	myStore.Store, err = libkv.NewStore(
 		libkvStore.ETCD,
 		etcdEndpoints,
-		&libkvStore.Config{},
+		&libkvStore.Config{TLS: tlsConfig},
 	)
 
 	if err != nil {
 		return nil, err
 	}
 
-	// BEGIN EXPERIMENT...
-	//	myStore.etcdCli, err := clientv3.New(clientv3.Config{
-	//		Endpoints: etcdEndpoints,
-	//	})
-	//	if err != nil {
-	//		return nil, err
-	//	}
-	// END EXPERIMENT
+	// Test connection
+	_, err = myStore.Exists("test")
+	if err != nil {
+		return nil, err
+	}
+
+	return myStore, nil
+}
+
+// NewStoreV3 creates a Store connected to etcd with v3 API. If
+// tlsConfig is provided, TLS is used to connect to etcd.
+func NewStoreV3(etcdEndpoints []string, prefix string, tlsConfig *tls.Config) (*Store, error) {
+	etcd, err := newEtcdV3(etcdEndpoints, tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	myStore := &Store{prefix: prefix, Store: etcd}
 
 	// Test connection
 	_, err = myStore.Exists("test")
 	if err != nil {
+		etcd.Close()
 		return nil, err
 	}
 
 	return myStore, nil
 }
 
+// NewMemoryStore creates a Store kept in memory, and in the file
+// if it is provided. See memStore.
+func NewMemoryStore(prefix string, file string) (*Store, error) {
+	mem, err := newMemStore(file)
+	if err != nil {
+		return nil, err
+	}
+	return &Store{prefix: prefix, Store: mem}, nil
+}
+
+// NewStoreFromConfig creates a Store with the backend of config,
+// connected to etcd with the API version, endpoints and TLS options
+// of config unless the backend is memory.
+func NewStoreFromConfig(config *common.Config) (*Store, error) {
+	err := config.ValidateStoreBackend()
+	if err != nil {
+		return nil, err
+	}
+	if config.StoreBackend == common.StoreBackendMemory {
+		return NewMemoryStore(config.EtcdPrefix, config.StoreFile)
+	}
+
+	err = config.ValidateEtcdAPI()
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig, err := config.EtcdTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	if config.EtcdAPI == common.EtcdAPIv3 {
+		return NewStoreV3(config.EtcdEndpoints, config.EtcdPrefix, tlsConfig)
+	}
+	return NewStore(config.EtcdEndpoints, config.EtcdPrefix, tlsConfig)
+}
+
 func normalize(key string) string {
 	key2 := strings.TrimSpace(key)
 	elts := strings.Split(key2, "/")
@@ -97,6 +146,15 @@ func (s *Store) getKey(key string) string {
 	return normalizedKey
 }
 
+// relativeKey strips prefix from the key, it is the reverse of getKey.
+func (s *Store) relativeKey(key string) string {
+	prefix := normalize(s.prefix)
+	if prefix == "/" {
+		return key
+	}
+	return strings.TrimPrefix(key, prefix)
+}
+
 // BEGIN WRAPPER METHODS
 
 // For now, the wrapper methods (below) just ensure the specified
@@ -15,6 +15,23 @@
 
 package common
 
+import (
+	"flag"
+	"fmt"
+)
+
+// Versions of etcd API that romana data can be stored with.
+const (
+	EtcdAPIv2 = "v2"
+	EtcdAPIv3 = "v3"
+)
+
+// Backends that romana data can be stored with.
+const (
+	StoreBackendEtcd   = "etcd"
+	StoreBackendMemory = "memory"
+)
+
 // Config is the configuration required for a Romana client library.
 // TODO it is here temporarily until circular imports are resolved.
 type Config struct {
@@ -22,4 +39,79 @@ type Config struct {
 	EtcdPrefix          string
 	InitialTopologyFile *string
 	Mock                bool
+
+	// EtcdAPI is the version of etcd API romana data is stored
+	// with, EtcdAPIv2 (the default if empty) or EtcdAPIv3.
+	EtcdAPI string
+
+	// StoreBackend is where romana data is stored, StoreBackendEtcd
+	// (the default if empty) or StoreBackendMemory. Data stored in
+	// memory is kept in StoreFile if it is set, and is lost on exit
+	// otherwise. Processes on the same host using memory store with
+	// the same StoreFile share it, e.g. romanad, the listener and the
+	// agent of a single node setup.
+	StoreBackend string
+	StoreFile    string
+
+	// AuthPublicKey is a path to PEM encoded RSA public key used
+	// to verify authentication tokens. Authentication is off if empty.
+	AuthPublicKey string
+
+	// EtcdCAFile, EtcdCertFile and EtcdKeyFile are paths to PEM
+	// encoded CA certificates to verify etcd servers with, and client
+	// certificate and key to present to them. Plain HTTP is used to
+	// connect to etcd if none are set.
+	EtcdCAFile   string
+	EtcdCertFile string
+	EtcdKeyFile  string
+
+	// TLSCertFile and TLSKeyFile are paths to PEM encoded certificate
+	// and key to serve REST API with. Plain HTTP is served if not set.
+	// If TLSClientCAFile is set, clients must present certificates
+	// signed by CAs from it.
+	TLSCertFile     string
+	TLSKeyFile      string
+	TLSClientCAFile string
+}
+
+// ValidateEtcdAPI checks that EtcdAPI is a supported version.
+func (c Config) ValidateEtcdAPI() error {
+	switch c.EtcdAPI {
+	case "", EtcdAPIv2, EtcdAPIv3:
+		return nil
+	}
+	return fmt.Errorf("unknown etcd API version %s, expected %s or %s", c.EtcdAPI, EtcdAPIv2, EtcdAPIv3)
+}
+
+// AddEtcdAPIFlag registers command line flag
+// for etcd API version of the config.
+func (c *Config) AddEtcdAPIFlag(flagSet *flag.FlagSet) {
+	flagSet.StringVar(&c.EtcdAPI, "etcd-api", EtcdAPIv2, "Version of etcd API to store romana data with, v2 or v3.")
+}
+
+// ValidateStoreBackend checks that StoreBackend is a supported backend.
+func (c Config) ValidateStoreBackend() error {
+	switch c.StoreBackend {
+	case "", StoreBackendEtcd, StoreBackendMemory:
+		return nil
+	}
+	return fmt.Errorf("unknown store backend %s, expected %s or %s", c.StoreBackend, StoreBackendEtcd, StoreBackendMemory)
+}
+
+// ValidateSharedStore checks that StoreBackend is a supported backend
+// which can be shared with romanad, i.e. that memory store is kept
+// in StoreFile.
+func (c Config) ValidateSharedStore() error {
+	if c.StoreBackend == StoreBackendMemory && c.StoreFile == "" {
+		return fmt.Errorf("memory store needs a store file to be shared with romanad")
+	}
+	return c.ValidateStoreBackend()
+}
+
+// AddStoreFlags registers command line flags
+// for store backend options of the config.
+func (c *Config) AddStoreFlags(flagSet *flag.FlagSet) {
+	flagSet.StringVar(&c.StoreBackend, "store", StoreBackendEtcd,
+		"Where to store romana data, etcd or memory (single node, for tests and demos).")
+	flagSet.StringVar(&c.StoreFile, "store-file", "", "File to keep romana data stored in memory in, shared by romana processes using the same file.")
 }
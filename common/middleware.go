@@ -174,24 +174,16 @@ func wrapHandler(restHandler RestHandler, route Route) http.Handler {
 				writer.Write([]byte(err.Error()))
 				return
 			}
-			user := context.Get(request, ContextKeyUser).(User)
+			var user User
+			if userObj, ok := context.Get(request, ContextKeyUser).(User); ok {
+				user = userObj
+			}
 			restContext := RestContext{PathVariables: mux.Vars(request), QueryVariables: request.Form, User: user}
 			respReq := UnwrappedRestHandlerInput{writer, request}
 
 			marshaller := ContentTypeMarshallers["application/json"]
 
-			userOk := false
-			if route.AuthZChecker == nil {
-				for _, role := range user.Roles {
-					if role.Name == RoleAdmin || role.Name == RoleService {
-						userOk = true
-						break
-					}
-				}
-			} else {
-				userOk = route.AuthZChecker(restContext)
-			}
-			if !userOk {
+			if !authorized(route, restContext) {
 				write403(writer, marshaller)
 				return
 			}
@@ -304,22 +296,10 @@ func wrapHandler(restHandler RestHandler, route Route) http.Handler {
 			User:           user,
 		}
 
-		// Currently disabled authenticator
-		//		userOk := false
-		//		if route.AuthZChecker == nil {
-		//			for _, role := range user.Roles {
-		//				if role.Name == RoleAdmin || role.Name == RoleService {
-		//					userOk = true
-		//					break
-		//				}
-		//			}
-		//		} else {
-		//			userOk = route.AuthZChecker(restContext)
-		//		}
-		//		if !userOk {
-		//			write403(writer, marshaller)
-		//			return
-		//		}
+		if !authorized(route, restContext) {
+			write403(writer, marshaller)
+			return
+		}
 
 		outData, err := restHandler(inData, restContext)
 		if err == nil {
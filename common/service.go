@@ -19,6 +19,7 @@ package common
 // interfaces.
 
 import (
+	"crypto/tls"
 	clog "log"
 	"net"
 	"net/http"
@@ -108,7 +109,7 @@ type Service interface {
 }
 
 // initNegroni initializes Negroni with all the middleware and starts it.
-func initNegroni(service Service) (*RestServiceInfo, error) {
+func initNegroni(service Service, config Config) (*RestServiceInfo, error) {
 	var err error
 	// Create negroni
 	negroni := negroni.New()
@@ -126,17 +127,24 @@ func initNegroni(service Service) (*RestServiceInfo, error) {
 	// into a map
 	negroni.Use(NewUnmarshaller())
 
-	//	authMiddleware, err := NewAuthMiddleware(service, config, client)
-	//	if err != nil {
-	//		return nil, err
-	//	}
-	//	negroni.Use(authMiddleware)
+	// Authenticate requests, this has to follow content negotiation
+	// since errors are marshalled according to negotiated content type.
+	authMiddleware, err := NewAuthMiddleware(config)
+	if err != nil {
+		return nil, err
+	}
+	negroni.Use(authMiddleware)
 
 	router := newRouter(service.Routes())
 	timeoutHandler := http.TimeoutHandler(router, DefaultTimeout, TimeoutMessage)
 	negroni.UseHandler(timeoutHandler)
 
-	svcInfo, err := RunNegroni(negroni, service.GetAddress())
+	tlsConfig, err := config.ServerTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	svcInfo, err := RunNegroni(negroni, service.GetAddress(), tlsConfig)
 	return svcInfo, err
 }
 
@@ -152,7 +160,7 @@ func InitializeService(service Service, config Config) (*RestServiceInfo, error)
 		return nil, err
 	}
 
-	svcInfo, err := initNegroni(service)
+	svcInfo, err := initNegroni(service, config)
 	if err != nil {
 		return nil, err
 	}
@@ -162,10 +170,11 @@ func InitializeService(service Service, config Config) (*RestServiceInfo, error)
 
 // RunNegroni is a convenience function that runs the negroni stack as a
 // provided HTTP server, with the following caveats:
-// 1. the Handler field of the provided serverConfig should be nil,
-//    because the Handler used will be the n Negroni object.
-func RunNegroni(n *negroni.Negroni, addr string) (*RestServiceInfo, error) {
-	svr := &http.Server{Addr: addr}
+//  1. the Handler field of the provided serverConfig should be nil,
+//     because the Handler used will be the n Negroni object.
+//  2. if tlsConfig is provided, HTTPS is served instead of HTTP.
+func RunNegroni(n *negroni.Negroni, addr string, tlsConfig *tls.Config) (*RestServiceInfo, error) {
+	svr := &http.Server{Addr: addr, TLSConfig: tlsConfig}
 	l := clog.New(os.Stderr, "[negroni] ", 0)
 	svr.Handler = n
 	svr.ErrorLog = l
@@ -194,7 +203,8 @@ func (ln tcpKeepAliveListener) Accept() (c net.Conn, err error) {
 
 // ListenAndServe is same as http.ListenAndServe except it returns
 // the address that will be listened on (which is useful when using
-// arbitrary ports).
+// arbitrary ports). If TLSConfig of the server is set, it serves
+// HTTPS like http.ListenAndServeTLS.
 // See https://github.com/golang/go/blob/master/src/net/http/server.go
 func ListenAndServe(svr *http.Server) (*RestServiceInfo, error) {
 	log.Infof("Entering ListenAndServe(%p)", svr)
@@ -214,7 +224,11 @@ func ListenAndServe(svr *http.Server) (*RestServiceInfo, error) {
 	go func() {
 		channel <- Starting
 		l.Printf("ListenAndServe(%p): listening on %s (asked for %s)\n", svr, realAddr, svr.Addr)
-		err := svr.Serve(tcpKeepAliveListener{ln.(*net.TCPListener)})
+		var listener net.Listener = tcpKeepAliveListener{ln.(*net.TCPListener)}
+		if svr.TLSConfig != nil {
+			listener = tls.NewListener(listener, svr.TLSConfig)
+		}
+		err := svr.Serve(listener)
 		if err != nil {
 			log.Criticalf("RestService: Fatal error %v", err)
 			os.Exit(255)
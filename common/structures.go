@@ -16,6 +16,7 @@
 package common
 
 import (
+	"math/big"
 	"net"
 )
 
@@ -43,3 +44,28 @@ func IPv4ToInt(ip net.IP) uint64 {
 func IntToIPv4(ipInt uint64) net.IP {
 	return net.IPv4(byte(ipInt>>24), byte(ipInt>>16), byte(ipInt>>8), byte(ipInt))
 }
+
+// IPToBigInt converts an IP address to a big.Int. IPv4 addresses
+// (including IPv4-mapped IPv6 ones) are converted from their 4-byte
+// form, so that the result is the same as IPv4ToInt.
+func IPToBigInt(ip net.IP) *big.Int {
+	if ip4 := ip.To4(); ip4 != nil {
+		return new(big.Int).SetBytes(ip4)
+	}
+	return new(big.Int).SetBytes(ip.To16())
+}
+
+// BigIntToIP converts a big.Int back to an IP address. If ipv6 is
+// false, the result is an IPv4 address.
+func BigIntToIP(i *big.Int, ipv6 bool) net.IP {
+	if !ipv6 {
+		return IntToIPv4(i.Uint64())
+	}
+	b := i.Bytes()
+	ip := make(net.IP, net.IPv6len)
+	if len(b) > net.IPv6len {
+		b = b[len(b)-net.IPv6len:]
+	}
+	copy(ip[net.IPv6len-len(b):], b)
+	return ip
+}
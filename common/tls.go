@@ -0,0 +1,128 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+// TLS-related code.
+
+package common
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"io/ioutil"
+)
+
+// loadCertPool reads PEM encoded CA certificates from the file.
+func loadCertPool(caFile string) (*x509.CertPool, error) {
+	data, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in %s", caFile)
+	}
+	return pool, nil
+}
+
+// NewClientTLSConfig creates TLS configuration for connecting to
+// servers. Server certificates are verified with CA certificates from
+// caFile, or with system CAs if it is empty. Client certificate is
+// presented if certFile and keyFile are provided.
+func NewClientTLSConfig(caFile string, certFile string, keyFile string) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if caFile != "" {
+		pool, err := loadCertPool(caFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// NewServerTLSConfig creates TLS configuration for serving with
+// the certificate from certFile and keyFile. If clientCAFile is
+// provided, clients must present certificates signed by these CAs.
+func NewServerTLSConfig(certFile string, keyFile string, clientCAFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+	}
+
+	if clientCAFile != "" {
+		pool, err := loadCertPool(clientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return tlsConfig, nil
+}
+
+// EtcdTLSConfig returns TLS configuration for etcd client
+// connections, or nil if no etcd TLS options are set.
+func (c Config) EtcdTLSConfig() (*tls.Config, error) {
+	if c.EtcdCAFile == "" && c.EtcdCertFile == "" && c.EtcdKeyFile == "" {
+		return nil, nil
+	}
+	return NewClientTLSConfig(c.EtcdCAFile, c.EtcdCertFile, c.EtcdKeyFile)
+}
+
+// ServerTLSConfig returns TLS configuration for serving REST
+// API, or nil if plain HTTP should be served.
+func (c Config) ServerTLSConfig() (*tls.Config, error) {
+	if c.TLSCertFile == "" && c.TLSKeyFile == "" {
+		if c.TLSClientCAFile != "" {
+			return nil, fmt.Errorf("client certificate verification requires TLS certificate and key")
+		}
+		return nil, nil
+	}
+	return NewServerTLSConfig(c.TLSCertFile, c.TLSKeyFile, c.TLSClientCAFile)
+}
+
+// AddEtcdTLSFlags registers command line flags
+// for etcd client TLS options of the config.
+func (c *Config) AddEtcdTLSFlags(flagSet *flag.FlagSet) {
+	flagSet.StringVar(&c.EtcdCAFile, "etcd-cafile", "", "CA certificates to verify etcd servers with, enables TLS to etcd.")
+	flagSet.StringVar(&c.EtcdCertFile, "etcd-certfile", "", "Client certificate to present to etcd servers.")
+	flagSet.StringVar(&c.EtcdKeyFile, "etcd-keyfile", "", "Key of the client certificate to present to etcd servers.")
+}
+
+// AddServerTLSFlags registers command line flags
+// for REST API TLS options of the config.
+func (c *Config) AddServerTLSFlags(flagSet *flag.FlagSet) {
+	flagSet.StringVar(&c.TLSCertFile, "tls-cert-file", "", "Certificate to serve HTTPS with.")
+	flagSet.StringVar(&c.TLSKeyFile, "tls-key-file", "", "Key of the certificate to serve HTTPS with.")
+	flagSet.StringVar(&c.TLSClientCAFile, "tls-client-ca-file", "", "CA certificates to verify client certificates with, enables mutual TLS.")
+}
@@ -0,0 +1,165 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package common
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCert creates a certificate signed by parent (self-signed if
+// parent is nil) and writes it and its key into dir.
+func writeCert(t *testing.T, dir string, name string, isCA bool,
+	parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if isCA {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	if err := ioutil.WriteFile(filepath.Join(dir, name+".pem"), certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, name+"-key.pem"), keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return cert, key
+}
+
+// clientTLSFiles groups client TLS files for TestMutualTLS.
+type clientTLSFiles struct {
+	ca, cert, key string
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "romana-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca, caKey := writeCert(t, dir, "ca", true, nil, nil)
+	writeCert(t, dir, "server", false, ca, caKey)
+	writeCert(t, dir, "client", false, ca, caKey)
+	path := func(name string) string { return filepath.Join(dir, name) }
+
+	config := Config{
+		TLSCertFile:     path("server.pem"),
+		TLSKeyFile:      path("server-key.pem"),
+		TLSClientCAFile: path("ca.pem"),
+	}
+	serverTLS, err := config.ServerTLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	server.TLS = serverTLS
+	server.StartTLS()
+	defer server.Close()
+
+	get := func(clientTLS *clientTLSFiles) error {
+		tlsConfig, err := NewClientTLSConfig(clientTLS.ca, clientTLS.cert, clientTLS.key)
+		if err != nil {
+			return err
+		}
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		return nil
+	}
+
+	if err := get(&clientTLSFiles{path("ca.pem"), path("client.pem"), path("client-key.pem")}); err != nil {
+		t.Errorf("expected request with client certificate to succeed, %s", err)
+	}
+	if err := get(&clientTLSFiles{path("ca.pem"), "", ""}); err == nil {
+		t.Errorf("expected request without client certificate to fail")
+	}
+	if err := get(&clientTLSFiles{"", path("client.pem"), path("client-key.pem")}); err == nil {
+		t.Errorf("expected request without CA to fail to verify server")
+	}
+}
+
+func TestTLSConfigDefaults(t *testing.T) {
+	config := Config{}
+	if tlsConfig, err := config.ServerTLSConfig(); tlsConfig != nil || err != nil {
+		t.Errorf("expected no server TLS by default, got %v, %v", tlsConfig, err)
+	}
+	if tlsConfig, err := config.EtcdTLSConfig(); tlsConfig != nil || err != nil {
+		t.Errorf("expected no etcd TLS by default, got %v, %v", tlsConfig, err)
+	}
+
+	config.TLSClientCAFile = "ca.pem"
+	if _, err := config.ServerTLSConfig(); err == nil {
+		t.Errorf("expected error for client CA without server certificate")
+	}
+
+	config = Config{EtcdCAFile: "/nonexistent/ca.pem"}
+	if _, err := config.EtcdTLSConfig(); err == nil {
+		t.Errorf("expected error for missing etcd CA file")
+	}
+}
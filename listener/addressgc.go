@@ -0,0 +1,214 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+// Package listener's addressgc.go contains a reconciler that releases
+// IPAM addresses of kubernetes pods which no longer exist, e.g. when
+// CNI DEL was never called for the pod because of a node crash.
+package listener
+
+import (
+	"net"
+	"strings"
+	"time"
+
+	romanaErrors "github.com/romana/core/common/api/errors"
+
+	log "github.com/romana/rlog"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+const (
+	defaultAddressGCIntervalStr    = "5m"
+	defaultAddressGCGracePeriodStr = "10m"
+)
+
+// addressGC tracks IPAM addresses that have no matching pod and
+// releases them once they stay orphaned for longer than a grace period.
+type addressGC struct {
+	gracePeriod time.Duration
+	dryRun      bool
+
+	// orphans maps address name to the time when address
+	// was first found without a pod.
+	orphans map[string]time.Time
+}
+
+// addressGCResult summarizes one pass of addressGC.
+type addressGCResult struct {
+	Orphaned int
+	Released []string
+	Failed   int
+}
+
+func newAddressGC(gracePeriod time.Duration, dryRun bool) *addressGC {
+	return &addressGC{
+		gracePeriod: gracePeriod,
+		dryRun:      dryRun,
+		orphans:     make(map[string]time.Time),
+	}
+}
+
+// parseAddressName splits address name produced by CNI plugin
+// for kubernetes pods, <pod name>.<namespace>.<infra container id>,
+// into pod name and namespace. Pod names may contain dots
+// but namespaces and container ids can not.
+func parseAddressName(name string) (pod string, namespace string, ok bool) {
+	parts := strings.Split(name, ".")
+	if len(parts) < 3 {
+		return "", "", false
+	}
+
+	pod = strings.Join(parts[:len(parts)-2], ".")
+	namespace = parts[len(parts)-2]
+	if pod == "" || namespace == "" || parts[len(parts)-1] == "" {
+		return "", "", false
+	}
+
+	return pod, namespace, true
+}
+
+// podKey builds a key to match pods against address names.
+func podKey(pod, namespace string) string {
+	return namespace + "/" + pod
+}
+
+// podOwnsAddress returns true if the pod with the given IP, as reported
+// by kubernetes, owns the address. Addresses of earlier sandboxes of
+// the pod have IPs other than the pod's. Pods without IP may be in
+// the middle of setting up a sandbox, so they own any of their addresses.
+func podOwnsAddress(podIP string, ip net.IP) bool {
+	return podIP == "" || net.ParseIP(podIP).Equal(ip)
+}
+
+// collect compares allocated address names against the set of
+// existing pods, mapped to their IPs, and releases addresses which
+// stayed orphaned for longer than a grace period. Names which do
+// not look like kubernetes pod addresses are ignored.
+func (gc *addressGC) collect(addresses map[string]net.IP, pods map[string]string, now time.Time, release func(string) error) addressGCResult {
+	var result addressGCResult
+	orphans := make(map[string]time.Time)
+
+	for name, ip := range addresses {
+		if strings.HasPrefix(name, romanaVIPAddressPrefix) {
+			continue
+		}
+
+		pod, namespace, ok := parseAddressName(name)
+		if !ok {
+			continue
+		}
+
+		podIP, exists := pods[podKey(pod, namespace)]
+		if exists && podOwnsAddress(podIP, ip) {
+			continue
+		}
+
+		since, ok := gc.orphans[name]
+		if !ok {
+			if exists {
+				log.Infof("Address %s (%s) belongs to an earlier sandbox of pod %s (%s) in namespace %s, will release it after %s",
+					name, ip, pod, podIP, namespace, gc.gracePeriod)
+			} else {
+				log.Infof("Address %s (%s) has no pod %s in namespace %s, will release it after %s",
+					name, ip, pod, namespace, gc.gracePeriod)
+			}
+			since = now
+		}
+
+		if now.Sub(since) < gc.gracePeriod {
+			orphans[name] = since
+			result.Orphaned++
+			continue
+		}
+
+		if gc.dryRun {
+			log.Infof("Dry run, not releasing address %s (%s) orphaned since %s", name, ip, since)
+			orphans[name] = since
+			result.Orphaned++
+			continue
+		}
+
+		err := release(name)
+		if err != nil {
+			if _, ok := err.(romanaErrors.RomanaNotFoundError); !ok {
+				log.Errorf("Failed to release address %s (%s), %s", name, ip, err)
+				orphans[name] = since
+				result.Orphaned++
+				result.Failed++
+				continue
+			}
+		}
+
+		log.Infof("Released address %s (%s) orphaned since %s", name, ip, since)
+		result.Released = append(result.Released, name)
+	}
+
+	gc.orphans = orphans
+	return result
+}
+
+// startAddressGC periodically releases IPAM addresses of
+// pods that no longer exist.
+func (l *KubeListener) startAddressGC(stop <-chan struct{}) {
+	if l.addressGCInterval <= 0 {
+		log.Infof("Address garbage collection disabled")
+		return
+	}
+
+	log.Infof("Starting address garbage collection every %s, grace period %s, dry run %t",
+		l.addressGCInterval, l.addressGC.gracePeriod, l.addressGC.dryRun)
+
+	go func() {
+		ticker := time.NewTicker(l.addressGCInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				l.collectAddresses()
+			case <-stop:
+				log.Infof("Stopping address garbage collection")
+				return
+			}
+		}
+	}()
+}
+
+// collectAddresses runs one pass of address garbage collection.
+func (l *KubeListener) collectAddresses() {
+	// Addresses are copied before pods are listed, so that
+	// addresses allocated in between are not considered orphaned.
+	addresses := l.client.ListAddresses()
+
+	podList, err := l.kubeClientSet.Core().Pods("").List(v1.ListOptions{})
+	if err != nil {
+		log.Errorf("Failed to list pods for address garbage collection, %s", err)
+		AddressGCErrors.Inc()
+		return
+	}
+
+	pods := make(map[string]string)
+	for _, pod := range podList.Items {
+		pods[podKey(pod.Name, pod.Namespace)] = pod.Status.PodIP
+	}
+
+	result := l.addressGC.collect(addresses, pods, time.Now(), l.client.IPAM.DeallocateIP)
+
+	AddressGCOrphaned.Set(float64(result.Orphaned))
+	AddressGCReleased.Add(float64(len(result.Released)))
+	AddressGCErrors.Add(float64(result.Failed))
+	log.Debugf("Address garbage collection checked %d addresses against %d pods, %d orphaned, %d released",
+		len(addresses), len(pods), result.Orphaned, len(result.Released))
+}
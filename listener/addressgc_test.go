@@ -0,0 +1,147 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package listener
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	romanaErrors "github.com/romana/core/common/api/errors"
+)
+
+func TestParseAddressName(t *testing.T) {
+	cases := []struct {
+		name, pod, namespace string
+		ok                   bool
+	}{
+		{"nginx-1234.default.a1b2c3d4", "nginx-1234", "default", true},
+		{"web.example.com.prod.a1b2c3d4", "web.example.com", "prod", true},
+		{"nginx.default", "", "", false},
+		{"vip", "", "", false},
+		{".default.a1b2c3d4", "", "", false},
+	}
+
+	for _, tc := range cases {
+		pod, namespace, ok := parseAddressName(tc.name)
+		if pod != tc.pod || namespace != tc.namespace || ok != tc.ok {
+			t.Errorf("parseAddressName(%q) = %q, %q, %t, expected %q, %q, %t",
+				tc.name, pod, namespace, ok, tc.pod, tc.namespace, tc.ok)
+		}
+	}
+}
+
+func TestAddressGCCollect(t *testing.T) {
+	addresses := map[string]net.IP{
+		"alive.default.a1b2c3d4": net.ParseIP("10.0.0.1"),
+		"gone.default.a1b2c3d4":  net.ParseIP("10.0.0.2"),
+		"gone.kube.e5f6a7b8":     net.ParseIP("10.0.0.3"),
+		"not-a-pod":              net.ParseIP("10.0.0.4"),
+	}
+	pods := map[string]string{
+		podKey("alive", "default"): "10.0.0.1",
+	}
+
+	var released []string
+	release := func(name string) error {
+		released = append(released, name)
+		if name == "gone.kube.e5f6a7b8" {
+			return romanaErrors.NewRomanaNotFoundError("", "address", fmt.Sprintf("name=%s", name))
+		}
+		return nil
+	}
+
+	start := time.Now()
+	gc := newAddressGC(10*time.Minute, false)
+
+	// Orphans are only recorded on the first pass.
+	result := gc.collect(addresses, pods, start, release)
+	if result.Orphaned != 2 || len(result.Released) != 0 || len(released) != 0 {
+		t.Fatalf("unexpected result of first pass %+v, released %v", result, released)
+	}
+
+	// Still within grace period.
+	result = gc.collect(addresses, pods, start.Add(5*time.Minute), release)
+	if result.Orphaned != 2 || len(released) != 0 {
+		t.Fatalf("unexpected result within grace period %+v, released %v", result, released)
+	}
+
+	// Pod showed up, address is no longer orphaned.
+	pods[podKey("gone", "kube")] = ""
+	result = gc.collect(addresses, pods, start.Add(6*time.Minute), release)
+	if result.Orphaned != 1 {
+		t.Fatalf("unexpected result after pod showed up %+v", result)
+	}
+	delete(pods, podKey("gone", "kube"))
+
+	result = gc.collect(addresses, pods, start.Add(11*time.Minute), release)
+	if len(result.Released) != 1 || result.Released[0] != "gone.default.a1b2c3d4" || result.Orphaned != 1 {
+		t.Fatalf("unexpected result after grace period %+v", result)
+	}
+
+	// Address already released elsewhere counts as released.
+	delete(addresses, "gone.default.a1b2c3d4")
+	result = gc.collect(addresses, pods, start.Add(22*time.Minute), release)
+	if len(result.Released) != 1 || result.Orphaned != 0 || result.Failed != 0 {
+		t.Fatalf("unexpected result for address released elsewhere %+v", result)
+	}
+}
+
+func TestAddressGCOldSandbox(t *testing.T) {
+	addresses := map[string]net.IP{
+		"restarted.default.a1b2c3d4": net.ParseIP("10.0.0.1"),
+		"restarted.default.e5f6a7b8": net.ParseIP("10.0.0.2"),
+		"pending.default.a1b2c3d4":   net.ParseIP("10.0.0.3"),
+	}
+	pods := map[string]string{
+		podKey("restarted", "default"): "10.0.0.2",
+		podKey("pending", "default"):   "",
+	}
+
+	var released []string
+	release := func(name string) error {
+		released = append(released, name)
+		return nil
+	}
+
+	start := time.Now()
+	gc := newAddressGC(time.Minute, false)
+	gc.collect(addresses, pods, start, release)
+	result := gc.collect(addresses, pods, start.Add(time.Hour), release)
+	if len(released) != 1 || released[0] != "restarted.default.a1b2c3d4" || result.Orphaned != 0 {
+		t.Errorf("expected only address of earlier sandbox released, got %v, %+v", released, result)
+	}
+}
+
+func TestAddressGCDryRun(t *testing.T) {
+	addresses := map[string]net.IP{
+		"gone.default.a1b2c3d4": net.ParseIP("10.0.0.2"),
+	}
+
+	release := func(name string) error {
+		t.Errorf("unexpected release of %s in dry run", name)
+		return nil
+	}
+
+	start := time.Now()
+	gc := newAddressGC(time.Minute, true)
+	gc.collect(addresses, nil, start, release)
+	result := gc.collect(addresses, nil, start.Add(time.Hour), release)
+	if result.Orphaned != 1 || len(result.Released) != 0 {
+		t.Errorf("unexpected result of dry run %+v", result)
+	}
+}
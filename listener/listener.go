@@ -78,6 +78,22 @@ type KubeListener struct {
 
 	// romanaExposedIPSpecMap stores romana VIP mapping information.
 	romanaExposedIPSpecMap ExposedIPSpecMap
+
+	// serviceStore and endpointsStore cache services and endpoints
+	// used to place romana VIPs on nodes, romanaVIPSyncMutex
+	// serializes romana VIP resynchronization.
+	serviceStore       cache.Store
+	endpointsStore     cache.Store
+	romanaVIPSyncMutex sync.Mutex
+
+	// romanaVIPNetwork is the IPAM network romana VIPs are
+	// allocated from in auto mode, auto mode is disabled if empty.
+	romanaVIPNetwork string
+
+	// addressGC releases IPAM addresses of pods that no longer exist,
+	// every addressGCInterval, disabled when interval is 0.
+	addressGC         *addressGC
+	addressGCInterval time.Duration
 }
 
 // Routes returns various routes used in the service.
@@ -126,6 +142,37 @@ func (l *KubeListener) loadConfig() error {
 	}
 	l.nodeAttributes = strings.Split(nodeAttrStr, ",")
 
+	var addressGCInterval string
+	addressGCInterval, err = l.client.Store.GetString(configPrefix+"addressGCInterval", defaultAddressGCIntervalStr)
+	if err != nil {
+		return err
+	}
+	l.addressGCInterval, err = time.ParseDuration(addressGCInterval)
+	if err != nil {
+		return err
+	}
+
+	var addressGCGracePeriodStr string
+	addressGCGracePeriodStr, err = l.client.Store.GetString(configPrefix+"addressGCGracePeriod", defaultAddressGCGracePeriodStr)
+	if err != nil {
+		return err
+	}
+	addressGCGracePeriod, err := time.ParseDuration(addressGCGracePeriodStr)
+	if err != nil {
+		return err
+	}
+
+	addressGCDryRun, err := l.client.Store.GetBool(configPrefix+"addressGCDryRun", false)
+	if err != nil {
+		return err
+	}
+	l.addressGC = newAddressGC(addressGCGracePeriod, addressGCDryRun)
+
+	l.romanaVIPNetwork, err = l.client.Store.GetString(client.RomanaVIPNetworkKey, "")
+	if err != nil {
+		return err
+	}
+
 	if err := l.kubeClientInit(); err != nil {
 		return fmt.Errorf("Error while loading kubernetes client %s", err)
 	}
@@ -184,6 +231,8 @@ func (l *KubeListener) Initialize(clientConfig common.Config) error {
 	l.romanaExposedIPSpecMap = ExposedIPSpecMap{IPForService: make(map[string]api.ExposedIPSpec)}
 	l.startRomanaVIPSync(done)
 
+	l.startAddressGC(done)
+
 	log.Info("All routines started")
 	return nil
 }
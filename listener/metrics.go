@@ -0,0 +1,75 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package listener
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/romana/rlog"
+)
+
+var (
+	AddressGCReleased = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "romana_ipam_gc_released_addresses_total",
+			Help: "Number of orphaned IPAM addresses released by garbage collection.",
+		},
+	)
+	AddressGCErrors = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "romana_ipam_gc_errors_total",
+			Help: "Number of errors during IPAM address garbage collection.",
+		},
+	)
+	AddressGCOrphaned = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "romana_ipam_gc_orphaned_addresses",
+			Help: "Number of IPAM addresses without a pod waiting to be released.",
+		},
+	)
+)
+
+// MetricStart publishes listener metrics on given port.
+func MetricStart(port int) error {
+	if port <= 0 {
+		return nil
+	}
+
+	registry := prometheus.NewRegistry()
+	for _, collector := range []prometheus.Collector{
+		AddressGCReleased,
+		AddressGCErrors,
+		AddressGCOrphaned,
+	} {
+		err := registry.Register(collector)
+		if err != nil {
+			return err
+		}
+	}
+
+	handler := promhttp.HandlerFor(registry, promhttp.HandlerOpts{ErrorHandling: promhttp.HTTPErrorOnError})
+
+	go func() {
+		mux := http.NewServeMux()
+		mux.Handle("/", handler)
+		log.Errorf("Metrics publishing stopped due to %s", http.ListenAndServe(fmt.Sprintf(":%d", port), mux))
+	}()
+
+	return nil
+}
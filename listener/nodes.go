@@ -318,6 +318,15 @@ func (l *KubeListener) kubernetesUpdateNodeEventHandler(o, n interface{}) {
 		return
 	}
 
+	// romana VIPs have to be moved away from nodes that went
+	// not ready, or placed on nodes that became ready if they
+	// couldn't be placed anywhere before.
+	if oldNode, ok := o.(*v1.Node); ok && nodeReady(oldNode) != nodeReady(node) {
+		log.Infof("Node %s readiness changed to %t, resynchronizing romana VIPs",
+			node.Name, nodeReady(node))
+		go l.resyncRomanaVIPs()
+	}
+
 	host, err := l.nodeToHost(node)
 	if err != nil {
 		log.Errorf("Cannot update node %s: %s", node.Name, err)
@@ -21,17 +21,19 @@ import (
 	"fmt"
 	"net"
 	"os"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/romana/core/common/api"
+	romanaErrors "github.com/romana/core/common/api/errors"
 	"github.com/romana/core/common/log/trace"
 
 	log "github.com/romana/rlog"
 	k8sapi "k8s.io/client-go/pkg/api"
 	"k8s.io/client-go/pkg/api/v1"
 	"k8s.io/client-go/pkg/fields"
-	"k8s.io/client-go/pkg/labels"
 	"k8s.io/client-go/tools/cache"
 )
 
@@ -44,7 +46,45 @@ var (
 	serviceSyncTimer = 60 * time.Second
 )
 
+const (
+	// romanaVIPAnnotation holds romana VIP configuration for the service.
+	romanaVIPAnnotation = "romanavip"
+
+	// romanaVIPIPAnnotation is set by the listener to the romana VIP
+	// allocated for the service in auto mode.
+	romanaVIPIPAnnotation = "romanavip-ip"
+
+	// romanaVIPAddressPrefix prefixes IPAM address names of romana
+	// VIPs allocated in auto mode, it contains a character not allowed
+	// in kubernetes names so it can't be mistaken for a pod address.
+	romanaVIPAddressPrefix = "romanavip:"
+)
+
 func (l *KubeListener) startRomanaVIPSync(stop <-chan struct{}) {
+	// endpointsWatcher is used to find nodes where pods backing
+	// services run, so romana VIPs can be moved to another node
+	// when the current one loses its endpoints.
+	endpointsWatcher := cache.NewListWatchFromClient(
+		l.kubeClientSet.CoreV1Client.RESTClient(),
+		"endpoints",
+		k8sapi.NamespaceAll,
+		fields.Everything())
+
+	endpointsStore, endpointsInformer := cache.NewInformer(
+		endpointsWatcher,
+		&v1.Endpoints{},
+		0,
+		cache.ResourceEventHandlerFuncs{
+			AddFunc:    l.kubernetesEndpointsEventHandler,
+			UpdateFunc: func(o, n interface{}) { l.kubernetesEndpointsEventHandler(n) },
+			DeleteFunc: l.kubernetesEndpointsEventHandler,
+		},
+	)
+	l.endpointsStore = endpointsStore
+
+	log.Println("Started receiving endpoints events.")
+	go endpointsInformer.Run(stop)
+
 	// serviceWatcher is a new ListWatch object created from the specified
 	// CoreClientSet above for watching service events.
 	serviceWatcher := cache.NewListWatchFromClient(
@@ -64,6 +104,7 @@ func (l *KubeListener) startRomanaVIPSync(stop <-chan struct{}) {
 			DeleteFunc: l.kubernetesDeleteServiceEventHandler,
 		},
 	)
+	l.serviceStore = serviceStore
 
 	log.Println("Started receiving service events.")
 	go serviceInformer.Run(stop)
@@ -82,8 +123,8 @@ func (l *KubeListener) startRomanaVIPSync(stop <-chan struct{}) {
 			log.Errorf("timeout after %s while synchronizing services", duration)
 			os.Exit(1)
 		case <-ticker.C:
-			if serviceInformer.HasSynced() {
-				go l.startRomanaVIPPeriodicSync(stop, serviceStore)
+			if serviceInformer.HasSynced() && endpointsInformer.HasSynced() {
+				go l.startRomanaVIPPeriodicSync(stop)
 				return
 			}
 		case <-stop:
@@ -93,27 +134,20 @@ func (l *KubeListener) startRomanaVIPSync(stop <-chan struct{}) {
 	}
 }
 
-func (l *KubeListener) startRomanaVIPPeriodicSync(stop <-chan struct{}, serviceStore cache.Store) {
+func (l *KubeListener) startRomanaVIPPeriodicSync(stop <-chan struct{}) {
 	serviceSyncTicker := time.NewTicker(serviceSyncTimer)
 	defer serviceSyncTicker.Stop()
-	var romanaVIPPeriodicSyncMutex sync.Mutex
-
-	// run syncRomanaVIPs and syncExposedIPs once before running
-	// them at the interval of serviceSyncTimer, since ticker
-	// skips the 0th interval and starts from the first
-	// serviceSyncTimer interval.
-	romanaVIPPeriodicSyncMutex.Lock()
-	l.syncRomanaVIPs(serviceStore)
-	l.syncExposedIPs()
-	romanaVIPPeriodicSyncMutex.Unlock()
+
+	// run resyncRomanaVIPs once before running it at the
+	// interval of serviceSyncTimer, since ticker skips the
+	// 0th interval and starts from the first serviceSyncTimer
+	// interval.
+	l.resyncRomanaVIPs()
 
 	for {
 		select {
 		case <-serviceSyncTicker.C:
-			romanaVIPPeriodicSyncMutex.Lock()
-			l.syncRomanaVIPs(serviceStore)
-			l.syncExposedIPs()
-			romanaVIPPeriodicSyncMutex.Unlock()
+			l.resyncRomanaVIPs()
 		case <-stop:
 			log.Info("received stop request from listener")
 			return
@@ -121,12 +155,40 @@ func (l *KubeListener) startRomanaVIPPeriodicSync(stop <-chan struct{}, serviceS
 	}
 }
 
+// resyncRomanaVIPs brings romana VIPs in the kvstore in line with
+// services, their endpoints and readiness of nodes.
+func (l *KubeListener) resyncRomanaVIPs() {
+	if l.serviceStore == nil {
+		return
+	}
+
+	l.romanaVIPSyncMutex.Lock()
+	defer l.romanaVIPSyncMutex.Unlock()
+
+	l.syncRomanaVIPs(l.serviceStore)
+	l.syncExposedIPs()
+}
+
 func (l *KubeListener) syncRomanaVIPs(serviceStore cache.Store) {
+	l.romanaExposedIPSpecMap.Lock()
+	defer l.romanaExposedIPSpecMap.Unlock()
+
 	serviceListAll := serviceStore.List()
 	romanaVIPMap := make(map[string]api.ExposedIPSpec)
 	serviceMap := make(map[string]v1.Service)
+	autoVIPs := make(map[string]bool)
 
 	for i := range serviceListAll {
+		// remember services asking for auto romana VIPs, even if
+		// their details can't be fetched at the moment, so that
+		// their allocations are not released below.
+		if service, ok := serviceListAll[i].(*v1.Service); ok {
+			key, romanaVIP, err := romanaVIPForService(service)
+			if err == nil && romanaVIP.Auto {
+				autoVIPs[key] = true
+			}
+		}
+
 		service, key, exposedIPSpec, err := l.extractServiceDetails(serviceListAll[i])
 		if err != nil {
 			log.Debugf("error fetching service details: %s", err)
@@ -137,14 +199,14 @@ func (l *KubeListener) syncRomanaVIPs(serviceStore cache.Store) {
 		serviceMap[key] = *service
 	}
 
+	l.releaseStaleRomanaVIPs(autoVIPs)
+
 	// if no service with romana VIP annotation is found, skip
 	// syncing, since there is nothing to be done here.
 	if len(romanaVIPMap) == 0 && len(l.romanaExposedIPSpecMap.IPForService) == 0 {
 		return
 	}
 
-	l.romanaExposedIPSpecMap.Lock()
-
 	// update/add new services which we see
 	for key, rip := range romanaVIPMap {
 		eip, ok := l.romanaExposedIPSpecMap.IPForService[key]
@@ -157,9 +219,9 @@ func (l *KubeListener) syncRomanaVIPs(serviceStore cache.Store) {
 		}
 
 		// update service locally for external IP
-		updatedService := serviceMap[key]
-		updatedService.Spec.ExternalIPs = []string{rip.RomanaVIP.IP}
-		_, err := l.kubeClientSet.CoreV1Client.Services(rip.Namespace).Update(&updatedService)
+		service := serviceMap[key]
+		updatedService := serviceWithRomanaVIP(&service, rip)
+		_, err := l.kubeClientSet.CoreV1Client.Services(rip.Namespace).Update(updatedService)
 		if err != nil {
 			log.Errorf("externalIP couldn't be updated for service (%s): %s",
 				key, err)
@@ -182,8 +244,6 @@ func (l *KubeListener) syncRomanaVIPs(serviceStore cache.Store) {
 		// service was removed, so lets remove the details about it here
 		delete(l.romanaExposedIPSpecMap.IPForService, key)
 	}
-
-	l.romanaExposedIPSpecMap.Unlock()
 }
 
 func (l *KubeListener) syncExposedIPs() {
@@ -260,35 +320,158 @@ func (l *KubeListener) extractServiceDetails(svc interface{}) (
 		return nil, "", nil, errors.New("error, received service information is not compatible")
 	}
 
+	key, romanaVIP, err := romanaVIPForService(service)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	serviceName := service.GetName()
+	namespace := service.GetNamespace()
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	if l.endpointsStore == nil || l.nodeStore == nil {
+		return nil, "", nil, fmt.Errorf("endpoints or nodes not synchronized yet for service (%s)",
+			serviceName)
+	}
+
+	item, exists, err := l.endpointsStore.GetByKey(namespace + "/" + serviceName)
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("endpoints error for service (%s): %s",
+			serviceName, err)
+	}
+	endpoints, ok := item.(*v1.Endpoints)
+	if !exists || !ok {
+		return nil, "", nil, fmt.Errorf("endpoints not found for service (%s)", serviceName)
+	}
+
+	// keep romana VIP on the node it is on now as long as
+	// the node is ready and has endpoints for the service.
+	var currentNodeIPAddress string
+	if exposedIPSpec, ok := l.romanaExposedIPSpecMap.IPForService[key]; ok {
+		currentNodeIPAddress = exposedIPSpec.NodeIPAddress
+	}
+
+	nodeName, nodeIPAddress, err := selectRomanaVIPNode(endpoints, currentNodeIPAddress, l.readyNodeAddress)
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("no node for romana VIP of service (%s): %s",
+			serviceName, err)
+	}
+
+	if romanaVIP.Auto {
+		romanaVIP.IP, err = l.allocateRomanaVIP(key, namespace, nodeName)
+		if err != nil {
+			return nil, "", nil, fmt.Errorf("romana VIP couldn't be allocated for service (%s): %s",
+				serviceName, err)
+		}
+	}
+
+	exposedIPSpec := api.ExposedIPSpec{
+		RomanaVIP:     romanaVIP,
+		NodeIPAddress: nodeIPAddress,
+		Activated:     true,
+		Namespace:     namespace,
+	}
+
+	return service, key, &exposedIPSpec, nil
+}
+
+// selectRomanaVIPNode selects a node for romana VIP among ready nodes
+// running ready endpoints of the service. Node with the current address
+// is preferred, so that romana VIP only moves when that node goes away,
+// otherwise nodes are tried in the order of their names.
+func selectRomanaVIPNode(endpoints *v1.Endpoints, currentNodeIPAddress string,
+	readyNodeAddress func(string) (string, bool)) (string, string, error) {
+
+	var nodeNames []string
+	seen := make(map[string]bool)
+	for _, subset := range endpoints.Subsets {
+		for _, address := range subset.Addresses {
+			if address.NodeName == nil || seen[*address.NodeName] {
+				continue
+			}
+			seen[*address.NodeName] = true
+			nodeNames = append(nodeNames, *address.NodeName)
+		}
+	}
+	sort.Strings(nodeNames)
+
+	var selectedName, selectedAddress string
+	for _, nodeName := range nodeNames {
+		nodeAddress, ready := readyNodeAddress(nodeName)
+		if !ready {
+			continue
+		}
+		if nodeAddress == currentNodeIPAddress {
+			return nodeName, nodeAddress, nil
+		}
+		if selectedName == "" {
+			selectedName, selectedAddress = nodeName, nodeAddress
+		}
+	}
+
+	if selectedName == "" {
+		return "", "", fmt.Errorf("no ready node with endpoints found among %v", nodeNames)
+	}
+
+	return selectedName, selectedAddress, nil
+}
+
+// readyNodeAddress returns address of the node with given name
+// and whether the node is known and ready.
+func (l *KubeListener) readyNodeAddress(nodeName string) (string, bool) {
+	item, exists, err := l.nodeStore.GetByKey(nodeName)
+	if err != nil || !exists {
+		return "", false
+	}
+
+	node, ok := item.(*v1.Node)
+	if !ok || !nodeReady(node) || len(node.Status.Addresses) < 1 {
+		return "", false
+	}
+
+	return node.Status.Addresses[0].Address, true
+}
+
+// nodeReady returns true if node reports Ready condition.
+func nodeReady(node *v1.Node) bool {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == v1.NodeReady {
+			return condition.Status == v1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// romanaVIPForService parses romana VIP annotation of the service
+// and returns it along with the key used to track the service.
+func romanaVIPForService(service *v1.Service) (string, api.RomanaVIP, error) {
+	var romanaVIP api.RomanaVIP
+
 	serviceName := service.GetName()
 	if serviceName == "" {
 		// no service name, so ignore it
-		return nil, "", nil, errors.New("error, received no service name")
+		return "", romanaVIP, errors.New("error, received no service name")
 	}
 
 	annotation := service.GetAnnotations()
-	romanaAnnotation, ok := annotation["romanavip"]
+	romanaAnnotation, ok := annotation[romanaVIPAnnotation]
 	if !ok {
 		// no romana VIP annotation for service, so ignore it
-		return nil, "", nil, fmt.Errorf("error, no romana VIP annotation found for the service: %s",
+		return "", romanaVIP, fmt.Errorf("error, no romana VIP annotation found for the service: %s",
 			serviceName)
 	}
 
-	var romanaVIP api.RomanaVIP
 	err := json.Unmarshal([]byte(romanaAnnotation), &romanaVIP)
 	if err != nil {
 		// romana VIP annotation is there, but not a
 		// valid one thus return an error.
-		return nil, "", nil, fmt.Errorf("error while accessing romana VIP annotation: %s", err)
+		return "", romanaVIP, fmt.Errorf("error while accessing romana VIP annotation: %s", err)
 	}
 
-	// TODO: implement auto cidr mode for romana VIPs
-	if romanaVIP.Auto {
-		return nil, "", nil, fmt.Errorf("romana VIP auto cidr mode not supported in this release")
-	}
-
-	if net.ParseIP(romanaVIP.IP) == nil {
-		return nil, "", nil, fmt.Errorf("romana VIP (%s) is not valid for service (%s)",
+	if !romanaVIP.Auto && net.ParseIP(romanaVIP.IP) == nil {
+		return "", romanaVIP, fmt.Errorf("romana VIP (%s) is not valid for service (%s)",
 			romanaVIP.IP, serviceName)
 	}
 
@@ -297,46 +480,136 @@ func (l *KubeListener) extractServiceDetails(svc interface{}) (
 		namespace = "default"
 	}
 
-	key := serviceName + "." + namespace
+	return serviceName + "." + namespace, romanaVIP, nil
+}
+
+// serviceWithRomanaVIP returns a copy of the service with romana VIP
+// set as its external IP, and recorded in an annotation if the VIP
+// was allocated in auto mode.
+func serviceWithRomanaVIP(service *v1.Service, exposedIPSpec api.ExposedIPSpec) *v1.Service {
+	updatedService := *service
+	updatedService.Spec.ExternalIPs = []string{exposedIPSpec.RomanaVIP.IP}
+
+	if exposedIPSpec.RomanaVIP.Auto {
+		annotations := make(map[string]string)
+		for k, v := range service.GetAnnotations() {
+			annotations[k] = v
+		}
+		annotations[romanaVIPIPAnnotation] = exposedIPSpec.RomanaVIP.IP
+		updatedService.SetAnnotations(annotations)
+	}
 
-	pods, err := l.kubeClientSet.CoreV1Client.Endpoints(namespace).List(
-		v1.ListOptions{
-			LabelSelector: labels.FormatLabels(service.GetLabels()),
-		})
-	if len(pods.Items) < 1 {
-		return nil, "", nil, fmt.Errorf("pod not found for service (%s)", serviceName)
+	return &updatedService
+}
+
+// romanaVIPAddressName returns IPAM address name for
+// the romana VIP of the service with the given key.
+func romanaVIPAddressName(key string) string {
+	return romanaVIPAddressPrefix + key
+}
+
+// allocateRomanaVIP returns romana VIP allocated for the service with the
+// given key from the romana VIP network, allocating one if the service
+// doesn't have it yet. Blocks of the romana VIP network are not routed
+// to their hosts (see client.RoutedBlocks), so the node the VIP is
+// allocated on doesn't tie the VIP to it.
+func (l *KubeListener) allocateRomanaVIP(key string, namespace string, nodeName string) (string, error) {
+	if l.romanaVIPNetwork == "" {
+		return "", errors.New("romana VIP network for auto mode is not configured")
+	}
+
+	addressName := romanaVIPAddressName(key)
+	if ip, ok := l.client.IPAM.AddressNameToIP[addressName]; ok {
+		return ip.String(), nil
+	}
+
+	ip, err := l.client.IPAM.AllocateIPInNetwork(addressName, l.romanaVIPNetwork, nodeName, namespace, "")
+	if _, ok := err.(romanaErrors.RomanaExistsError); ok {
+		// Allocated already, but IPAM of the client
+		// hasn't caught up with it yet.
+		ip, err = l.client.IPAM.LookupLatestAddressByName(addressName)
+		if err != nil {
+			return "", err
+		}
+		return ip.String(), nil
 	}
 	if err != nil {
-		return nil, "", nil, fmt.Errorf("pod error for service (%s): %s",
-			serviceName, err)
+		return "", err
 	}
-	if !(len(pods.Items[0].Subsets) > 0 &&
-		len(pods.Items[0].Subsets[0].Addresses) > 0) {
-		return nil, "", nil, fmt.Errorf("node address not found for service (%s)",
-			serviceName)
+
+	log.Infof("Allocated romana VIP (%s) for service (%s) from network %s",
+		ip, key, l.romanaVIPNetwork)
+	return ip.String(), nil
+}
+
+// releaseRomanaVIP releases romana VIP allocated in auto mode
+// for the service with the given key, if any.
+func (l *KubeListener) releaseRomanaVIP(key string) {
+	addressName := romanaVIPAddressName(key)
+	if _, ok := l.client.IPAM.AddressNameToIP[addressName]; !ok {
+		return
 	}
 
-	// use first pod to get node address for now until we support ipam
-	// for romana VIP allocations.
-	node, err := l.kubeClientSet.CoreV1Client.Nodes().Get(*pods.Items[0].Subsets[0].Addresses[0].NodeName)
+	err := l.client.IPAM.DeallocateIP(addressName)
 	if err != nil {
-		return nil, "", nil, fmt.Errorf("node not found for pod for service (%s): %s",
-			serviceName, err)
+		if _, ok := err.(romanaErrors.RomanaNotFoundError); !ok {
+			log.Errorf("error releasing romana VIP for service (%s): %s", key, err)
+			return
+		}
 	}
 
-	if len(node.Status.Addresses) < 1 {
-		return nil, "", nil, fmt.Errorf("node address not found for node (%s)",
-			node.Name)
+	log.Infof("Released romana VIP for service (%s)", key)
+}
+
+// releaseStaleRomanaVIPs releases romana VIPs allocated in auto mode
+// for services which are gone or don't ask for auto mode anymore.
+// This catches services deleted while the listener was not running.
+func (l *KubeListener) releaseStaleRomanaVIPs(autoVIPs map[string]bool) {
+	var stale []string
+	for addressName := range l.client.IPAM.AddressNameToIP {
+		if !strings.HasPrefix(addressName, romanaVIPAddressPrefix) {
+			continue
+		}
+		key := strings.TrimPrefix(addressName, romanaVIPAddressPrefix)
+		if !autoVIPs[key] {
+			stale = append(stale, key)
+		}
 	}
 
-	exposedIPSpec := api.ExposedIPSpec{
-		RomanaVIP:     romanaVIP,
-		NodeIPAddress: node.Status.Addresses[0].Address,
-		Activated:     true,
-		Namespace:     namespace,
+	for _, key := range stale {
+		l.releaseRomanaVIP(key)
 	}
+}
 
-	return service, key, &exposedIPSpec, nil
+// kubernetesEndpointsEventHandler is called when Kubernetes reports
+// changes in endpoints. Romana VIPs are resynchronized if endpoints
+// belong to a service with romana VIP, so that romana VIP can be
+// moved to another node if the current one lost its endpoints.
+func (l *KubeListener) kubernetesEndpointsEventHandler(n interface{}) {
+	endpoints, ok := n.(*v1.Endpoints)
+	if !ok {
+		log.Debugf("Error processing endpoints event (%s) ", n)
+		return
+	}
+
+	if l.serviceStore == nil {
+		return
+	}
+
+	item, exists, err := l.serviceStore.GetByKey(endpoints.Namespace + "/" + endpoints.Name)
+	if err != nil || !exists {
+		return
+	}
+	service, ok := item.(*v1.Service)
+	if !ok {
+		return
+	}
+	if _, ok := service.GetAnnotations()[romanaVIPAnnotation]; !ok {
+		return
+	}
+
+	log.Debugf("Endpoints changed for service (%s) with romana VIP", service.GetName())
+	go l.resyncRomanaVIPs()
 }
 
 // kubernetesAddServiceEventHandler is called when Kubernetes reports an
@@ -409,9 +682,8 @@ func (l *KubeListener) updateRomanaVIP(service *v1.Service) error {
 		return nil
 	}
 
-	updatedService := *service
-	updatedService.Spec.ExternalIPs = []string{exposedIPSpec.RomanaVIP.IP}
-	_, err = l.kubeClientSet.CoreV1Client.Services(exposedIPSpec.Namespace).Update(&updatedService)
+	updatedService := serviceWithRomanaVIP(service, *exposedIPSpec)
+	_, err = l.kubeClientSet.CoreV1Client.Services(exposedIPSpec.Namespace).Update(updatedService)
 	if err != nil {
 		return fmt.Errorf("externalIP couldn't be updated for service (%s): %s",
 			service.GetName(), err)
@@ -444,6 +716,10 @@ func (l *KubeListener) deleteRomanaVIP(service *v1.Service) {
 	}
 	key := serviceName + "." + namespace
 
+	// release auto mode romana VIP, if any, even if it wasn't
+	// exposed yet.
+	l.releaseRomanaVIP(key)
+
 	exposedIPSpec, ok := l.romanaExposedIPSpecMap.IPForService[key]
 	if !ok {
 		log.Debugf("romana VIP for service (%s) not found in the list", serviceName)
@@ -0,0 +1,169 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package listener
+
+import (
+	"testing"
+
+	"github.com/romana/core/common/api"
+
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+func newService(name, namespace string, annotations map[string]string) *v1.Service {
+	service := &v1.Service{}
+	service.Name = name
+	service.Namespace = namespace
+	service.Annotations = annotations
+	return service
+}
+
+func TestRomanaVIPForService(t *testing.T) {
+	cases := []struct {
+		service *v1.Service
+		key     string
+		vip     api.RomanaVIP
+		ok      bool
+	}{
+		{newService("web", "prod", map[string]string{"romanavip": `{"ip":"192.168.99.200"}`}),
+			"web.prod", api.RomanaVIP{IP: "192.168.99.200"}, true},
+		{newService("web", "", map[string]string{"romanavip": `{"auto":true}`}),
+			"web.default", api.RomanaVIP{Auto: true}, true},
+		{newService("web", "prod", map[string]string{"romanavip": `{"ip":"bogus"}`}),
+			"", api.RomanaVIP{}, false},
+		{newService("web", "prod", map[string]string{"romanavip": `{`}),
+			"", api.RomanaVIP{}, false},
+		{newService("web", "prod", nil), "", api.RomanaVIP{}, false},
+		{newService("", "prod", map[string]string{"romanavip": `{"auto":true}`}),
+			"", api.RomanaVIP{}, false},
+	}
+
+	for i, tc := range cases {
+		key, vip, err := romanaVIPForService(tc.service)
+		if (err == nil) != tc.ok {
+			t.Errorf("case %d: unexpected error %v", i, err)
+			continue
+		}
+		if tc.ok && (key != tc.key || vip != tc.vip) {
+			t.Errorf("case %d: expected %s %+v, got %s %+v", i, tc.key, tc.vip, key, vip)
+		}
+	}
+}
+
+func TestServiceWithRomanaVIP(t *testing.T) {
+	annotations := map[string]string{"romanavip": `{"auto":true}`}
+	service := newService("web", "prod", annotations)
+
+	updated := serviceWithRomanaVIP(service, api.ExposedIPSpec{
+		RomanaVIP: api.RomanaVIP{Auto: true, IP: "10.1.0.1"},
+	})
+	if len(updated.Spec.ExternalIPs) != 1 || updated.Spec.ExternalIPs[0] != "10.1.0.1" {
+		t.Errorf("unexpected external IPs %v", updated.Spec.ExternalIPs)
+	}
+	if updated.Annotations[romanaVIPIPAnnotation] != "10.1.0.1" {
+		t.Errorf("expected allocated VIP in annotations, got %v", updated.Annotations)
+	}
+	if _, ok := annotations[romanaVIPIPAnnotation]; ok || len(service.Spec.ExternalIPs) != 0 {
+		t.Errorf("original service must not be modified")
+	}
+
+	updated = serviceWithRomanaVIP(service, api.ExposedIPSpec{
+		RomanaVIP: api.RomanaVIP{IP: "192.168.99.200"},
+	})
+	if _, ok := updated.Annotations[romanaVIPIPAnnotation]; ok {
+		t.Errorf("unexpected annotation for manual VIP %v", updated.Annotations)
+	}
+}
+
+func TestSelectRomanaVIPNode(t *testing.T) {
+	nodeName := func(name string) *string { return &name }
+	endpoints := &v1.Endpoints{
+		Subsets: []v1.EndpointSubset{
+			{
+				Addresses: []v1.EndpointAddress{
+					{IP: "10.0.0.2", NodeName: nodeName("node2")},
+					{IP: "10.0.0.3", NodeName: nodeName("node3")},
+				},
+				NotReadyAddresses: []v1.EndpointAddress{
+					{IP: "10.0.0.1", NodeName: nodeName("node1")},
+				},
+			},
+			{
+				Addresses: []v1.EndpointAddress{
+					{IP: "10.0.0.4", NodeName: nodeName("node2")},
+					{IP: "10.0.0.5"},
+				},
+			},
+		},
+	}
+
+	var ready map[string]bool
+	readyNodeAddress := func(name string) (string, bool) {
+		return "192.168.99." + name[len(name)-1:], ready[name]
+	}
+
+	cases := []struct {
+		current string
+		ready   map[string]bool
+		name    string
+		address string
+	}{
+		// no current node, first ready node by name
+		{"", map[string]bool{"node1": true, "node2": true, "node3": true}, "node2", "192.168.99.2"},
+		// current node is kept
+		{"192.168.99.3", map[string]bool{"node2": true, "node3": true}, "node3", "192.168.99.3"},
+		// current node went not ready
+		{"192.168.99.3", map[string]bool{"node2": true}, "node2", "192.168.99.2"},
+		// current node has no ready endpoints
+		{"192.168.99.1", map[string]bool{"node1": true, "node3": true}, "node3", "192.168.99.3"},
+		// no ready nodes with endpoints
+		{"192.168.99.2", map[string]bool{"node1": true}, "", ""},
+	}
+
+	for i, tc := range cases {
+		ready = tc.ready
+		name, address, err := selectRomanaVIPNode(endpoints, tc.current, readyNodeAddress)
+		if tc.name == "" {
+			if err == nil {
+				t.Errorf("case %d: expected error, got %s %s", i, name, address)
+			}
+			continue
+		}
+		if err != nil || name != tc.name || address != tc.address {
+			t.Errorf("case %d: expected %s %s, got %s %s, err=%v",
+				i, tc.name, tc.address, name, address, err)
+		}
+	}
+}
+
+func TestNodeReady(t *testing.T) {
+	node := &v1.Node{}
+	if nodeReady(node) {
+		t.Errorf("node without conditions must not be ready")
+	}
+
+	node.Status.Conditions = []v1.NodeCondition{
+		{Type: v1.NodeReady, Status: v1.ConditionFalse},
+	}
+	if nodeReady(node) {
+		t.Errorf("node with Ready=False must not be ready")
+	}
+
+	node.Status.Conditions[0].Status = v1.ConditionTrue
+	if !nodeReady(node) {
+		t.Errorf("node with Ready=True must be ready")
+	}
+}
@@ -97,7 +97,7 @@ func (l *Translator) translateNetworkPolicy(kubePolicy *v1beta1.NetworkPolicy) (
 	romanaPolicy := &api.Policy{Direction: api.PolicyDirectionIngress, ID: policyID}
 
 	// Prepare translate group with original kubernetes policy and empty romana policy.
-	translateGroup := &TranslateGroup{kubePolicy, romanaPolicy, TranslateGroupStartIndex}
+	translateGroup := &TranslateGroup{kubePolicy, romanaPolicy, TranslateGroupStartIndex, TranslateGroupStartIndex}
 
 	// Fill in AppliedTo field of romana policy.
 	err := translateGroup.translateTarget(l)
@@ -118,6 +118,24 @@ func (l *Translator) translateNetworkPolicy(kubePolicy *v1beta1.NetworkPolicy) (
 		}
 	}
 
+	// For each Egress field in kubernetes policy, create Peer and Rule fields in
+	// egress section of romana policy.
+	for {
+		err := translateGroup.translateNextEgress(l)
+		if _, ok := err.(NoMoreEgressEntities); ok {
+			break
+		}
+
+		if err != nil {
+			return *translateGroup.romanaPolicy, TranslatorError{ErrorTranslatingPolicyEgress, err}
+		}
+	}
+
+	// Policy that only restricts outbound traffic is an egress policy.
+	if len(romanaPolicy.Ingress) == 0 && len(romanaPolicy.Egress) > 0 {
+		romanaPolicy.Direction = api.PolicyDirectionEgress
+	}
+
 	return *translateGroup.romanaPolicy, nil
 }
 
@@ -142,6 +160,7 @@ const (
 	ErrorTenantNotInCache
 	ErrorTranslatingPolicyTarget
 	ErrorTranslatingPolicyIngress
+	ErrorTranslatingPolicyEgress
 )
 
 // TranslateGroup represent a state of translation of kubernetes policy
@@ -150,6 +169,7 @@ type TranslateGroup struct {
 	kubePolicy   *v1beta1.NetworkPolicy
 	romanaPolicy *api.Policy
 	ingressIndex int
+	egressIndex  int
 }
 
 const TranslateGroupStartIndex = 0
@@ -192,35 +212,9 @@ func (tg *TranslateGroup) makeNextIngressPeer(translator *Translator) error {
 	// romanaIngress := tg.romanaPolicy.Ingress[tg.ingressIndex]
 
 	for _, fromEntry := range ingress.From {
-		var sourceEndpoint api.Endpoint
-
-		// This ingress field matching a namespace which will be our source tenant.
-		if fromEntry.NamespaceSelector != nil {
-			tenantID := GetTenantIDFromNamespaceName(fromEntry.NamespaceSelector.MatchLabels[translator.tenantLabelName])
-			if tenantID == "" {
-				// Use the namespace from objectmeta
-				log.Infof("No label found for %s, using %s for tenant identifier", translator.tenantLabelName, tg.kubePolicy.ObjectMeta.Namespace)
-				tenantID = tg.kubePolicy.ObjectMeta.Namespace
-			}
-
-			// Found a source tenant, let's register it as romana Peer.
-			sourceEndpoint.TenantID = tenantID
-		}
-
-		// if source tenant not specified assume same as target tenant.
-		if sourceEndpoint.TenantID == "" {
-			sourceEndpoint.TenantID = GetTenantIDFromNamespaceName(tg.kubePolicy.ObjectMeta.Namespace)
-		}
-
-		// This ingress field matches a either one segment or all segments.
-		if fromEntry.PodSelector != nil {
-
-			// Get segment name from podSelector.
-			kubeSegmentID, ok := fromEntry.PodSelector.MatchLabels[translator.segmentLabelName]
-			if ok {
-				// Register source tenant/segment as a romana Peer.
-				sourceEndpoint.SegmentID = kubeSegmentID
-			}
+		sourceEndpoint, err := tg.makePeer(fromEntry, translator)
+		if err != nil {
+			return err
 		}
 
 		tg.romanaPolicy.Ingress[tg.ingressIndex].Peers = append(tg.romanaPolicy.Ingress[tg.ingressIndex].Peers, sourceEndpoint)
@@ -237,11 +231,99 @@ func (tg *TranslateGroup) makeNextIngressPeer(translator *Translator) error {
 	return nil
 }
 
+// makeNextEgressPeer analyzes current Egress rule and adds new Peer to
+// egress section of romanaPolicy.
+func (tg *TranslateGroup) makeNextEgressPeer(translator *Translator) error {
+	egress := tg.kubePolicy.Spec.Egress[tg.egressIndex]
+
+	for _, toEntry := range egress.To {
+		destinationEndpoint, err := tg.makePeer(toEntry, translator)
+		if err != nil {
+			return err
+		}
+
+		tg.romanaPolicy.Egress[tg.egressIndex].Peers = append(tg.romanaPolicy.Egress[tg.egressIndex].Peers, destinationEndpoint)
+	}
+
+	// kubernetes policy with empty Egress with empty To field matches traffic
+	// to all destinations.
+	if len(egress.To) == 0 {
+		tg.romanaPolicy.Egress[tg.egressIndex].Peers = append(tg.romanaPolicy.Egress[tg.egressIndex].Peers, api.Endpoint{Peer: api.Wildcard})
+	}
+
+	return nil
+}
+
+// makePeer translates kubernetes policy peer into romana Endpoint.
+func (tg *TranslateGroup) makePeer(kubePeer v1beta1.NetworkPolicyPeer, translator *Translator) (api.Endpoint, error) {
+	var endpoint api.Endpoint
+
+	// This peer matches a range of addresses outside of the cluster.
+	if kubePeer.IPBlock != nil {
+		if len(kubePeer.IPBlock.Except) > 0 {
+			return endpoint, fmt.Errorf("ipBlock with except field is not supported, cidr=%s", kubePeer.IPBlock.CIDR)
+		}
+
+		endpoint.Cidr = kubePeer.IPBlock.CIDR
+		return endpoint, nil
+	}
+
+	// This peer matching a namespace which will be our peer tenant.
+	if kubePeer.NamespaceSelector != nil {
+		tenantID := GetTenantIDFromNamespaceName(kubePeer.NamespaceSelector.MatchLabels[translator.tenantLabelName])
+		if tenantID == "" {
+			// Use the namespace from objectmeta
+			log.Infof("No label found for %s, using %s for tenant identifier", translator.tenantLabelName, tg.kubePolicy.ObjectMeta.Namespace)
+			tenantID = tg.kubePolicy.ObjectMeta.Namespace
+		}
+
+		// Found a peer tenant, let's register it as romana Peer.
+		endpoint.TenantID = tenantID
+	}
+
+	// if peer tenant not specified assume same as target tenant.
+	if endpoint.TenantID == "" {
+		endpoint.TenantID = GetTenantIDFromNamespaceName(tg.kubePolicy.ObjectMeta.Namespace)
+	}
+
+	// This peer matches a either one segment or all segments.
+	if kubePeer.PodSelector != nil {
+
+		// Get segment name from podSelector.
+		kubeSegmentID, ok := kubePeer.PodSelector.MatchLabels[translator.segmentLabelName]
+		if ok {
+			// Register peer tenant/segment as a romana Peer.
+			endpoint.SegmentID = kubeSegmentID
+		}
+	}
+
+	return endpoint, nil
+}
+
 // makeNextRule analizes current ingress rule and adds a new Rule to romanaPolicy.Rules.
 func (tg *TranslateGroup) makeNextRule(translator *Translator) error {
 	ingress := tg.kubePolicy.Spec.Ingress[tg.ingressIndex]
 
-	for _, toPort := range ingress.Ports {
+	tg.romanaPolicy.Ingress[tg.ingressIndex].Rules = append(tg.romanaPolicy.Ingress[tg.ingressIndex].Rules, makeRules(ingress.Ports)...)
+
+	return nil
+}
+
+// makeNextEgressRule analizes current egress rule and adds a new Rule to
+// egress section of romanaPolicy.
+func (tg *TranslateGroup) makeNextEgressRule(translator *Translator) error {
+	egress := tg.kubePolicy.Spec.Egress[tg.egressIndex]
+
+	tg.romanaPolicy.Egress[tg.egressIndex].Rules = append(tg.romanaPolicy.Egress[tg.egressIndex].Rules, makeRules(egress.Ports)...)
+
+	return nil
+}
+
+// makeRules translates kubernetes policy ports into romana rules.
+func makeRules(kubePorts []v1beta1.NetworkPolicyPort) []api.Rule {
+	var rules []api.Rule
+
+	for _, toPort := range kubePorts {
 		var proto string
 		var ports []uint
 
@@ -257,17 +339,15 @@ func (tg *TranslateGroup) makeNextRule(translator *Translator) error {
 			ports = []uint{uint(toPort.Port.IntValue())}
 		}
 
-		rule := api.Rule{Protocol: proto, Ports: ports}
-		tg.romanaPolicy.Ingress[tg.ingressIndex].Rules = append(tg.romanaPolicy.Ingress[tg.ingressIndex].Rules, rule)
+		rules = append(rules, api.Rule{Protocol: proto, Ports: ports})
 	}
 
 	// treat policy with no rules as policy that targets all traffic.
-	if len(ingress.Ports) == 0 {
-		rule := api.Rule{Protocol: api.Wildcard}
-		tg.romanaPolicy.Ingress[tg.ingressIndex].Rules = append(tg.romanaPolicy.Ingress[tg.ingressIndex].Rules, rule)
+	if len(kubePorts) == 0 {
+		rules = append(rules, api.Rule{Protocol: api.Wildcard})
 	}
 
-	return nil
+	return rules
 }
 
 // translateNextIngress translates next Ingress object from kubePolicy into romanaPolicy
@@ -304,3 +384,38 @@ type NoMoreIngressEntities struct{}
 func (e NoMoreIngressEntities) Error() string {
 	return "Done translating"
 }
+
+// translateNextEgress translates next Egress object from kubePolicy into
+// Peer and Rule fields of romanaPolicy egress section.
+func (tg *TranslateGroup) translateNextEgress(translator *Translator) error {
+
+	if tg.egressIndex > len(tg.kubePolicy.Spec.Egress)-1 {
+		return NoMoreEgressEntities{}
+	}
+
+	tg.romanaPolicy.Egress = append(tg.romanaPolicy.Egress, api.RomanaEgress{})
+
+	// Translate Egress.To into romanaPolicy peers.
+	err := tg.makeNextEgressPeer(translator)
+	if err != nil {
+		return err
+	}
+
+	// Translate Egress.Ports into romanaPolicy.Rules.
+	err = tg.makeNextEgressRule(translator)
+	if err != nil {
+		return err
+	}
+
+	tg.egressIndex++
+
+	return nil
+}
+
+// NoMoreEgressEntities is an error that indicates that translateNextEgress
+// went through all Egress entries in TranslateGroup.kubePolicy.
+type NoMoreEgressEntities struct{}
+
+func (e NoMoreEgressEntities) Error() string {
+	return "Done translating"
+}
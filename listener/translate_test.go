@@ -382,3 +382,111 @@ func TestMakeNextRule(t *testing.T) {
 		}
 	}
 }
+
+func TestMakeNextEgressPeer(t *testing.T) {
+	tg := TranslateGroup{
+		kubePolicy: &v1beta1.NetworkPolicy{
+			ObjectMeta: v1.ObjectMeta{
+				Namespace: "default",
+			},
+			Spec: v1beta1.NetworkPolicySpec{
+				Egress: []v1beta1.NetworkPolicyEgressRule{
+					v1beta1.NetworkPolicyEgressRule{},
+				},
+			},
+		},
+		romanaPolicy: &api.Policy{
+			ID: "TestPolicy",
+		},
+		egressIndex: 0,
+	}
+
+	translator := Translator{
+		cacheMu:          &sync.Mutex{},
+		segmentLabelName: "role",
+		tenantLabelName:  "tenantName",
+	}
+
+	testCases := []struct {
+		To           []v1beta1.NetworkPolicyPeer
+		RomanaPolicy api.Policy
+		expected     func(*api.Policy, error) bool
+	}{
+		{
+			To: []v1beta1.NetworkPolicyPeer{
+				v1beta1.NetworkPolicyPeer{
+					PodSelector: &unversioned.LabelSelector{
+						MatchLabels: map[string]string{
+							"role": "TestSegment",
+						},
+					},
+				},
+			},
+			RomanaPolicy: api.Policy{
+				ID: "TestPolicyWithSegment",
+				Egress: []api.RomanaEgress{
+					api.RomanaEgress{},
+				},
+			},
+			expected: func(p *api.Policy, err error) bool {
+				return err == nil && p.Egress[0].Peers[0].TenantID == "default" && p.Egress[0].Peers[0].SegmentID == "TestSegment"
+			},
+		}, {
+			To: []v1beta1.NetworkPolicyPeer{
+				v1beta1.NetworkPolicyPeer{
+					IPBlock: &v1beta1.IPBlock{
+						CIDR: "10.0.0.0/8",
+					},
+				},
+			},
+			RomanaPolicy: api.Policy{
+				ID: "TestPolicyWithCIDR",
+				Egress: []api.RomanaEgress{
+					api.RomanaEgress{},
+				},
+			},
+			expected: func(p *api.Policy, err error) bool {
+				return err == nil && p.Egress[0].Peers[0].Cidr == "10.0.0.0/8" && p.Egress[0].Peers[0].TenantID == ""
+			},
+		}, {
+			To: []v1beta1.NetworkPolicyPeer{
+				v1beta1.NetworkPolicyPeer{
+					IPBlock: &v1beta1.IPBlock{
+						CIDR:   "10.0.0.0/8",
+						Except: []string{"10.1.0.0/16"},
+					},
+				},
+			},
+			RomanaPolicy: api.Policy{
+				ID: "TestPolicyWithCIDRExcept",
+				Egress: []api.RomanaEgress{
+					api.RomanaEgress{},
+				},
+			},
+			expected: func(p *api.Policy, err error) bool {
+				return err != nil
+			},
+		}, {
+			To: []v1beta1.NetworkPolicyPeer{},
+			RomanaPolicy: api.Policy{
+				ID: "TestPolicyEmptyEgress",
+				Egress: []api.RomanaEgress{
+					api.RomanaEgress{},
+				},
+			},
+			expected: func(p *api.Policy, err error) bool {
+				return err == nil && p.Egress[0].Peers[0].Peer == "any"
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		tg.kubePolicy.Spec.Egress[tg.egressIndex].To = testCase.To
+		tg.romanaPolicy = &testCase.RomanaPolicy
+		err := tg.makeNextEgressPeer(&translator)
+
+		if !testCase.expected(tg.romanaPolicy, err) {
+			t.Errorf("Failed to translate romana policy %s, err=%v", tg.romanaPolicy.ID, err)
+		}
+	}
+}
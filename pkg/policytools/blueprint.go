@@ -50,11 +50,29 @@ func MakeBlueprintKey(direction, iptablesSchemeType string, peerType PolicyPeerT
 		result = fmt.Sprintf("ingress_%s_from_%s_to_%s_", iptablesSchemeType, peerType, targetType)
 	case api.PolicyDirectionEgress:
 		result = fmt.Sprintf("egress_%s_from_%s_to_%s_", iptablesSchemeType, targetType, peerType)
+	case DirectionEgressSection:
+		result = fmt.Sprintf("egressSection_%s_from_%s_to_%s_", iptablesSchemeType, targetType, peerType)
 	}
 
 	return result
 }
 
+// DirectionEgressSection is the direction of blueprints for the policies
+// produced by SplitPolicy from Egress section. Unlike rules of the Ingress
+// section of a policy with egress Direction, which drop matching traffic,
+// Egress section allows matching traffic and the rest of the traffic
+// from policy targets is dropped.
+const DirectionEgressSection = "egressSection"
+
+// BlueprintDirection returns the direction of blueprints to translate
+// the policy produced by SplitPolicy with.
+func BlueprintDirection(policy api.Policy) string {
+	if len(policy.Egress) > 0 {
+		return DirectionEgressSection
+	}
+	return policy.Direction
+}
+
 const (
 	SchemePolicyOnTop     = "policyOnTop"
 	SchemeTargetOnTop     = "targetOnTop"
@@ -783,4 +783,324 @@ var Blueprints = map[string]RuleBlueprint{
 		FourthRuleMatch:  MakePolicyRuleWithAction,
 		FourthRuleAction: "ACCEPT",
 	},
+
+	MakeBlueprintKey(
+		DirectionEgressSection,
+		SchemePolicyOnTop,
+		PeerAny,
+		TargetTenant,
+	): RuleBlueprint{
+		BaseChain:        firewall.ChainNameEgressPolicy,
+		TopRuleMatch:     MatchEndpoint(""),
+		TopRuleAction:    MakeRomanaPolicyName,
+		SecondBaseChain:  MakeRomanaPolicyName,
+		SecondRuleMatch:  MakeSrcTenantMatch,
+		SecondRuleAction: MakeRomanaPolicyNameExtended,
+		ThirdBaseChain:   MakeRomanaPolicyNameExtended,
+		ThirdRuleMatch:   MatchEndpoint(""),
+		ThirdRuleAction:  MakeRomanaPolicyNameRules,
+		FourthBaseChain:  MakeRomanaPolicyNameRules,
+		FourthRuleMatch:  MakePolicyRuleWithAction,
+		FourthRuleAction: "ROMANA-EGRESS-ALLOWED",
+	},
+
+	MakeBlueprintKey(
+		DirectionEgressSection,
+		SchemeTargetOnTop,
+		PeerAny,
+		TargetTenant,
+	): RuleBlueprint{
+		BaseChain:        firewall.ChainNameEgressPolicy,
+		TopRuleMatch:     MakeSrcTenantMatch,
+		TopRuleAction:    MakeRomanaPolicyName,
+		SecondBaseChain:  MatchPolicyString(""),
+		SecondRuleMatch:  MatchEndpoint(""),
+		SecondRuleAction: MatchPolicyString(""),
+		ThirdBaseChain:   MakeRomanaPolicyName,
+		ThirdRuleMatch:   MatchEndpoint(""),
+		ThirdRuleAction:  MakeRomanaPolicyNameRules,
+		FourthBaseChain:  MakeRomanaPolicyNameRules,
+		FourthRuleMatch:  MakePolicyRuleWithAction,
+		FourthRuleAction: "ROMANA-EGRESS-ALLOWED",
+	},
+
+	MakeBlueprintKey(
+		DirectionEgressSection,
+		SchemePolicyOnTop,
+		PeerAny,
+		TargetTenantSegment,
+	): RuleBlueprint{
+		BaseChain:        firewall.ChainNameEgressPolicy,
+		TopRuleMatch:     MatchEndpoint(""),
+		TopRuleAction:    MakeRomanaPolicyName,
+		SecondBaseChain:  MakeRomanaPolicyName,
+		SecondRuleMatch:  MakeSrcTenantSegmentMatch,
+		SecondRuleAction: MakeRomanaPolicyNameExtended,
+		ThirdBaseChain:   MakeRomanaPolicyNameExtended,
+		ThirdRuleMatch:   MatchEndpoint(""),
+		ThirdRuleAction:  MakeRomanaPolicyNameRules,
+		FourthBaseChain:  MakeRomanaPolicyNameRules,
+		FourthRuleMatch:  MakePolicyRuleWithAction,
+		FourthRuleAction: "ROMANA-EGRESS-ALLOWED",
+	},
+
+	MakeBlueprintKey(
+		DirectionEgressSection,
+		SchemeTargetOnTop,
+		PeerAny,
+		TargetTenantSegment,
+	): RuleBlueprint{
+		BaseChain:        firewall.ChainNameEgressPolicy,
+		TopRuleMatch:     MakeSrcTenantSegmentMatch,
+		TopRuleAction:    MakeRomanaPolicyName,
+		SecondBaseChain:  MatchPolicyString(""),
+		SecondRuleMatch:  MatchEndpoint(""),
+		SecondRuleAction: MatchPolicyString(""),
+		ThirdBaseChain:   MakeRomanaPolicyName,
+		ThirdRuleMatch:   MatchEndpoint(""),
+		ThirdRuleAction:  MakeRomanaPolicyNameRules,
+		FourthBaseChain:  MakeRomanaPolicyNameRules,
+		FourthRuleMatch:  MakePolicyRuleWithAction,
+		FourthRuleAction: "ROMANA-EGRESS-ALLOWED",
+	},
+
+	MakeBlueprintKey(
+		DirectionEgressSection,
+		SchemePolicyOnTop,
+		PeerCIDR,
+		TargetTenant,
+	): RuleBlueprint{
+		BaseChain:        firewall.ChainNameEgressPolicy,
+		TopRuleMatch:     MatchEndpoint(""),
+		TopRuleAction:    MakeRomanaPolicyName,
+		SecondBaseChain:  MakeRomanaPolicyName,
+		SecondRuleMatch:  MakeSrcTenantMatch,
+		SecondRuleAction: MakeRomanaPolicyNameExtended,
+		ThirdBaseChain:   MakeRomanaPolicyNameExtended,
+		ThirdRuleMatch:   MakeDstCIDRMatch,
+		ThirdRuleAction:  MakeRomanaPolicyNameRules,
+		FourthBaseChain:  MakeRomanaPolicyNameRules,
+		FourthRuleMatch:  MakePolicyRuleWithAction,
+		FourthRuleAction: "ROMANA-EGRESS-ALLOWED",
+	},
+
+	MakeBlueprintKey(
+		DirectionEgressSection,
+		SchemeTargetOnTop,
+		PeerCIDR,
+		TargetTenant,
+	): RuleBlueprint{
+		BaseChain:        firewall.ChainNameEgressPolicy,
+		TopRuleMatch:     MakeSrcTenantMatch,
+		TopRuleAction:    MakeRomanaPolicyName,
+		SecondBaseChain:  MatchPolicyString(""),
+		SecondRuleMatch:  MatchEndpoint(""),
+		SecondRuleAction: MatchPolicyString(""),
+		ThirdBaseChain:   MakeRomanaPolicyName,
+		ThirdRuleMatch:   MakeDstCIDRMatch,
+		ThirdRuleAction:  MakeRomanaPolicyNameRules,
+		FourthBaseChain:  MakeRomanaPolicyNameRules,
+		FourthRuleMatch:  MakePolicyRuleWithAction,
+		FourthRuleAction: "ROMANA-EGRESS-ALLOWED",
+	},
+
+	MakeBlueprintKey(
+		DirectionEgressSection,
+		SchemePolicyOnTop,
+		PeerCIDR,
+		TargetTenantSegment,
+	): RuleBlueprint{
+		BaseChain:        firewall.ChainNameEgressPolicy,
+		TopRuleMatch:     MatchEndpoint(""),
+		TopRuleAction:    MakeRomanaPolicyName,
+		SecondBaseChain:  MakeRomanaPolicyName,
+		SecondRuleMatch:  MakeSrcTenantSegmentMatch,
+		SecondRuleAction: MakeRomanaPolicyNameExtended,
+		ThirdBaseChain:   MakeRomanaPolicyNameExtended,
+		ThirdRuleMatch:   MakeDstCIDRMatch,
+		ThirdRuleAction:  MakeRomanaPolicyNameRules,
+		FourthBaseChain:  MakeRomanaPolicyNameRules,
+		FourthRuleMatch:  MakePolicyRuleWithAction,
+		FourthRuleAction: "ROMANA-EGRESS-ALLOWED",
+	},
+
+	MakeBlueprintKey(
+		DirectionEgressSection,
+		SchemeTargetOnTop,
+		PeerCIDR,
+		TargetTenantSegment,
+	): RuleBlueprint{
+		BaseChain:        firewall.ChainNameEgressPolicy,
+		TopRuleMatch:     MakeSrcTenantSegmentMatch,
+		TopRuleAction:    MakeRomanaPolicyName,
+		SecondBaseChain:  MatchPolicyString(""),
+		SecondRuleMatch:  MatchEndpoint(""),
+		SecondRuleAction: MatchPolicyString(""),
+		ThirdBaseChain:   MakeRomanaPolicyName,
+		ThirdRuleMatch:   MakeDstCIDRMatch,
+		ThirdRuleAction:  MakeRomanaPolicyNameRules,
+		FourthBaseChain:  MakeRomanaPolicyNameRules,
+		FourthRuleMatch:  MakePolicyRuleWithAction,
+		FourthRuleAction: "ROMANA-EGRESS-ALLOWED",
+	},
+
+	MakeBlueprintKey(
+		DirectionEgressSection,
+		SchemePolicyOnTop,
+		PeerTenant,
+		TargetTenant,
+	): RuleBlueprint{
+		BaseChain:        firewall.ChainNameEgressPolicy,
+		TopRuleMatch:     MatchEndpoint(""),
+		TopRuleAction:    MakeRomanaPolicyName,
+		SecondBaseChain:  MakeRomanaPolicyName,
+		SecondRuleMatch:  MakeSrcTenantMatch,
+		SecondRuleAction: MakeRomanaPolicyNameExtended,
+		ThirdBaseChain:   MakeRomanaPolicyNameExtended,
+		ThirdRuleMatch:   MakeDstTenantMatch,
+		ThirdRuleAction:  MakeRomanaPolicyNameRules,
+		FourthBaseChain:  MakeRomanaPolicyNameRules,
+		FourthRuleMatch:  MakePolicyRuleWithAction,
+		FourthRuleAction: "ROMANA-EGRESS-ALLOWED",
+	},
+
+	MakeBlueprintKey(
+		DirectionEgressSection,
+		SchemeTargetOnTop,
+		PeerTenant,
+		TargetTenant,
+	): RuleBlueprint{
+		BaseChain:        firewall.ChainNameEgressPolicy,
+		TopRuleMatch:     MakeSrcTenantMatch,
+		TopRuleAction:    MakeRomanaPolicyName,
+		SecondBaseChain:  MatchPolicyString(""),
+		SecondRuleMatch:  MatchEndpoint(""),
+		SecondRuleAction: MatchPolicyString(""),
+		ThirdBaseChain:   MakeRomanaPolicyName,
+		ThirdRuleMatch:   MakeDstTenantMatch,
+		ThirdRuleAction:  MakeRomanaPolicyNameRules,
+		FourthBaseChain:  MakeRomanaPolicyNameRules,
+		FourthRuleMatch:  MakePolicyRuleWithAction,
+		FourthRuleAction: "ROMANA-EGRESS-ALLOWED",
+	},
+
+	MakeBlueprintKey(
+		DirectionEgressSection,
+		SchemePolicyOnTop,
+		PeerTenantSegment,
+		TargetTenant,
+	): RuleBlueprint{
+		BaseChain:        firewall.ChainNameEgressPolicy,
+		TopRuleMatch:     MatchEndpoint(""),
+		TopRuleAction:    MakeRomanaPolicyName,
+		SecondBaseChain:  MakeRomanaPolicyName,
+		SecondRuleMatch:  MakeSrcTenantMatch,
+		SecondRuleAction: MakeRomanaPolicyNameExtended,
+		ThirdBaseChain:   MakeRomanaPolicyNameExtended,
+		ThirdRuleMatch:   MakeDstTenantSegmentMatch,
+		ThirdRuleAction:  MakeRomanaPolicyNameRules,
+		FourthBaseChain:  MakeRomanaPolicyNameRules,
+		FourthRuleMatch:  MakePolicyRuleWithAction,
+		FourthRuleAction: "ROMANA-EGRESS-ALLOWED",
+	},
+
+	MakeBlueprintKey(
+		DirectionEgressSection,
+		SchemeTargetOnTop,
+		PeerTenantSegment,
+		TargetTenant,
+	): RuleBlueprint{
+		BaseChain:        firewall.ChainNameEgressPolicy,
+		TopRuleMatch:     MakeSrcTenantMatch,
+		TopRuleAction:    MakeRomanaPolicyName,
+		SecondBaseChain:  MatchPolicyString(""),
+		SecondRuleMatch:  MatchEndpoint(""),
+		SecondRuleAction: MatchPolicyString(""),
+		ThirdBaseChain:   MakeRomanaPolicyName,
+		ThirdRuleMatch:   MakeDstTenantSegmentMatch,
+		ThirdRuleAction:  MakeRomanaPolicyNameRules,
+		FourthBaseChain:  MakeRomanaPolicyNameRules,
+		FourthRuleMatch:  MakePolicyRuleWithAction,
+		FourthRuleAction: "ROMANA-EGRESS-ALLOWED",
+	},
+
+	MakeBlueprintKey(
+		DirectionEgressSection,
+		SchemePolicyOnTop,
+		PeerTenant,
+		TargetTenantSegment,
+	): RuleBlueprint{
+		BaseChain:        firewall.ChainNameEgressPolicy,
+		TopRuleMatch:     MatchEndpoint(""),
+		TopRuleAction:    MakeRomanaPolicyName,
+		SecondBaseChain:  MakeRomanaPolicyName,
+		SecondRuleMatch:  MakeSrcTenantSegmentMatch,
+		SecondRuleAction: MakeRomanaPolicyNameExtended,
+		ThirdBaseChain:   MakeRomanaPolicyNameExtended,
+		ThirdRuleMatch:   MakeDstTenantMatch,
+		ThirdRuleAction:  MakeRomanaPolicyNameRules,
+		FourthBaseChain:  MakeRomanaPolicyNameRules,
+		FourthRuleMatch:  MakePolicyRuleWithAction,
+		FourthRuleAction: "ROMANA-EGRESS-ALLOWED",
+	},
+
+	MakeBlueprintKey(
+		DirectionEgressSection,
+		SchemeTargetOnTop,
+		PeerTenant,
+		TargetTenantSegment,
+	): RuleBlueprint{
+		BaseChain:        firewall.ChainNameEgressPolicy,
+		TopRuleMatch:     MakeSrcTenantSegmentMatch,
+		TopRuleAction:    MakeRomanaPolicyName,
+		SecondBaseChain:  MatchPolicyString(""),
+		SecondRuleMatch:  MatchEndpoint(""),
+		SecondRuleAction: MatchPolicyString(""),
+		ThirdBaseChain:   MakeRomanaPolicyName,
+		ThirdRuleMatch:   MakeDstTenantMatch,
+		ThirdRuleAction:  MakeRomanaPolicyNameRules,
+		FourthBaseChain:  MakeRomanaPolicyNameRules,
+		FourthRuleMatch:  MakePolicyRuleWithAction,
+		FourthRuleAction: "ROMANA-EGRESS-ALLOWED",
+	},
+
+	MakeBlueprintKey(
+		DirectionEgressSection,
+		SchemePolicyOnTop,
+		PeerTenantSegment,
+		TargetTenantSegment,
+	): RuleBlueprint{
+		BaseChain:        firewall.ChainNameEgressPolicy,
+		TopRuleMatch:     MatchEndpoint(""),
+		TopRuleAction:    MakeRomanaPolicyName,
+		SecondBaseChain:  MakeRomanaPolicyName,
+		SecondRuleMatch:  MakeSrcTenantSegmentMatch,
+		SecondRuleAction: MakeRomanaPolicyNameExtended,
+		ThirdBaseChain:   MakeRomanaPolicyNameExtended,
+		ThirdRuleMatch:   MakeDstTenantSegmentMatch,
+		ThirdRuleAction:  MakeRomanaPolicyNameRules,
+		FourthBaseChain:  MakeRomanaPolicyNameRules,
+		FourthRuleMatch:  MakePolicyRuleWithAction,
+		FourthRuleAction: "ROMANA-EGRESS-ALLOWED",
+	},
+
+	MakeBlueprintKey(
+		DirectionEgressSection,
+		SchemeTargetOnTop,
+		PeerTenantSegment,
+		TargetTenantSegment,
+	): RuleBlueprint{
+		BaseChain:        firewall.ChainNameEgressPolicy,
+		TopRuleMatch:     MakeSrcTenantSegmentMatch,
+		TopRuleAction:    MakeRomanaPolicyName,
+		SecondBaseChain:  MatchPolicyString(""),
+		SecondRuleMatch:  MatchEndpoint(""),
+		SecondRuleAction: MatchPolicyString(""),
+		ThirdBaseChain:   MakeRomanaPolicyName,
+		ThirdRuleMatch:   MakeDstTenantSegmentMatch,
+		ThirdRuleAction:  MakeRomanaPolicyNameRules,
+		FourthBaseChain:  MakeRomanaPolicyNameRules,
+		FourthRuleMatch:  MakePolicyRuleWithAction,
+		FourthRuleAction: "ROMANA-EGRESS-ALLOWED",
+	},
 }
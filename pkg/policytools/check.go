@@ -0,0 +1,282 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package policytools
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/romana/core/common/api"
+)
+
+// checkEndpoint is an api.PolicyCheckEndpoint resolved through IPAM blocks.
+type checkEndpoint struct {
+	api.PolicyCheckEndpoint
+
+	// ip is nil when endpoint is given as tenant and segment.
+	ip net.IP
+
+	// blocks of the tenant and segment the endpoint belongs to.
+	blocks []net.IPNet
+
+	// romana is true when the endpoint belongs to one of romana blocks
+	// and therefore is subject to romana policies.
+	romana bool
+
+	// host is true when the endpoint is an address of romana host.
+	host bool
+}
+
+// resolveCheckEndpoint finds tenant and segment of the endpoint in blocks.
+func resolveCheckEndpoint(e api.PolicyCheckEndpoint, blocks []api.IPAMBlockResponse, hosts []api.Host) (checkEndpoint, error) {
+	result := checkEndpoint{PolicyCheckEndpoint: e}
+
+	if e.IP != "" {
+		result.ip = net.ParseIP(e.IP)
+		if result.ip == nil {
+			return result, fmt.Errorf("invalid IP %s", e.IP)
+		}
+
+		for _, block := range blocks {
+			if !block.CIDR.Contains(result.ip) {
+				continue
+			}
+			if (e.TenantID != "" && e.TenantID != block.Tenant) ||
+				(e.SegmentID != "" && e.SegmentID != block.Segment) {
+				return result, fmt.Errorf("IP %s belongs to tenant %s segment %s",
+					e.IP, block.Tenant, block.Segment)
+			}
+			result.TenantID = block.Tenant
+			result.SegmentID = block.Segment
+			result.blocks = []net.IPNet{block.CIDR.IPNet}
+			result.romana = true
+			return result, nil
+		}
+
+		for _, host := range hosts {
+			for _, ip := range append([]net.IP{host.IP}, host.IPs...) {
+				if ip.Equal(result.ip) {
+					result.host = true
+				}
+			}
+		}
+
+		if e.TenantID != "" {
+			return result, fmt.Errorf("IP %s does not belong to tenant %s", e.IP, e.TenantID)
+		}
+
+		return result, nil
+	}
+
+	if e.TenantID == "" {
+		return result, fmt.Errorf("either IP or tenant required")
+	}
+
+	for _, block := range blocks {
+		if block.Tenant == e.TenantID && (e.SegmentID == "" || block.Segment == e.SegmentID) {
+			result.blocks = append(result.blocks, block.CIDR.IPNet)
+		}
+	}
+	if len(result.blocks) == 0 {
+		return result, fmt.Errorf("no blocks found for %s", e)
+	}
+	result.romana = true
+
+	return result, nil
+}
+
+// targetMatches checks if the policy target selects the endpoint.
+// Targets other than tenants and segments apply to the traffic
+// going to romana hosts and never select an endpoint.
+func targetMatches(target api.Endpoint, e checkEndpoint) bool {
+	if !e.romana {
+		return false
+	}
+
+	switch DetectPolicyTargetType(target) {
+	case TargetTenant:
+		return target.TenantID == e.TenantID
+	case TargetTenantSegment:
+		return target.TenantID == e.TenantID && target.SegmentID == e.SegmentID
+	}
+
+	return false
+}
+
+// peerMatches checks if the policy peer selects the endpoint.
+func peerMatches(peer api.Endpoint, e checkEndpoint) bool {
+	switch DetectPolicyPeerType(peer) {
+	case PeerAny:
+		return true
+	case PeerHost:
+		return e.host
+	case PeerTenant:
+		return e.romana && peer.TenantID == e.TenantID
+	case PeerTenantSegment:
+		return e.romana && peer.TenantID == e.TenantID && peer.SegmentID == e.SegmentID
+	case PeerCIDR:
+		_, cidr, err := net.ParseCIDR(peer.Cidr)
+		if err != nil {
+			return false
+		}
+		if e.ip != nil {
+			return cidr.Contains(e.ip)
+		}
+
+		// endpoint given as tenant and segment is only matched
+		// when all of its blocks are within the cidr.
+		for _, block := range e.blocks {
+			ones, _ := block.Mask.Size()
+			cidrOnes, _ := cidr.Mask.Size()
+			if !cidr.Contains(block.IP) || ones < cidrOnes {
+				return false
+			}
+		}
+		return true
+	}
+
+	return false
+}
+
+// ruleMatches checks if the rule allows the protocol and port.
+// Port 0 stands for no specific port and is only matched
+// by the rules that allow every port.
+func ruleMatches(rule api.Rule, protocol string, port uint) bool {
+	ruleProtocol := strings.ToLower(rule.Protocol)
+	if ruleProtocol == api.Wildcard {
+		return true
+	}
+
+	if ruleProtocol != protocol {
+		return false
+	}
+
+	if protocol != "tcp" && protocol != "udp" {
+		return true
+	}
+
+	if len(rule.Ports) == 0 && len(rule.PortRanges) == 0 {
+		return true
+	}
+
+	for _, p := range rule.Ports {
+		if p == port {
+			return true
+		}
+	}
+
+	for _, r := range rule.PortRanges {
+		if port != 0 && r[0] <= port && port <= r[1] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// CheckConnectivity evaluates policies for the traffic described by
+// the request, the same way romana agents would enforce them.
+//
+// Traffic leaving romana endpoint is allowed unless that endpoint
+// is a target of an Egress section, and traffic towards romana endpoint
+// is denied unless it is allowed by an ingress policy. Rules of policies
+// with egress direction but without Egress section deny matching traffic.
+func CheckConnectivity(policies []api.Policy, blocks []api.IPAMBlockResponse, hosts []api.Host, req api.PolicyCheckRequest) (api.PolicyCheckResponse, error) {
+	var resp api.PolicyCheckResponse
+
+	protocol := strings.ToLower(strings.TrimSpace(req.Protocol))
+	if !isValidProto(protocol) || protocol == api.Wildcard {
+		return resp, fmt.Errorf("invalid protocol %s, expected tcp, udp or icmp", req.Protocol)
+	}
+	if req.Port > api.MaxPortNumber {
+		return resp, fmt.Errorf("invalid port %d", req.Port)
+	}
+
+	src, err := resolveCheckEndpoint(req.Source, blocks, hosts)
+	if err != nil {
+		return resp, fmt.Errorf("source: %s", err)
+	}
+	dst, err := resolveCheckEndpoint(req.Destination, blocks, hosts)
+	if err != nil {
+		return resp, fmt.Errorf("destination: %s", err)
+	}
+	resp.Source = src.PolicyCheckEndpoint
+	resp.Destination = dst.PolicyCheckEndpoint
+
+	var egressRestricted, egressAllowed, ingressAllowed bool
+	var egressDeniedBy string
+	if len(policies) > 0 {
+		iterator, err := NewPolicyIterator(policies)
+		if err != nil {
+			return resp, err
+		}
+
+		for iterator.Next() {
+			policy, target, peer, rule := iterator.Items()
+
+			var allowed bool
+			switch BlueprintDirection(policy) {
+			case DirectionEgressSection:
+				if !targetMatches(target, src) {
+					continue
+				}
+				egressRestricted = true
+				allowed = peerMatches(peer, dst) && ruleMatches(rule, protocol, req.Port)
+				egressAllowed = egressAllowed || allowed
+			case api.PolicyDirectionEgress:
+				if egressDeniedBy == "" && targetMatches(target, src) &&
+					peerMatches(peer, dst) && ruleMatches(rule, protocol, req.Port) {
+					egressDeniedBy = policy.ID
+				}
+			case api.PolicyDirectionIngress:
+				if !targetMatches(target, dst) {
+					continue
+				}
+				allowed = peerMatches(peer, src) && ruleMatches(rule, protocol, req.Port)
+				ingressAllowed = ingressAllowed || allowed
+			}
+
+			if allowed {
+				resp.Matches = append(resp.Matches, api.PolicyCheckMatch{
+					PolicyID:  policy.ID,
+					Direction: policy.Direction,
+					Target:    target,
+					Peer:      peer,
+					Rule:      rule,
+				})
+			}
+		}
+	}
+
+	switch {
+	case egressDeniedBy != "":
+		resp.Reason = fmt.Sprintf("egress policy %s denies the traffic", egressDeniedBy)
+	case egressRestricted && !egressAllowed:
+		resp.Reason = fmt.Sprintf("egress policies for %s do not allow the traffic", src)
+	case dst.romana && !ingressAllowed:
+		resp.Reason = fmt.Sprintf("no ingress policy for %s allows the traffic", dst)
+	default:
+		resp.Allowed = true
+		if len(resp.Matches) == 0 {
+			resp.Reason = "traffic is not subject to romana policies"
+		} else {
+			resp.Reason = "traffic is allowed by policies"
+		}
+	}
+
+	return resp, nil
+}
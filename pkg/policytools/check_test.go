@@ -0,0 +1,174 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package policytools
+
+import (
+	"net"
+	"testing"
+
+	"github.com/romana/core/common/api"
+)
+
+func TestCheckConnectivity(t *testing.T) {
+	makeCIDR := func(s string) api.IPNet {
+		_, ipnet, _ := net.ParseCIDR(s)
+		return api.IPNet{IPNet: *ipnet}
+	}
+
+	blocks := []api.IPAMBlockResponse{
+		{Tenant: "tenant-a", Segment: "frontend", CIDR: makeCIDR("10.0.0.0/28"), Host: "host1"},
+		{Tenant: "tenant-a", Segment: "backend", CIDR: makeCIDR("10.0.0.16/28"), Host: "host2"},
+		{Tenant: "tenant-b", Segment: "db", CIDR: makeCIDR("10.0.1.0/28"), Host: "host2"},
+	}
+	hosts := []api.Host{
+		{Name: "host1", IP: net.ParseIP("192.168.0.1")},
+	}
+
+	policies := []api.Policy{
+		{
+			ID:        "frontend-to-backend",
+			Direction: api.PolicyDirectionIngress,
+			AppliedTo: []api.Endpoint{{TenantID: "tenant-a", SegmentID: "backend"}},
+			Ingress: []api.RomanaIngress{
+				{
+					Peers: []api.Endpoint{{TenantID: "tenant-a", SegmentID: "frontend"}},
+					Rules: []api.Rule{
+						{Protocol: "tcp", Ports: []uint{80}},
+						{Protocol: "tcp", PortRanges: []api.PortRange{{8000, 8080}}},
+					},
+				},
+			},
+		},
+		{
+			ID:        "db-from-office",
+			Direction: api.PolicyDirectionIngress,
+			AppliedTo: []api.Endpoint{{TenantID: "tenant-b"}},
+			Ingress: []api.RomanaIngress{
+				{
+					Peers: []api.Endpoint{{Cidr: "172.16.0.0/16"}, {Peer: "host"}},
+					Rules: []api.Rule{{Protocol: "any"}},
+				},
+			},
+		},
+		{
+			ID:        "frontend-no-ssh",
+			Direction: api.PolicyDirectionEgress,
+			AppliedTo: []api.Endpoint{{TenantID: "tenant-a", SegmentID: "frontend"}},
+			Ingress: []api.RomanaIngress{
+				{
+					Peers: []api.Endpoint{{Cidr: "172.16.0.0/16"}},
+					Rules: []api.Rule{{Protocol: "tcp", Ports: []uint{22}}},
+				},
+			},
+		},
+		{
+			ID:        "backend-egress",
+			Direction: api.PolicyDirectionEgress,
+			AppliedTo: []api.Endpoint{{TenantID: "tenant-a", SegmentID: "backend"}},
+			Egress: []api.RomanaEgress{
+				{
+					Peers: []api.Endpoint{{Cidr: "10.0.1.0/24"}},
+					Rules: []api.Rule{{Protocol: "udp"}},
+				},
+			},
+		},
+	}
+
+	ip := func(s string) api.PolicyCheckEndpoint { return api.PolicyCheckEndpoint{IP: s} }
+	segment := func(tenant, segment string) api.PolicyCheckEndpoint {
+		return api.PolicyCheckEndpoint{TenantID: tenant, SegmentID: segment}
+	}
+
+	testCases := []struct {
+		name     string
+		src, dst api.PolicyCheckEndpoint
+		protocol string
+		port     uint
+		allowed  bool
+		matches  []string
+	}{
+		{"ingress port", ip("10.0.0.1"), ip("10.0.0.17"), "tcp", 80, true, []string{"frontend-to-backend"}},
+		{"ingress port range", segment("tenant-a", "frontend"), ip("10.0.0.17"), "tcp", 8001, true, []string{"frontend-to-backend"}},
+		{"ingress wrong port", ip("10.0.0.1"), ip("10.0.0.17"), "tcp", 22, false, nil},
+		{"ingress wrong protocol", ip("10.0.0.1"), ip("10.0.0.17"), "udp", 80, false, nil},
+		{"ingress default deny", ip("10.0.0.17"), ip("10.0.0.1"), "tcp", 80, false, nil},
+		{"ingress from cidr", ip("172.16.1.1"), segment("tenant-b", ""), "icmp", 0, true, []string{"db-from-office"}},
+		{"ingress from host", ip("192.168.0.1"), ip("10.0.1.1"), "tcp", 5432, true, []string{"db-from-office"}},
+		{"egress allowed ingress denied", ip("10.0.0.17"), ip("10.0.1.1"), "udp", 53, false, []string{"backend-egress"}},
+		{"egress denied", ip("10.0.0.17"), ip("8.8.8.8"), "udp", 53, false, nil},
+		{"egress unrestricted", ip("10.0.0.1"), ip("8.8.8.8"), "tcp", 443, true, nil},
+		{"egress direction denied", ip("10.0.0.1"), ip("172.16.1.1"), "tcp", 22, false, nil},
+		{"egress direction other port", ip("10.0.0.1"), ip("172.16.1.1"), "tcp", 443, true, nil},
+		{"external traffic", ip("172.16.1.1"), ip("8.8.8.8"), "tcp", 443, true, nil},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, err := CheckConnectivity(policies, blocks, hosts, api.PolicyCheckRequest{
+				Source:      tc.src,
+				Destination: tc.dst,
+				Protocol:    tc.protocol,
+				Port:        tc.port,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if resp.Allowed != tc.allowed {
+				t.Errorf("expected allowed=%t, got %t (%s)", tc.allowed, resp.Allowed, resp.Reason)
+			}
+
+			var matches []string
+			for _, m := range resp.Matches {
+				matches = append(matches, m.PolicyID)
+			}
+			if len(matches) != len(tc.matches) {
+				t.Fatalf("expected matches %v, got %v", tc.matches, matches)
+			}
+			for i := range matches {
+				if matches[i] != tc.matches[i] {
+					t.Errorf("expected matches %v, got %v", tc.matches, matches)
+				}
+			}
+		})
+	}
+
+	// endpoints resolve to tenant and segment of their blocks.
+	resp, err := CheckConnectivity(policies, blocks, hosts, api.PolicyCheckRequest{
+		Source: ip("10.0.0.1"), Destination: ip("10.0.1.1"), Protocol: "tcp",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Source.TenantID != "tenant-a" || resp.Source.SegmentID != "frontend" {
+		t.Errorf("unexpected source %+v", resp.Source)
+	}
+	if resp.Destination.TenantID != "tenant-b" || resp.Destination.SegmentID != "db" {
+		t.Errorf("unexpected destination %+v", resp.Destination)
+	}
+
+	for _, req := range []api.PolicyCheckRequest{
+		{Source: ip("10.0.0.1"), Destination: ip("10.0.0.17"), Protocol: "sctp"},
+		{Source: ip("not-an-ip"), Destination: ip("10.0.0.17"), Protocol: "tcp"},
+		{Source: segment("tenant-c", ""), Destination: ip("10.0.0.17"), Protocol: "tcp"},
+		{Source: api.PolicyCheckEndpoint{IP: "10.0.0.1", TenantID: "tenant-b"}, Destination: ip("10.0.0.17"), Protocol: "tcp"},
+		{Source: api.PolicyCheckEndpoint{}, Destination: ip("10.0.0.17"), Protocol: "tcp"},
+	} {
+		if _, err := CheckConnectivity(policies, blocks, hosts, req); err == nil {
+			t.Errorf("expected error for %+v", req)
+		}
+	}
+}
@@ -23,11 +23,13 @@ import (
 
 // PolicyIterator provides a way to iterate over every combination of a
 // target * peer * rule in a list of policies.
+// Policies that have both Ingress and Egress sections are iterated
+// as two separate policies, see SplitPolicy.
 type PolicyIterator struct {
 	policies   []api.Policy
 	policyIdx  int
 	targetIdx  int
-	ingressIdx int
+	sectionIdx int
 	peerIdx    int
 	ruleIdx    int
 	started    bool
@@ -39,12 +41,12 @@ func NewPolicyIterator(policies []api.Policy) (*PolicyIterator, error) {
 		return nil, fmt.Errorf("must have non empty policies list")
 	}
 
-	emptyIngress := func(p api.Policy) bool {
-		return len(p.Ingress) == 0
+	emptySections := func(p api.Policy) bool {
+		return len(p.Ingress) == 0 && len(p.Egress) == 0
 	}
 
 	emptyRules := func(p api.Policy) bool {
-		for _, i := range p.Ingress {
+		for _, i := range policySections(p) {
 			if len(i.Rules) == 0 {
 				return true
 			}
@@ -53,7 +55,7 @@ func NewPolicyIterator(policies []api.Policy) (*PolicyIterator, error) {
 	}
 
 	emptyPeers := func(p api.Policy) bool {
-		for _, i := range p.Ingress {
+		for _, i := range policySections(p) {
 			if len(i.Peers) == 0 {
 				return true
 			}
@@ -65,13 +67,58 @@ func NewPolicyIterator(policies []api.Policy) (*PolicyIterator, error) {
 		return len(p.AppliedTo) == 0
 	}
 
+	var split []api.Policy
 	for _, p := range policies {
-		if emptyIngress(p) || emptyTargets(p) || emptyPeers(p) || emptyRules(p) {
-			return nil, fmt.Errorf("policy %s has .Ingress .AppliedTo .Peers or .Rules field empty", p)
+		if emptySections(p) || emptyTargets(p) {
+			return nil, fmt.Errorf("policy %s has .Ingress .Egress .AppliedTo .Peers or .Rules field empty", p)
 		}
+
+		for _, sp := range SplitPolicy(p) {
+			if emptyPeers(sp) || emptyRules(sp) {
+				return nil, fmt.Errorf("policy %s has .Ingress .Egress .AppliedTo .Peers or .Rules field empty", p)
+			}
+			split = append(split, sp)
+		}
+	}
+
+	return &PolicyIterator{policies: split}, nil
+}
+
+// SplitPolicy returns one policy per section of the given policy.
+// Ingress section keeps Direction of the original policy, and Egress section
+// always becomes a policy with egress direction. Policy that only has
+// one section is returned as is, unless it needs its Direction corrected.
+func SplitPolicy(policy api.Policy) []api.Policy {
+	if len(policy.Egress) == 0 {
+		return []api.Policy{policy}
+	}
+
+	egress := policy
+	egress.Direction = api.PolicyDirectionEgress
+	egress.Ingress = nil
+
+	if len(policy.Ingress) == 0 {
+		return []api.Policy{egress}
+	}
+
+	ingress := policy
+	ingress.Egress = nil
+
+	return []api.Policy{ingress, egress}
+}
+
+// policySections returns peers and rules of a policy produced by SplitPolicy,
+// egress sections are converted into ingress type for convenience.
+func policySections(policy api.Policy) []api.RomanaIngress {
+	if len(policy.Egress) == 0 {
+		return policy.Ingress
 	}
 
-	return &PolicyIterator{policies: policies}, nil
+	var sections []api.RomanaIngress
+	for _, egress := range policy.Egress {
+		sections = append(sections, api.RomanaIngress(egress))
+	}
+	return sections
 }
 
 // Next advances policy iterator to the next combination
@@ -83,21 +130,21 @@ func (i *PolicyIterator) Next() bool {
 		return true
 	}
 
-	policy, _, ingress, _, _ := i.items()
+	policy, _, section, _, _ := i.items()
 
-	if i.ruleIdx < len(ingress.Rules)-1 {
+	if i.ruleIdx < len(section.Rules)-1 {
 		i.ruleIdx += 1
 		return true
 	}
 
-	if i.peerIdx < len(ingress.Peers)-1 {
+	if i.peerIdx < len(section.Peers)-1 {
 		i.peerIdx += 1
 		i.ruleIdx = 0
 		return true
 	}
 
-	if i.ingressIdx < len(policy.Ingress)-1 {
-		i.ingressIdx += 1
+	if i.sectionIdx < len(policySections(policy))-1 {
+		i.sectionIdx += 1
 		i.ruleIdx = 0
 		i.peerIdx = 0
 		return true
@@ -105,7 +152,7 @@ func (i *PolicyIterator) Next() bool {
 
 	if i.targetIdx < len(policy.AppliedTo)-1 {
 		i.targetIdx += 1
-		i.ingressIdx = 0
+		i.sectionIdx = 0
 		i.ruleIdx = 0
 		i.peerIdx = 0
 		return true
@@ -114,7 +161,7 @@ func (i *PolicyIterator) Next() bool {
 	if i.policyIdx < len(i.policies)-1 {
 		i.policyIdx += 1
 		i.targetIdx = 0
-		i.ingressIdx = 0
+		i.sectionIdx = 0
 		i.ruleIdx = 0
 		i.peerIdx = 0
 		return true
@@ -124,6 +171,8 @@ func (i *PolicyIterator) Next() bool {
 }
 
 // Items retrieves current combination of policy * target * peer * rule from iterator.
+// Returned policy is the one produced by SplitPolicy, so its Direction
+// always matches the direction of the peer and rule.
 func (i PolicyIterator) Items() (api.Policy, api.Endpoint, api.Endpoint, api.Rule) {
 	policy, target, _, peer, rule := i.items()
 	return policy, target, peer, rule
@@ -132,8 +181,8 @@ func (i PolicyIterator) Items() (api.Policy, api.Endpoint, api.Endpoint, api.Rul
 func (i PolicyIterator) items() (api.Policy, api.Endpoint, api.RomanaIngress, api.Endpoint, api.Rule) {
 	policy := i.policies[i.policyIdx]
 	target := policy.AppliedTo[i.targetIdx]
-	ingress := policy.Ingress[i.ingressIdx]
-	peer := policy.Ingress[i.ingressIdx].Peers[i.peerIdx]
-	rule := policy.Ingress[i.ingressIdx].Rules[i.ruleIdx]
-	return policy, target, ingress, peer, rule
+	section := policySections(policy)[i.sectionIdx]
+	peer := section.Peers[i.peerIdx]
+	rule := section.Rules[i.ruleIdx]
+	return policy, target, section, peer, rule
 }
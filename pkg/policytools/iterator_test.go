@@ -58,6 +58,25 @@ func TestNewPolicyIterator(t *testing.T) {
 		}
 	}
 
+	// returns expectFunc that counts iterations in each direction
+	// and matches them against expected values.
+	countDirections := func(ingress, egress int) expectFunc {
+		return func(p *PolicyIterator, e error) error {
+			if e != nil {
+				return e
+			}
+			counts := map[string]int{}
+			for p.Next() {
+				policy, _, _, _ := p.Items()
+				counts[policy.Direction]++
+			}
+			if counts[api.PolicyDirectionIngress] != ingress || counts[api.PolicyDirectionEgress] != egress {
+				return fmt.Errorf("Unexpected number of iterations, expect %d ingress and %d egress, got %v", ingress, egress, counts)
+			}
+			return nil
+		}
+	}
+
 	endpoint1 := api.Endpoint{
 		TenantID: "Arthur",
 	}
@@ -98,6 +117,11 @@ func TestNewPolicyIterator(t *testing.T) {
 		Rules: []api.Rule{rule1, rule2},
 	}
 
+	egress1 := api.RomanaEgress{
+		Peers: []api.Endpoint{endpoint3, endpoint4},
+		Rules: []api.Rule{rule1, rule2},
+	}
+
 	testCases := []struct {
 		name     string
 		policies []api.Policy
@@ -224,6 +248,51 @@ func TestNewPolicyIterator(t *testing.T) {
 			},
 			expect: countIterations(12),
 		},
+		{
+			name: "test policy with empty egress peers",
+			policies: []api.Policy{
+				api.Policy{
+					ID:        "empty egress peers",
+					AppliedTo: []api.Endpoint{endpoint1},
+					Egress: []api.RomanaEgress{
+						api.RomanaEgress{
+							Rules: []api.Rule{rule1},
+						},
+					},
+				},
+			},
+			expect: mustErr,
+		},
+		{
+			name: "test egress policy with 8 iterations",
+			policies: []api.Policy{
+				api.Policy{
+					ID:        "policy1",
+					AppliedTo: []api.Endpoint{endpoint1, endpoint2},
+					Egress: []api.RomanaEgress{
+						egress1,
+					},
+				},
+			},
+			expect: countDirections(0, 8),
+		},
+		{
+			name: "test ingress and egress policy with 5 iterations",
+			policies: []api.Policy{
+				api.Policy{
+					ID:        "policy1",
+					Direction: api.PolicyDirectionIngress,
+					AppliedTo: []api.Endpoint{endpoint2},
+					Ingress: []api.RomanaIngress{
+						ingress1,
+					},
+					Egress: []api.RomanaEgress{
+						egress1,
+					},
+				},
+			},
+			expect: countDirections(1, 4),
+		},
 	}
 
 	for _, testCase := range testCases {
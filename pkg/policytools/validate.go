@@ -113,6 +113,12 @@ func validateRule(r api.Rule) []string {
 func ValidatePolicy(policy api.Policy) error {
 	toList := func(p ...api.Policy) []api.Policy { return p }
 
+	// Ingress section follows policy direction, so egress policy
+	// can not have it alongside with Egress section.
+	if policy.Direction == api.PolicyDirectionEgress && len(policy.Ingress) > 0 && len(policy.Egress) > 0 {
+		return fmt.Errorf("policy with direction %s can not have both ingress and egress sections", policy.Direction)
+	}
+
 	iterator, err := NewPolicyIterator(toList(policy))
 	if err != nil {
 		return err
@@ -0,0 +1,421 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+// The package advertises list of networks by running BGP sessions
+// to configured neighbors from within the process, so no external
+// routing daemon is required.
+//
+// Speaker only announces IPv4 unicast networks and ignores
+// any routes received from neighbors.
+package bgp
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	router "github.com/romana/core/routepublisher/publisher"
+
+	log "github.com/romana/rlog"
+)
+
+const (
+	// DefaultHoldTime is a hold time proposed to neighbors, seconds.
+	DefaultHoldTime = 90
+
+	// DefaultPort is a port neighbors are listening on.
+	DefaultPort = 179
+
+	// retryInterval is a delay between attempts to
+	// establish failed session.
+	retryInterval = 5 * time.Second
+)
+
+// Speaker implements router.Interface by announcing networks
+// to BGP neighbors directly.
+type Speaker struct {
+	localAS  uint32
+	routerID net.IP
+	nextHop  net.IP
+	holdTime uint16
+	port     int
+
+	sessions []*session
+	stop     chan struct{}
+	stopOnce *sync.Once
+}
+
+func init() {
+	router.Register("bgp", New)
+}
+
+// New creates a Speaker and starts sessions to all neighbors.
+func New(config router.Config) (router.Interface, error) {
+	speaker := &Speaker{
+		stop:     make(chan struct{}),
+		stopOnce: &sync.Once{},
+	}
+
+	localAS, ok := config["localAS"]
+	if !ok {
+		return nil, fmt.Errorf("Parameter missing `localAS`")
+	}
+	as, err := strconv.ParseUint(localAS, 10, 32)
+	if err != nil || as == 0 {
+		return nil, fmt.Errorf("Invalid localAS %s", localAS)
+	}
+	speaker.localAS = uint32(as)
+
+	if routerID, ok := config["routerID"]; ok && routerID != "" {
+		speaker.routerID = net.ParseIP(routerID).To4()
+		if speaker.routerID == nil {
+			return nil, fmt.Errorf("Invalid routerID %s, must be an IPv4 address", routerID)
+		}
+	}
+
+	if nextHop, ok := config["nextHop"]; ok && nextHop != "" {
+		speaker.nextHop = net.ParseIP(nextHop).To4()
+		if speaker.nextHop == nil {
+			return nil, fmt.Errorf("Invalid nextHop %s, must be an IPv4 address", nextHop)
+		}
+	}
+
+	holdTime, err := strconv.ParseUint(config.SetDefault("holdTime", strconv.Itoa(DefaultHoldTime)), 10, 16)
+	if err != nil || (holdTime > 0 && holdTime < 3) {
+		return nil, fmt.Errorf("Invalid holdTime %s, must be 0 or at least 3 seconds", config["holdTime"])
+	}
+	speaker.holdTime = uint16(holdTime)
+
+	speaker.port, err = strconv.Atoi(config.SetDefault("port", strconv.Itoa(DefaultPort)))
+	if err != nil {
+		return nil, fmt.Errorf("Invalid port %s", config["port"])
+	}
+
+	neighbors, err := router.ParseNeighbors(config["neighbors"])
+	if err != nil {
+		return nil, err
+	}
+	if len(neighbors) == 0 {
+		return nil, fmt.Errorf("Parameter missing `neighbors`")
+	}
+
+	for _, neighbor := range neighbors {
+		s := &session{
+			speaker:  speaker,
+			neighbor: neighbor,
+			mu:       &sync.Mutex{},
+			desired:  make(map[string]net.IPNet),
+			updates:  make(chan struct{}, 1),
+		}
+		speaker.sessions = append(speaker.sessions, s)
+		go s.run()
+	}
+
+	return speaker, nil
+}
+
+// Update implements router.Interface by announcing new networks and
+// withdrawing networks that are no longer present on all neighbors.
+func (s *Speaker) Update(networks []net.IPNet, args map[string]interface{}) error {
+	desired := make(map[string]net.IPNet)
+	for _, network := range networks {
+		if network.IP.To4() == nil {
+			log.Debugf("Network %s is skipped, only IPv4 networks are announced", network.String())
+			continue
+		}
+		desired[network.String()] = network
+	}
+
+	log.Infof("Starting bgp update with %d networks for %d neighbors", len(desired), len(s.sessions))
+	for _, session := range s.sessions {
+		session.setDesired(desired)
+	}
+
+	return nil
+}
+
+// Stop closes all sessions.
+func (s *Speaker) Stop() {
+	s.stopOnce.Do(func() { close(s.stop) })
+}
+
+// session maintains BGP session with a single neighbor.
+type session struct {
+	speaker  *Speaker
+	neighbor router.Neighbor
+
+	// desired networks are guarded by mu, updates
+	// signals that desired networks changed.
+	mu      *sync.Mutex
+	desired map[string]net.IPNet
+	updates chan struct{}
+}
+
+func (s *session) setDesired(desired map[string]net.IPNet) {
+	s.mu.Lock()
+	s.desired = desired
+	s.mu.Unlock()
+
+	select {
+	case s.updates <- struct{}{}:
+	default:
+	}
+}
+
+func (s *session) getDesired() map[string]net.IPNet {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.desired
+}
+
+// run establishes the session and restarts it on failure
+// until speaker is stopped.
+func (s *session) run() {
+	address := net.JoinHostPort(s.neighbor.IP.String(), strconv.Itoa(s.speaker.port))
+	for {
+		conn, err := net.DialTimeout("tcp", address, retryInterval)
+		if err == nil {
+			log.Infof("Connected to bgp neighbor %s", s.neighbor)
+			err = s.serve(conn)
+			conn.Close()
+		}
+
+		if err == nil {
+			log.Infof("Session with bgp neighbor %s stopped", s.neighbor)
+			return
+		}
+		log.Errorf("Session with bgp neighbor %s failed, err=(%s)", s.neighbor, err)
+
+		select {
+		case <-s.speaker.stop:
+			return
+		case <-time.After(retryInterval):
+		}
+	}
+}
+
+// serve runs BGP state machine over established connection,
+// returns nil when speaker is stopped.
+func (s *session) serve(conn net.Conn) error {
+	localIP := conn.LocalAddr().(*net.TCPAddr).IP.To4()
+	routerID := s.speaker.routerID
+	if routerID == nil {
+		routerID = localIP
+	}
+	if routerID == nil {
+		return fmt.Errorf("no IPv4 router id available for neighbor %s", s.neighbor)
+	}
+	nextHop := s.speaker.nextHop
+	if nextHop == nil {
+		nextHop = localIP
+	}
+
+	_, err := conn.Write(marshalOpen(s.speaker.localAS, s.speaker.holdTime, routerID))
+	if err != nil {
+		return err
+	}
+
+	conn.SetReadDeadline(time.Now().Add(retryInterval * 2))
+	msgType, body, err := readMessage(conn)
+	if err != nil {
+		return err
+	}
+	if msgType != msgOpen {
+		return fmt.Errorf("expected open message from %s, got message type %d", s.neighbor, msgType)
+	}
+	open, err := parseOpen(body)
+	if err != nil {
+		return err
+	}
+	if open.version != bgpVersion {
+		conn.Write(marshalNotification(errOpenMessage, errUnsupportedVersion))
+		return fmt.Errorf("unsupported bgp version %d", open.version)
+	}
+	if open.as != s.neighbor.AS {
+		conn.Write(marshalNotification(errOpenMessage, errBadPeerAS))
+		return fmt.Errorf("neighbor %s reported as %d", s.neighbor, open.as)
+	}
+	if s.speaker.localAS > 0xffff && !open.fourOctetAS {
+		conn.Write(marshalNotification(errOpenMessage, errBadPeerAS))
+		return fmt.Errorf("neighbor %s doesn't support 4-octet as required for local as %d", s.neighbor, s.speaker.localAS)
+	}
+
+	holdTime := s.speaker.holdTime
+	if open.holdTime < holdTime {
+		holdTime = open.holdTime
+	}
+
+	attrs := pathAttributes{
+		nextHop:     nextHop,
+		fourOctetAS: open.fourOctetAS,
+		ibgp:        s.neighbor.AS == s.speaker.localAS,
+	}
+	if !attrs.ibgp {
+		attrs.asPath = []uint32{s.speaker.localAS}
+	}
+
+	_, err = conn.Write(marshalKeepalive())
+	if err != nil {
+		return err
+	}
+
+	// reader delivers errors from incoming messages, keepalives
+	// are handled by extending read deadline.
+	errCh := make(chan error, 1)
+	established := make(chan struct{})
+	go func() {
+		var once sync.Once
+		for {
+			if holdTime > 0 {
+				conn.SetReadDeadline(time.Now().Add(time.Duration(holdTime) * time.Second))
+			} else {
+				conn.SetReadDeadline(time.Time{})
+			}
+
+			msgType, body, err := readMessage(conn)
+			if err != nil {
+				if ne, ok := err.(net.Error); ok && ne.Timeout() {
+					conn.Write(marshalNotification(errHoldTimer, 0))
+					err = fmt.Errorf("hold timer expired")
+				}
+				errCh <- err
+				return
+			}
+
+			switch msgType {
+			case msgKeepalive:
+				once.Do(func() { close(established) })
+			case msgNotification:
+				if len(body) >= 2 {
+					errCh <- fmt.Errorf("notification from neighbor, code %d subcode %d", body[0], body[1])
+				} else {
+					errCh <- fmt.Errorf("notification from neighbor")
+				}
+				return
+			case msgUpdate:
+				// routes from neighbors are not used.
+			default:
+				errCh <- fmt.Errorf("unexpected message type %d", msgType)
+				return
+			}
+		}
+	}()
+
+	select {
+	case <-established:
+	case err := <-errCh:
+		return err
+	case <-s.speaker.stop:
+		conn.Write(marshalNotification(errCease, 0))
+		return nil
+	}
+	log.Infof("Session with bgp neighbor %s established, hold time %d", s.neighbor, holdTime)
+
+	var keepalive <-chan time.Time
+	if holdTime > 0 {
+		ticker := time.NewTicker(time.Duration(holdTime) * time.Second / 3)
+		defer ticker.Stop()
+		keepalive = ticker.C
+	}
+
+	advertised := make(map[string]net.IPNet)
+	err = s.sync(conn, advertised, attrs)
+	if err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-s.updates:
+			err = s.sync(conn, advertised, attrs)
+		case <-keepalive:
+			_, err = conn.Write(marshalKeepalive())
+		case err = <-errCh:
+		case <-s.speaker.stop:
+			conn.Write(marshalNotification(errCease, 0))
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// sync sends updates to the neighbor that bring advertised networks
+// in line with desired networks.
+func (s *session) sync(conn net.Conn, advertised map[string]net.IPNet, attrs pathAttributes) error {
+	desired := s.getDesired()
+
+	var withdrawn, announced []net.IPNet
+	for key, network := range advertised {
+		if _, ok := desired[key]; !ok {
+			withdrawn = append(withdrawn, network)
+		}
+	}
+	for key, network := range desired {
+		if _, ok := advertised[key]; !ok {
+			announced = append(announced, network)
+		}
+	}
+	sortNetworks(withdrawn)
+	sortNetworks(announced)
+
+	for len(withdrawn) > 0 {
+		chunk := withdrawn
+		if len(chunk) > maxPrefixesPerUpdate {
+			chunk = chunk[:maxPrefixesPerUpdate]
+		}
+		if _, err := conn.Write(marshalUpdate(chunk, nil, attrs)); err != nil {
+			return err
+		}
+		for _, network := range chunk {
+			delete(advertised, network.String())
+		}
+		withdrawn = withdrawn[len(chunk):]
+	}
+
+	for len(announced) > 0 {
+		chunk := announced
+		if len(chunk) > maxPrefixesPerUpdate {
+			chunk = chunk[:maxPrefixesPerUpdate]
+		}
+		if _, err := conn.Write(marshalUpdate(nil, chunk, attrs)); err != nil {
+			return err
+		}
+		for _, network := range chunk {
+			advertised[network.String()] = network
+		}
+		announced = announced[len(chunk):]
+	}
+
+	log.Tracef(4, "Neighbor %s has %d networks advertised", s.neighbor, len(advertised))
+	return nil
+}
+
+// sortNetworks sorts networks to produce predictable updates.
+func sortNetworks(networks []net.IPNet) {
+	sort.Sort(networkList(networks))
+}
+
+// networkList implements sort.Interface to allow sorting of []net.IPNet.
+type networkList []net.IPNet
+
+func (n networkList) Len() int           { return len(n) }
+func (n networkList) Swap(i, j int)      { n[i], n[j] = n[j], n[i] }
+func (n networkList) Less(i, j int) bool { return n[i].String() < n[j].String() }
@@ -0,0 +1,183 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package bgp
+
+import (
+	"bytes"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	router "github.com/romana/core/routepublisher/publisher"
+)
+
+func mustParseCIDR(s string) net.IPNet {
+	_, ipnet, err := net.ParseCIDR(s)
+	if err != nil {
+		panic(err)
+	}
+	return *ipnet
+}
+
+func TestMarshalUpdate(t *testing.T) {
+	attrs := pathAttributes{
+		nextHop:     net.ParseIP("192.168.99.10"),
+		asPath:      []uint32{65000},
+		fourOctetAS: true,
+	}
+
+	announced := []net.IPNet{mustParseCIDR("10.0.0.0/8"), mustParseCIDR("192.168.1.0/24")}
+	withdrawn := []net.IPNet{mustParseCIDR("10.1.2.0/25")}
+
+	msg := marshalUpdate(withdrawn, announced, attrs)
+	msgType, body, err := readMessage(bytes.NewReader(msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msgType != msgUpdate {
+		t.Fatalf("expected update message, got %d", msgType)
+	}
+
+	if !bytes.HasSuffix(body, []byte{8, 10, 24, 192, 168, 1}) {
+		t.Errorf("unexpected nlri in %v", body)
+	}
+
+	gotWithdrawn, gotAnnounced, err := parseUpdate(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(gotWithdrawn) != 1 || gotWithdrawn[0].String() != "10.1.2.0/25" {
+		t.Errorf("unexpected withdrawn routes %v", gotWithdrawn)
+	}
+	if len(gotAnnounced) != 2 || gotAnnounced[1].String() != "192.168.1.0/24" {
+		t.Errorf("unexpected announced routes %v", gotAnnounced)
+	}
+}
+
+func TestParseOpen(t *testing.T) {
+	msg := marshalOpen(4200000000, 90, net.ParseIP("10.0.0.1"))
+	_, body, err := readMessage(bytes.NewReader(msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	open, err := parseOpen(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !open.fourOctetAS || open.as != 4200000000 || open.holdTime != 90 || !open.routerID.Equal(net.ParseIP("10.0.0.1")) {
+		t.Errorf("unexpected open message %+v", open)
+	}
+}
+
+// fakePeer accepts a session from the speaker and
+// returns messages received after the session is established.
+func fakePeer(t *testing.T, listener net.Listener, as uint32, messages chan<- []byte) {
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Error(err)
+		close(messages)
+		return
+	}
+	defer conn.Close()
+	defer close(messages)
+
+	msgType, body, err := readMessage(conn)
+	if err != nil || msgType != msgOpen {
+		t.Errorf("expected open message, got %d, err=%v", msgType, err)
+		return
+	}
+	if open, err := parseOpen(body); err != nil || open.as != 65000 {
+		t.Errorf("unexpected open message %+v, err=%v", open, err)
+		return
+	}
+
+	conn.Write(marshalOpen(as, 30, net.ParseIP("10.0.0.2")))
+	conn.Write(marshalKeepalive())
+
+	for {
+		msgType, body, err := readMessage(conn)
+		if err != nil {
+			return
+		}
+		if msgType == msgKeepalive {
+			continue
+		}
+		messages <- append([]byte{msgType}, body...)
+	}
+}
+
+func TestSpeakerSession(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	messages := make(chan []byte, 10)
+	go fakePeer(t, listener, 65001, messages)
+
+	config := router.Config{
+		"localAS":   "65000",
+		"neighbors": "127.0.0.1:65001",
+		"port":      strconv.Itoa(listener.Addr().(*net.TCPAddr).Port),
+	}
+	speaker, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer speaker.(*Speaker).Stop()
+
+	expectUpdate := func(withdrawn, announced string) {
+		select {
+		case msg := <-messages:
+			if msg == nil || msg[0] != msgUpdate {
+				t.Fatalf("expected update message, got %v", msg)
+			}
+			w, a, err := parseUpdate(msg[1:])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(w) > 0 && w[0].String() != withdrawn || len(w) == 0 && withdrawn != "" {
+				t.Errorf("expected %s withdrawn, got %v", withdrawn, w)
+			}
+			if len(a) > 0 && a[0].String() != announced || len(a) == 0 && announced != "" {
+				t.Errorf("expected %s announced, got %v", announced, a)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for update")
+		}
+	}
+
+	speaker.Update([]net.IPNet{mustParseCIDR("10.112.0.0/28")}, nil)
+	expectUpdate("", "10.112.0.0/28")
+
+	speaker.Update([]net.IPNet{mustParseCIDR("10.112.0.16/28")}, nil)
+	expectUpdate("10.112.0.0/28", "")
+	expectUpdate("", "10.112.0.16/28")
+
+	speaker.(*Speaker).Stop()
+	select {
+	case msg := <-messages:
+		if msg == nil || msg[0] != msgNotification || msg[1] != errCease {
+			t.Errorf("expected cease notification, got %v", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for notification")
+	}
+}
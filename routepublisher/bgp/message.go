@@ -0,0 +1,322 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package bgp
+
+// This file implements encoding and decoding of the subset of
+// BGP-4 messages (RFC 4271) that is needed to announce IPv4 unicast
+// networks, with support for 4-octet AS numbers (RFC 6793).
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+)
+
+const (
+	msgOpen         = 1
+	msgUpdate       = 2
+	msgNotification = 3
+	msgKeepalive    = 4
+
+	headerLen     = 19
+	maxMessageLen = 4096
+
+	bgpVersion = 4
+
+	// asTrans is used in place of 4-octet AS numbers
+	// in 2-octet fields, RFC 6793.
+	asTrans = 23456
+
+	attrFlagTransitive = 0x40
+	attrOrigin         = 1
+	attrASPath         = 2
+	attrNextHop        = 3
+	attrLocalPref      = 5
+
+	originIGP    = 0
+	asSequence   = 2
+	defaultLocal = 100
+
+	paramCapabilities = 2
+	capMultiprotocol  = 1
+	capFourOctetAS    = 65
+
+	afiIPv4     = 1
+	safiUnicast = 1
+
+	// Notification error codes.
+	errOpenMessage = 2
+	errHoldTimer   = 4
+	errCease       = 6
+
+	// Open message error subcodes.
+	errUnsupportedVersion = 1
+	errBadPeerAS          = 2
+
+	// maxPrefixesPerUpdate keeps update messages well
+	// below maxMessageLen.
+	maxPrefixesPerUpdate = 500
+)
+
+// openMessage is a decoded BGP OPEN message.
+type openMessage struct {
+	version     byte
+	as          uint32
+	holdTime    uint16
+	routerID    net.IP
+	fourOctetAS bool
+}
+
+// pathAttributes describe announced networks.
+type pathAttributes struct {
+	nextHop     net.IP
+	asPath      []uint32
+	fourOctetAS bool
+	ibgp        bool
+}
+
+// marshalMessage prepends BGP header to the message body.
+func marshalMessage(msgType byte, body []byte) []byte {
+	msg := make([]byte, headerLen, headerLen+len(body))
+	for i := 0; i < 16; i++ {
+		msg[i] = 0xff
+	}
+	binary.BigEndian.PutUint16(msg[16:18], uint16(headerLen+len(body)))
+	msg[18] = msgType
+	return append(msg, body...)
+}
+
+// readMessage reads one BGP message and returns its type and body.
+func readMessage(r io.Reader) (byte, []byte, error) {
+	header := make([]byte, headerLen)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, nil, err
+	}
+
+	for i := 0; i < 16; i++ {
+		if header[i] != 0xff {
+			return 0, nil, fmt.Errorf("invalid message marker")
+		}
+	}
+
+	length := int(binary.BigEndian.Uint16(header[16:18]))
+	if length < headerLen || length > maxMessageLen {
+		return 0, nil, fmt.Errorf("invalid message length %d", length)
+	}
+
+	body := make([]byte, length-headerLen)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+
+	return header[18], body, nil
+}
+
+// marshalOpen builds OPEN message that advertises IPv4 unicast
+// and 4-octet AS capabilities.
+func marshalOpen(as uint32, holdTime uint16, routerID net.IP) []byte {
+	var caps bytes.Buffer
+	// Multiprotocol IPv4 unicast.
+	caps.Write([]byte{capMultiprotocol, 4, 0, afiIPv4, 0, safiUnicast})
+	// 4-octet AS number.
+	caps.Write([]byte{capFourOctetAS, 4})
+	binary.Write(&caps, binary.BigEndian, as)
+
+	var body bytes.Buffer
+	body.WriteByte(bgpVersion)
+	myAS := uint16(asTrans)
+	if as <= 0xffff {
+		myAS = uint16(as)
+	}
+	binary.Write(&body, binary.BigEndian, myAS)
+	binary.Write(&body, binary.BigEndian, holdTime)
+	body.Write(routerID.To4())
+	body.WriteByte(byte(2 + caps.Len()))
+	body.WriteByte(paramCapabilities)
+	body.WriteByte(byte(caps.Len()))
+	body.Write(caps.Bytes())
+
+	return marshalMessage(msgOpen, body.Bytes())
+}
+
+// parseOpen decodes body of the OPEN message.
+func parseOpen(body []byte) (*openMessage, error) {
+	if len(body) < 10 {
+		return nil, fmt.Errorf("open message too short")
+	}
+
+	open := &openMessage{
+		version:  body[0],
+		as:       uint32(binary.BigEndian.Uint16(body[1:3])),
+		holdTime: binary.BigEndian.Uint16(body[3:5]),
+		routerID: net.IP(body[5:9]),
+	}
+
+	paramsLen := int(body[9])
+	params := body[10:]
+	if len(params) < paramsLen {
+		return nil, fmt.Errorf("open message optional parameters truncated")
+	}
+	params = params[:paramsLen]
+
+	for len(params) >= 2 {
+		paramType, paramLen := params[0], int(params[1])
+		if len(params) < 2+paramLen {
+			return nil, fmt.Errorf("open message parameter truncated")
+		}
+		value := params[2 : 2+paramLen]
+		params = params[2+paramLen:]
+
+		if paramType != paramCapabilities {
+			continue
+		}
+
+		for len(value) >= 2 {
+			capCode, capLen := value[0], int(value[1])
+			if len(value) < 2+capLen {
+				return nil, fmt.Errorf("open message capability truncated")
+			}
+			if capCode == capFourOctetAS && capLen == 4 {
+				open.fourOctetAS = true
+				open.as = binary.BigEndian.Uint32(value[2:6])
+			}
+			value = value[2+capLen:]
+		}
+	}
+
+	return open, nil
+}
+
+// marshalKeepalive builds KEEPALIVE message.
+func marshalKeepalive() []byte {
+	return marshalMessage(msgKeepalive, nil)
+}
+
+// marshalNotification builds NOTIFICATION message.
+func marshalNotification(code, subcode byte) []byte {
+	return marshalMessage(msgNotification, []byte{code, subcode})
+}
+
+// marshalPrefixes encodes networks in NLRI format.
+func marshalPrefixes(networks []net.IPNet) []byte {
+	var buf bytes.Buffer
+	for _, network := range networks {
+		ones, _ := network.Mask.Size()
+		buf.WriteByte(byte(ones))
+		buf.Write(network.IP.To4()[:(ones+7)/8])
+	}
+	return buf.Bytes()
+}
+
+// marshalAttributes encodes path attributes of the announced networks.
+func marshalAttributes(attrs pathAttributes) []byte {
+	var buf bytes.Buffer
+
+	buf.Write([]byte{attrFlagTransitive, attrOrigin, 1, originIGP})
+
+	var path bytes.Buffer
+	if len(attrs.asPath) > 0 {
+		path.Write([]byte{asSequence, byte(len(attrs.asPath))})
+		for _, as := range attrs.asPath {
+			if attrs.fourOctetAS {
+				binary.Write(&path, binary.BigEndian, as)
+			} else {
+				binary.Write(&path, binary.BigEndian, uint16(as))
+			}
+		}
+	}
+	buf.Write([]byte{attrFlagTransitive, attrASPath, byte(path.Len())})
+	buf.Write(path.Bytes())
+
+	buf.Write([]byte{attrFlagTransitive, attrNextHop, 4})
+	buf.Write(attrs.nextHop.To4())
+
+	if attrs.ibgp {
+		buf.Write([]byte{attrFlagTransitive, attrLocalPref, 4})
+		binary.Write(&buf, binary.BigEndian, uint32(defaultLocal))
+	}
+
+	return buf.Bytes()
+}
+
+// marshalUpdate builds UPDATE message that withdraws and announces
+// given networks. Path attributes are only included when
+// there are networks to announce.
+func marshalUpdate(withdrawn, announced []net.IPNet, attrs pathAttributes) []byte {
+	var body bytes.Buffer
+
+	withdrawnRoutes := marshalPrefixes(withdrawn)
+	binary.Write(&body, binary.BigEndian, uint16(len(withdrawnRoutes)))
+	body.Write(withdrawnRoutes)
+
+	var pathAttrs []byte
+	if len(announced) > 0 {
+		pathAttrs = marshalAttributes(attrs)
+	}
+	binary.Write(&body, binary.BigEndian, uint16(len(pathAttrs)))
+	body.Write(pathAttrs)
+
+	body.Write(marshalPrefixes(announced))
+
+	return marshalMessage(msgUpdate, body.Bytes())
+}
+
+// parsePrefixes decodes networks in NLRI format.
+func parsePrefixes(data []byte) ([]net.IPNet, error) {
+	var networks []net.IPNet
+	for len(data) > 0 {
+		ones := int(data[0])
+		size := (ones + 7) / 8
+		if ones > 32 || len(data) < 1+size {
+			return nil, fmt.Errorf("invalid prefix")
+		}
+		ip := make(net.IP, net.IPv4len)
+		copy(ip, data[1:1+size])
+		networks = append(networks, net.IPNet{IP: ip, Mask: net.CIDRMask(ones, 32)})
+		data = data[1+size:]
+	}
+	return networks, nil
+}
+
+// parseUpdate decodes withdrawn and announced networks of the UPDATE message.
+func parseUpdate(body []byte) (withdrawn, announced []net.IPNet, err error) {
+	if len(body) < 4 {
+		return nil, nil, fmt.Errorf("update message too short")
+	}
+
+	withdrawnLen := int(binary.BigEndian.Uint16(body[0:2]))
+	if len(body) < 4+withdrawnLen {
+		return nil, nil, fmt.Errorf("update message withdrawn routes truncated")
+	}
+	withdrawn, err = parsePrefixes(body[2 : 2+withdrawnLen])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rest := body[2+withdrawnLen:]
+	attrsLen := int(binary.BigEndian.Uint16(rest[0:2]))
+	if len(rest) < 2+attrsLen {
+		return nil, nil, fmt.Errorf("update message path attributes truncated")
+	}
+	announced, err = parsePrefixes(rest[2+attrsLen:])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return withdrawn, announced, nil
+}
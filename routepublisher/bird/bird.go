@@ -71,6 +71,10 @@ type BirdRoutePublisher struct {
 	Networks []net.IPNet
 }
 
+func init() {
+	router.Register("bird", New)
+}
+
 func New(config router.Config) (router.Interface, error) {
 	var ok bool
 	publisher := &BirdRoutePublisher{Mutex: &sync.Mutex{}}
@@ -0,0 +1,226 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+// The package advertises list of networks by rerendering FRR (or Quagga)
+// bgpd config file and asking FRR to reload it.
+package frr
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+	"sync"
+	"text/template"
+
+	utilexec "github.com/romana/core/agent/exec"
+	router "github.com/romana/core/routepublisher/publisher"
+
+	log "github.com/romana/rlog"
+)
+
+const (
+	// DefaultConfigName is a location of bgpd config file used
+	// when frrConfigName is not provided.
+	DefaultConfigName = "/etc/frr/bgpd.conf"
+
+	// DefaultReloadCommand is used when reloadCommand is not provided,
+	// name of the config file is appended to the command.
+	DefaultReloadCommand = "/usr/lib/frr/frr-reload.py --reload --daemon bgpd"
+)
+
+// defaultTemplate is used when frrTemplateFileName is not provided.
+// It announces every network to every neighbor.
+const defaultTemplate = `! Rendered by romana route publisher, do not edit.
+router bgp {{.LocalAS}}
+{{- if .RouterID}}
+ bgp router-id {{.RouterID}}
+{{- end}}
+ no bgp ebgp-requires-policy
+ no bgp network import-check
+{{- range .Neighbors}}
+ neighbor {{.IP}} remote-as {{.AS}}
+{{- end}}
+ !
+ address-family ipv4 unicast
+{{- range .IPv4Networks}}
+  network {{.}}
+{{- end}}
+ exit-address-family
+{{- if .IPv6Networks}}
+ !
+ address-family ipv6 unicast
+{{- range .IPv6Networks}}
+  network {{.}}
+{{- end}}
+{{- range .Neighbors}}
+  neighbor {{.IP}} activate
+{{- end}}
+ exit-address-family
+{{- end}}
+!
+`
+
+// FrrRoutePublisher implements router.Interface by rendering bgpd config
+// for FRR and reloading FRR when config changes.
+type FrrRoutePublisher struct {
+	*sync.Mutex
+
+	// Name of template used to generate bgpd config,
+	// built in template is used when empty.
+	templateFileName string
+
+	// Name of a bgpd config file to generate.
+	configName string
+
+	// Command that makes FRR to pick up the new config.
+	reloadCommand []string
+
+	exec utilexec.Executable
+
+	// Config that FRR was last reloaded with, so that
+	// unchanged config isn't reloaded again.
+	applied []byte
+
+	// .LocalAS can be used inside the template
+	LocalAS string
+
+	// .RouterID can be used inside the template
+	// optional
+	RouterID string
+
+	// .Neighbors can be used inside the template
+	Neighbors []router.Neighbor
+
+	// .Args available inside the template as a map
+	Args map[string]interface{}
+
+	// .Networks, .IPv4Networks and .IPv6Networks
+	// can be used inside the template
+	Networks     []net.IPNet
+	IPv4Networks []net.IPNet
+	IPv6Networks []net.IPNet
+}
+
+func init() {
+	router.Register("frr", New)
+}
+
+func New(config router.Config) (router.Interface, error) {
+	return NewWithExecutor(config, utilexec.DefaultExecutor{})
+}
+
+// NewWithExecutor creates FrrRoutePublisher that uses provided
+// executor to run reload command.
+func NewWithExecutor(config router.Config, exec utilexec.Executable) (router.Interface, error) {
+	var ok bool
+	var err error
+
+	publisher := &FrrRoutePublisher{Mutex: &sync.Mutex{}, exec: exec}
+	publisher.templateFileName, _ = config["frrTemplateFileName"]
+	publisher.configName = config.SetDefault("frrConfigName", DefaultConfigName)
+	publisher.reloadCommand = strings.Fields(config.SetDefault("reloadCommand", DefaultReloadCommand))
+	publisher.RouterID, _ = config["routerID"]
+
+	if publisher.LocalAS, ok = config["localAS"]; !ok {
+		return nil, fmt.Errorf("Parameter missing `localAS`")
+	}
+
+	publisher.Neighbors, err = router.ParseNeighbors(config["neighbors"])
+	if err != nil {
+		return nil, err
+	}
+	if len(publisher.Neighbors) == 0 {
+		return nil, fmt.Errorf("Parameter missing `neighbors`")
+	}
+
+	return publisher, nil
+}
+
+// Update implements router.Interface by rendering new bgpd config file
+// and reloading FRR if config has changed since the last successful reload.
+func (q *FrrRoutePublisher) Update(networks []net.IPNet, args map[string]interface{}) error {
+	q.Lock()
+	defer q.Unlock()
+	log.Infof("Starting frr update with %d networks for %d neighbors", len(networks), len(q.Neighbors))
+
+	q.Args = args
+	q.Networks = networks
+	q.IPv4Networks = nil
+	q.IPv6Networks = nil
+	for _, network := range networks {
+		if network.IP.To4() != nil {
+			q.IPv4Networks = append(q.IPv4Networks, network)
+		} else {
+			q.IPv6Networks = append(q.IPv6Networks, network)
+		}
+	}
+
+	config, err := q.render()
+	if err != nil {
+		return err
+	}
+
+	if q.applied != nil && bytes.Equal(q.applied, config) {
+		log.Infof("Config %s is up to date, skipping frr reload", q.configName)
+		return nil
+	}
+
+	// Config is reloaded again on the next update unless this one succeeds.
+	q.applied = nil
+	err = ioutil.WriteFile(q.configName, config, 0644)
+	if err != nil {
+		return err
+	}
+
+	if len(q.reloadCommand) > 0 {
+		var cmdArgs []string
+		cmdArgs = append(cmdArgs, q.reloadCommand[1:]...)
+		cmdArgs = append(cmdArgs, q.configName)
+		out, err := q.exec.Exec(q.reloadCommand[0], cmdArgs)
+		if err != nil {
+			return fmt.Errorf("Failed to reload frr with %s, err=(%s), out=(%s)",
+				strings.Join(q.reloadCommand, " "), err, out)
+		}
+	}
+	q.applied = config
+
+	log.Infof("Finished frr update")
+	return nil
+}
+
+// render executes the template into bgpd config.
+func (q *FrrRoutePublisher) render() ([]byte, error) {
+	var tmpl *template.Template
+	var err error
+
+	if q.templateFileName == "" {
+		tmpl, err = template.New("bgpd.conf").Parse(defaultTemplate)
+	} else {
+		tmpl, err = template.ParseFiles(q.templateFileName)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	err = tmpl.Execute(&buf, q)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
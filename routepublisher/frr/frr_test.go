@@ -0,0 +1,116 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package frr
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	utilexec "github.com/romana/core/agent/exec"
+	router "github.com/romana/core/routepublisher/publisher"
+)
+
+func TestUpdate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "frr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	configName := filepath.Join(dir, "bgpd.conf")
+	exec := &utilexec.FakeExecutor{}
+	publisher, err := NewWithExecutor(router.Config{
+		"localAS":       "65000",
+		"neighbors":     "192.168.99.1:65001,fd00::1:65002",
+		"frrConfigName": configName,
+		"reloadCommand": "vtysh -b",
+	}, exec)
+	if err == nil {
+		t.Fatal("expected error for neighbor without brackets")
+	}
+
+	publisher, err = NewWithExecutor(router.Config{
+		"localAS":       "65000",
+		"neighbors":     "192.168.99.1:65001,[fd00::1]:65002",
+		"frrConfigName": configName,
+		"reloadCommand": "vtysh -b",
+	}, exec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, v4, _ := net.ParseCIDR("10.112.0.0/28")
+	_, v6, _ := net.ParseCIDR("fd00:1234::/120")
+	err = publisher.Update([]net.IPNet{*v4, *v6}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(configName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := string(data)
+	t.Log(config)
+
+	for _, expected := range []string{
+		"router bgp 65000",
+		"neighbor 192.168.99.1 remote-as 65001",
+		"neighbor fd00::1 remote-as 65002",
+		"  network 10.112.0.0/28",
+		"  network fd00:1234::/120",
+	} {
+		if !strings.Contains(config, expected) {
+			t.Errorf("expected %q in rendered config", expected)
+		}
+	}
+
+	if exec.Commands == nil || *exec.Commands != "vtysh -b "+configName {
+		t.Errorf("unexpected reload commands %v", exec.Commands)
+	}
+
+	// Same networks must not cause a reload.
+	exec.Commands = nil
+	err = publisher.Update([]net.IPNet{*v4, *v6}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exec.Commands != nil {
+		t.Errorf("unexpected reload for unchanged config, %s", *exec.Commands)
+	}
+
+	// Failed reload must be retried by the next update with same networks.
+	_, v4b, _ := net.ParseCIDR("10.112.0.16/28")
+	exec.Error = errors.New("reload failed")
+	err = publisher.Update([]net.IPNet{*v4, *v4b, *v6}, nil)
+	if err == nil {
+		t.Fatal("expected error for failed reload")
+	}
+	exec.Error = nil
+	exec.Commands = nil
+	err = publisher.Update([]net.IPNet{*v4, *v4b, *v6}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exec.Commands == nil {
+		t.Error("expected reload after failed reload of the same config")
+	}
+}
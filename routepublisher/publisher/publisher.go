@@ -18,7 +18,12 @@
 package publisher
 
 import (
+	"fmt"
 	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 )
 
 type Config map[string]string
@@ -35,3 +40,93 @@ type Interface interface {
 	// Updates list of networks advertised via routing protocol.
 	Update([]net.IPNet, map[string]interface{}) error
 }
+
+// Constructor creates new publisher from a configuration.
+type Constructor func(Config) (Interface, error)
+
+var (
+	registryMu sync.Mutex
+	registry   = make(map[string]Constructor)
+)
+
+// Register makes publisher implementation available by name,
+// implementations are expected to call it from their init().
+func Register(name string, constructor Constructor) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if _, ok := registry[name]; ok {
+		panic(fmt.Sprintf("route publisher %s registered twice", name))
+	}
+	registry[name] = constructor
+}
+
+// New creates publisher registered under given name.
+func New(name string, config Config) (Interface, error) {
+	registryMu.Lock()
+	constructor, ok := registry[name]
+	registryMu.Unlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown route publisher %s, must be one of %s",
+			name, strings.Join(Names(), ", "))
+	}
+
+	return constructor(config)
+}
+
+// Names returns sorted list of registered publishers.
+func Names() []string {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	var names []string
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Neighbor is a BGP peer that networks are published to.
+type Neighbor struct {
+	IP net.IP
+	AS uint32
+}
+
+func (n Neighbor) String() string {
+	return fmt.Sprintf("%s:%d", n.IP, n.AS)
+}
+
+// ParseNeighbors parses comma separated list of neighbors
+// in a form of ip:as, e.g. 192.168.99.1:65534,[fd00::1]:65535
+func ParseNeighbors(s string) ([]Neighbor, error) {
+	var neighbors []Neighbor
+
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		host, port, err := net.SplitHostPort(item)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse neighbor %s, expected ip:as, err=(%s)", item, err)
+		}
+
+		ip := net.ParseIP(host)
+		if ip == nil {
+			return nil, fmt.Errorf("failed to parse neighbor %s, invalid ip %s", item, host)
+		}
+
+		as, err := strconv.ParseUint(port, 10, 32)
+		if err != nil || as == 0 {
+			return nil, fmt.Errorf("failed to parse neighbor %s, invalid as %s", item, port)
+		}
+
+		neighbors = append(neighbors, Neighbor{IP: ip, AS: uint32(as)})
+	}
+
+	return neighbors, nil
+}
@@ -16,22 +16,83 @@
 package server
 
 import (
+	"fmt"
+	"net"
+	"strconv"
 	"strings"
 
+	"github.com/romana/core/agent/enforcer"
 	"github.com/romana/core/common"
 	"github.com/romana/core/common/api"
 	"github.com/romana/core/common/api/errors"
 	"github.com/romana/core/common/client"
+	"github.com/romana/core/pkg/policytools"
+
+	"github.com/romana/ipset"
 )
 
 // deallocateIP deallocates IP specified by query parameter
 // "addressName".
 func (r *Romanad) deallocateIP(input interface{}, ctx common.RestContext) (interface{}, error) {
 	addressName := ctx.QueryVariables.Get("addressName")
+	if !ctx.User.HasRole(common.RoleAdmin, common.RoleService) {
+		// tenants may only release their own addresses.
+		address, err := r.client.IPAM.LookupAddressByName(addressName)
+		if err != nil {
+			return nil, errors.RomanaErrorToHTTPError(err)
+		}
+		if err := common.CheckTenant(ctx, address.Tenant); err != nil {
+			return nil, err
+		}
+	}
 	err := r.client.IPAM.DeallocateIP(addressName)
 	return nil, errors.RomanaErrorToHTTPError(err)
 }
 
+// lookupAddress returns information about the IP specified
+// by query parameter "ip".
+func (r *Romanad) lookupAddress(input interface{}, ctx common.RestContext) (interface{}, error) {
+	ipStr := ctx.QueryVariables.Get("ip")
+	if ipStr == "" {
+		return nil, common.NewError400("ip required")
+	}
+	ip := net.ParseIP(ipStr)
+	if ip == nil {
+		return nil, common.NewError400(fmt.Sprintf("Invalid IP %s", ipStr))
+	}
+	resp, err := r.client.IPAM.LookupAddress(ip)
+	if err != nil {
+		return nil, errors.RomanaErrorToHTTPError(err)
+	}
+	if err := checkAddressTenant(ctx, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// getAddress returns information about the address
+// allocated under the name.
+func (r *Romanad) getAddress(input interface{}, ctx common.RestContext) (interface{}, error) {
+	resp, err := r.client.IPAM.LookupAddressByName(ctx.PathVariables["name"])
+	if err != nil {
+		return nil, errors.RomanaErrorToHTTPError(err)
+	}
+	if err := checkAddressTenant(ctx, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// checkAddressTenant returns a 403 error if the user in the context
+// is a tenant other than the one the address is allocated to. Read-only
+// users may see all addresses, like the rest of IPAM.
+func checkAddressTenant(ctx common.RestContext, address *api.IPAMAddressResponse) error {
+	if ctx.User.HasRole(common.RoleReadOnly) {
+		return nil
+	}
+	return common.CheckTenant(ctx, address.Tenant)
+}
+
 func (r *Romanad) allocateIP(input interface{}, ctx common.RestContext) (interface{}, error) {
 	req := input.(*api.IPAMAddressRequest)
 	if req.Name == "" {
@@ -40,6 +101,13 @@ func (r *Romanad) allocateIP(input interface{}, ctx common.RestContext) (interfa
 	if req.Host == "" {
 		return nil, common.NewError400("Host required")
 	}
+	if err := common.CheckTenant(ctx, req.Tenant); err != nil {
+		return nil, err
+	}
+	if req.DualStack {
+		retval, err := r.client.IPAM.AllocateIPs(req.Name, req.Host, req.Tenant, req.Segment)
+		return retval, errors.RomanaErrorToHTTPError(err)
+	}
 	retval, err := r.client.IPAM.AllocateIP(req.Name, req.Host, req.Tenant, req.Segment)
 	return retval, errors.RomanaErrorToHTTPError(err)
 }
@@ -54,6 +122,106 @@ func (r *Romanad) listNetworkBlocks(input interface{}, ctx common.RestContext) (
 	return r.client.IPAM.ListNetworkBlocks(netName), nil
 }
 
+// getNetwork returns details of the network.
+func (r *Romanad) getNetwork(input interface{}, ctx common.RestContext) (interface{}, error) {
+	netName := ctx.PathVariables["network"]
+	network, err := r.client.IPAM.GetNetwork(netName)
+	return network, errors.RomanaErrorToHTTPError(err)
+}
+
+// addNetwork adds a new network to the topology.
+func (r *Romanad) addNetwork(input interface{}, ctx common.RestContext) (interface{}, error) {
+	req := input.(*api.NetworkAddRequest)
+	if req.Name == "" {
+		return nil, common.NewError400("Name required")
+	}
+	if req.CIDR == "" {
+		return nil, common.NewError400("CIDR required")
+	}
+	err := r.client.IPAM.AddNetwork(*req)
+	if err != nil {
+		return nil, errors.RomanaErrorToHTTPError(err)
+	}
+	network, err := r.client.IPAM.GetNetwork(req.Name)
+	return network, errors.RomanaErrorToHTTPError(err)
+}
+
+// removeNetwork removes the network from the topology.
+func (r *Romanad) removeNetwork(input interface{}, ctx common.RestContext) (interface{}, error) {
+	netName := ctx.PathVariables["network"]
+	err := r.client.IPAM.RemoveNetwork(netName)
+	return nil, errors.RomanaErrorToHTTPError(err)
+}
+
+// listBlackedOut lists CIDRs blacked out in the network.
+func (r *Romanad) listBlackedOut(input interface{}, ctx common.RestContext) (interface{}, error) {
+	netName := ctx.PathVariables["network"]
+	resp, err := r.client.IPAM.ListBlackedOut(netName)
+	return resp, errors.RomanaErrorToHTTPError(err)
+}
+
+// blackOut removes CIDR provided in request body from
+// consideration for allocations in the network.
+func (r *Romanad) blackOut(input interface{}, ctx common.RestContext) (interface{}, error) {
+	netName := ctx.PathVariables["network"]
+	req := input.(*api.IPAMBlackoutRequest)
+	err := r.checkBlackoutCIDR(netName, req.CIDR)
+	if err != nil {
+		return nil, err
+	}
+
+	err = r.client.IPAM.BlackOut(req.CIDR)
+	if err != nil {
+		return nil, errors.RomanaErrorToHTTPError(err)
+	}
+
+	resp, err := r.client.IPAM.ListBlackedOut(netName)
+	return resp, errors.RomanaErrorToHTTPError(err)
+}
+
+// unBlackOut returns CIDR specified by query parameter "cidr"
+// back into the pool of the network.
+func (r *Romanad) unBlackOut(input interface{}, ctx common.RestContext) (interface{}, error) {
+	netName := ctx.PathVariables["network"]
+	cidr := ctx.QueryVariables.Get("cidr")
+	err := r.checkBlackoutCIDR(netName, cidr)
+	if err != nil {
+		return nil, err
+	}
+
+	err = r.client.IPAM.UnBlackOut(cidr)
+	if err != nil {
+		return nil, errors.RomanaErrorToHTTPError(err)
+	}
+
+	resp, err := r.client.IPAM.ListBlackedOut(netName)
+	return resp, errors.RomanaErrorToHTTPError(err)
+}
+
+// checkBlackoutCIDR verifies that CIDR is valid and
+// belongs to the network.
+func (r *Romanad) checkBlackoutCIDR(netName string, cidrStr string) error {
+	if cidrStr == "" {
+		return common.NewError400("CIDR required")
+	}
+	_, cidr, err := net.ParseCIDR(cidrStr)
+	if err != nil {
+		return common.NewError400(fmt.Sprintf("Invalid CIDR %s: %s", cidrStr, err))
+	}
+
+	network, err := r.client.IPAM.ListBlackedOut(netName)
+	if err != nil {
+		return errors.RomanaErrorToHTTPError(err)
+	}
+
+	ones, _ := cidr.Mask.Size()
+	networkOnes, _ := network.CIDR.Mask.Size()
+	if !network.CIDR.Contains(cidr.IP) || ones < networkOnes {
+		return common.NewError400(fmt.Sprintf("CIDR %s is not within network %s (%s)", cidrStr, netName, network.CIDR.String()))
+	}
+	return nil
+}
+
 func (r *Romanad) listAllBlocks(input interface{}, ctx common.RestContext) (interface{}, error) {
 	return r.client.IPAM.ListAllBlocks(), nil
 }
@@ -82,6 +250,45 @@ func (r *Romanad) updateTopology(input interface{}, ctx common.RestContext) (int
 	return nil, r.client.IPAM.UpdateTopology(*topoReq, true)
 }
 
+// exportIPAM returns snapshot of IPAM.
+func (r *Romanad) exportIPAM(input interface{}, ctx common.RestContext) (interface{}, error) {
+	return r.client.ExportIPAM()
+}
+
+// importIPAM replaces IPAM with the snapshot provided in request
+// body, or only lists the changes if query parameter "dry_run" is true.
+func (r *Romanad) importIPAM(input interface{}, ctx common.RestContext) (interface{}, error) {
+	snapshot := input.(*api.IPAMSnapshot)
+	dryRun := false
+	if dryRunStr := ctx.QueryVariables.Get("dry_run"); dryRunStr != "" {
+		var err error
+		dryRun, err = strconv.ParseBool(dryRunStr)
+		if err != nil {
+			return nil, common.NewError400(fmt.Sprintf("Invalid dry_run %s: %s", dryRunStr, err))
+		}
+	}
+
+	ipam, err := client.ParseIPAMSnapshot(*snapshot)
+	if err != nil {
+		return nil, common.NewUnprocessableEntityError(err.Error())
+	}
+	return r.client.ImportIPAM(ipam, dryRun)
+}
+
+// verifyIPAM lists inconsistencies found in IPAM.
+func (r *Romanad) verifyIPAM(input interface{}, ctx common.RestContext) (interface{}, error) {
+	return api.IPAMVerifyResponse{Problems: r.client.IPAM.Verify()}, nil
+}
+
+// repairIPAM repairs inconsistencies found in IPAM.
+func (r *Romanad) repairIPAM(input interface{}, ctx common.RestContext) (interface{}, error) {
+	problems, err := r.client.IPAM.Repair()
+	if err != nil {
+		return nil, err
+	}
+	return api.IPAMVerifyResponse{Repair: true, Problems: problems}, nil
+}
+
 // getPolicy is a handler for the /policy/{name} URL that
 // returns the policy.
 func (r *Romanad) getPolicy(input interface{}, ctx common.RestContext) (interface{}, error) {
@@ -130,9 +337,86 @@ func (r *Romanad) addPolicy(input interface{}, ctx common.RestContext) (interfac
 	return nil, r.client.AddPolicy(*policy)
 }
 
-// addPolicy stores the new policy and sends it to all agents.
+// renderPolicies renders iptables rules and ipsets that romana agent
+// on the host specified by query parameter "host" would apply for the
+// proposed policies and current blocks. Nothing is stored or applied.
+func (r *Romanad) renderPolicies(input interface{}, ctx common.RestContext) (interface{}, error) {
+	hostName := ctx.QueryVariables.Get("host")
+	if hostName == "" {
+		return nil, common.NewError400("host required")
+	}
+	host, err := r.client.IPAM.GetHost(hostName)
+	if err != nil {
+		return nil, errors.RomanaErrorToHTTPError(err)
+	}
+
+	policies := *input.(*[]api.Policy)
+	for _, policy := range policies {
+		if policy.ID == "" {
+			return nil, common.NewUnprocessableEntityError("Policy ID not found in input")
+		}
+		if err := policytools.ValidatePolicy(policy); err != nil {
+			return nil, common.NewUnprocessableEntityError(
+				fmt.Sprintf("Invalid policy %s: %s", policy.ID, err))
+		}
+	}
+
+	blocks := r.client.IPAM.ListAllBlocks()
+	iptables, sets, err := enforcer.Render(policies, blocks.Blocks, host.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	return api.PolicyRenderResponse{
+		Host:     host.Name,
+		IPtables: iptables.Render(),
+		Ipsets:   sets.Render(ipset.RenderSave),
+	}, nil
+}
+
+// checkPolicies reports whether current policies allow the traffic
+// described in the request and which policies allow it.
+func (r *Romanad) checkPolicies(input interface{}, ctx common.RestContext) (interface{}, error) {
+	req := input.(*api.PolicyCheckRequest)
+
+	policies, err := r.client.ListPolicies()
+	if err != nil {
+		return nil, err
+	}
+
+	blocks := r.client.IPAM.ListAllBlocks()
+	hosts := r.client.IPAM.ListHosts()
+	resp, err := policytools.CheckConnectivity(policies, blocks.Blocks, hosts.Hosts, *req)
+	if err != nil {
+		return nil, common.NewError400(err.Error())
+	}
+
+	return resp, nil
+}
+
+// addHost adds a new host to the topology.
 func (r *Romanad) addHost(input interface{}, ctx common.RestContext) (interface{}, error) {
 	host := input.(*api.Host)
 	err := r.client.IPAM.AddHost(*host)
 	return nil, errors.RomanaErrorToHTTPError(err)
 }
+
+// getHost returns the host by its name or IP.
+func (r *Romanad) getHost(input interface{}, ctx common.RestContext) (interface{}, error) {
+	host, err := r.client.IPAM.GetHost(ctx.PathVariables["host"])
+	if err != nil {
+		return nil, errors.RomanaErrorToHTTPError(err)
+	}
+	return host, nil
+}
+
+// removeHost removes the host specified by its name or IP.
+func (r *Romanad) removeHost(input interface{}, ctx common.RestContext) (interface{}, error) {
+	nameOrIP := ctx.PathVariables["host"]
+	host, err := r.client.IPAM.GetHost(nameOrIP)
+	if err != nil {
+		return nil, errors.RomanaErrorToHTTPError(err)
+	}
+	err = r.client.IPAM.RemoveHost(api.Host{Name: host.Name, IP: host.IP})
+	return nil, errors.RomanaErrorToHTTPError(err)
+}
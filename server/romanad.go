@@ -53,6 +53,20 @@ func (r *Romanad) Routes() common.Routes {
 			MakeMessage:     func() interface{} { return &api.Policy{} },
 			UseRequestToken: false,
 		},
+		common.Route{
+			Method:       "POST",
+			Pattern:      "/policies/render",
+			Handler:      r.renderPolicies,
+			MakeMessage:  func() interface{} { return &[]api.Policy{} },
+			AuthZChecker: common.AuthZReadOnly,
+		},
+		common.Route{
+			Method:       "POST",
+			Pattern:      "/policies/check",
+			Handler:      r.checkPolicies,
+			MakeMessage:  func() interface{} { return &api.PolicyCheckRequest{} },
+			AuthZChecker: common.AuthZReadOnly,
+		},
 		common.Route{
 			Method:          "DELETE",
 			Pattern:         "/policies",
@@ -73,6 +87,7 @@ func (r *Romanad) Routes() common.Routes {
 			Handler:         r.listPolicies,
 			MakeMessage:     nil,
 			UseRequestToken: false,
+			AuthZChecker:    common.AuthZReadOnly,
 		},
 		common.Route{
 			Method:          "GET",
@@ -80,37 +95,90 @@ func (r *Romanad) Routes() common.Routes {
 			Handler:         r.getPolicy,
 			MakeMessage:     nil,
 			UseRequestToken: false,
+			AuthZChecker:    common.AuthZReadOnly,
 		},
 		common.Route{
-			Method:  "GET",
-			Pattern: "/networks/{network}/blocks",
-			Handler: r.listNetworkBlocks,
+			Method:       "GET",
+			Pattern:      "/networks/{network}/blocks",
+			Handler:      r.listNetworkBlocks,
+			AuthZChecker: common.AuthZReadOnly,
 		},
 		common.Route{
-			Method:  "GET",
-			Pattern: "/blocks",
-			Handler: r.listAllBlocks,
+			Method:       "GET",
+			Pattern:      "/networks/{network}/blackout",
+			Handler:      r.listBlackedOut,
+			AuthZChecker: common.AuthZReadOnly,
 		},
 		common.Route{
 			Method:      "POST",
-			Pattern:     "/address",
-			Handler:     r.allocateIP,
-			MakeMessage: func() interface{} { return &api.IPAMAddressRequest{} },
+			Pattern:     "/networks/{network}/blackout",
+			Handler:     r.blackOut,
+			MakeMessage: func() interface{} { return &api.IPAMBlackoutRequest{} },
 		},
 		common.Route{
 			Method:  "DELETE",
-			Pattern: "/address",
-			Handler: r.deallocateIP,
+			Pattern: "/networks/{network}/blackout",
+			Handler: r.unBlackOut,
+		},
+		common.Route{
+			Method:       "GET",
+			Pattern:      "/blocks",
+			Handler:      r.listAllBlocks,
+			AuthZChecker: common.AuthZReadOnly,
+		},
+		common.Route{
+			Method:       "POST",
+			Pattern:      "/address",
+			Handler:      r.allocateIP,
+			MakeMessage:  func() interface{} { return &api.IPAMAddressRequest{} },
+			AuthZChecker: common.AuthZTenant,
+		},
+		common.Route{
+			Method:       "DELETE",
+			Pattern:      "/address",
+			Handler:      r.deallocateIP,
+			AuthZChecker: common.AuthZTenant,
+		},
+		common.Route{
+			Method:       "GET",
+			Pattern:      "/address",
+			Handler:      r.lookupAddress,
+			AuthZChecker: common.AuthZReadOnly,
+		},
+		common.Route{
+			Method:       "GET",
+			Pattern:      "/address/{name}",
+			Handler:      r.getAddress,
+			AuthZChecker: common.AuthZReadOnly,
+		},
+		common.Route{
+			Method:       "GET",
+			Pattern:      "/networks",
+			Handler:      r.listNetworks,
+			AuthZChecker: common.AuthZReadOnly,
+		},
+		common.Route{
+			Method:      "POST",
+			Pattern:     "/networks",
+			Handler:     r.addNetwork,
+			MakeMessage: func() interface{} { return &api.NetworkAddRequest{} },
 		},
 		common.Route{
-			Method:  "GET",
-			Pattern: "/networks",
-			Handler: r.listNetworks,
+			Method:       "GET",
+			Pattern:      "/networks/{network}",
+			Handler:      r.getNetwork,
+			AuthZChecker: common.AuthZReadOnly,
 		},
 		common.Route{
-			Method:  "GET",
-			Pattern: "/topology",
-			Handler: r.getTopology,
+			Method:  "DELETE",
+			Pattern: "/networks/{network}",
+			Handler: r.removeNetwork,
+		},
+		common.Route{
+			Method:       "GET",
+			Pattern:      "/topology",
+			Handler:      r.getTopology,
+			AuthZChecker: common.AuthZReadOnly,
 		},
 		common.Route{
 			Method:      "POST",
@@ -119,9 +187,33 @@ func (r *Romanad) Routes() common.Routes {
 			MakeMessage: func() interface{} { return &api.TopologyUpdateRequest{} },
 		},
 		common.Route{
-			Method:  "GET",
-			Pattern: "/hosts",
-			Handler: r.listHosts,
+			Method:       "GET",
+			Pattern:      "/ipam/export",
+			Handler:      r.exportIPAM,
+			AuthZChecker: common.AuthZReadOnly,
+		},
+		common.Route{
+			Method:      "POST",
+			Pattern:     "/ipam/import",
+			Handler:     r.importIPAM,
+			MakeMessage: func() interface{} { return &api.IPAMSnapshot{} },
+		},
+		common.Route{
+			Method:       "GET",
+			Pattern:      "/ipam/verify",
+			Handler:      r.verifyIPAM,
+			AuthZChecker: common.AuthZReadOnly,
+		},
+		common.Route{
+			Method:  "POST",
+			Pattern: "/ipam/repair",
+			Handler: r.repairIPAM,
+		},
+		common.Route{
+			Method:       "GET",
+			Pattern:      "/hosts",
+			Handler:      r.listHosts,
+			AuthZChecker: common.AuthZReadOnly,
 		},
 		common.Route{
 			Method:      "POST",
@@ -129,6 +221,17 @@ func (r *Romanad) Routes() common.Routes {
 			Handler:     r.addHost,
 			MakeMessage: func() interface{} { return &api.Host{} },
 		},
+		common.Route{
+			Method:       "GET",
+			Pattern:      "/hosts/{host}",
+			Handler:      r.getHost,
+			AuthZChecker: common.AuthZReadOnly,
+		},
+		common.Route{
+			Method:  "DELETE",
+			Pattern: "/hosts/{host}",
+			Handler: r.removeHost,
+		},
 	}
 	return routes
 }